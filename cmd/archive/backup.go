@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+
+	"github.com/debemdeboas/the-archive/internal/backup"
+	"github.com/debemdeboas/the-archive/internal/config"
+	"github.com/debemdeboas/the-archive/internal/db"
+)
+
+// runBackup takes a snapshot of the database and rotates the old ones, or
+// lists the snapshots.
+func runBackup(args []string) error {
+	cfg := config.Get().Database.Backup
+
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	flags.StringVar(&cfg.Dir, "dir", cfg.Dir, "Directory snapshots are written to")
+	flags.BoolVar(&cfg.Compress, "compress", cfg.Compress, "Compress the snapshot with zstd")
+	flags.IntVar(&cfg.Keep, "keep", cfg.Keep, "Number of snapshots to keep (0 keeps every snapshot)")
+	list := flags.Bool("list", false, "List the snapshots instead of taking one")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: archive backup [flags]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if *list {
+		snapshots, err := backup.List(cfg.Dir)
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tSIZE\tCREATED")
+		for _, s := range snapshots {
+			fmt.Fprintf(w, "%s\t%d\t%s\n", s.Name, s.Size, s.Created.Format(time.RFC3339))
+		}
+		return w.Flush()
+	}
+
+	database, err := db.New(config.Get().Database)
+	if err != nil {
+		return err
+	}
+	if err := database.Open(); err != nil {
+		return fmt.Errorf("error opening database: %w", err)
+	}
+	defer database.Close()
+
+	info, err := backup.Create(context.Background(), database, cfg)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %s (%d bytes)\n", info.Path, info.Size)
+
+	deleted, err := backup.Rotate(cfg.Dir, cfg.Keep)
+	for _, s := range deleted {
+		fmt.Printf("Deleted %s\n", s.Name)
+	}
+	return err
+}
+
+// runRestore replaces the database with a snapshot.
+func runRestore(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: archive restore <snapshot>\n\nStop the server before restoring. The snapshot may be a path or the name\nof a snapshot in the backup directory.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	src := flags.Arg(0)
+	if _, err := os.Stat(src); errors.Is(err, os.ErrNotExist) && filepath.Base(src) == src {
+		src = filepath.Join(config.Get().Database.Backup.Dir, src)
+	}
+
+	previous, err := backup.Restore(context.Background(), src, config.Get().Database)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Restored %s\n", src)
+	if previous != "" {
+		fmt.Printf("The replaced database was kept at %s\n", previous)
+	}
+	return nil
+}
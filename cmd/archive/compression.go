@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/debemdeboas/the-archive/internal/config"
+	"github.com/debemdeboas/the-archive/internal/db"
+	"github.com/debemdeboas/the-archive/internal/repository"
+	"github.com/debemdeboas/the-archive/internal/util/compression"
+)
+
+// openPostsDB opens the database and the posts stored in it, compressed as
+// configured by cfg.
+func openPostsDB(cfg config.DBPostsConfig) (db.DB, *repository.DBPostRepository, error) {
+	database, err := db.New(config.Get().Database)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := database.InitDB(); err != nil {
+		return nil, nil, fmt.Errorf("error opening database: %w", err)
+	}
+	repo, err := repository.NewDBPostRepositoryFromConfig(database, cfg)
+	if err != nil {
+		database.Close()
+		return nil, nil, err
+	}
+	return database, repo, nil
+}
+
+// runRecompress rewrites the posts stored in the database with the
+// configured codec.
+func runRecompress(args []string) error {
+	cfg := config.Get().Posts.DB
+
+	flags := flag.NewFlagSet("recompress", flag.ExitOnError)
+	flags.StringVar(&cfg.Compression, "compression", cfg.Compression, "Codec to compress the posts with (zstd, gzip or none)")
+	flags.StringVar(&cfg.Dictionary, "dictionary", cfg.Dictionary, "Trained zstd dictionary to use with the zstd codec")
+	batch := flags.Int("batch", 100, "Number of posts rewritten per transaction")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: archive recompress [flags]\n\nPosts compressed with a dictionary need it to be configured to be\nrecompressed without it.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	database, repo, err := openPostsDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	n, err := repo.Recompress(context.Background(), *batch)
+	fmt.Printf("Recompressed %d posts\n", n)
+	return err
+}
+
+// runTrainDict trains a zstd dictionary on the posts stored in the database.
+func runTrainDict(args []string) error {
+	cfg := config.Get().Posts.DB
+
+	flags := flag.NewFlagSet("train-dict", flag.ExitOnError)
+	out := flags.String("o", "posts.dict", "File the dictionary is written to")
+	size := flags.Int("size", 16<<10, "Maximum size of the dictionary in bytes")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: archive train-dict [flags]\n\nSet posts.db.dictionary to the written file and run archive recompress\nto use it.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	database, repo, err := openPostsDB(cfg)
+	if err != nil {
+		return err
+	}
+	defer database.Close()
+
+	posts, _, err := repo.GetPosts(context.Background())
+	if err != nil {
+		return err
+	}
+	samples := make([][]byte, len(posts))
+	for i, post := range posts {
+		samples[i] = post.Markdown
+	}
+
+	dictionary, err := compression.TrainZstdDictionary(samples, *size)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(*out, dictionary, 0o644); err != nil {
+		return fmt.Errorf("error writing dictionary: %w", err)
+	}
+	fmt.Printf("Wrote %s (%d bytes) from %d posts\n", *out, len(dictionary), len(samples))
+	return nil
+}
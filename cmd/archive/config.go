@@ -0,0 +1,39 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+
+	"github.com/debemdeboas/the-archive/internal/config"
+)
+
+// runConfigMigrate migrates the configuration file to the current version
+// and reports what changed.
+func runConfigMigrate(args []string) error {
+	flags := flag.NewFlagSet("config-migrate", flag.ExitOnError)
+	write := flags.Bool("write", false, "Rewrite the file with the migrated configuration, keeping its comments")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: archive config-migrate [flags]\n\nWithout -write, only reports the changes.")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	changes, err := config.MigrateFile(*configFile, *write)
+	if err != nil {
+		return err
+	}
+	if len(changes) == 0 {
+		fmt.Printf("%s is up to date (version %s)\n", *configFile, config.CurrentVersion)
+		return nil
+	}
+
+	for _, change := range changes {
+		fmt.Println(change)
+	}
+	if *write {
+		fmt.Printf("Migrated %s to version %s\n", *configFile, config.CurrentVersion)
+	} else {
+		fmt.Printf("Run with -write to migrate %s to version %s\n", *configFile, config.CurrentVersion)
+	}
+	return nil
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"github.com/debemdeboas/the-archive/internal/config"
+)
+
+// command is a subcommand of the archive tool.
+type command struct {
+	usage string
+	run   func(args []string) error
+	// raw commands run without loading the configuration
+	raw bool
+}
+
+var (
+	configFile = flag.String("config", "config.yaml", "Path to the configuration file")
+	overrides  = config.Overrides{}
+)
+
+func init() {
+	flag.Var(overrides, "set", "Override a configuration field, as section.field=value (can be repeated)")
+}
+
+var commands = map[string]command{
+	"backup":         {usage: "backup [-list]: snapshot the database and delete old snapshots", run: runBackup},
+	"config-migrate": {usage: "config-migrate [-write]: migrate the configuration file to the current version", run: runConfigMigrate, raw: true},
+	"media-gc":       {usage: "media-gc [-delete]: report, or delete, uploads that no post references", run: runMediaGC},
+	"migrate":        {usage: "migrate up|down|status: manage the database schema", run: runMigrate},
+	"recompress":     {usage: "recompress [-compression codec]: rewrite the posts in the database with another codec", run: runRecompress},
+	"restore":        {usage: "restore <snapshot>: replace the database with a snapshot", run: runRestore},
+	"train-dict":     {usage: "train-dict [-o file]: train a zstd dictionary on the posts in the database", run: runTrainDict},
+}
+
+// main runs administrative commands against the archive.
+func main() {
+	flag.Usage = usage
+	flag.Parse()
+
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
+	if !cmd.raw {
+		if err := config.LoadConfigWithOverrides(*configFile, overrides); err != nil {
+			fmt.Fprintf(os.Stderr, "Error loading %s: %v\n", *configFile, err)
+			os.Exit(1)
+		}
+	}
+	if err := cmd.run(flag.Args()[1:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", flag.Arg(0), err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(flag.CommandLine.Output(), "Usage: archive [-config file] [-set section.field=value] <command> [arguments]\n\nCommands:")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(flag.CommandLine.Output(), "  %s\n", commands[name].usage)
+	}
+}
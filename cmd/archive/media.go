@@ -0,0 +1,86 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/debemdeboas/the-archive/internal/config"
+	"github.com/debemdeboas/the-archive/internal/db"
+	"github.com/debemdeboas/the-archive/internal/media"
+	"github.com/debemdeboas/the-archive/internal/media/library"
+	"github.com/debemdeboas/the-archive/internal/repository"
+)
+
+// runMediaGC reports uploads that no post references and optionally deletes
+// them. The posts are read from the configured repositories, as the server
+// reads them.
+func runMediaGC(args []string) error {
+	flags := flag.NewFlagSet("media-gc", flag.ExitOnError)
+	deleteOrphans := flags.Bool("delete", false, "Delete orphaned uploads instead of only reporting them")
+	minAge := flags.Duration("min-age", 24*time.Hour, "Ignore uploads younger than this")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: archive media-gc [flags]")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	cfg := config.Get()
+	database, err := db.New(cfg.Database)
+	if err != nil {
+		return err
+	}
+	if err := database.InitDB(); err != nil {
+		return fmt.Errorf("error opening database: %w", err)
+	}
+	defer database.Close()
+
+	ctx := context.Background()
+	posts, err := repository.NewPostRepositoryFromConfig(ctx, cfg.Posts, cfg.Repositories, database)
+	if err != nil {
+		return err
+	}
+	if err := posts.Start(ctx); err != nil {
+		return fmt.Errorf("error loading posts: %w", err)
+	}
+	defer posts.Stop()
+
+	store, err := media.NewStoreFromConfig(ctx, cfg.Media.Storage)
+	if err != nil {
+		return fmt.Errorf("error initializing media storage: %w", err)
+	}
+
+	report, err := library.CollectGarbage(
+		ctx,
+		posts,
+		repository.NewDBMediaRepository(database),
+		store,
+		library.Options{MinAge: *minAge, Delete: *deleteOrphans},
+	)
+	if err != nil {
+		return err
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tFILES\tSIZE\tTRACKED")
+	var total int64
+	for _, orphan := range report.Orphans {
+		fmt.Fprintf(w, "%s\t%d\t%d\t%t\n", orphan.Name, len(orphan.Files), orphan.Size, orphan.Tracked)
+		total += orphan.Size
+	}
+	w.Flush()
+
+	fmt.Printf("Scanned %d posts, found %d orphaned uploads (%d bytes)\n", report.Posts, len(report.Orphans), total)
+	if report.Pruned > 0 {
+		fmt.Printf("Dropped the references of %d removed posts\n", report.Pruned)
+	}
+	if *deleteOrphans {
+		fmt.Printf("Deleted %d orphaned uploads\n", report.Deleted)
+	} else if len(report.Orphans) > 0 {
+		fmt.Println("Dry run, re-run with -delete to remove them")
+	}
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/debemdeboas/the-archive/internal/config"
+	"github.com/debemdeboas/the-archive/internal/db"
+	"github.com/debemdeboas/the-archive/internal/logger"
+)
+
+// runMigrate applies, rolls back or lists the database migrations.
+func runMigrate(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	to := flags.Int("to", 0, "Migrate up to this version instead of the latest (up)")
+	steps := flags.Int("steps", 1, "Number of migrations to roll back (down)")
+	flags.Usage = func() {
+		fmt.Fprintln(flags.Output(), "Usage: archive migrate [flags] up|down|status")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		flags.Usage()
+		os.Exit(2)
+	}
+
+	db.SetLogger(logger.New("info"))
+
+	database, err := db.New(config.Get().Database)
+	if err != nil {
+		return err
+	}
+	if err := database.Open(); err != nil {
+		return fmt.Errorf("error opening database: %w", err)
+	}
+	defer database.Close()
+
+	migrator, err := db.NewMigrator(database.Get(), database.Dialect())
+	if err != nil {
+		return err
+	}
+
+	switch flags.Arg(0) {
+	case "up":
+		version := migrator.Latest()
+		if *to > 0 {
+			version = *to
+		}
+		applied, err := migrator.UpTo(version)
+		fmt.Printf("Applied %d migrations\n", applied)
+		return err
+	case "down":
+		rolledBack, err := migrator.Down(*steps)
+		fmt.Printf("Rolled back %d migrations\n", rolledBack)
+		return err
+	case "status":
+		status, err := migrator.Status()
+		if err != nil {
+			return err
+		}
+
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED")
+		for _, s := range status {
+			applied := "pending"
+			if s.AppliedAt != nil {
+				applied = s.AppliedAt.Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%d\t%s\t%s\n", s.Version, s.Name, applied)
+		}
+		return w.Flush()
+	default:
+		return errors.New("unknown subcommand " + flags.Arg(0))
+	}
+}
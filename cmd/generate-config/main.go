@@ -3,6 +3,7 @@ package main
 import (
 	"flag"
 	"fmt"
+	"go/format"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -20,7 +21,7 @@ func main() {
 
 	var (
 		outputFile  = flag.String("output", "config.reference.yaml", "Output file path (use - for stdout)")
-		mode        = flag.String("mode", "reference", "Generation mode: reference, example, minimal")
+		mode        = flag.String("mode", "reference", "Generation mode: reference, example, minimal, schema")
 		updateTests = flag.Bool("update-tests", false, "Update test fixtures with current defaults")
 	)
 	flag.Parse()
@@ -46,6 +47,12 @@ func main() {
 		}
 		log.Info().Str("file", *outputFile).Msg("Generated minimal configuration")
 
+	case "schema":
+		if err := generateSchema(*outputFile); err != nil {
+			log.Fatal().Err(err).Msg("Failed to generate schema")
+		}
+		log.Info().Str("file", *outputFile).Msg("Generated configuration JSON Schema")
+
 	default:
 		log.Fatal().Str("mode", *mode).Msg("Unknown generation mode")
 	}
@@ -68,6 +75,16 @@ func generateReference(outputFile string) error {
 	return writeOutput(outputFile, yamlData)
 }
 
+// generateSchema creates a JSON Schema of the config for editors
+func generateSchema(outputFile string) error {
+	schema, err := config.GenerateSchema()
+	if err != nil {
+		return fmt.Errorf("failed to generate schema: %w", err)
+	}
+
+	return writeOutput(outputFile, schema)
+}
+
 // generateExample creates a simple example config
 func generateExample(outputFile string) error {
 	cfg := &config.Config{}
@@ -79,7 +96,7 @@ func generateExample(outputFile string) error {
 	}
 
 	gitCommit := config.GetGitCommitSHA()
-	header := fmt.Sprintf("# The Archive Configuration Example\n# Generated from commit: %s\n# Copy this file to config.yaml and customize as needed\n\n", gitCommit)
+	header := fmt.Sprintf("# yaml-language-server: $schema=./config.schema.json\n# The Archive Configuration Example\n# Generated from commit: %s\n# Copy this file to config.yaml and customize as needed\n\n", gitCommit)
 	output := header + string(yamlData)
 
 	return writeOutput(outputFile, []byte(output))
@@ -167,7 +184,12 @@ func generateTestConstantsFile(cfg *config.Config, testDir string) error {
 
 	sb.WriteString(")\n")
 
-	return os.WriteFile("internal/config/config_generated_constants.go", []byte(sb.String()), 0644)
+	formatted, err := format.Source([]byte(sb.String()))
+	if err != nil {
+		return fmt.Errorf("failed to format constants: %w", err)
+	}
+
+	return os.WriteFile("internal/config/config_generated_constants.go", formatted, 0644)
 }
 
 // generateConstants recursively generates constants for struct fields
@@ -2,10 +2,12 @@ package main
 
 import (
 	"database/sql"
+	"flag"
 	"fmt"
 	"log"
 	"time"
 
+	"github.com/debemdeboas/the-archive/internal/config"
 	"github.com/debemdeboas/the-archive/internal/db"
 	_ "github.com/mattn/go-sqlite3"
 )
@@ -38,10 +40,17 @@ func updateTimestamp(db *sql.DB, id, column string, newTime time.Time) error {
 }
 
 func main() {
+	configFile := flag.String("config", "config.yaml", "Path to the configuration file")
+	flag.Parse()
+
+	if err := config.LoadConfig(*configFile); err != nil {
+		log.Fatalf("Error loading %s: %v", *configFile, err)
+	}
+
 	log.Println("Starting timestamp migration...")
 
 	// Initialize database connection
-	database := db.NewSQLite()
+	database := db.NewSQLite(config.Get().Database)
 	if err := database.InitDB(); err != nil {
 		log.Fatalf("Error initializing database: %v", err)
 	}
@@ -1,12 +1,14 @@
 package main
 
 import (
+	"context"
 	"flag"
 	"log"
 	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/debemdeboas/the-archive/internal/config"
 	"github.com/debemdeboas/the-archive/internal/db"
 	"github.com/debemdeboas/the-archive/internal/model"
 	"github.com/debemdeboas/the-archive/internal/repository"
@@ -20,6 +22,7 @@ func main() {
 	// Define command-line flags
 	path := flag.String("path", "", "Path to the directory containing .md files")
 	ownerID := flag.String("owner-id", "", "Owner user ID for the posts")
+	configFile := flag.String("config", "config.yaml", "Path to the configuration file")
 	flag.Parse()
 
 	// Validate required flags
@@ -27,8 +30,15 @@ func main() {
 		log.Fatal("Both --path and --owner-id flags are required")
 	}
 
+	if err := config.LoadConfig(*configFile); err != nil {
+		log.Fatalf("Error loading %s: %v", *configFile, err)
+	}
+
 	// Initialize the SQLite database and ensure tables exist
-	DB := db.NewSQLite()
+	DB, err := db.New(config.Get().Database)
+	if err != nil {
+		log.Fatalf("Error creating database: %v", err)
+	}
 	DB.InitDB()
 
 	// Create a repository instance to interact with the database
@@ -41,9 +51,10 @@ func main() {
 	}
 
 	// Process each .md file
+	ctx := context.Background()
 	for _, file := range files {
 		if !file.IsDir() && strings.HasSuffix(file.Name(), ".md") {
-			err := processFile(*path, file, repo, *ownerID)
+			err := processFile(ctx, *path, file, repo, *ownerID)
 			if err != nil {
 				log.Printf("Error processing file %s: %v", file.Name(), err)
 				continue
@@ -54,7 +65,7 @@ func main() {
 }
 
 // processFile handles the migration of a single .md file to the database.
-func processFile(dirPath string, file os.DirEntry, repo repository.PostRepository, ownerID string) error {
+func processFile(ctx context.Context, dirPath string, file os.DirEntry, repo repository.PostRepository, ownerID string) error {
 	filePath := filepath.Join(dirPath, file.Name())
 
 	content, err := os.ReadFile(filePath)
@@ -98,5 +109,5 @@ func processFile(dirPath string, file os.DirEntry, repo repository.PostRepositor
 	post.Path = string(post.ID)
 
 	// Save the post to the database
-	return repo.SavePost(post)
+	return repo.SavePost(ctx, post)
 }
@@ -0,0 +1,333 @@
+// Package backup takes consistent snapshots of the SQLite database while it is
+// in use, rotates them and restores them.
+package backup
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/debemdeboas/the-archive/internal/config"
+	"github.com/debemdeboas/the-archive/internal/db"
+	"github.com/klauspost/compress/zstd"
+)
+
+const (
+	filePrefix    = "archive-"
+	fileExt       = ".db"
+	compressedExt = ".db.zst"
+	timeFormat    = "20060102-150405.000"
+
+	// restoredSuffix is appended to the name of the database replaced by a
+	// restore, which is kept in case the wrong snapshot was restored.
+	restoredSuffix = ".before-restore"
+)
+
+// ErrUnsupported is returned when taking a snapshot of a database that cannot
+// take one, such as a PostgreSQL database.
+var ErrUnsupported = errors.New("the database does not support snapshots")
+
+// Snapshotter is a database that can write a consistent copy of itself to a
+// file while it is in use.
+type Snapshotter interface {
+	Snapshot(ctx context.Context, path string) error
+}
+
+// Info describes a snapshot file.
+type Info struct {
+	Name       string    `json:"name"`
+	Path       string    `json:"-"`
+	Size       int64     `json:"size"`
+	Created    time.Time `json:"created"`
+	Compressed bool      `json:"compressed"`
+}
+
+// Create writes a snapshot of database to cfg.Dir, compressed if
+// cfg.Compress is set. Snapshots are named after the time they were taken.
+func Create(ctx context.Context, database db.DB, cfg config.DatabaseBackupConfig) (*Info, error) {
+	snapshotter, ok := database.(Snapshotter)
+	if !ok {
+		return nil, ErrUnsupported
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o755); err != nil {
+		return nil, fmt.Errorf("error creating backup directory: %w", err)
+	}
+
+	created := time.Now().UTC()
+	name := filePrefix + created.Format(timeFormat) + fileExt
+	if cfg.Compress {
+		name = filePrefix + created.Format(timeFormat) + compressedExt
+	}
+	path := filepath.Join(cfg.Dir, name)
+
+	// The snapshot is written next to its final name and only renamed once
+	// complete, so a snapshot that is listed is never partial
+	tmp := filepath.Join(cfg.Dir, "."+name+".tmp")
+	defer os.Remove(tmp)
+	snapshot := tmp
+	if cfg.Compress {
+		snapshot = filepath.Join(cfg.Dir, "."+name+".raw")
+		defer os.Remove(snapshot)
+	}
+	if err := snapshotter.Snapshot(ctx, snapshot); err != nil {
+		return nil, fmt.Errorf("error taking snapshot: %w", err)
+	}
+
+	if cfg.Compress {
+		if err := compressFile(snapshot, tmp); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := os.Rename(tmp, path); err != nil {
+		return nil, fmt.Errorf("error writing snapshot: %w", err)
+	}
+	stat, err := os.Stat(path)
+	if err != nil {
+		return nil, err
+	}
+	return &Info{Name: name, Path: path, Size: stat.Size(), Created: created, Compressed: cfg.Compress}, nil
+}
+
+// compressFile writes src compressed with zstd to dst. The file is streamed
+// through the encoder, as databases may not fit in memory.
+func compressFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("error reading snapshot: %w", err)
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("error writing snapshot: %w", err)
+	}
+	defer out.Close()
+
+	encoder, err := zstd.NewWriter(out)
+	if err != nil {
+		return fmt.Errorf("error compressing snapshot: %w", err)
+	}
+	if _, err := io.Copy(encoder, in); err != nil {
+		encoder.Close()
+		return fmt.Errorf("error compressing snapshot: %w", err)
+	}
+	if err := encoder.Close(); err != nil {
+		return fmt.Errorf("error compressing snapshot: %w", err)
+	}
+	if err := out.Close(); err != nil {
+		return fmt.Errorf("error writing snapshot: %w", err)
+	}
+	return nil
+}
+
+// List returns the snapshots in dir, newest first. A missing directory has
+// no snapshots.
+func List(dir string) ([]Info, error) {
+	entries, err := os.ReadDir(dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error listing backups: %w", err)
+	}
+
+	var snapshots []Info
+	for _, entry := range entries {
+		info, ok := parseName(entry.Name())
+		if !ok || !entry.Type().IsRegular() {
+			continue
+		}
+		stat, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		info.Path = filepath.Join(dir, info.Name)
+		info.Size = stat.Size()
+		snapshots = append(snapshots, info)
+	}
+
+	slices.SortFunc(snapshots, func(a, b Info) int {
+		return b.Created.Compare(a.Created)
+	})
+	return snapshots, nil
+}
+
+// parseName returns the snapshot a file name belongs to, if any.
+func parseName(name string) (Info, bool) {
+	stamp, ok := strings.CutPrefix(name, filePrefix)
+	if !ok {
+		return Info{}, false
+	}
+
+	info := Info{Name: name}
+	if trimmed, ok := strings.CutSuffix(stamp, compressedExt); ok {
+		stamp, info.Compressed = trimmed, true
+	} else if stamp, ok = strings.CutSuffix(stamp, fileExt); !ok {
+		return Info{}, false
+	}
+
+	created, err := time.Parse(timeFormat, stamp)
+	if err != nil {
+		return Info{}, false
+	}
+	info.Created = created
+	return info, true
+}
+
+// Rotate deletes all but the keep newest snapshots in dir and returns the
+// deleted ones. A keep of 0 keeps every snapshot.
+func Rotate(dir string, keep int) ([]Info, error) {
+	if keep <= 0 {
+		return nil, nil
+	}
+	snapshots, err := List(dir)
+	if err != nil || len(snapshots) <= keep {
+		return nil, err
+	}
+
+	var deleted []Info
+	for _, snapshot := range snapshots[keep:] {
+		if err := os.Remove(snapshot.Path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return deleted, fmt.Errorf("error deleting backup %s: %w", snapshot.Name, err)
+		}
+		deleted = append(deleted, snapshot)
+	}
+	return deleted, nil
+}
+
+// Restore replaces the SQLite database configured by cfg with the snapshot at
+// src. The snapshot is checked before anything is replaced. The replaced
+// database is kept next to it and its path returned, or "" if there was
+// none. Nothing may be using the database while it is restored.
+func Restore(ctx context.Context, src string, cfg config.DatabaseConfig) (string, error) {
+	if (cfg.Driver != "" && cfg.Driver != "sqlite") || cfg.InMemory || cfg.DSN != "" {
+		return "", errors.New("only SQLite database files configured by path can be restored")
+	}
+	path := cfg.Path
+	if path == "" {
+		path = "database.db"
+	}
+
+	// Unpack the snapshot next to the database, so that it can be renamed
+	// into place
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".restore-*")
+	if err != nil {
+		return "", fmt.Errorf("error restoring backup: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := unpack(src, tmp); err != nil {
+		tmp.Close()
+		return "", err
+	}
+	if err := tmp.Close(); err != nil {
+		return "", fmt.Errorf("error restoring backup: %w", err)
+	}
+
+	if err := check(ctx, tmp.Name()); err != nil {
+		return "", fmt.Errorf("backup %s failed the integrity check: %w", src, err)
+	}
+
+	// The files moved aside are put back if the restore fails halfway, so the
+	// current database is never left missing or split from its WAL
+	previous := ""
+	var moved []string
+	rollback := func() {
+		for _, suffix := range moved {
+			os.Rename(previous+suffix, path+suffix)
+		}
+	}
+	if _, err := os.Stat(path); err == nil {
+		previous = path + restoredSuffix
+		for _, suffix := range []string{"", "-wal", "-shm"} {
+			os.Remove(previous + suffix)
+			if err := os.Rename(path+suffix, previous+suffix); errors.Is(err, os.ErrNotExist) {
+				continue
+			} else if err != nil {
+				rollback()
+				return "", fmt.Errorf("error moving the current database aside: %w", err)
+			}
+			moved = append(moved, suffix)
+		}
+	}
+	if err := os.Rename(tmp.Name(), path); err != nil {
+		rollback()
+		return "", fmt.Errorf("error restoring backup: %w", err)
+	}
+	return previous, nil
+}
+
+// unpack copies the snapshot at src to dst, decompressing it if needed. The
+// snapshot is streamed rather than read into memory.
+func unpack(src string, dst io.Writer) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return fmt.Errorf("error reading backup: %w", err)
+	}
+	defer in.Close()
+
+	var r io.Reader = in
+	if strings.HasSuffix(src, ".zst") {
+		decoder, err := zstd.NewReader(in)
+		if err != nil {
+			return fmt.Errorf("error decompressing backup: %w", err)
+		}
+		defer decoder.Close()
+		r = decoder
+	}
+	if _, err := io.Copy(dst, r); err != nil {
+		return fmt.Errorf("error restoring backup: %w", err)
+	}
+	return nil
+}
+
+// check verifies that the database file at path is intact and that its
+// schema is not newer than this version of the archive knows.
+func check(ctx context.Context, path string) error {
+	database := db.NewSQLite(config.DatabaseConfig{Path: path})
+	if err := database.Open(); err != nil {
+		return err
+	}
+	defer database.Close()
+
+	rows, err := database.QueryContext(ctx, `PRAGMA integrity_check`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	var problems []string
+	for rows.Next() {
+		var result string
+		if err := rows.Scan(&result); err != nil {
+			return err
+		}
+		if result != "ok" {
+			problems = append(problems, result)
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(problems) > 0 {
+		return errors.New(strings.Join(problems, "; "))
+	}
+
+	migrator, err := db.NewMigrator(database.Get(), db.SQLiteDialect)
+	if err != nil {
+		return err
+	}
+	version, err := migrator.Version()
+	if err != nil {
+		return err
+	}
+	if version > migrator.Latest() {
+		return fmt.Errorf("schema version %d is newer than the latest known version %d", version, migrator.Latest())
+	}
+	return nil
+}
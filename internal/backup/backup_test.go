@@ -0,0 +1,236 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/debemdeboas/the-archive/internal/config"
+	"github.com/debemdeboas/the-archive/internal/db"
+)
+
+// newTestDB creates a migrated database file with one user in it.
+func newTestDB(t *testing.T, path string, username string) *db.SQLite {
+	t.Helper()
+	database := db.NewSQLite(config.DatabaseConfig{Path: path, JournalMode: "wal"})
+	if err := database.InitDB(); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	t.Cleanup(func() { database.Close() })
+
+	if _, err := database.Exec(`INSERT INTO users (id, username) VALUES (?, ?)`, "user-1", username); err != nil {
+		t.Fatalf("Failed to insert user: %v", err)
+	}
+	return database
+}
+
+func username(t *testing.T, path string) string {
+	t.Helper()
+	database := db.NewSQLite(config.DatabaseConfig{Path: path})
+	if err := database.Open(); err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	defer database.Close()
+
+	var name string
+	if err := database.QueryRow(`SELECT username FROM users WHERE id = ?`, "user-1").Scan(&name); err != nil {
+		t.Fatalf("Failed to read user: %v", err)
+	}
+	return name
+}
+
+func TestCreate(t *testing.T) {
+	ctx := context.Background()
+	database := newTestDB(t, filepath.Join(t.TempDir(), "archive.db"), "original")
+
+	for _, compress := range []bool{false, true} {
+		cfg := config.DatabaseBackupConfig{Dir: filepath.Join(t.TempDir(), "backups"), Compress: compress}
+		info, err := Create(ctx, database, cfg)
+		if err != nil {
+			t.Fatalf("Create failed: %v", err)
+		}
+
+		if info.Compressed != compress || !strings.HasSuffix(info.Name, map[bool]string{false: ".db", true: ".db.zst"}[compress]) {
+			t.Errorf("Unexpected snapshot %+v", info)
+		}
+		if stat, err := os.Stat(info.Path); err != nil || stat.Size() != info.Size || info.Size == 0 {
+			t.Errorf("Expected a %d byte snapshot at %s, got %v", info.Size, info.Path, err)
+		}
+
+		// Only the finished snapshot is left behind
+		entries, _ := os.ReadDir(cfg.Dir)
+		if len(entries) != 1 {
+			t.Errorf("Expected 1 file in the backup directory, got %d", len(entries))
+		}
+	}
+
+	t.Run("Databases without snapshots", func(t *testing.T) {
+		postgres := db.NewPostgres(config.DatabaseConfig{})
+		if _, err := Create(ctx, postgres, config.DatabaseBackupConfig{Dir: t.TempDir()}); !errors.Is(err, ErrUnsupported) {
+			t.Errorf("Expected ErrUnsupported, got %v", err)
+		}
+	})
+}
+
+func TestListAndRotate(t *testing.T) {
+	dir := t.TempDir()
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	var names []string
+	for i := range 5 {
+		ext := fileExt
+		if i%2 == 1 {
+			ext = compressedExt
+		}
+		name := filePrefix + start.Add(time.Duration(i)*time.Hour).Format(timeFormat) + ext
+		names = append(names, name)
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("snapshot"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, other := range []string{"notes.txt", "archive-latest.db", "." + names[0] + ".tmp"} {
+		os.WriteFile(filepath.Join(dir, other), nil, 0o600)
+	}
+
+	snapshots, err := List(dir)
+	if err != nil {
+		t.Fatalf("List failed: %v", err)
+	}
+	if len(snapshots) != 5 {
+		t.Fatalf("Expected 5 snapshots, got %+v", snapshots)
+	}
+	for i, snapshot := range snapshots {
+		if snapshot.Name != names[4-i] {
+			t.Errorf("Expected snapshot %d to be %s, got %s", i, names[4-i], snapshot.Name)
+		}
+		if snapshot.Compressed != strings.HasSuffix(snapshot.Name, ".zst") || snapshot.Size != int64(len("snapshot")) {
+			t.Errorf("Unexpected snapshot %+v", snapshot)
+		}
+	}
+
+	t.Run("Keeping every snapshot", func(t *testing.T) {
+		if deleted, err := Rotate(dir, 0); err != nil || len(deleted) != 0 {
+			t.Errorf("Expected nothing to be deleted, got %+v (%v)", deleted, err)
+		}
+	})
+
+	t.Run("The oldest snapshots are deleted", func(t *testing.T) {
+		deleted, err := Rotate(dir, 2)
+		if err != nil {
+			t.Fatalf("Rotate failed: %v", err)
+		}
+		if len(deleted) != 3 || deleted[0].Name != names[2] || deleted[2].Name != names[0] {
+			t.Errorf("Expected the 3 oldest snapshots to be deleted, got %+v", deleted)
+		}
+		if left, _ := List(dir); len(left) != 2 || left[0].Name != names[4] || left[1].Name != names[3] {
+			t.Errorf("Expected the 2 newest snapshots to be kept, got %+v", left)
+		}
+		if _, err := os.Stat(filepath.Join(dir, "notes.txt")); err != nil {
+			t.Errorf("Expected other files to be kept: %v", err)
+		}
+	})
+
+	t.Run("Missing directory", func(t *testing.T) {
+		if snapshots, err := List(filepath.Join(dir, "missing")); err != nil || len(snapshots) != 0 {
+			t.Errorf("Expected no snapshots, got %+v (%v)", snapshots, err)
+		}
+	})
+}
+
+func TestRestore(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	source := newTestDB(t, filepath.Join(dir, "source.db"), "from backup")
+
+	compressed, err := Create(ctx, source, config.DatabaseBackupConfig{Dir: filepath.Join(dir, "backups"), Compress: true})
+	if err != nil {
+		t.Fatalf("Create failed: %v", err)
+	}
+
+	target := filepath.Join(dir, "target.db")
+	newTestDB(t, target, "current").Close()
+	cfg := config.DatabaseConfig{Path: target}
+
+	t.Run("Corrupt backups are rejected", func(t *testing.T) {
+		corrupt := filepath.Join(dir, "corrupt.db")
+		os.WriteFile(corrupt, []byte(strings.Repeat("not a database ", 512)), 0o600)
+
+		if _, err := Restore(ctx, corrupt, cfg); err == nil {
+			t.Fatal("Expected an error")
+		}
+		if got := username(t, target); got != "current" {
+			t.Errorf("Expected the current database to be kept, got %q", got)
+		}
+	})
+
+	t.Run("Backups from newer versions are rejected", func(t *testing.T) {
+		newer := filepath.Join(dir, "newer.db")
+		database := newTestDB(t, newer, "newer")
+		database.Exec(`INSERT INTO schema_migrations (version, name) VALUES (?, ?)`, 9999, "future")
+		database.Close()
+
+		if _, err := Restore(ctx, newer, cfg); err == nil || !strings.Contains(err.Error(), "newer") {
+			t.Fatalf("Expected the schema version to be rejected, got %v", err)
+		}
+		if got := username(t, target); got != "current" {
+			t.Errorf("Expected the current database to be kept, got %q", got)
+		}
+	})
+
+	t.Run("Compressed backup", func(t *testing.T) {
+		previous, err := Restore(ctx, compressed.Path, cfg)
+		if err != nil {
+			t.Fatalf("Restore failed: %v", err)
+		}
+		if got := username(t, target); got != "from backup" {
+			t.Errorf("Expected the restored database, got %q", got)
+		}
+		if previous != target+restoredSuffix || username(t, previous) != "current" {
+			t.Errorf("Expected the replaced database to be kept at %s, got %s", target+restoredSuffix, previous)
+		}
+	})
+
+	t.Run("Missing database", func(t *testing.T) {
+		path := filepath.Join(dir, "new.db")
+		previous, err := Restore(ctx, compressed.Path, config.DatabaseConfig{Path: path})
+		if err != nil || previous != "" {
+			t.Fatalf("Expected nothing to be replaced, got %q (%v)", previous, err)
+		}
+		if got := username(t, path); got != "from backup" {
+			t.Errorf("Expected the restored database, got %q", got)
+		}
+	})
+
+	t.Run("Failed restores leave the database in place", func(t *testing.T) {
+		path := filepath.Join(dir, "failed.db")
+		newTestDB(t, path, "current").Close()
+		if err := os.WriteFile(path+"-wal", nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		// A non-empty directory where the WAL is moved to makes the move fail
+		if err := os.MkdirAll(filepath.Join(path+restoredSuffix+"-wal", "x"), 0o700); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := Restore(ctx, compressed.Path, config.DatabaseConfig{Path: path}); err == nil {
+			t.Fatal("Expected an error restoring over a blocked WAL")
+		}
+		if _, err := os.Stat(path + "-wal"); err != nil {
+			t.Errorf("Expected the WAL to be kept: %v", err)
+		}
+		if got := username(t, path); got != "current" {
+			t.Errorf("Expected the current database to be kept, got %q", got)
+		}
+	})
+
+	t.Run("Only SQLite files can be restored", func(t *testing.T) {
+		for _, cfg := range []config.DatabaseConfig{{Driver: "postgres"}, {InMemory: true}, {DSN: "file:x.db"}} {
+			if _, err := Restore(ctx, compressed.Path, cfg); err == nil {
+				t.Errorf("Expected an error restoring into %+v", cfg)
+			}
+		}
+	})
+}
@@ -1,4 +1,4 @@
-// Code generated by generate-config --update-tests from commit 4be29492. DO NOT EDIT.
+// Code generated by generate-config --update-tests from commit f2abc8e7. DO NOT EDIT.
 package config
 
 // Test constants for default values
@@ -9,14 +9,30 @@ const (
 	DefaultSiteTagline                         = "Welcome to The Archive"
 	DefaultServerHost                          = "0.0.0.0"
 	DefaultServerPort                          = "12600"
+	DefaultServerReadTimeout                   = 30
+	DefaultServerWriteTimeout                  = 60
+	DefaultServerIdleTimeout                   = 120
+	DefaultServerShutdownTimeout               = 10
+	DefaultServerCompression                   = true
+	DefaultServerTLSEnabled                    = false
+	DefaultServerTLSHSTSMaxAge                 = 31536000
 	DefaultThemeDefault                        = "dark"
 	DefaultThemeAllowSwitching                 = true
 	DefaultThemeSyntaxHighlightingDefaultDark  = "gruvbox"
 	DefaultThemeSyntaxHighlightingDefaultLight = "catppuccin-latte"
 	DefaultPostsReloadTimeout                  = 10
 	DefaultPostsPostsPerPage                   = 50
+	DefaultPostsRepository                     = "db"
+	DefaultPostsDBCompression                  = "zstd"
+	DefaultPostsFSPath                         = "posts"
+	DefaultPostsS3Region                       = "auto"
+	DefaultPostsS3UsePathStyle                 = false
+	DefaultPostsGitPath                        = "posts"
+	DefaultPostsGitBranch                      = "main"
+	DefaultPostsGitAuthorEmailDomain           = "localhost"
 	DefaultFeaturesAuthenticationEnabled       = true
 	DefaultFeaturesAuthenticationType          = "ed25519"
+	DefaultFeaturesAuthenticationPublicKey     = "env:ED25519_PUBKEY"
 	DefaultFeaturesEditorEnabled               = true
 	DefaultFeaturesEditorLivePreview           = true
 	DefaultFeaturesEditorEnableDrafts          = false
@@ -24,4 +40,35 @@ const (
 	DefaultFeaturesCommentsEnabled             = false
 	DefaultMetaFavicon                         = "/static/favicon.ico"
 	DefaultLoggingLevel                        = "info"
+	DefaultProfileEnabled                      = true
+	DefaultProfileName                         = "rafael almeida de bem"
+	DefaultProfileImageURL                     = "https://avatars.githubusercontent.com/u/35022953?v=4"
+	DefaultProfileCVURL                        = "https://cv.debem.dev"
+	DefaultProfileEmail                        = "rafael.bem@debem.dev"
+	DefaultProfileContentFile                  = "profile.md"
+	DefaultMediaMaxUploadSize                  = 10
+	DefaultMediaMaxWidth                       = 2560
+	DefaultMediaMaxHeight                      = 2560
+	DefaultMediaMaxMegapixels                  = 50
+	DefaultMediaJPEGQuality                    = 85
+	DefaultMediaSizes                          = "(max-width: 800px) 100vw, 800px"
+	DefaultMediaLazyLoading                    = true
+	DefaultMediaStorageType                    = "fs"
+	DefaultMediaStoragePath                    = "static/uploads"
+	DefaultMediaStorageServeMode               = "proxy"
+	DefaultMediaStorageSignedURLExpiry         = 900
+	DefaultMediaStorageS3Region                = "auto"
+	DefaultMediaStorageS3UsePathStyle          = false
+	DefaultDatabaseDriver                      = "sqlite"
+	DefaultDatabasePath                        = "database.db"
+	DefaultDatabaseInMemory                    = false
+	DefaultDatabaseJournalMode                 = "wal"
+	DefaultDatabaseBusyTimeout                 = 5000
+	DefaultDatabaseSynchronous                 = "normal"
+	DefaultDatabaseMaxOpenConns                = 0
+	DefaultDatabaseMaxIdleConns                = 2
+	DefaultDatabaseConnMaxLifetime             = 0
+	DefaultDatabaseBackupDir                   = "backups"
+	DefaultDatabaseBackupCompress              = true
+	DefaultDatabaseBackupKeep                  = 7
 )
@@ -88,6 +88,24 @@ func TestInvalidConfigValidation(t *testing.T) {
 			expectError: true,
 			errorText:   "unsupported configuration version",
 		},
+		{
+			name:        "Invalid theme",
+			filename:    "testdata/invalid_theme.yaml",
+			expectError: true,
+			errorText:   "testdata/invalid_theme.yaml:6:12: theme.default: \"invalid_theme\" must be one of light, dark",
+		},
+		{
+			name:        "Invalid log level",
+			filename:    "testdata/invalid_log_level.yaml",
+			expectError: true,
+			errorText:   "logging.level: \"invalid_level\" must be one of",
+		},
+		{
+			name:        "Invalid auth type",
+			filename:    "testdata/invalid_auth_type.yaml",
+			expectError: true,
+			errorText:   "features.authentication.type: \"invalid_auth_type\" must be one of ed25519",
+		},
 		{
 			name:        "Valid defaults file",
 			filename:    "testdata/defaults.yaml",
@@ -97,8 +115,8 @@ func TestInvalidConfigValidation(t *testing.T) {
 
 	for _, tc := range testCases {
 		t.Run(tc.name, func(t *testing.T) {
-			originalAppConfig := AppConfig
-			defer func() { AppConfig = originalAppConfig }()
+			originalConfig := Get()
+			defer func() { Set(originalConfig) }()
 
 			err := LoadConfig(tc.filename)
 
@@ -204,27 +204,70 @@ func TestLoadConfig(t *testing.T) {
 	SetLogger(logger)
 
 	t.Run("Load non-existent config file", func(t *testing.T) {
-		originalAppConfig := AppConfig
-		defer func() { AppConfig = originalAppConfig }()
+		originalConfig := Get()
+		defer func() { Set(originalConfig) }()
 
 		err := LoadConfig("non-existent-config.yaml")
 		if err != nil {
 			t.Errorf("Expected no error for non-existent config file, got %v", err)
 		}
 
-		if AppConfig == nil {
-			t.Fatal("Expected AppConfig to be set with defaults")
+		if Get() == nil {
+			t.Fatal("Expected the configuration to be set with defaults")
 		}
 
 		// Verify defaults were applied
-		if AppConfig.Site.Name != "The Archive" {
-			t.Errorf("Expected default site name, got %q", AppConfig.Site.Name)
+		if Get().Site.Name != "The Archive" {
+			t.Errorf("Expected default site name, got %q", Get().Site.Name)
+		}
+	})
+
+	t.Run("Defaults apply to each repository source", func(t *testing.T) {
+		originalConfig := Get()
+		defer func() { Set(originalConfig) }()
+
+		configContent := `
+repositories:
+  writable: main
+  sources:
+    - name: main
+      root: true
+    - name: notes
+      type: git
+      read_only: true
+      git:
+        branch: published
+`
+		tempFile, err := os.CreateTemp("", "test-config-*.yaml")
+		if err != nil {
+			t.Fatalf(failedToCreateTempFile, err)
+		}
+		defer os.Remove(tempFile.Name())
+
+		if _, err := tempFile.WriteString(configContent); err != nil {
+			t.Fatalf(failedToWriteConfigCont, err)
+		}
+		tempFile.Close()
+
+		if err := LoadConfig(tempFile.Name()); err != nil {
+			t.Fatalf("Expected no error loading config, got %v", err)
+		}
+
+		sources := Get().Repositories.Sources
+		if len(sources) != 2 {
+			t.Fatalf("Expected 2 sources, got %d", len(sources))
+		}
+		if sources[0].Type != DefaultPostsRepository || !sources[0].Root {
+			t.Errorf("Expected the first source to default to the db type, got %+v", sources[0])
+		}
+		if sources[1].Git.Branch != "published" || sources[1].Git.Path != DefaultPostsGitPath || !sources[1].ReadOnly {
+			t.Errorf("Expected defaults next to explicit values, got %+v", sources[1])
 		}
 	})
 
 	t.Run("Load valid config file", func(t *testing.T) {
-		originalAppConfig := AppConfig
-		defer func() { AppConfig = originalAppConfig }()
+		originalConfig := Get()
+		defer func() { Set(originalConfig) }()
 
 		// Create temporary config file
 		configContent := `
@@ -256,42 +299,42 @@ posts:
 			t.Fatalf("Expected no error loading valid config, got %v", err)
 		}
 
-		if AppConfig == nil {
-			t.Fatal("Expected AppConfig to be set")
+		if Get() == nil {
+			t.Fatal("Expected the configuration to be set")
 		}
 
 		// Verify loaded values
-		if AppConfig.Site.Name != "Test Blog" {
-			t.Errorf("Expected site name 'Test Blog', got %q", AppConfig.Site.Name)
+		if Get().Site.Name != "Test Blog" {
+			t.Errorf("Expected site name 'Test Blog', got %q", Get().Site.Name)
 		}
-		if AppConfig.Site.Description != "Test Description" {
-			t.Errorf("Expected description 'Test Description', got %q", AppConfig.Site.Description)
+		if Get().Site.Description != "Test Description" {
+			t.Errorf("Expected description 'Test Description', got %q", Get().Site.Description)
 		}
-		if AppConfig.Server.Host != "127.0.0.1" {
-			t.Errorf("Expected host '127.0.0.1', got %q", AppConfig.Server.Host)
+		if Get().Server.Host != "127.0.0.1" {
+			t.Errorf("Expected host '127.0.0.1', got %q", Get().Server.Host)
 		}
-		if AppConfig.Server.Port != "8080" {
-			t.Errorf("Expected port '8080', got %q", AppConfig.Server.Port)
+		if Get().Server.Port != "8080" {
+			t.Errorf("Expected port '8080', got %q", Get().Server.Port)
 		}
-		if AppConfig.Theme.Default != "light" {
-			t.Errorf("Expected theme 'light', got %q", AppConfig.Theme.Default)
+		if Get().Theme.Default != "light" {
+			t.Errorf("Expected theme 'light', got %q", Get().Theme.Default)
 		}
-		if AppConfig.Theme.AllowSwitching {
+		if Get().Theme.AllowSwitching {
 			t.Error("Expected theme switching to be disabled")
 		}
-		if AppConfig.Posts.PostsPerPage != 25 {
-			t.Errorf("Expected posts per page 25, got %d", AppConfig.Posts.PostsPerPage)
+		if Get().Posts.PostsPerPage != 25 {
+			t.Errorf("Expected posts per page 25, got %d", Get().Posts.PostsPerPage)
 		}
 
 		// Verify defaults were still applied for unspecified fields
-		if AppConfig.Site.Tagline != "Welcome to The Archive" {
-			t.Errorf("Expected default tagline, got %q", AppConfig.Site.Tagline)
+		if Get().Site.Tagline != "Welcome to The Archive" {
+			t.Errorf("Expected default tagline, got %q", Get().Site.Tagline)
 		}
 	})
 
 	t.Run("Load invalid YAML file", func(t *testing.T) {
-		originalAppConfig := AppConfig
-		defer func() { AppConfig = originalAppConfig }()
+		originalConfig := Get()
+		defer func() { Set(originalConfig) }()
 
 		// Create temporary invalid config file
 		invalidContent := `
@@ -320,8 +363,8 @@ site:
 	})
 
 	t.Run("Partial config with defaults", func(t *testing.T) {
-		originalAppConfig := AppConfig
-		defer func() { AppConfig = originalAppConfig }()
+		originalConfig := Get()
+		defer func() { Set(originalConfig) }()
 
 		// Create config with only some fields
 		configContent := `
@@ -348,19 +391,19 @@ features:
 		}
 
 		// Verify specified values
-		if AppConfig.Site.Name != "Partial Config" {
-			t.Errorf("Expected site name 'Partial Config', got %q", AppConfig.Site.Name)
+		if Get().Site.Name != "Partial Config" {
+			t.Errorf("Expected site name 'Partial Config', got %q", Get().Site.Name)
 		}
-		if AppConfig.Features.Authentication.Enabled {
+		if Get().Features.Authentication.Enabled {
 			t.Error("Expected authentication to be disabled")
 		}
 
 		// Verify defaults were applied for unspecified fields
-		if AppConfig.Site.Description != "A personal blog and knowledge archive" {
-			t.Errorf("Expected default description, got %q", AppConfig.Site.Description)
+		if Get().Site.Description != "A personal blog and knowledge archive" {
+			t.Errorf("Expected default description, got %q", Get().Site.Description)
 		}
-		if AppConfig.Server.Port != "12600" {
-			t.Errorf("Expected default port, got %q", AppConfig.Server.Port)
+		if Get().Server.Port != "12600" {
+			t.Errorf("Expected default port, got %q", Get().Server.Port)
 		}
 	})
 }
@@ -535,6 +578,42 @@ func TestSliceDefaults(t *testing.T) {
 		}
 	})
 
+	t.Run("Int slice default", func(t *testing.T) {
+		type TestStruct struct {
+			Widths []int `default:"480, 960,1600"`
+			Bad    []int `default:"1,two,3"`
+		}
+
+		test := &TestStruct{}
+		applyDefaults(test)
+
+		expected := []int{480, 960, 1600}
+		if !reflect.DeepEqual(test.Widths, expected) {
+			t.Errorf("Expected int items %v, got %v", expected, test.Widths)
+		}
+		if test.Bad != nil {
+			t.Errorf("Expected invalid int slice default to be skipped, got %v", test.Bad)
+		}
+	})
+
+	t.Run("Slice of structs", func(t *testing.T) {
+		type Item struct {
+			Name    string `default:"unnamed"`
+			Enabled bool   `default:"true"`
+		}
+		type TestStruct struct {
+			Items []Item
+		}
+
+		test := &TestStruct{Items: []Item{{}, {Name: "custom"}}}
+		applyDefaults(test)
+
+		expected := []Item{{Name: "unnamed", Enabled: true}, {Name: "custom", Enabled: true}}
+		if !reflect.DeepEqual(test.Items, expected) {
+			t.Errorf("Expected defaults on each element %v, got %v", expected, test.Items)
+		}
+	})
+
 	t.Run("Non-empty slice should not be overwritten", func(t *testing.T) {
 		type TestStruct struct {
 			Items []string `default:"default1,default2"`
@@ -572,6 +651,10 @@ func TestComplexNestedStructDefaults(t *testing.T) {
 		{"Features", func() bool { return config.Features.Authentication.Type != "" }},
 		{"Meta", func() bool { return len(config.Meta.Keywords) > 0 }},
 		{"Logging", func() bool { return config.Logging.Level != "" }},
+		{"Media", func() bool { return len(config.Media.ResponsiveWidths) > 0 }},
+		{"Database", func() bool { return config.Database.Path != "" && config.Database.JournalMode != "" }},
+		{"Database backups", func() bool { return config.Database.Backup.Dir != "" && config.Database.Backup.Keep > 0 }},
+		{"Posts database", func() bool { return config.Posts.DB.Compression == "zstd" }},
 	}
 
 	for _, section := range sections {
@@ -0,0 +1,223 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// EnvPrefix starts the names of the environment variables that override
+// configuration fields.
+const EnvPrefix = "ARCHIVE_"
+
+var errUnsupportedType = errors.New("unsupported field type")
+
+// walkFields calls visit for every field of the struct config points to that
+// holds a value, descending into nested structs and into the elements of
+// slices of structs. path is the dotted YAML path of the field, such as
+// server.port or repositories.sources.0.name.
+func walkFields(config any, prefix string, visit func(field reflect.Value, fieldType reflect.StructField, path string)) {
+	v := reflect.ValueOf(config)
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+
+	if v.Kind() != reflect.Struct {
+		return
+	}
+
+	t := v.Type()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		fieldType := t.Field(i)
+
+		if !field.IsValid() || !field.CanSet() {
+			continue
+		}
+
+		name := strings.Split(fieldType.Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			name = strings.ToLower(fieldType.Name)
+		}
+		path := name
+		if prefix != "" {
+			path = prefix + "." + name
+		}
+
+		// Recursively walk nested structs
+		if field.Kind() == reflect.Struct {
+			walkFields(field.Addr().Interface(), path, visit)
+			continue
+		}
+
+		// And each element of a slice of structs
+		if field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.Struct {
+			for j := 0; j < field.Len(); j++ {
+				walkFields(field.Index(j).Addr().Interface(), fmt.Sprintf("%s.%d", path, j), visit)
+			}
+			continue
+		}
+
+		visit(field, fieldType, path)
+	}
+}
+
+// setField parses value into field.
+func setField(field reflect.Value, value string) error {
+	switch field.Kind() {
+	case reflect.String:
+		field.SetString(value)
+	case reflect.Bool:
+		val, err := strconv.ParseBool(value)
+		if err != nil {
+			return err
+		}
+		field.SetBool(val)
+	case reflect.Int:
+		val, err := strconv.ParseInt(value, 10, 64)
+		if err != nil {
+			return err
+		}
+		field.SetInt(val)
+	case reflect.Float64:
+		val, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return err
+		}
+		field.SetFloat(val)
+	case reflect.Slice:
+		return setSlice(field, value)
+	default:
+		return fmt.Errorf("%w %s", errUnsupportedType, field.Kind())
+	}
+	return nil
+}
+
+// setSlice parses a comma-separated value into a string or int slice. An
+// empty value is an empty slice.
+func setSlice(field reflect.Value, value string) error {
+	var parts []string
+	if value != "" {
+		parts = strings.Split(value, ",")
+	}
+	slice := reflect.MakeSlice(field.Type(), len(parts), len(parts))
+
+	switch field.Type().Elem().Kind() {
+	case reflect.String:
+		for j, part := range parts {
+			slice.Index(j).SetString(strings.TrimSpace(part))
+		}
+	case reflect.Int:
+		for j, part := range parts {
+			val, err := strconv.ParseInt(strings.TrimSpace(part), 10, 64)
+			if err != nil {
+				return err
+			}
+			slice.Index(j).SetInt(val)
+		}
+	default:
+		return fmt.Errorf("%w []%s", errUnsupportedType, field.Type().Elem().Kind())
+	}
+
+	field.Set(slice)
+	return nil
+}
+
+// EnvName returns the environment variable overriding the field at the
+// dotted path, such as ARCHIVE_SERVER_PORT for server.port.
+func EnvName(path string) string {
+	return EnvPrefix + strings.ToUpper(strings.ReplaceAll(path, ".", "_"))
+}
+
+// applyEnv overrides the fields of config with the environment variables in
+// environ, as returned by os.Environ, that are named after them.
+func applyEnv(config any, environ []string) error {
+	vars := make(map[string]string)
+	for _, kv := range environ {
+		if name, value, ok := strings.Cut(kv, "="); ok && strings.HasPrefix(name, EnvPrefix) {
+			vars[name] = value
+		}
+	}
+
+	var errs []error
+	walkFields(config, "", func(field reflect.Value, _ reflect.StructField, path string) {
+		name := EnvName(path)
+		value, ok := vars[name]
+		if !ok {
+			return
+		}
+		delete(vars, name)
+		if err := setField(field, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", name, err))
+		}
+	})
+
+	for name := range vars {
+		configLogger.Warn().Str("name", name).Msg("Environment variable does not match any configuration field")
+	}
+	return errors.Join(errs...)
+}
+
+// Overrides sets configuration fields by their dotted path, such as
+// server.port. As a flag.Value it is set with path=value, so that the flag
+// can be repeated.
+type Overrides map[string]string
+
+func (o Overrides) String() string {
+	var pairs []string
+	for path, value := range o {
+		pairs = append(pairs, path+"="+value)
+	}
+	slices.Sort(pairs)
+	return strings.Join(pairs, ",")
+}
+
+func (o Overrides) Set(s string) error {
+	path, value, ok := strings.Cut(s, "=")
+	if !ok || path == "" {
+		return fmt.Errorf("expected section.field=value, got %q", s)
+	}
+	o[path] = value
+	return nil
+}
+
+// apply sets the fields of config to the overrides. Every override must
+// name a field.
+func (o Overrides) apply(config any) error {
+	pending := maps.Clone(o)
+
+	var errs []error
+	walkFields(config, "", func(field reflect.Value, _ reflect.StructField, path string) {
+		value, ok := pending[path]
+		if !ok {
+			return
+		}
+		delete(pending, path)
+		if err := setField(field, value); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", path, err))
+		}
+	})
+
+	unknown := slices.Sorted(maps.Keys(pending))
+	for _, path := range unknown {
+		errs = append(errs, fmt.Errorf("unknown configuration field %s", path))
+	}
+	return errors.Join(errs...)
+}
+
+// applyOverrides applies the environment and then overrides on top of
+// config, and checks that the version is still supported.
+func applyOverrides(config *Config, overrides Overrides) error {
+	if err := applyEnv(config, os.Environ()); err != nil {
+		return fmt.Errorf("invalid environment override: %w", err)
+	}
+	if err := overrides.apply(config); err != nil {
+		return fmt.Errorf("invalid override: %w", err)
+	}
+	return ValidateVersion(config.Version)
+}
@@ -0,0 +1,202 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+// writeConfig writes a configuration file and returns its path.
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf(failedToWriteConfigCont, err)
+	}
+	return path
+}
+
+func TestEnvName(t *testing.T) {
+	testCases := map[string]string{
+		"server.port":                      "ARCHIVE_SERVER_PORT",
+		"posts.reload_timeout":             "ARCHIVE_POSTS_RELOAD_TIMEOUT",
+		"database.backup.keep":             "ARCHIVE_DATABASE_BACKUP_KEEP",
+		"repositories.sources.0.read_only": "ARCHIVE_REPOSITORIES_SOURCES_0_READ_ONLY",
+	}
+	for path, expected := range testCases {
+		if got := EnvName(path); got != expected {
+			t.Errorf("EnvName(%q) = %q, expected %q", path, got, expected)
+		}
+	}
+
+	t.Run("Every field has its own variable", func(t *testing.T) {
+		seen := map[string]string{}
+		walkFields(&Config{}, "", func(_ reflect.Value, _ reflect.StructField, path string) {
+			name := EnvName(path)
+			if other, ok := seen[name]; ok {
+				t.Errorf("%s and %s are both set by %s", other, path, name)
+			}
+			seen[name] = path
+		})
+		if len(seen) < 50 {
+			t.Errorf("Expected every field to be walked, got %d", len(seen))
+		}
+	})
+}
+
+func TestApplyEnv(t *testing.T) {
+	SetLogger(zerolog.Nop())
+
+	cfg := &Config{Repositories: RepositoriesConfig{Sources: []PostSourceConfig{{}, {}}}}
+	applyDefaults(cfg)
+	err := applyEnv(cfg, []string{
+		"ARCHIVE_SITE_NAME=From the environment",
+		"ARCHIVE_POSTS_POSTS_PER_PAGE=7",
+		"ARCHIVE_FEATURES_EDITOR_ENABLE_DRAFTS=true",
+		"ARCHIVE_META_KEYWORDS=go, notes",
+		"ARCHIVE_MEDIA_RESPONSIVE_WIDTHS=",
+		"ARCHIVE_REPOSITORIES_SOURCES_1_NAME=notes",
+		"ARCHIVE_SOMETHING_ELSE=ignored",
+		"HOME=/root",
+	})
+	if err != nil {
+		t.Fatalf("applyEnv failed: %v", err)
+	}
+
+	if cfg.Site.Name != "From the environment" || cfg.Posts.PostsPerPage != 7 || !cfg.Features.Editor.EnableDrafts {
+		t.Errorf("Expected the scalar fields to be overridden, got %+v %+v %+v", cfg.Site, cfg.Posts, cfg.Features.Editor)
+	}
+	if !reflect.DeepEqual(cfg.Meta.Keywords, []string{"go", "notes"}) {
+		t.Errorf("Expected keywords [go notes], got %v", cfg.Meta.Keywords)
+	}
+	if cfg.Media.ResponsiveWidths == nil || len(cfg.Media.ResponsiveWidths) != 0 {
+		t.Errorf("Expected an empty list of widths, got %#v", cfg.Media.ResponsiveWidths)
+	}
+	if cfg.Repositories.Sources[0].Name != "" || cfg.Repositories.Sources[1].Name != "notes" {
+		t.Errorf("Expected the second source to be named, got %+v", cfg.Repositories.Sources)
+	}
+
+	t.Run("Invalid values", func(t *testing.T) {
+		err := applyEnv(cfg, []string{"ARCHIVE_POSTS_RELOAD_TIMEOUT=soon", "ARCHIVE_MEDIA_RESPONSIVE_WIDTHS=480,big"})
+		if err == nil {
+			t.Fatal("Expected an error")
+		}
+		for _, name := range []string{"ARCHIVE_POSTS_RELOAD_TIMEOUT", "ARCHIVE_MEDIA_RESPONSIVE_WIDTHS"} {
+			if !strings.Contains(err.Error(), name) {
+				t.Errorf("Expected the error to name %s, got %v", name, err)
+			}
+		}
+	})
+}
+
+func TestOverrides(t *testing.T) {
+	overrides := Overrides{}
+	for _, s := range []string{"server.port=9000", "site.name=a=b"} {
+		if err := overrides.Set(s); err != nil {
+			t.Fatalf("Set(%q) failed: %v", s, err)
+		}
+	}
+	if overrides.String() != "server.port=9000,site.name=a=b" {
+		t.Errorf("Unexpected overrides %s", overrides)
+	}
+	if err := overrides.Set("server.port"); err == nil {
+		t.Error("Expected an error without a value")
+	}
+
+	cfg := &Config{}
+	applyDefaults(cfg)
+	if err := overrides.apply(cfg); err != nil {
+		t.Fatalf("apply failed: %v", err)
+	}
+	if cfg.Server.Port != "9000" || cfg.Site.Name != "a=b" {
+		t.Errorf("Expected the overrides to be applied, got %+v %+v", cfg.Server, cfg.Site)
+	}
+
+	if err := (Overrides{"server.prot": "1"}).apply(cfg); err == nil || !strings.Contains(err.Error(), "unknown configuration field server.prot") {
+		t.Errorf("Expected an unknown field error, got %v", err)
+	}
+}
+
+func TestLoadConfigPrecedence(t *testing.T) {
+	SetLogger(zerolog.Nop())
+	originalConfig := Get()
+	defer func() { Set(originalConfig) }()
+
+	path := writeConfig(t, `
+site:
+  name: "From the file"
+  description: "From the file"
+  tagline: "From the file"
+`)
+	t.Setenv("ARCHIVE_SITE_DESCRIPTION", "From the environment")
+	t.Setenv("ARCHIVE_SITE_TAGLINE", "From the environment")
+
+	if err := LoadConfigWithOverrides(path, Overrides{"site.tagline": "From the flag"}); err != nil {
+		t.Fatalf("LoadConfigWithOverrides failed: %v", err)
+	}
+
+	got := map[string]string{
+		"default":     Get().Server.Port,
+		"file":        Get().Site.Name,
+		"environment": Get().Site.Description,
+		"flag":        Get().Site.Tagline,
+	}
+	want := map[string]string{
+		"default":     DefaultServerPort,
+		"file":        "From the file",
+		"environment": "From the environment",
+		"flag":        "From the flag",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	t.Run("Without a file", func(t *testing.T) {
+		if err := LoadConfig(filepath.Join(t.TempDir(), "missing.yaml")); err != nil {
+			t.Fatalf("LoadConfig failed: %v", err)
+		}
+		if Get().Site.Description != "From the environment" || Get().Site.Name != DefaultSiteName {
+			t.Errorf("Expected the environment over the defaults, got %+v", Get().Site)
+		}
+	})
+
+	t.Run("Overridden versions are validated", func(t *testing.T) {
+		if err := LoadConfigWithOverrides(path, Overrides{"version": "9.9"}); err == nil {
+			t.Error("Expected an unsupported version error")
+		}
+	})
+}
+
+func TestValidateFile(t *testing.T) {
+	SetLogger(zerolog.Nop())
+	originalConfig := Get()
+	defer func() { Set(originalConfig) }()
+	Set(nil)
+
+	valid := writeConfig(t, "server:\n  port: \"8080\"\n")
+	if err := ValidateFile(valid, nil); err != nil {
+		t.Errorf("Expected %s to be valid, got %v", valid, err)
+	}
+	if Get() != nil {
+		t.Error("Expected the validated configuration not to be used")
+	}
+
+	invalid := map[string]struct {
+		path      string
+		overrides Overrides
+	}{
+		"Missing file":        {filepath.Join(t.TempDir(), "missing.yaml"), nil},
+		"Malformed YAML":      {writeConfig(t, "server: [\n"), nil},
+		"Unsupported version": {writeConfig(t, "version: \"2.0\"\n"), nil},
+		"Invalid override":    {valid, Overrides{"posts.posts_per_page": "many"}},
+	}
+	for name, tc := range invalid {
+		if err := ValidateFile(tc.path, tc.overrides); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
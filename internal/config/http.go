@@ -5,6 +5,10 @@ const (
 	HETag         = "ETag"
 	HCacheControl = "Cache-Control"
 
+	HContentLength = "Content-Length"
+	HLastModified  = "Last-Modified"
+	HIfNoneMatch   = "If-None-Match"
+
 	HHxRedirect = "Hx-Redirect"
 	HHxRefresh  = "Hx-Refresh"
 
@@ -0,0 +1,443 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// CurrentVersion is the configuration version read by this build. Files of
+// older versions are migrated to it when they are loaded.
+const CurrentVersion = "1.0"
+
+// legacyVersion is the version of configuration files that do not set one.
+const legacyVersion = "1.0"
+
+// Migration upgrades configuration files from the version From to the
+// version To by editing their YAML node tree with the methods of Document,
+// which report what they change.
+type Migration struct {
+	From        string
+	To          string
+	Description string
+	Apply       func(doc *Document) error
+}
+
+// migrations upgrade configuration files one version at a time, up to
+// CurrentVersion. When a release changes the layout of the configuration,
+// it bumps CurrentVersion and appends the migration from the previous one.
+var migrations []Migration
+
+// Change is an edit made to a configuration file by a migration.
+type Change struct {
+	// Version is the version the change migrates to
+	Version string
+	// Line is where the change was made in the original file, or 0 for
+	// new content
+	Line        int
+	Description string
+}
+
+func (c Change) String() string {
+	if c.Line > 0 {
+		return fmt.Sprintf("%s: line %d: %s", c.Version, c.Line, c.Description)
+	}
+	return fmt.Sprintf("%s: %s", c.Version, c.Description)
+}
+
+// Document is a configuration file being migrated. Paths are dotted, such
+// as features.editor.enabled, and may index sequences, such as
+// repositories.sources.0.type. The methods that take a path do nothing when
+// the file does not set it.
+type Document struct {
+	root    *yaml.Node
+	version string
+	changes []Change
+}
+
+func (d *Document) record(line int, format string, args ...any) {
+	d.changes = append(d.changes, Change{Version: d.version, Line: line, Description: fmt.Sprintf(format, args...)})
+}
+
+// Get returns the value at path, or nil if it is not set.
+func (d *Document) Get(path string) *yaml.Node {
+	mapping, i := d.find(path)
+	if mapping == nil {
+		return nil
+	}
+	return mapping.Content[i+1]
+}
+
+// Rename renames the key at path to name, keeping it in the same mapping.
+func (d *Document) Rename(path, name string) error {
+	mapping, i := d.find(path)
+	if mapping == nil {
+		return nil
+	}
+	to := joinPath(parentPath(path), name)
+	if keyIndex(mapping, name) >= 0 {
+		return fmt.Errorf("cannot rename %s to %s: %s is already set", path, to, to)
+	}
+
+	key := mapping.Content[i]
+	key.Value = name
+	d.record(key.Line, "renamed %s to %s", path, to)
+	return nil
+}
+
+// Move moves the key at from, and its value, to the path to, creating the
+// mappings leading to it. Mappings left empty are removed.
+func (d *Document) Move(from, to string) error {
+	mapping, i := d.find(from)
+	if mapping == nil {
+		return nil
+	}
+	if d.Get(to) != nil {
+		return fmt.Errorf("cannot move %s to %s: %s is already set", from, to, to)
+	}
+	target, err := d.mapping(parentPath(to))
+	if err != nil {
+		return fmt.Errorf("cannot move %s to %s: %w", from, to, err)
+	}
+
+	key, value := mapping.Content[i], mapping.Content[i+1]
+	mapping.Content = slices.Delete(mapping.Content, i, i+2)
+	key.Value = lastSegment(to)
+	target.Content = append(target.Content, key, value)
+	d.prune(parentPath(from))
+
+	d.record(key.Line, "moved %s to %s", from, to)
+	return nil
+}
+
+// Delete removes the key at path and its value. Mappings left empty are
+// removed.
+func (d *Document) Delete(path string) {
+	mapping, i := d.find(path)
+	if mapping == nil {
+		return
+	}
+	key := mapping.Content[i]
+	mapping.Content = slices.Delete(mapping.Content, i, i+2)
+	d.prune(parentPath(path))
+
+	d.record(key.Line, "removed %s", path)
+}
+
+// Convert replaces the value at path with the one convert returns, to change
+// its type. Comments on the value are kept.
+func (d *Document) Convert(path string, convert func(value *yaml.Node) (*yaml.Node, error)) error {
+	mapping, i := d.find(path)
+	if mapping == nil {
+		return nil
+	}
+	value := mapping.Content[i+1]
+	converted, err := convert(value)
+	if err != nil {
+		return fmt.Errorf("cannot convert %s: %w", path, err)
+	}
+	if converted.LineComment == "" && converted.Kind != yaml.MappingNode {
+		converted.LineComment = value.LineComment
+	}
+	mapping.Content[i+1] = converted
+
+	d.record(value.Line, "converted %s from %s to %s", path, describeNode(value), describeNode(converted))
+	return nil
+}
+
+// setVersion sets the version of the file, adding it first if it is not set.
+func (d *Document) setVersion(version string) {
+	if i := keyIndex(d.root, "version"); i >= 0 {
+		d.root.Content[i+1].Value = version
+		d.root.Content[i+1].Tag = "!!str"
+	} else {
+		d.root.Content = append([]*yaml.Node{
+			{Kind: yaml.ScalarNode, Tag: "!!str", Value: "version"},
+			{Kind: yaml.ScalarNode, Tag: "!!str", Value: version, Style: yaml.DoubleQuotedStyle},
+		}, d.root.Content...)
+	}
+	d.record(0, "set version to %s", version)
+}
+
+// find returns the mapping holding the key at path and the index of the key
+// in its content, or nil if path is not set.
+func (d *Document) find(path string) (*yaml.Node, int) {
+	segments := strings.Split(path, ".")
+	node := d.root
+	for _, segment := range segments[:len(segments)-1] {
+		switch node.Kind {
+		case yaml.MappingNode:
+			i := keyIndex(node, segment)
+			if i < 0 {
+				return nil, -1
+			}
+			node = node.Content[i+1]
+		case yaml.SequenceNode:
+			i, err := strconv.Atoi(segment)
+			if err != nil || i < 0 || i >= len(node.Content) {
+				return nil, -1
+			}
+			node = node.Content[i]
+		default:
+			return nil, -1
+		}
+	}
+
+	if node.Kind != yaml.MappingNode {
+		return nil, -1
+	}
+	i := keyIndex(node, segments[len(segments)-1])
+	if i < 0 {
+		return nil, -1
+	}
+	return node, i
+}
+
+// mapping returns the mapping at path, creating the missing ones.
+func (d *Document) mapping(path string) (*yaml.Node, error) {
+	node := d.root
+	if path == "" {
+		return node, nil
+	}
+	for _, segment := range strings.Split(path, ".") {
+		i := keyIndex(node, segment)
+		if i < 0 {
+			next := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+			node.Content = append(node.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: segment}, next)
+			node = next
+			continue
+		}
+		node = node.Content[i+1]
+		if node.Kind != yaml.MappingNode {
+			return nil, fmt.Errorf("%s is not a mapping", segment)
+		}
+	}
+	return node, nil
+}
+
+// prune removes the mapping at path and its parents while they are empty.
+func (d *Document) prune(path string) {
+	for path != "" {
+		mapping, i := d.find(path)
+		if mapping == nil || mapping.Content[i+1].Kind != yaml.MappingNode || len(mapping.Content[i+1].Content) > 0 {
+			return
+		}
+		mapping.Content = slices.Delete(mapping.Content, i, i+2)
+		path = parentPath(path)
+	}
+}
+
+// keyIndex returns the index of key in the content of mapping, or -1.
+func keyIndex(mapping *yaml.Node, key string) int {
+	for i := 0; i+1 < len(mapping.Content); i += 2 {
+		if mapping.Content[i].Value == key {
+			return i
+		}
+	}
+	return -1
+}
+
+func parentPath(path string) string {
+	if i := strings.LastIndex(path, "."); i >= 0 {
+		return path[:i]
+	}
+	return ""
+}
+
+func lastSegment(path string) string {
+	return path[strings.LastIndex(path, ".")+1:]
+}
+
+func joinPath(parent, name string) string {
+	if parent == "" {
+		return name
+	}
+	return parent + "." + name
+}
+
+// describeNode names the type of a YAML value in a change report.
+func describeNode(node *yaml.Node) string {
+	switch node.Kind {
+	case yaml.MappingNode:
+		return "a mapping"
+	case yaml.SequenceNode:
+		return "a list"
+	}
+	switch node.ShortTag() {
+	case "!!bool":
+		return "a boolean"
+	case "!!int":
+		return "an integer"
+	case "!!float":
+		return "a number"
+	}
+	return "a string"
+}
+
+// Migrate upgrades the configuration document root to CurrentVersion in
+// place and returns what changed.
+func Migrate(root *yaml.Node) ([]Change, error) {
+	return migrate(root, migrations)
+}
+
+// migrate runs the migrations from the version of root up to the version
+// the last one migrates to, which is CurrentVersion for the registered
+// migrations.
+func migrate(root *yaml.Node, migrations []Migration) ([]Change, error) {
+	if root.Kind != yaml.DocumentNode || len(root.Content) == 0 || root.Content[0].Kind != yaml.MappingNode {
+		// Empty files are the defaults, and invalid ones fail to decode
+		return nil, nil
+	}
+	doc := &Document{root: root.Content[0]}
+
+	version := legacyVersion
+	if node := doc.Get("version"); node != nil && node.Value != "" {
+		version = node.Value
+	}
+
+	target := CurrentVersion
+	if len(migrations) > 0 {
+		target = migrations[len(migrations)-1].To
+	}
+
+	for version != target {
+		i := slices.IndexFunc(migrations, func(m Migration) bool { return m.From == version })
+		if i < 0 {
+			supported := []string{target}
+			for _, m := range migrations {
+				supported = append(supported, m.From)
+			}
+			slices.Sort(supported)
+			return nil, fmt.Errorf("unsupported configuration version: %s (supported: %v)", version, supported)
+		}
+
+		m := migrations[i]
+		doc.version = m.To
+		if err := m.Apply(doc); err != nil {
+			return nil, fmt.Errorf("migrating from %s to %s (%s): %w", m.From, m.To, m.Description, err)
+		}
+		doc.setVersion(m.To)
+		version = m.To
+	}
+	return doc.changes, nil
+}
+
+// encode writes root as YAML, keeping its comments. The blank lines of the
+// original file are lost, so top level sections are separated by one.
+func encode(root *yaml.Node) ([]byte, error) {
+	var b bytes.Buffer
+	enc := yaml.NewEncoder(&b)
+	enc.SetIndent(2)
+	if err := enc.Encode(root); err != nil {
+		return nil, err
+	}
+	if err := enc.Close(); err != nil {
+		return nil, err
+	}
+
+	var out bytes.Buffer
+	lines := bytes.SplitAfter(b.Bytes(), []byte("\n"))
+	for i, line := range lines {
+		topLevel := len(line) > 0 && line[0] != ' ' && line[0] != '\n'
+		if i > 0 && topLevel && lines[i-1][0] != '#' {
+			out.WriteByte('\n')
+		}
+		out.Write(line)
+	}
+	return out.Bytes(), nil
+}
+
+// MigrateFile migrates the configuration file at path to CurrentVersion and
+// returns what changed. With write, the file is replaced by the migrated one,
+// keeping its comments.
+func MigrateFile(path string, write bool) ([]Change, error) {
+	return migrateFile(path, write, migrations)
+}
+
+func migrateFile(path string, write bool, migrations []Migration) ([]Change, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return nil, fmt.Errorf("failed to parse config file: %w", err)
+	}
+
+	changes, err := migrate(&root, migrations)
+	if err != nil || !write || len(changes) == 0 {
+		return changes, err
+	}
+
+	migrated, err := encode(&root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode config file: %w", err)
+	}
+	return changes, writeFileAtomic(path, migrated)
+}
+
+// writeFileAtomic replaces the file at path with data, keeping its mode, so
+// that a reader never sees half of it.
+func writeFileAtomic(path string, data []byte) error {
+	info, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(info.Mode().Perm()); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// WrapIn returns a conversion for Document.Convert that turns a value into a
+// mapping holding it under key, such as editor: true into
+// editor: {enabled: true}.
+func WrapIn(key string) func(value *yaml.Node) (*yaml.Node, error) {
+	return func(value *yaml.Node) (*yaml.Node, error) {
+		if value.Kind == yaml.MappingNode {
+			return nil, fmt.Errorf("expected a single value, got %s", describeNode(value))
+		}
+		wrapped := *value
+		return &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map", Content: []*yaml.Node{
+			{Kind: yaml.ScalarNode, Tag: "!!str", Value: key},
+			&wrapped,
+		}}, nil
+	}
+}
+
+// SplitString returns a conversion for Document.Convert that turns a string
+// of values separated by sep into a list of strings.
+func SplitString(sep string) func(value *yaml.Node) (*yaml.Node, error) {
+	return func(value *yaml.Node) (*yaml.Node, error) {
+		if value.Kind != yaml.ScalarNode {
+			return nil, fmt.Errorf("expected a string, got %s", describeNode(value))
+		}
+		list := &yaml.Node{Kind: yaml.SequenceNode, Tag: "!!seq", Style: yaml.FlowStyle}
+		for _, item := range strings.Split(value.Value, sep) {
+			if item = strings.TrimSpace(item); item != "" {
+				list.Content = append(list.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: item})
+			}
+		}
+		return list, nil
+	}
+}
@@ -0,0 +1,191 @@
+package config
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+	"gopkg.in/yaml.v3"
+)
+
+var updateGolden = flag.Bool("update", false, "Update the golden files of the migration tests")
+
+// testMigrations restructure a made up configuration up to CurrentVersion,
+// the way releases are expected to.
+var testMigrations = []Migration{
+	{
+		From:        "0.8",
+		To:          "0.9",
+		Description: "name the site and move the author to meta",
+		Apply: func(doc *Document) error {
+			if err := doc.Rename("site.title", "name"); err != nil {
+				return err
+			}
+			return doc.Move("author", "meta.author")
+		},
+	},
+	{
+		From:        "0.9",
+		To:          CurrentVersion,
+		Description: "turn features into sections",
+		Apply: func(doc *Document) error {
+			if err := doc.Convert("features.editor", WrapIn("enabled")); err != nil {
+				return err
+			}
+			if err := doc.Convert("meta.keywords", SplitString(",")); err != nil {
+				return err
+			}
+			if err := doc.Move("features.profile", "profile.enabled"); err != nil {
+				return err
+			}
+			doc.Delete("features.legacy_markdown")
+			return nil
+		},
+	},
+}
+
+// TestMigrateGoldenFiles migrates each testdata/migrate/*.yaml file and
+// compares the rewritten file and the report with its .golden and .report
+// files. Run with -update to regenerate them.
+func TestMigrateGoldenFiles(t *testing.T) {
+	SetLogger(zerolog.Nop())
+	inputs, err := filepath.Glob("testdata/migrate/*.yaml")
+	if err != nil || len(inputs) == 0 {
+		t.Fatalf("Expected migration fixtures, got %v (%v)", inputs, err)
+	}
+
+	for _, input := range inputs {
+		name := strings.TrimSuffix(input, ".yaml")
+		t.Run(filepath.Base(name), func(t *testing.T) {
+			original, err := os.ReadFile(input)
+			if err != nil {
+				t.Fatal(err)
+			}
+			path := filepath.Join(t.TempDir(), "config.yaml")
+			if err := os.WriteFile(path, original, 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			changes, err := migrateFile(path, true, testMigrations)
+			if err != nil {
+				t.Fatalf("migrateFile failed: %v", err)
+			}
+			var report strings.Builder
+			for _, change := range changes {
+				report.WriteString(change.String() + "\n")
+			}
+			migrated, err := os.ReadFile(path)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if *updateGolden {
+				if err := os.WriteFile(name+".golden", migrated, 0o644); err != nil {
+					t.Fatal(err)
+				}
+				if err := os.WriteFile(name+".report", []byte(report.String()), 0o644); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			assertGolden(t, name+".golden", string(migrated))
+			assertGolden(t, name+".report", report.String())
+
+			if len(changes) == 0 && string(migrated) != string(original) {
+				t.Error("Expected an up to date file not to be rewritten")
+			}
+			if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0o600 {
+				t.Errorf("Expected the file mode to be kept, got %v (%v)", info.Mode(), err)
+			}
+
+			// The migrated file is read as is by the current version
+			originalConfig := Get()
+			defer func() { Set(originalConfig) }()
+			if err := LoadConfig(path); err != nil {
+				t.Errorf("Expected the migrated file to load, got %v", err)
+			}
+		})
+	}
+}
+
+func assertGolden(t *testing.T, path, got string) {
+	t.Helper()
+	want, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read %s, run the tests with -update to create it: %v", path, err)
+	}
+	if got != string(want) {
+		t.Errorf("%s does not match, run the tests with -update if the change is expected.\nGot:\n%s\nWant:\n%s", path, got, want)
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	parse := func(t *testing.T, content string) *yaml.Node {
+		t.Helper()
+		var root yaml.Node
+		if err := yaml.Unmarshal([]byte(content), &root); err != nil {
+			t.Fatal(err)
+		}
+		return &root
+	}
+
+	t.Run("Registered migrations", func(t *testing.T) {
+		changes, err := Migrate(parse(t, "site:\n  name: \"Notes\"\n"))
+		if err != nil || len(changes) != 0 {
+			t.Errorf("Expected files without a version to be current, got %v (%v)", changes, err)
+		}
+	})
+
+	t.Run("Unsupported versions", func(t *testing.T) {
+		_, err := migrate(parse(t, "version: \"0.5\"\n"), testMigrations)
+		if err == nil || !strings.Contains(err.Error(), "unsupported configuration version: 0.5 (supported: [0.8 0.9 1.0])") {
+			t.Errorf("Expected an unsupported version, got %v", err)
+		}
+	})
+
+	t.Run("Conflicts", func(t *testing.T) {
+		_, err := migrate(parse(t, "version: \"0.8\"\nsite:\n  title: \"Old\"\n  name: \"New\"\n"), testMigrations)
+		if err == nil || !strings.Contains(err.Error(), "cannot rename site.title to site.name: site.name is already set") {
+			t.Errorf("Expected a conflict, got %v", err)
+		}
+	})
+
+	t.Run("Invalid types", func(t *testing.T) {
+		_, err := migrate(parse(t, "version: \"0.9\"\nmeta:\n  keywords: [go]\n"), testMigrations)
+		if err == nil || !strings.Contains(err.Error(), "cannot convert meta.keywords: expected a string, got a list") {
+			t.Errorf("Expected a conversion error, got %v", err)
+		}
+	})
+
+	t.Run("Paths through lists", func(t *testing.T) {
+		doc := &Document{root: parse(t, "sources:\n  - name: a\n    kind: fs\n").Content[0]}
+		if err := doc.Rename("sources.0.kind", "type"); err != nil {
+			t.Fatal(err)
+		}
+		if node := doc.Get("sources.0.type"); node == nil || node.Value != "fs" {
+			t.Errorf("Expected the source type to be renamed, got %v", node)
+		}
+		if doc.Get("sources.1.name") != nil || doc.Get("sources.x.name") != nil {
+			t.Error("Expected missing items not to be found")
+		}
+	})
+}
+
+func TestMigrateFileWithoutWrite(t *testing.T) {
+	original, err := os.ReadFile("testdata/migrate/legacy.yaml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := writeConfig(t, string(original))
+
+	changes, err := migrateFile(path, false, testMigrations)
+	if err != nil || len(changes) == 0 {
+		t.Fatalf("Expected changes to be reported, got %v (%v)", changes, err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != string(original) {
+		t.Error("Expected the file to be left alone without write")
+	}
+}
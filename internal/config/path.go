@@ -9,12 +9,16 @@ const (
 	PostsLocalDir = "posts"
 	PostsURLPath  = "/" + PostsLocalDir + "/"
 
+	UploadsLocalDir = StaticLocalDir + "/uploads"
+	UploadsURLPath  = "/" + UploadsLocalDir + "/"
+
 	TemplatesLocalDir = "templates"
 
 	TemplateLayout = "layout.html"
 	TemplateIndex  = "index.html"
 	TemplatePost   = "post.html"
 	TemplateEditor = "editor.html"
+	TemplateMedia  = "media.html"
 
 	// Template names (without .html extension)
 	TemplateNameAuth = "ed25519_auth"
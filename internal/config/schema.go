@@ -0,0 +1,123 @@
+package config
+
+import (
+	"encoding/json"
+	"reflect"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2/styles"
+)
+
+// SchemaURL is the JSON Schema dialect of GenerateSchema.
+const SchemaURL = "https://json-schema.org/draft/2020-12/schema"
+
+// schema is the subset of JSON Schema describing the configuration.
+type schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Type                 string             `json:"type"`
+	Default              any                `json:"default,omitempty"`
+	Enum                 []string           `json:"enum,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+	Minimum              *int64             `json:"minimum,omitempty"`
+	Maximum              *int64             `json:"maximum,omitempty"`
+	Items                *schema            `json:"items,omitempty"`
+	Properties           map[string]*schema `json:"properties,omitempty"`
+	AdditionalProperties *bool              `json:"additionalProperties,omitempty"`
+}
+
+// GenerateSchema returns a JSON Schema of the configuration file, so that
+// editors can complete and check it. It holds the same constraints as
+// Validate.
+func GenerateSchema() ([]byte, error) {
+	config := &Config{}
+	applyDefaults(config)
+
+	root := objectSchema(reflect.ValueOf(config).Elem())
+	root.Schema = SchemaURL
+	root.Title = "The Archive configuration"
+
+	data, err := json.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(data, '\n'), nil
+}
+
+// objectSchema describes the struct v, whose fields hold their defaults.
+func objectSchema(v reflect.Value) *schema {
+	closed := false
+	s := &schema{Type: "object", Properties: map[string]*schema{}, AdditionalProperties: &closed}
+
+	t := v.Type()
+	for i := 0; i < v.NumField(); i++ {
+		field, fieldType := v.Field(i), t.Field(i)
+		if !fieldType.IsExported() {
+			continue
+		}
+		name := strings.Split(fieldType.Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			name = strings.ToLower(fieldType.Name)
+		}
+
+		property := fieldSchema(field, fieldType.Tag)
+		property.Description = fieldType.Tag.Get("description")
+		s.Properties[name] = property
+	}
+	return s
+}
+
+// fieldSchema describes a field holding its default.
+func fieldSchema(field reflect.Value, tag reflect.StructTag) *schema {
+	switch field.Kind() {
+	case reflect.Struct:
+		return objectSchema(field)
+	case reflect.Slice:
+		elem := reflect.New(field.Type().Elem()).Elem()
+		if elem.Kind() == reflect.Struct {
+			// Elements of slices of structs get their defaults one by one
+			applyDefaults(elem.Addr().Interface())
+			return &schema{Type: "array", Items: objectSchema(elem)}
+		}
+		s := &schema{Type: "array", Items: fieldSchema(elem, tag)}
+		s.Items.Default = nil
+		if field.Len() > 0 {
+			s.Default = field.Interface()
+		}
+		return s
+	}
+
+	s := &schema{Default: field.Interface()}
+	switch field.Kind() {
+	case reflect.Bool:
+		s.Type = "boolean"
+	case reflect.Int:
+		s.Type = "integer"
+		if minimum, err := strconv.ParseInt(tag.Get("min"), 10, 64); err == nil {
+			s.Minimum = &minimum
+		}
+		if maximum, err := strconv.ParseInt(tag.Get("max"), 10, 64); err == nil {
+			s.Maximum = &maximum
+		}
+	case reflect.Float64:
+		s.Type = "number"
+	default:
+		s.Type = "string"
+	}
+
+	if valid := tag.Get("valid"); valid != "" {
+		s.Enum = strings.Split(valid, ",")
+	}
+	switch tag.Get("format") {
+	case FormatURL:
+		s.Format = "uri"
+	case FormatPort:
+		s.Pattern = "^[0-9]{1,5}$"
+	case FormatSyntaxTheme:
+		s.Enum = styles.Names()
+	}
+	return s
+}
@@ -0,0 +1,116 @@
+package config
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+)
+
+// Prefixes of the references a string field can hold instead of its value.
+// They are resolved when the configuration is loaded.
+const (
+	// RefEnv reads the value from an environment variable, such as
+	// env:ED25519_PUBKEY. Unset variables are empty.
+	RefEnv = "env:"
+	// RefFile reads the value from a file, such as file:/run/secrets/s3,
+	// without its trailing newline.
+	RefFile = "file:"
+	// RefBase64 decodes the standard base64 value that follows it.
+	RefBase64 = "base64:"
+)
+
+// RedactedValue replaces the values of secret fields in a redacted
+// configuration.
+const RedactedValue = "[redacted]"
+
+// IsReference reports whether value refers to its value instead of holding
+// it.
+func IsReference(value string) bool {
+	return strings.HasPrefix(value, RefEnv) || strings.HasPrefix(value, RefFile) || strings.HasPrefix(value, RefBase64)
+}
+
+// resolveReference returns the value value refers to, or value itself if it
+// is not a reference.
+func resolveReference(value string) (string, error) {
+	switch {
+	case strings.HasPrefix(value, RefEnv):
+		name := strings.TrimPrefix(value, RefEnv)
+		if name == "" {
+			return "", errors.New("missing environment variable name")
+		}
+		resolved, ok := os.LookupEnv(name)
+		if !ok {
+			configLogger.Debug().Str("name", name).Msg("Environment variable referenced by the configuration is not set")
+		}
+		return resolved, nil
+	case strings.HasPrefix(value, RefFile):
+		path := strings.TrimPrefix(value, RefFile)
+		if path == "" {
+			return "", errors.New("missing file path")
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", err
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case strings.HasPrefix(value, RefBase64):
+		data, err := base64.StdEncoding.DecodeString(strings.TrimSpace(strings.TrimPrefix(value, RefBase64)))
+		if err != nil {
+			return "", fmt.Errorf("invalid base64: %w", err)
+		}
+		return string(data), nil
+	}
+	return value, nil
+}
+
+// resolveReferences replaces the references held by the string fields of
+// config with their values.
+func resolveReferences(config any) error {
+	var errs []error
+	walkFields(config, "", func(field reflect.Value, _ reflect.StructField, path string) {
+		if field.Kind() != reflect.String || !IsReference(field.String()) {
+			return
+		}
+		resolved, err := resolveReference(field.String())
+		if err != nil {
+			// The error does not include the reference, which may hold the secret
+			errs = append(errs, &FieldError{Path: path, Err: err})
+			return
+		}
+		field.SetString(resolved)
+	})
+	return errors.Join(errs...)
+}
+
+// Redacted returns a copy of c whose secret fields, tagged secret:"true",
+// are replaced by RedactedValue, to be logged or shown.
+func (c *Config) Redacted() *Config {
+	redacted := *c
+	redact(reflect.ValueOf(&redacted).Elem())
+	return &redacted
+}
+
+// redact replaces the secret fields of the struct v, copying the slices of
+// structs it holds so that the original is left alone.
+func redact(v reflect.Value) {
+	t := v.Type()
+	for i := 0; i < v.NumField(); i++ {
+		field := v.Field(i)
+		switch {
+		case field.Kind() == reflect.Struct:
+			redact(field)
+		case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.Struct:
+			clone := reflect.MakeSlice(field.Type(), field.Len(), field.Len())
+			reflect.Copy(clone, field)
+			field.Set(clone)
+			for j := 0; j < clone.Len(); j++ {
+				redact(clone.Index(j))
+			}
+		case field.Kind() == reflect.String && t.Field(i).Tag.Get("secret") == "true" && field.String() != "":
+			field.SetString(RedactedValue)
+		}
+	}
+}
@@ -0,0 +1,238 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2/styles"
+	"gopkg.in/yaml.v3"
+)
+
+// Formats checked by the format tag
+const (
+	FormatURL         = "url"
+	FormatPort        = "port"
+	FormatSyntaxTheme = "syntax_theme"
+)
+
+var errUnknownField = errors.New("unknown field")
+
+// FieldError is a configuration field whose value is invalid, or a key of the
+// configuration file that is not a field. Line and Column locate it in File
+// when it was set there.
+type FieldError struct {
+	File   string
+	Line   int
+	Column int
+	Path   string
+	Err    error
+}
+
+func (e *FieldError) Error() string {
+	if e.Line > 0 {
+		return fmt.Sprintf("%s:%d:%d: %s: %v", e.File, e.Line, e.Column, e.Path, e.Err)
+	}
+	return fmt.Sprintf("%s: %v", e.Path, e.Err)
+}
+
+func (e *FieldError) Unwrap() error {
+	return e.Err
+}
+
+// Validate checks every field of config against the valid, min, max and
+// format tags of its struct field.
+func Validate(config *Config) error {
+	return validate(config, "", nil)
+}
+
+// validate checks config like Validate. When root is the YAML document
+// config was read from, the errors of the fields it sets are located in file,
+// and its keys that are not fields are errors too.
+func validate(config *Config, file string, root *yaml.Node) error {
+	var errs []error
+	positions := map[string]*yaml.Node{}
+	if root != nil && len(root.Content) > 0 {
+		errs = append(errs, checkKeys(root.Content[0], reflect.TypeOf(config).Elem(), "", file, positions)...)
+	}
+
+	fail := func(path string, err error) {
+		fieldErr := &FieldError{File: file, Path: path, Err: err}
+		if node, ok := positions[path]; ok {
+			fieldErr.Line, fieldErr.Column = node.Line, node.Column
+		}
+		errs = append(errs, fieldErr)
+	}
+	walkFields(config, "", func(field reflect.Value, fieldType reflect.StructField, path string) {
+		if err := checkField(field, fieldType.Tag); err != nil {
+			fail(path, err)
+		}
+	})
+	checkDependencies(config, fail)
+	return errors.Join(errs...)
+}
+
+// checkDependencies calls fail for the fields whose value is invalid given
+// the value of others.
+func checkDependencies(config *Config, fail func(path string, err error)) {
+	if tls := config.Server.TLS; tls.Enabled {
+		if tls.CertFile == "" {
+			fail("server.tls.cert_file", errors.New("required when TLS is enabled"))
+		}
+		if tls.KeyFile == "" {
+			fail("server.tls.key_file", errors.New("required when TLS is enabled"))
+		}
+	}
+}
+
+// checkKeys returns an error for every key of the mapping node that is not a
+// field of the struct type t, descending into nested mappings and sequences.
+// It records the value node of each field in positions by its dotted path.
+func checkKeys(node *yaml.Node, t reflect.Type, prefix, file string, positions map[string]*yaml.Node) []error {
+	if node.Kind != yaml.MappingNode || t.Kind() != reflect.Struct {
+		return nil
+	}
+
+	fields := map[string]reflect.StructField{}
+	for i := 0; i < t.NumField(); i++ {
+		name := strings.Split(t.Field(i).Tag.Get("yaml"), ",")[0]
+		if name == "" || name == "-" {
+			name = strings.ToLower(t.Field(i).Name)
+		}
+		fields[name] = t.Field(i)
+	}
+
+	var errs []error
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		key, value := node.Content[i], node.Content[i+1]
+		path := key.Value
+		if prefix != "" {
+			path = prefix + "." + key.Value
+		}
+
+		field, ok := fields[key.Value]
+		if !ok {
+			err := errUnknownField
+			if suggestion := closestName(key.Value, fields); suggestion != "" {
+				err = fmt.Errorf("%w, did you mean %s?", errUnknownField, suggestion)
+			}
+			errs = append(errs, &FieldError{File: file, Line: key.Line, Column: key.Column, Path: path, Err: err})
+			continue
+		}
+		positions[path] = value
+
+		switch {
+		case field.Type.Kind() == reflect.Struct:
+			errs = append(errs, checkKeys(value, field.Type, path, file, positions)...)
+		case field.Type.Kind() == reflect.Slice && value.Kind == yaml.SequenceNode:
+			for j, item := range value.Content {
+				itemPath := fmt.Sprintf("%s.%d", path, j)
+				positions[itemPath] = item
+				errs = append(errs, checkKeys(item, field.Type.Elem(), itemPath, file, positions)...)
+			}
+		}
+	}
+	return errs
+}
+
+// closestName returns the field name that is a typo away from name, if any.
+func closestName(name string, fields map[string]reflect.StructField) string {
+	best, bestDistance := "", 3
+	for candidate := range fields {
+		d := editDistance(name, candidate)
+		if d >= len(candidate) {
+			continue
+		}
+		if d < bestDistance || (d == bestDistance && candidate < best) {
+			best, bestDistance = candidate, d
+		}
+	}
+	return best
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	prev := make([]int, len(b)+1)
+	curr := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		curr[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			curr[j] = min(prev[j]+1, curr[j-1]+1, prev[j-1]+cost)
+		}
+		prev, curr = curr, prev
+	}
+	return prev[len(b)]
+}
+
+// checkField checks the value of field against its tags.
+func checkField(field reflect.Value, tag reflect.StructTag) error {
+	switch field.Kind() {
+	case reflect.String:
+		value := field.String()
+		if valid := tag.Get("valid"); valid != "" && !slices.Contains(strings.Split(valid, ","), value) {
+			return fmt.Errorf("%q must be one of %s", value, strings.ReplaceAll(valid, ",", ", "))
+		}
+		return checkFormat(value, tag.Get("format"))
+	case reflect.Int:
+		return checkRange(field.Int(), tag)
+	case reflect.Slice:
+		if field.Type().Elem().Kind() == reflect.Int {
+			for i := 0; i < field.Len(); i++ {
+				if err := checkRange(field.Index(i).Int(), tag); err != nil {
+					return fmt.Errorf("item %d: %w", i, err)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// checkRange checks that value is within the min and max tags.
+func checkRange(value int64, tag reflect.StructTag) error {
+	if s := tag.Get("min"); s != "" {
+		if minimum, _ := strconv.ParseInt(s, 10, 64); value < minimum {
+			return fmt.Errorf("%d is less than the minimum of %d", value, minimum)
+		}
+	}
+	if s := tag.Get("max"); s != "" {
+		if maximum, _ := strconv.ParseInt(s, 10, 64); value > maximum {
+			return fmt.Errorf("%d is more than the maximum of %d", value, maximum)
+		}
+	}
+	return nil
+}
+
+// checkFormat checks that value is written in format. URLs are optional.
+func checkFormat(value, format string) error {
+	switch format {
+	case FormatURL:
+		if value == "" {
+			return nil
+		}
+		u, err := url.Parse(value)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return fmt.Errorf("%q is not an http or https URL", value)
+		}
+	case FormatPort:
+		port, err := strconv.Atoi(value)
+		if err != nil || port < 1 || port > 65535 {
+			return fmt.Errorf("%q is not a port number between 1 and 65535", value)
+		}
+	case FormatSyntaxTheme:
+		if _, ok := styles.Registry[value]; !ok {
+			return fmt.Errorf("%q is not a syntax highlighting theme", value)
+		}
+	}
+	return nil
+}
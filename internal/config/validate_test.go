@@ -0,0 +1,170 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/rs/zerolog"
+)
+
+func TestValidate(t *testing.T) {
+	valid := &Config{Repositories: RepositoriesConfig{Sources: []PostSourceConfig{{}}}}
+	applyDefaults(valid)
+	if err := Validate(valid); err != nil {
+		t.Fatalf("Expected the defaults to be valid, got %v", err)
+	}
+
+	testCases := map[string]struct {
+		change func(cfg *Config)
+		path   string
+		errStr string
+	}{
+		"Enumeration":  {func(cfg *Config) { cfg.Logging.Level = "verbose" }, "logging.level", "must be one of debug, info, warn, error"},
+		"Source type":  {func(cfg *Config) { cfg.Repositories.Sources[0].Type = "ftp" }, "repositories.sources.0.type", "must be one of"},
+		"Minimum":      {func(cfg *Config) { cfg.Posts.PostsPerPage = 0 }, "posts.posts_per_page", "less than the minimum of 1"},
+		"Maximum":      {func(cfg *Config) { cfg.Media.JPEGQuality = 101 }, "media.jpeg_quality", "more than the maximum of 100"},
+		"List item":    {func(cfg *Config) { cfg.Media.ResponsiveWidths = []int{480, 0} }, "media.responsive_widths", "item 1"},
+		"URL":          {func(cfg *Config) { cfg.Social.GitHub = "github.com/someone" }, "social.github", "not an http or https URL"},
+		"Port":         {func(cfg *Config) { cfg.Server.Port = "http" }, "server.port", "not a port number"},
+		"Empty port":   {func(cfg *Config) { cfg.Server.Port = "" }, "server.port", "not a port number"},
+		"Syntax theme": {func(cfg *Config) { cfg.Theme.SyntaxHighlighting.DefaultLight = "solarized" }, "theme.syntax_highlighting.default_light", "not a syntax highlighting theme"},
+		"TLS":          {func(cfg *Config) { cfg.Server.TLS.Enabled = true }, "server.tls.cert_file", "required when TLS is enabled"},
+	}
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			cfg := &Config{Repositories: RepositoriesConfig{Sources: []PostSourceConfig{{}}}}
+			applyDefaults(cfg)
+			tc.change(cfg)
+
+			err := Validate(cfg)
+			var fieldErr *FieldError
+			if !errors.As(err, &fieldErr) {
+				t.Fatalf("Expected a field error, got %v", err)
+			}
+			if fieldErr.Path != tc.path || !strings.Contains(err.Error(), tc.errStr) {
+				t.Errorf("Expected %s to fail with %q, got %v", tc.path, tc.errStr, err)
+			}
+			if fieldErr.Line != 0 {
+				t.Errorf("Expected no position without a file, got line %d", fieldErr.Line)
+			}
+		})
+	}
+
+	t.Run("Optional URLs", func(t *testing.T) {
+		cfg := &Config{}
+		applyDefaults(cfg)
+		cfg.Social.GitHub = ""
+		cfg.Media.Storage.S3.Endpoint = "http://localhost:9000"
+		if err := Validate(cfg); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	})
+}
+
+func TestLoadConfigStrict(t *testing.T) {
+	SetLogger(zerolog.Nop())
+	originalConfig := Get()
+	defer func() { Set(originalConfig) }()
+
+	path := writeConfig(t, `site:
+  nmae: "Typo"
+theme:
+  default: "blue"
+repositories:
+  sources:
+    - name: "notes"
+      tpye: "fs"
+unknown_section:
+  enabled: true
+`)
+	err := LoadConfig(path)
+	if err == nil {
+		t.Fatal("Expected an error")
+	}
+
+	for _, expected := range []string{
+		path + ":2:3: site.nmae: unknown field, did you mean name?",
+		path + ":4:12: theme.default: \"blue\" must be one of light, dark",
+		path + ":8:7: repositories.sources.0.tpye: unknown field, did you mean type?",
+		path + ":9:1: unknown_section: unknown field\n",
+	} {
+		if !strings.Contains(err.Error()+"\n", expected) {
+			t.Errorf("Expected the error to contain %q, got:\n%v", expected, err)
+		}
+	}
+	if !errors.Is(err, errUnknownField) {
+		t.Error("Expected unknown fields to be reported as such")
+	}
+
+	t.Run("Overrides are checked", func(t *testing.T) {
+		path := writeConfig(t, "server:\n  port: \"8080\"\n")
+		err := LoadConfigWithOverrides(path, Overrides{"server.port": "0"})
+		if err == nil || !strings.Contains(err.Error(), "server.port: \"0\" is not a port number") {
+			t.Errorf("Expected an invalid port, got %v", err)
+		}
+	})
+
+	t.Run("Empty files are valid", func(t *testing.T) {
+		if err := LoadConfig(writeConfig(t, "")); err != nil {
+			t.Errorf("Expected no error, got %v", err)
+		}
+	})
+}
+
+func TestEditDistance(t *testing.T) {
+	testCases := []struct {
+		a, b     string
+		expected int
+	}{
+		{"", "", 0},
+		{"name", "name", 0},
+		{"nmae", "name", 2},
+		{"tpye", "type", 2},
+		{"port", "prot", 2},
+		{"host", "hosts", 1},
+		{"level", "", 5},
+	}
+	for _, tc := range testCases {
+		if got := editDistance(tc.a, tc.b); got != tc.expected {
+			t.Errorf("editDistance(%q, %q) = %d, expected %d", tc.a, tc.b, got, tc.expected)
+		}
+	}
+}
+
+func TestGenerateSchema(t *testing.T) {
+	data, err := GenerateSchema()
+	if err != nil {
+		t.Fatalf("GenerateSchema failed: %v", err)
+	}
+
+	var root schema
+	if err := json.Unmarshal(data, &root); err != nil {
+		t.Fatalf("Expected valid JSON, got %v", err)
+	}
+	if root.Schema != SchemaURL || root.AdditionalProperties == nil || *root.AdditionalProperties {
+		t.Errorf("Expected a closed %s schema, got %s (%v)", SchemaURL, root.Schema, root.AdditionalProperties)
+	}
+
+	theme := root.Properties["theme"]
+	if theme == nil || theme.Properties["default"] == nil {
+		t.Fatal("Expected theme.default to be described")
+	}
+	if d := theme.Properties["default"]; strings.Join(d.Enum, ",") != "light,dark" || d.Default != "dark" {
+		t.Errorf("Expected the themes and the default, got %v and %v", d.Enum, d.Default)
+	}
+	if dark := theme.Properties["syntax_highlighting"].Properties["default_dark"]; len(dark.Enum) < 10 {
+		t.Errorf("Expected the chroma themes, got %v", dark.Enum)
+	}
+
+	quality := root.Properties["media"].Properties["jpeg_quality"]
+	if quality.Type != "integer" || quality.Minimum == nil || *quality.Minimum != 1 || quality.Maximum == nil || *quality.Maximum != 100 {
+		t.Errorf("Expected an integer between 1 and 100, got %+v", quality)
+	}
+
+	sources := root.Properties["repositories"].Properties["sources"]
+	if sources.Type != "array" || sources.Items == nil || sources.Items.Properties["type"].Default != "db" {
+		t.Errorf("Expected sources to be described with their defaults, got %+v", sources)
+	}
+}
@@ -0,0 +1,108 @@
+package config
+
+import (
+	"context"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	current atomic.Pointer[Config]
+
+	subscribersMu sync.Mutex
+	subscribers   = map[int]func(old, new *Config){}
+	nextID        int
+)
+
+// Get returns the configuration in use. It is replaced as a whole when the
+// configuration file changes, so a request should call Get once and read
+// every field it needs from the same Config. Get returns nil until the
+// configuration is loaded.
+func Get() *Config {
+	return current.Load()
+}
+
+// Set replaces the configuration in use with config and notifies the
+// subscribers. The Config must not be modified afterwards.
+func Set(config *Config) {
+	old := current.Swap(config)
+
+	subscribersMu.Lock()
+	notify := make([]func(old, new *Config), 0, len(subscribers))
+	for _, fn := range subscribers {
+		notify = append(notify, fn)
+	}
+	subscribersMu.Unlock()
+
+	for _, fn := range notify {
+		fn(old, config)
+	}
+}
+
+// Subscribe calls fn with the previous and the new configuration every time
+// it is replaced, until the returned function is called.
+func Subscribe(fn func(old, new *Config)) (unsubscribe func()) {
+	subscribersMu.Lock()
+	defer subscribersMu.Unlock()
+
+	id := nextID
+	nextID++
+	subscribers[id] = fn
+	return func() {
+		subscribersMu.Lock()
+		defer subscribersMu.Unlock()
+		delete(subscribers, id)
+	}
+}
+
+// Watch checks the configuration file at path every interval in the
+// background, until ctx is done. When it changes, it is loaded again with
+// overrides and replaces the configuration in use. If the new file is
+// invalid, the error is logged and the previous configuration is kept.
+func Watch(ctx context.Context, path string, overrides Overrides, interval time.Duration) {
+	last, _ := os.Stat(path)
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			info, err := os.Stat(path)
+			if err != nil {
+				// Editors may remove the file while saving it
+				continue
+			}
+			if last != nil && info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size() {
+				continue
+			}
+			last = info
+
+			if err := Reload(path, overrides); err != nil {
+				configLogger.Error().Err(err).Str("path", path).Msg("Invalid configuration, keeping the previous one")
+			}
+		}
+	}()
+}
+
+// Reload loads the configuration file at path like LoadConfigWithOverrides.
+// Unlike it, a missing file is an error, so that the configuration in use is
+// not replaced by the defaults while the file is being written.
+func Reload(path string, overrides Overrides) error {
+	if _, err := os.Stat(path); err != nil {
+		return err
+	}
+	config, err := load(path, overrides)
+	if err != nil {
+		return err
+	}
+	Set(config)
+	configLogger.Info().Str("path", path).Msg("Configuration reloaded")
+	return nil
+}
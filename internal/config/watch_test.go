@@ -0,0 +1,129 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+func TestSubscribe(t *testing.T) {
+	originalConfig := Get()
+	defer func() { Set(originalConfig) }()
+
+	first := &Config{Site: SiteConfig{Name: "First"}}
+	second := &Config{Site: SiteConfig{Name: "Second"}}
+	Set(first)
+
+	var calls [][2]*Config
+	unsubscribe := Subscribe(func(old, new *Config) {
+		calls = append(calls, [2]*Config{old, new})
+	})
+	Set(second)
+	unsubscribe()
+	Set(first)
+
+	if len(calls) != 1 {
+		t.Fatalf("Expected a single notification, got %d", len(calls))
+	}
+	if calls[0][0] != first || calls[0][1] != second {
+		t.Errorf("Expected to be notified of the swap from First to Second, got %s to %s", calls[0][0].Site.Name, calls[0][1].Site.Name)
+	}
+	if Get() != first {
+		t.Errorf("Expected the last configuration set, got %s", Get().Site.Name)
+	}
+}
+
+func TestReload(t *testing.T) {
+	SetLogger(zerolog.Nop())
+	originalConfig := Get()
+	defer func() { Set(originalConfig) }()
+
+	path := writeConfig(t, "site:\n  name: \"Before\"\n")
+	if err := LoadConfig(path); err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+	before := Get()
+
+	invalid := map[string]string{
+		"Malformed YAML":      "site: [\n",
+		"Unsupported version": "version: \"2.0\"\nsite:\n  name: \"After\"\n",
+	}
+	for name, content := range invalid {
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf(failedToWriteConfigCont, err)
+		}
+		if err := Reload(path, nil); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+		if Get() != before {
+			t.Errorf("%s: expected the previous configuration to be kept", name)
+		}
+	}
+
+	if err := Reload(filepath.Join(t.TempDir(), "missing.yaml"), nil); err == nil || Get() != before {
+		t.Errorf("Expected a missing file not to replace the configuration, got %v", err)
+	}
+
+	if err := os.WriteFile(path, []byte("site:\n  name: \"After\"\n"), 0o644); err != nil {
+		t.Fatalf(failedToWriteConfigCont, err)
+	}
+	if err := Reload(path, Overrides{"site.tagline": "From the flag"}); err != nil {
+		t.Fatalf("Reload failed: %v", err)
+	}
+	if Get().Site.Name != "After" || Get().Site.Tagline != "From the flag" {
+		t.Errorf("Expected the new file and the overrides, got %+v", Get().Site)
+	}
+	if before.Site.Name != "Before" {
+		t.Errorf("Expected the previous configuration to be left as it was, got %+v", before.Site)
+	}
+}
+
+func TestWatch(t *testing.T) {
+	SetLogger(zerolog.Nop())
+	originalConfig := Get()
+	defer func() { Set(originalConfig) }()
+
+	path := writeConfig(t, "site:\n  name: \"Before\"\n")
+	if err := LoadConfig(path); err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	reloaded := make(chan string, 10)
+	defer Subscribe(func(_, new *Config) { reloaded <- new.Site.Name })()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	Watch(ctx, path, nil, 10*time.Millisecond)
+
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf(failedToWriteConfigCont, err)
+		}
+	}
+
+	write("site:\n  name: [\n")
+	write("site:\n  name: \"After the edit\"\n")
+	select {
+	case name := <-reloaded:
+		if name != "After the edit" {
+			t.Errorf("Expected the edited configuration, got %q", name)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the configuration to be reloaded")
+	}
+
+	write("site: [\n")
+	select {
+	case name := <-reloaded:
+		t.Errorf("Expected the invalid edit to be ignored, got %q", name)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if Get().Site.Name != "After the edit" {
+		t.Errorf("Expected the last valid configuration to be kept, got %q", Get().Site.Name)
+	}
+}
@@ -2,19 +2,40 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
+	"github.com/debemdeboas/the-archive/internal/config"
 	"github.com/rs/zerolog"
 )
 
+// DB is a database connection. Queries are written with ? placeholders,
+// which are rewritten for the dialect of the database.
 type DB interface {
+	// Open connects to the database without changing its schema.
+	Open() error
+	// InitDB opens the database and applies any pending migrations.
 	InitDB() error
 
+	Dialect() Dialect
 	Get() *sql.DB
 	Close() error
 
 	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
 	Exec(query string, args ...interface{}) (sql.Result, error)
+
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+
+	// WithTx runs fn in a transaction, which is committed if fn returns nil
+	// and rolled back otherwise. The statements of tx are bound to ctx.
+	WithTx(ctx context.Context, fn func(tx *Tx) error) error
 }
 
 var dbLogger zerolog.Logger
@@ -22,3 +43,191 @@ var dbLogger zerolog.Logger
 func SetLogger(l zerolog.Logger) {
 	dbLogger = l
 }
+
+// New creates the database selected by cfg.Driver.
+func New(cfg config.DatabaseConfig) (DB, error) {
+	switch cfg.Driver {
+	case "", "sqlite":
+		return NewSQLite(cfg), nil
+	case "postgres":
+		return NewPostgres(cfg), nil
+	default:
+		return nil, fmt.Errorf("unknown database driver: %s", cfg.Driver)
+	}
+}
+
+// Dialect is the flavor of SQL spoken by a database.
+type Dialect string
+
+const (
+	SQLiteDialect   Dialect = "sqlite"
+	PostgresDialect Dialect = "postgres"
+)
+
+// Rebind rewrites the ? placeholders of query for the dialect. Question marks
+// inside quoted strings and identifiers are kept.
+func (d Dialect) Rebind(query string) string {
+	if d != PostgresDialect || !strings.Contains(query, "?") {
+		return query
+	}
+
+	var b strings.Builder
+	b.Grow(len(query) + 8)
+	n := 0
+	var quote byte
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '?':
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// Tx is a transaction whose queries are rebound like those of DB. Its
+// statements are bound to the context the transaction was started with.
+type Tx struct {
+	tx      *sql.Tx
+	ctx     context.Context
+	dialect Dialect
+}
+
+// RunTx runs fn in a transaction on conn, which is committed if fn returns
+// nil and rolled back otherwise, also if fn panics.
+func RunTx(ctx context.Context, conn *sql.DB, dialect Dialect, fn func(tx *Tx) error) (err error) {
+	start := time.Now()
+	sqlTx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("error starting transaction: %w", err)
+	}
+
+	defer func() {
+		if p := recover(); p != nil {
+			sqlTx.Rollback()
+			panic(p)
+		}
+		if err != nil {
+			sqlTx.Rollback()
+			logStatement("Rollback", "", start, err)
+			return
+		}
+		if err = sqlTx.Commit(); err != nil {
+			err = fmt.Errorf("error committing transaction: %w", err)
+		}
+		logStatement("Commit", "", start, err)
+	}()
+
+	return fn(&Tx{tx: sqlTx, ctx: ctx, dialect: dialect})
+}
+
+func (tx *Tx) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := tx.tx.QueryContext(tx.ctx, tx.dialect.Rebind(query), args...)
+	logStatement("Query", query, start, err)
+	return rows, err
+}
+
+func (tx *Tx) QueryRow(query string, args ...interface{}) *sql.Row {
+	start := time.Now()
+	row := tx.tx.QueryRowContext(tx.ctx, tx.dialect.Rebind(query), args...)
+	logStatement("QueryRow", query, start, row.Err())
+	return row
+}
+
+func (tx *Tx) Exec(query string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	res, err := tx.tx.ExecContext(tx.ctx, tx.dialect.Rebind(query), args...)
+	logStatement("Exec", query, start, err)
+	return res, err
+}
+
+// pool implements the queries of DB on a connection pool. SQLite and
+// Postgres embed it.
+type pool struct {
+	conn    *sql.DB
+	dialect Dialect
+}
+
+func (p *pool) Dialect() Dialect {
+	return p.dialect
+}
+
+func (p *pool) Get() *sql.DB {
+	return p.conn
+}
+
+func (p *pool) Close() error {
+	if p.conn != nil {
+		return p.conn.Close()
+	}
+	return nil
+}
+
+func (p *pool) Query(query string, args ...interface{}) (*sql.Rows, error) {
+	return p.QueryContext(context.Background(), query, args...)
+}
+
+func (p *pool) QueryRow(query string, args ...interface{}) *sql.Row {
+	return p.QueryRowContext(context.Background(), query, args...)
+}
+
+func (p *pool) Exec(query string, args ...interface{}) (sql.Result, error) {
+	return p.ExecContext(context.Background(), query, args...)
+}
+
+func (p *pool) QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	start := time.Now()
+	rows, err := p.conn.QueryContext(ctx, p.dialect.Rebind(query), args...)
+	logStatement("Query", query, start, err)
+	return rows, err
+}
+
+func (p *pool) QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row {
+	start := time.Now()
+	row := p.conn.QueryRowContext(ctx, p.dialect.Rebind(query), args...)
+	logStatement("QueryRow", query, start, row.Err())
+	return row
+}
+
+func (p *pool) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	start := time.Now()
+	res, err := p.conn.ExecContext(ctx, p.dialect.Rebind(query), args...)
+	logStatement("Exec", query, start, err)
+	return res, err
+}
+
+func (p *pool) WithTx(ctx context.Context, fn func(tx *Tx) error) error {
+	return RunTx(ctx, p.conn, p.dialect, fn)
+}
+
+// logStatement logs a statement and how long it took. Queries are timed until
+// their first row is ready, not until every row was read.
+func logStatement(op, query string, start time.Time, err error) {
+	e := dbLogger.Debug()
+	if !e.Enabled() {
+		return
+	}
+	if query != "" {
+		e = e.Str("query", query)
+	}
+	e.Dur("duration", time.Since(start)).AnErr("error", err).Msg(op)
+}
+
+// configurePool applies the connection pool limits of cfg to conn.
+func configurePool(conn *sql.DB, cfg config.DatabaseConfig) {
+	conn.SetMaxOpenConns(cfg.MaxOpenConns)
+	if cfg.MaxIdleConns > 0 {
+		conn.SetMaxIdleConns(cfg.MaxIdleConns)
+	}
+	conn.SetConnMaxLifetime(time.Duration(cfg.ConnMaxLifetime) * time.Second)
+}
@@ -1,11 +1,19 @@
 package db
 
 import (
+	"bytes"
+	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"os"
+	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/debemdeboas/the-archive/internal/config"
 	"github.com/rs/zerolog"
 )
 
@@ -16,6 +24,8 @@ const insertUserUsername = `INSERT INTO users (id, username) VALUES (?, ?)`
 
 const testEmail = "test@example.com"
 
+var inMemory = config.DatabaseConfig{InMemory: true}
+
 func TestSetLogger(t *testing.T) {
 	logger := zerolog.New(os.Stdout).Level(zerolog.InfoLevel)
 	SetLogger(logger)
@@ -25,7 +35,7 @@ func TestSetLogger(t *testing.T) {
 }
 
 func TestNewSQLite(t *testing.T) {
-	db := NewSQLite()
+	db := NewSQLite(inMemory)
 
 	if db == nil {
 		t.Fatal("Expected non-nil SQLite instance")
@@ -49,10 +59,7 @@ func TestSQLiteBasicOperations(t *testing.T) {
 	defer os.Remove(dbFile.Name())
 	dbFile.Close()
 
-	// Note: We can't easily change the hardcoded path in InitDB,
-	// so we'll work with the actual database file
-
-	db := NewSQLite()
+	db := NewSQLite(config.DatabaseConfig{Path: dbFile.Name()})
 	defer db.Close()
 
 	t.Run("InitDB creates tables", func(t *testing.T) {
@@ -151,6 +158,32 @@ func TestSQLiteBasicOperations(t *testing.T) {
 		}
 	})
 
+	t.Run("Deleted posts leave a tombstone", func(t *testing.T) {
+		postID := fmt.Sprintf("tombstone-test-%d", time.Now().UnixNano())
+		if _, err := db.Exec("INSERT INTO posts (id, title) VALUES (?, ?)", postID, "Tombstone"); err != nil {
+			t.Fatalf("Failed to insert post: %v", err)
+		}
+		if _, err := db.Exec("DELETE FROM posts WHERE id = ?", postID); err != nil {
+			t.Fatalf("Failed to delete post: %v", err)
+		}
+
+		var count int
+		db.Get().QueryRow("SELECT COUNT(*) FROM post_tombstones WHERE post_id = ?", postID).Scan(&count)
+		if count != 1 {
+			t.Errorf("Expected 1 tombstone, got %d", count)
+		}
+
+		// Creating the post again removes its tombstone
+		if _, err := db.Exec("INSERT INTO posts (id, title) VALUES (?, ?)", postID, "Tombstone"); err != nil {
+			t.Fatalf("Failed to insert post: %v", err)
+		}
+		db.Get().QueryRow("SELECT COUNT(*) FROM post_tombstones WHERE post_id = ?", postID).Scan(&count)
+		if count != 0 {
+			t.Errorf("Expected the tombstone to be removed, got %d", count)
+		}
+		db.Exec("DELETE FROM posts WHERE id = ?", postID)
+	})
+
 	t.Run("Foreign keys are enabled", func(t *testing.T) {
 		rows, err := db.Query("PRAGMA foreign_keys")
 		if err != nil {
@@ -178,7 +211,7 @@ func TestSQLiteQueryAndExec(t *testing.T) {
 	logger := zerolog.New(os.Stdout).Level(zerolog.ErrorLevel)
 	SetLogger(logger)
 
-	db := NewSQLite()
+	db := NewSQLite(inMemory)
 	defer db.Close()
 
 	err := db.InitDB()
@@ -342,7 +375,7 @@ func TestSQLiteErrorHandling(t *testing.T) {
 	SetLogger(logger)
 
 	t.Run("Query on uninitialized database", func(t *testing.T) {
-		db := NewSQLite()
+		db := NewSQLite(inMemory)
 		defer db.Close()
 
 		// Don't call InitDB() - connection will be nil
@@ -357,7 +390,7 @@ func TestSQLiteErrorHandling(t *testing.T) {
 	})
 
 	t.Run("Exec on uninitialized database", func(t *testing.T) {
-		db := NewSQLite()
+		db := NewSQLite(inMemory)
 		defer db.Close()
 
 		// Don't call InitDB() - connection will be nil
@@ -371,7 +404,7 @@ func TestSQLiteErrorHandling(t *testing.T) {
 	})
 
 	t.Run("Invalid SQL query", func(t *testing.T) {
-		db := NewSQLite()
+		db := NewSQLite(inMemory)
 		defer db.Close()
 
 		err := db.InitDB()
@@ -386,7 +419,7 @@ func TestSQLiteErrorHandling(t *testing.T) {
 	})
 
 	t.Run("Invalid SQL exec", func(t *testing.T) {
-		db := NewSQLite()
+		db := NewSQLite(inMemory)
 		defer db.Close()
 
 		err := db.InitDB()
@@ -401,7 +434,7 @@ func TestSQLiteErrorHandling(t *testing.T) {
 	})
 
 	t.Run("Constraint violation", func(t *testing.T) {
-		db := NewSQLite()
+		db := NewSQLite(inMemory)
 		defer db.Close()
 
 		err := db.InitDB()
@@ -432,7 +465,7 @@ func TestSQLiteClose(t *testing.T) {
 	SetLogger(logger)
 
 	t.Run("Close initialized database", func(t *testing.T) {
-		db := NewSQLite()
+		db := NewSQLite(inMemory)
 
 		err := db.InitDB()
 		if err != nil {
@@ -454,7 +487,7 @@ func TestSQLiteClose(t *testing.T) {
 	})
 
 	t.Run("Close uninitialized database", func(t *testing.T) {
-		db := NewSQLite()
+		db := NewSQLite(inMemory)
 
 		// Don't call InitDB()
 		err := db.Close()
@@ -464,7 +497,7 @@ func TestSQLiteClose(t *testing.T) {
 	})
 
 	t.Run("Close database twice", func(t *testing.T) {
-		db := NewSQLite()
+		db := NewSQLite(inMemory)
 
 		err := db.InitDB()
 		if err != nil {
@@ -484,7 +517,7 @@ func TestSQLiteClose(t *testing.T) {
 }
 
 func TestSQLiteGet(t *testing.T) {
-	db := NewSQLite()
+	db := NewSQLite(inMemory)
 	defer db.Close()
 
 	t.Run("Get before init returns nil", func(t *testing.T) {
@@ -518,7 +551,7 @@ func TestDbInterface(t *testing.T) {
 	var _ DB = (*SQLite)(nil)
 
 	// Test interface methods work
-	db := NewSQLite()
+	db := NewSQLite(inMemory)
 	defer db.Close()
 
 	// Test interface method calls
@@ -531,9 +564,11 @@ func TestDbInterface(t *testing.T) {
 		t.Error("Interface Get returned nil")
 	}
 
-	_, err = db.Query(select1)
+	rows, err := db.Query(select1)
 	if err != nil {
 		t.Errorf("Interface Query failed: %v", err)
+	} else {
+		rows.Close()
 	}
 
 	_, err = db.Exec(select1)
@@ -548,18 +583,13 @@ func TestDbInterface(t *testing.T) {
 }
 
 func TestDatabaseCreationWithCustomPath(t *testing.T) {
-	// Note: This test demonstrates the limitation that the database path is hardcoded
-	// In a real refactor, we'd want to make the path configurable
-
 	logger := zerolog.New(os.Stdout).Level(zerolog.ErrorLevel)
 	SetLogger(logger)
 
 	t.Run("Database file is created", func(t *testing.T) {
-		// Clean up any existing database file
-		os.Remove("./database.db")
-		defer os.Remove("./database.db")
+		path := filepath.Join(t.TempDir(), "archive.db")
 
-		db := NewSQLite()
+		db := NewSQLite(config.DatabaseConfig{Path: path})
 		defer db.Close()
 
 		err := db.InitDB()
@@ -568,21 +598,221 @@ func TestDatabaseCreationWithCustomPath(t *testing.T) {
 		}
 
 		// Check that the database file was created
-		if _, err := os.Stat("./database.db"); os.IsNotExist(err) {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
 			t.Error("Expected database file to be created")
 		}
 	})
+
+	t.Run("Connections are tuned", func(t *testing.T) {
+		db := NewSQLite(config.DatabaseConfig{
+			Path:         filepath.Join(t.TempDir(), "archive.db"),
+			JournalMode:  "wal",
+			BusyTimeout:  1234,
+			Synchronous:  "full",
+			MaxOpenConns: 3,
+		})
+		defer db.Close()
+
+		if err := db.InitDB(); err != nil {
+			t.Fatalf(failedToInitDB, err)
+		}
+
+		var journalMode string
+		var busyTimeout, synchronous int
+		db.Get().QueryRow("PRAGMA journal_mode").Scan(&journalMode)
+		db.Get().QueryRow("PRAGMA busy_timeout").Scan(&busyTimeout)
+		db.Get().QueryRow("PRAGMA synchronous").Scan(&synchronous)
+		if journalMode != "wal" || busyTimeout != 1234 || synchronous != 2 {
+			t.Errorf("Unexpected pragmas journal_mode=%s busy_timeout=%d synchronous=%d", journalMode, busyTimeout, synchronous)
+		}
+		if n := db.Get().Stats().MaxOpenConnections; n != 3 {
+			t.Errorf("Expected at most 3 connections, got %d", n)
+		}
+	})
+
+	t.Run("In-memory databases leave no file behind", func(t *testing.T) {
+		dir := t.TempDir()
+		db := NewSQLite(config.DatabaseConfig{Path: filepath.Join(dir, "archive.db"), InMemory: true})
+		defer db.Close()
+
+		if err := db.InitDB(); err != nil {
+			t.Fatalf(failedToInitDB, err)
+		}
+		if _, err := db.Exec(insertUserUsername, "memory-user", "memory"); err != nil {
+			t.Fatalf("Failed to insert user: %v", err)
+		}
+
+		// Every query must see the same database
+		var count int
+		db.Get().QueryRow("SELECT COUNT(*) FROM users").Scan(&count)
+		if count != 1 {
+			t.Errorf("Expected 1 user, got %d", count)
+		}
+		if entries, _ := os.ReadDir(dir); len(entries) != 0 {
+			t.Errorf("Expected no files, got %d", len(entries))
+		}
+	})
+
+	t.Run("DSN is used as is", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "dsn.db")
+		db := NewSQLite(config.DatabaseConfig{Path: "ignored.db", DSN: "file:" + path + "?_time_format=auto"})
+		defer db.Close()
+
+		if err := db.InitDB(); err != nil {
+			t.Fatalf(failedToInitDB, err)
+		}
+		if _, err := os.Stat(path); err != nil {
+			t.Errorf("Expected the DSN database to be created: %v", err)
+		}
+	})
+
+	t.Run("Invalid path", func(t *testing.T) {
+		db := NewSQLite(config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "missing", "archive.db")})
+		if err := db.InitDB(); err == nil {
+			t.Error("Expected an error opening a database in a missing directory")
+		}
+	})
+}
+
+func TestSQLiteContext(t *testing.T) {
+	db := NewSQLite(inMemory)
+	if err := db.InitDB(); err != nil {
+		t.Fatalf(failedToInitDB, err)
+	}
+	defer db.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if _, err := db.ExecContext(ctx, insertUserUsername, "user-cancelled", "cancelled"); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected ExecContext to fail with context.Canceled, got %v", err)
+	}
+	if _, err := db.QueryContext(ctx, select1); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected QueryContext to fail with context.Canceled, got %v", err)
+	}
+	if err := db.QueryRowContext(ctx, select1).Scan(new(int)); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected QueryRowContext to fail with context.Canceled, got %v", err)
+	}
+
+	var one int
+	if err := db.QueryRowContext(context.Background(), select1).Scan(&one); err != nil || one != 1 {
+		t.Errorf("Expected 1, got %d (%v)", one, err)
+	}
+}
+
+func TestSQLiteWithTx(t *testing.T) {
+	db := NewSQLite(inMemory)
+	if err := db.InitDB(); err != nil {
+		t.Fatalf(failedToInitDB, err)
+	}
+	defer db.Close()
+	ctx := context.Background()
+
+	countUsers := func(t *testing.T, id string) int {
+		t.Helper()
+		var count int
+		if err := db.QueryRow(`SELECT COUNT(*) FROM users WHERE id = ?`, id).Scan(&count); err != nil {
+			t.Fatalf("Failed to count users: %v", err)
+		}
+		return count
+	}
+
+	t.Run("Commits when fn succeeds", func(t *testing.T) {
+		err := db.WithTx(ctx, func(tx *Tx) error {
+			if _, err := tx.Exec(insertUserUsername, "user-commit", "commit"); err != nil {
+				return err
+			}
+			var username string
+			return tx.QueryRow(`SELECT username FROM users WHERE id = ?`, "user-commit").Scan(&username)
+		})
+		if err != nil {
+			t.Fatalf("WithTx failed: %v", err)
+		}
+		if countUsers(t, "user-commit") != 1 {
+			t.Error("Expected the insert to be committed")
+		}
+	})
+
+	t.Run("Rolls back when fn fails", func(t *testing.T) {
+		errFailed := errors.New("failed")
+		err := db.WithTx(ctx, func(tx *Tx) error {
+			if _, err := tx.Exec(insertUserUsername, "user-error", "error"); err != nil {
+				return err
+			}
+			return errFailed
+		})
+		if !errors.Is(err, errFailed) {
+			t.Errorf("Expected the error of fn, got %v", err)
+		}
+		if countUsers(t, "user-error") != 0 {
+			t.Error("Expected the insert to be rolled back")
+		}
+	})
+
+	t.Run("Rolls back when fn panics", func(t *testing.T) {
+		func() {
+			defer func() {
+				if recover() == nil {
+					t.Error("Expected the panic to be propagated")
+				}
+			}()
+			db.WithTx(ctx, func(tx *Tx) error {
+				tx.Exec(insertUserUsername, "user-panic", "panic")
+				panic("boom")
+			})
+		}()
+		if countUsers(t, "user-panic") != 0 {
+			t.Error("Expected the insert to be rolled back")
+		}
+	})
+
+	t.Run("Cancelled context", func(t *testing.T) {
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		called := false
+		err := db.WithTx(cancelled, func(tx *Tx) error {
+			called = true
+			return nil
+		})
+		if !errors.Is(err, context.Canceled) || called {
+			t.Errorf("Expected the transaction not to start, got %v (called: %t)", err, called)
+		}
+	})
+}
+
+func TestStatementTiming(t *testing.T) {
+	var buf bytes.Buffer
+	SetLogger(zerolog.New(&buf).Level(zerolog.DebugLevel))
+	defer SetLogger(zerolog.Nop())
+
+	db := NewSQLite(inMemory)
+	if err := db.InitDB(); err != nil {
+		t.Fatalf(failedToInitDB, err)
+	}
+	defer db.Close()
+
+	buf.Reset()
+	if _, err := db.Exec(insertUserUsername, "user-timed", "timed"); err != nil {
+		t.Fatalf("Failed to insert user: %v", err)
+	}
+
+	var entry map[string]any
+	if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+		t.Fatalf("Expected one JSON log entry, got %q: %v", buf.String(), err)
+	}
+	if entry["message"] != "Exec" || entry["query"] != insertUserUsername {
+		t.Errorf("Unexpected log entry %v", entry)
+	}
+	if _, ok := entry["duration"].(float64); !ok {
+		t.Errorf("Expected the statement duration to be logged, got %v", entry)
+	}
 }
 
 func BenchmarkSQLiteOperations(b *testing.B) {
 	logger := zerolog.New(os.Stdout).Level(zerolog.ErrorLevel)
 	SetLogger(logger)
 
-	// Clean up
-	os.Remove("./database.db")
-	defer os.Remove("./database.db")
-
-	db := NewSQLite()
+	db := NewSQLite(config.DatabaseConfig{Path: filepath.Join(b.TempDir(), "bench.db")})
 	defer db.Close()
 
 	err := db.InitDB()
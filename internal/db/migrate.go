@@ -0,0 +1,302 @@
+package db
+
+import (
+	"database/sql"
+	"embed"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"slices"
+	"strconv"
+	"time"
+)
+
+// Migrations live in a directory per dialect, which must all hold the same
+// versions and names.
+//
+//go:embed migrations/sqlite/*.sql migrations/postgres/*.sql
+var migrationFiles embed.FS
+
+// migrationFileName matches migration files, e.g. 0002_media.up.sql.
+var migrationFileName = regexp.MustCompile(`^(\d+)_(\w+)\.(up|down)\.sql$`)
+
+var createSchemaMigrations = map[Dialect]string{
+	SQLiteDialect: `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version INTEGER PRIMARY KEY,
+    name TEXT NOT NULL,
+    applied_at DATETIME DEFAULT CURRENT_TIMESTAMP
+)`,
+	PostgresDialect: `
+CREATE TABLE IF NOT EXISTS schema_migrations (
+    version INTEGER PRIMARY KEY,
+    name TEXT NOT NULL,
+    applied_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+)`,
+}
+
+// Migration is a versioned change to the database schema.
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string // empty if the migration cannot be rolled back
+}
+
+// MigrationStatus is a migration and when it was applied, if it was.
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// LoadMigrations reads the migrations in the root of fsys, named
+// VERSION_NAME.up.sql and, optionally, VERSION_NAME.down.sql. They are
+// returned sorted by version.
+func LoadMigrations(fsys fs.FS) ([]Migration, error) {
+	entries, err := fs.ReadDir(fsys, ".")
+	if err != nil {
+		return nil, err
+	}
+
+	byVersion := make(map[int]*Migration)
+	for _, entry := range entries {
+		match := migrationFileName.FindStringSubmatch(entry.Name())
+		if entry.IsDir() || match == nil {
+			continue
+		}
+
+		version, _ := strconv.Atoi(match[1])
+		if version <= 0 {
+			return nil, fmt.Errorf("migration %s: version must be positive", entry.Name())
+		}
+		m, ok := byVersion[version]
+		if !ok {
+			m = &Migration{Version: version, Name: match[2]}
+			byVersion[version] = m
+		} else if m.Name != match[2] {
+			return nil, fmt.Errorf("migration %d is named both %q and %q", version, m.Name, match[2])
+		}
+
+		data, err := fs.ReadFile(fsys, entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		if match[3] == "up" {
+			m.Up = string(data)
+		} else {
+			m.Down = string(data)
+		}
+	}
+
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" {
+			return nil, fmt.Errorf("migration %d (%s) has no up script", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	slices.SortFunc(migrations, func(a, b Migration) int { return a.Version - b.Version })
+	return migrations, nil
+}
+
+// Migrator applies and rolls back migrations, recording the applied ones in
+// the schema_migrations table. Each migration runs in its own transaction.
+type Migrator struct {
+	conn       *sql.DB
+	dialect    Dialect
+	migrations []Migration
+}
+
+// NewMigrator returns a Migrator for the migrations of the dialect embedded in the binary.
+func NewMigrator(conn *sql.DB, dialect Dialect) (*Migrator, error) {
+	migrations, err := dialectMigrations(dialect)
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{conn: conn, dialect: dialect, migrations: migrations}, nil
+}
+
+func dialectMigrations(dialect Dialect) ([]Migration, error) {
+	if _, ok := createSchemaMigrations[dialect]; !ok {
+		return nil, fmt.Errorf("no migrations for dialect %s", dialect)
+	}
+	dir, err := fs.Sub(migrationFiles, "migrations/"+string(dialect))
+	if err != nil {
+		return nil, err
+	}
+	migrations, err := LoadMigrations(dir)
+	if err != nil {
+		return nil, fmt.Errorf("error loading %s migrations: %w", dialect, err)
+	}
+	return migrations, nil
+}
+
+// Latest returns the version of the newest known migration, or 0 if there are none.
+func (m *Migrator) Latest() int {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Version returns the version of the newest applied migration, or 0 if none was applied.
+func (m *Migrator) Version() (int, error) {
+	if _, err := m.conn.Exec(createSchemaMigrations[m.dialect]); err != nil {
+		return 0, err
+	}
+	var version int
+	err := m.conn.QueryRow(`SELECT COALESCE(MAX(version), 0) FROM schema_migrations`).Scan(&version)
+	return version, err
+}
+
+// Status lists the known migrations, and any applied migration this binary
+// does not know about, with when they were applied.
+func (m *Migrator) Status() ([]MigrationStatus, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	var status []MigrationStatus
+	for _, migration := range m.migrations {
+		s := MigrationStatus{Migration: migration}
+		if appliedAt, ok := applied[migration.Version]; ok {
+			s.AppliedAt = &appliedAt
+			delete(applied, migration.Version)
+		}
+		status = append(status, s)
+	}
+	for version, appliedAt := range applied {
+		status = append(status, MigrationStatus{
+			Migration: Migration{Version: version, Name: "unknown"},
+			AppliedAt: &appliedAt,
+		})
+	}
+	slices.SortFunc(status, func(a, b MigrationStatus) int { return a.Version - b.Version })
+	return status, nil
+}
+
+func (m *Migrator) applied() (map[int]time.Time, error) {
+	if _, err := m.conn.Exec(createSchemaMigrations[m.dialect]); err != nil {
+		return nil, err
+	}
+	rows, err := m.conn.Query(`SELECT version, applied_at FROM schema_migrations`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	applied := make(map[int]time.Time)
+	for rows.Next() {
+		var version int
+		var appliedAt time.Time
+		if err := rows.Scan(&version, &appliedAt); err != nil {
+			return nil, err
+		}
+		applied[version] = appliedAt
+	}
+	return applied, rows.Err()
+}
+
+// Up applies all pending migrations and returns how many were applied.
+func (m *Migrator) Up() (int, error) {
+	return m.UpTo(m.Latest())
+}
+
+// UpTo applies the pending migrations up to and including version and
+// returns how many were applied. Migrations are applied in order and
+// applying stops at the first one that fails.
+func (m *Migrator) UpTo(version int) (int, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return 0, err
+	}
+	for v := range applied {
+		if v > m.Latest() {
+			return 0, fmt.Errorf("database schema version %d is newer than the latest known migration %d", v, m.Latest())
+		}
+	}
+
+	count := 0
+	for _, migration := range m.migrations {
+		if migration.Version > version {
+			break
+		}
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+		if err := m.apply(migration, true); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// Down rolls back the given number of most recently applied migrations and
+// returns how many were rolled back.
+func (m *Migrator) Down(steps int) (int, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return 0, err
+	}
+	versions := make([]int, 0, len(applied))
+	for v := range applied {
+		versions = append(versions, v)
+	}
+	slices.Sort(versions)
+	slices.Reverse(versions)
+
+	count := 0
+	for _, v := range versions[:min(steps, len(versions))] {
+		i := slices.IndexFunc(m.migrations, func(migration Migration) bool { return migration.Version == v })
+		if i < 0 {
+			return count, fmt.Errorf("no migration for applied version %d", v)
+		}
+		if err := m.apply(m.migrations[i], false); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// apply runs a migration, or rolls it back, and records it in a single transaction.
+func (m *Migrator) apply(migration Migration, up bool) error {
+	script := migration.Up
+	record := `INSERT INTO schema_migrations (version, name) VALUES (?, ?)`
+	args := []any{migration.Version, migration.Name}
+	if !up {
+		if migration.Down == "" {
+			return fmt.Errorf("migration %d (%s) cannot be rolled back", migration.Version, migration.Name)
+		}
+		script = migration.Down
+		record = `DELETE FROM schema_migrations WHERE version = ?`
+		args = args[:1]
+	}
+
+	tx, err := m.conn.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(script); err != nil {
+		return fmt.Errorf("migration %d (%s): %w", migration.Version, migration.Name, err)
+	}
+	// A migration applied concurrently by another process violates the
+	// primary key, which rolls this one back
+	if _, err := tx.Exec(m.dialect.Rebind(record), args...); err != nil {
+		return fmt.Errorf("error recording migration %d (%s): %w", migration.Version, migration.Name, err)
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	if up {
+		dbLogger.Info().Int("version", migration.Version).Str("name", migration.Name).Msg("Applied migration")
+	} else {
+		dbLogger.Info().Int("version", migration.Version).Str("name", migration.Name).Msg("Rolled back migration")
+	}
+	return nil
+}
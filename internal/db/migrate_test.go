@@ -0,0 +1,258 @@
+package db
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// untrackedVersion is the last migration that existed before migrations were
+// tracked.
+const untrackedVersion = 4
+
+func newTestMigrator(t *testing.T) (*Migrator, *sql.DB) {
+	t.Helper()
+	conn, err := sql.Open("sqlite3", ":memory:")
+	if err != nil {
+		t.Fatalf("Failed to open database: %v", err)
+	}
+	// Every connection to :memory: is a separate database
+	conn.SetMaxOpenConns(1)
+	t.Cleanup(func() { conn.Close() })
+
+	migrator, err := NewMigrator(conn, SQLiteDialect)
+	if err != nil {
+		t.Fatalf("NewMigrator failed: %v", err)
+	}
+	return migrator, conn
+}
+
+// schema returns the definitions of everything in the database except the
+// bookkeeping tables, so that two databases can be compared.
+func schema(t *testing.T, conn *sql.DB) string {
+	t.Helper()
+	rows, err := conn.Query(`SELECT type, name, COALESCE(sql, '') FROM sqlite_master
+		WHERE name NOT IN ('schema_migrations', 'sqlite_sequence') ORDER BY type, name`)
+	if err != nil {
+		t.Fatalf("Failed to read schema: %v", err)
+	}
+	defer rows.Close()
+
+	var b strings.Builder
+	for rows.Next() {
+		var kind, name, definition string
+		if err := rows.Scan(&kind, &name, &definition); err != nil {
+			t.Fatalf("Failed to read schema: %v", err)
+		}
+		b.WriteString(kind + " " + name + ": " + definition + "\n")
+	}
+	return b.String()
+}
+
+func TestLoadMigrations(t *testing.T) {
+	migrator, _ := newTestMigrator(t)
+
+	t.Run("Embedded migrations are numbered in order", func(t *testing.T) {
+		for i, m := range migrator.migrations {
+			if m.Version != i+1 {
+				t.Errorf("Expected migration %d, got %d (%s)", i+1, m.Version, m.Name)
+			}
+			if m.Down == "" {
+				t.Errorf("Expected migration %d (%s) to have a down script", m.Version, m.Name)
+			}
+		}
+	})
+
+	testCases := map[string]fstest.MapFS{
+		"Missing up script": {
+			"0001_a.down.sql": {Data: []byte("DROP TABLE a;")},
+		},
+		"Conflicting names": {
+			"0001_a.up.sql": {Data: []byte("CREATE TABLE a (id TEXT);")},
+			"0001_b.up.sql": {Data: []byte("CREATE TABLE b (id TEXT);")},
+		},
+		"Version zero": {
+			"0000_a.up.sql": {Data: []byte("CREATE TABLE a (id TEXT);")},
+		},
+	}
+	for name, fsys := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := LoadMigrations(fsys); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+
+	t.Run("Other files are ignored", func(t *testing.T) {
+		migrations, err := LoadMigrations(fstest.MapFS{
+			"0002_b.up.sql": {Data: []byte("CREATE TABLE b (id TEXT);")},
+			"0001_a.up.sql": {Data: []byte("CREATE TABLE a (id TEXT);")},
+			"README.md":     {Data: []byte("# Migrations")},
+		})
+		if err != nil {
+			t.Fatalf("LoadMigrations failed: %v", err)
+		}
+		if len(migrations) != 2 || migrations[0].Name != "a" || migrations[1].Name != "b" {
+			t.Errorf("Unexpected migrations %+v", migrations)
+		}
+	})
+}
+
+func TestMigratorFromZero(t *testing.T) {
+	migrator, conn := newTestMigrator(t)
+
+	applied, err := migrator.Up()
+	if err != nil {
+		t.Fatalf("Up failed: %v", err)
+	}
+	if applied != migrator.Latest() {
+		t.Errorf("Expected %d migrations to be applied, got %d", migrator.Latest(), applied)
+	}
+	if version, _ := migrator.Version(); version != migrator.Latest() {
+		t.Errorf("Expected version %d, got %d", migrator.Latest(), version)
+	}
+	for _, table := range []string{"users", "drafts", "posts", "media", "media_references", "post_slugs", "post_tombstones"} {
+		var name string
+		if err := conn.QueryRow(`SELECT name FROM sqlite_master WHERE type = 'table' AND name = ?`, table).Scan(&name); err != nil {
+			t.Errorf("Expected table %s to exist: %v", table, err)
+		}
+	}
+	want := schema(t, conn)
+
+	t.Run("Applying again is a no-op", func(t *testing.T) {
+		if applied, err := migrator.Up(); err != nil || applied != 0 {
+			t.Errorf("Expected nothing to be applied, got %d (%v)", applied, err)
+		}
+	})
+
+	t.Run("Rolling back everything empties the database", func(t *testing.T) {
+		rolledBack, err := migrator.Down(migrator.Latest())
+		if err != nil {
+			t.Fatalf("Down failed: %v", err)
+		}
+		if rolledBack != migrator.Latest() {
+			t.Errorf("Expected %d migrations to be rolled back, got %d", migrator.Latest(), rolledBack)
+		}
+		if got := schema(t, conn); got != "" {
+			t.Errorf("Expected an empty schema, got:\n%s", got)
+		}
+		if version, _ := migrator.Version(); version != 0 {
+			t.Errorf("Expected version 0, got %d", version)
+		}
+	})
+
+	t.Run("Migrating again restores the schema", func(t *testing.T) {
+		if _, err := migrator.Up(); err != nil {
+			t.Fatalf("Up failed: %v", err)
+		}
+		if got := schema(t, conn); got != want {
+			t.Errorf("Expected schema:\n%s\ngot:\n%s", want, got)
+		}
+	})
+}
+
+func TestMigratorFromEachVersion(t *testing.T) {
+	reference, conn := newTestMigrator(t)
+	if _, err := reference.Up(); err != nil {
+		t.Fatalf("Up failed: %v", err)
+	}
+	want := schema(t, conn)
+
+	for version := 0; version <= reference.Latest(); version++ {
+		migrator, conn := newTestMigrator(t)
+		if _, err := migrator.UpTo(version); err != nil {
+			t.Fatalf("UpTo(%d) failed: %v", version, err)
+		}
+		if got, _ := migrator.Version(); got != version {
+			t.Errorf("Expected version %d, got %d", version, got)
+		}
+
+		applied, err := migrator.Up()
+		if err != nil {
+			t.Fatalf("Up from version %d failed: %v", version, err)
+		}
+		if applied != migrator.Latest()-version {
+			t.Errorf("Expected %d migrations to be applied from version %d, got %d", migrator.Latest()-version, version, applied)
+		}
+		if got := schema(t, conn); got != want {
+			t.Errorf("Schema migrated from version %d differs:\n%s\nwant:\n%s", version, got, want)
+		}
+	}
+
+	t.Run("Databases created before migrations were tracked", func(t *testing.T) {
+		// Their schema is the one of the migrations that existed back then,
+		// which are written to be applied again
+		migrator, conn := newTestMigrator(t)
+		for _, m := range migrator.migrations[:untrackedVersion] {
+			if _, err := conn.Exec(m.Up); err != nil {
+				t.Fatalf("Failed to create the schema: %v", err)
+			}
+		}
+
+		if _, err := migrator.Up(); err != nil {
+			t.Fatalf("Up failed: %v", err)
+		}
+		if got := schema(t, conn); got != want {
+			t.Errorf("Expected schema:\n%s\ngot:\n%s", want, got)
+		}
+	})
+}
+
+func TestMigratorFailures(t *testing.T) {
+	migrator, conn := newTestMigrator(t)
+	migrator.migrations = []Migration{
+		{Version: 1, Name: "a", Up: "CREATE TABLE a (id TEXT);"},
+		{Version: 2, Name: "b", Up: "CREATE TABLE b (id TEXT); INSERT INTO missing VALUES (1);", Down: "DROP TABLE b;"},
+	}
+
+	t.Run("Failed migrations are rolled back", func(t *testing.T) {
+		applied, err := migrator.Up()
+		if err == nil {
+			t.Fatal("Expected an error")
+		}
+		if applied != 1 {
+			t.Errorf("Expected the first migration to be applied, got %d", applied)
+		}
+		if version, _ := migrator.Version(); version != 1 {
+			t.Errorf("Expected version 1, got %d", version)
+		}
+		var name string
+		if err := conn.QueryRow(`SELECT name FROM sqlite_master WHERE name = 'b'`).Scan(&name); err != sql.ErrNoRows {
+			t.Errorf("Expected table b to be rolled back, got %v", err)
+		}
+	})
+
+	t.Run("Status reports applied migrations", func(t *testing.T) {
+		status, err := migrator.Status()
+		if err != nil {
+			t.Fatalf("Status failed: %v", err)
+		}
+		if len(status) != 2 || status[0].AppliedAt == nil || status[1].AppliedAt != nil {
+			t.Errorf("Unexpected status %+v", status)
+		}
+	})
+
+	t.Run("Migrations without a down script cannot be rolled back", func(t *testing.T) {
+		if _, err := migrator.Down(1); err == nil {
+			t.Error("Expected an error")
+		}
+		if version, _ := migrator.Version(); version != 1 {
+			t.Errorf("Expected version 1, got %d", version)
+		}
+	})
+
+	t.Run("Newer databases are not migrated", func(t *testing.T) {
+		if _, err := conn.Exec(`INSERT INTO schema_migrations (version, name) VALUES (3, 'c')`); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := migrator.Up(); err == nil {
+			t.Error("Expected an error")
+		}
+
+		status, _ := migrator.Status()
+		if len(status) != 3 || status[2].Name != "unknown" {
+			t.Errorf("Expected the unknown migration to be listed, got %+v", status)
+		}
+	})
+}
@@ -0,0 +1,24 @@
+CREATE TABLE users (
+    id TEXT PRIMARY KEY,
+    username TEXT UNIQUE,
+    email TEXT,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE drafts (
+    id TEXT PRIMARY KEY,
+    title TEXT,
+    content BYTEA,
+    user_id TEXT,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE posts (
+    id TEXT PRIMARY KEY,
+    title TEXT,
+    content BYTEA,
+    md_content_hash TEXT,
+    modified_at TIMESTAMPTZ,
+    user_id TEXT,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,19 @@
+CREATE TABLE media (
+    id TEXT PRIMARY KEY,
+    user_id TEXT,
+    content_type TEXT,
+    size BIGINT,
+    width INTEGER,
+    height INTEGER,
+    source_hash TEXT UNIQUE,
+    variants TEXT,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE media_references (
+    media_id TEXT NOT NULL,
+    post_id TEXT NOT NULL,
+    PRIMARY KEY (media_id, post_id)
+);
+
+CREATE INDEX idx_media_references_post_id ON media_references (post_id);
@@ -0,0 +1,8 @@
+CREATE TABLE post_slugs (
+    slug TEXT PRIMARY KEY,
+    post_id TEXT NOT NULL,
+    is_current INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX idx_post_slugs_post_id ON post_slugs (post_id);
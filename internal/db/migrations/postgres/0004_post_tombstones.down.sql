@@ -0,0 +1,6 @@
+DROP TRIGGER posts_untombstone ON posts;
+DROP FUNCTION posts_untombstone();
+DROP TRIGGER posts_tombstone ON posts;
+DROP FUNCTION posts_tombstone();
+DROP TABLE post_tombstones;
+DROP INDEX idx_posts_modified_at;
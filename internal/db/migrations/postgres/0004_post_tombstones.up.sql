@@ -0,0 +1,29 @@
+CREATE INDEX idx_posts_modified_at ON posts (modified_at);
+
+-- Deleted posts, so that other instances can drop them from their caches
+CREATE TABLE post_tombstones (
+    seq BIGSERIAL PRIMARY KEY,
+    post_id TEXT NOT NULL,
+    deleted_at TIMESTAMPTZ DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE FUNCTION posts_tombstone() RETURNS trigger AS $$
+BEGIN
+    INSERT INTO post_tombstones (post_id) VALUES (OLD.id);
+    RETURN OLD;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER posts_tombstone AFTER DELETE ON posts
+    FOR EACH ROW EXECUTE FUNCTION posts_tombstone();
+
+-- A post created again with the same ID is no longer deleted
+CREATE FUNCTION posts_untombstone() RETURNS trigger AS $$
+BEGIN
+    DELETE FROM post_tombstones WHERE post_id = NEW.id;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER posts_untombstone AFTER INSERT ON posts
+    FOR EACH ROW EXECUTE FUNCTION posts_untombstone();
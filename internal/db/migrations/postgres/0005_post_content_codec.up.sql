@@ -0,0 +1,3 @@
+-- The codec each post's content is compressed with, so that it can be
+-- changed without rewriting every row. Existing rows were compressed with zstd
+ALTER TABLE posts ADD COLUMN content_codec TEXT NOT NULL DEFAULT 'zstd';
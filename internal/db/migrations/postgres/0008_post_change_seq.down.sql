@@ -0,0 +1,5 @@
+DROP TRIGGER posts_change_seq ON posts;
+DROP FUNCTION posts_change_seq();
+DROP INDEX idx_posts_change_seq;
+ALTER TABLE posts DROP COLUMN change_seq;
+DROP TABLE post_change_seq;
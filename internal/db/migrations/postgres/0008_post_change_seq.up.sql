@@ -0,0 +1,22 @@
+-- Every insert and update of a post takes the next number of a single
+-- counter, so that other instances can read the posts changed since the last
+-- number they saw. Writers wait on the counter row until the previous writer
+-- commits, so the numbers follow the order writes commit in, unlike
+-- modification dates or sequences
+CREATE TABLE post_change_seq (
+    seq BIGINT NOT NULL
+);
+INSERT INTO post_change_seq (seq) VALUES (0);
+
+ALTER TABLE posts ADD COLUMN change_seq BIGINT NOT NULL DEFAULT 0;
+CREATE INDEX idx_posts_change_seq ON posts (change_seq);
+
+CREATE FUNCTION posts_change_seq() RETURNS trigger AS $$
+BEGIN
+    UPDATE post_change_seq SET seq = seq + 1 RETURNING seq INTO NEW.change_seq;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER posts_change_seq BEFORE INSERT OR UPDATE ON posts
+    FOR EACH ROW EXECUTE FUNCTION posts_change_seq();
@@ -0,0 +1,3 @@
+DROP TABLE posts;
+DROP TABLE drafts;
+DROP TABLE users;
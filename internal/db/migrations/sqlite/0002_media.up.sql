@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS media (
+    id TEXT PRIMARY KEY,
+    user_id TEXT,
+    content_type TEXT,
+    size INTEGER,
+    width INTEGER,
+    height INTEGER,
+    source_hash TEXT UNIQUE,
+    variants TEXT,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS media_references (
+    media_id TEXT NOT NULL,
+    post_id TEXT NOT NULL,
+    PRIMARY KEY (media_id, post_id)
+);
+
+CREATE INDEX IF NOT EXISTS idx_media_references_post_id ON media_references (post_id);
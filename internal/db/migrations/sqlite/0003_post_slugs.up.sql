@@ -0,0 +1,8 @@
+CREATE TABLE IF NOT EXISTS post_slugs (
+    slug TEXT PRIMARY KEY,
+    post_id TEXT NOT NULL,
+    is_current INTEGER NOT NULL DEFAULT 0,
+    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_post_slugs_post_id ON post_slugs (post_id);
@@ -0,0 +1,4 @@
+DROP TRIGGER posts_untombstone;
+DROP TRIGGER posts_tombstone;
+DROP TABLE post_tombstones;
+DROP INDEX idx_posts_modified_at;
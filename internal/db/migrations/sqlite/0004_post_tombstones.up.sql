@@ -0,0 +1,19 @@
+CREATE INDEX IF NOT EXISTS idx_posts_modified_at ON posts (modified_at);
+
+-- Deleted posts, so that other instances can drop them from their caches
+CREATE TABLE IF NOT EXISTS post_tombstones (
+    seq INTEGER PRIMARY KEY AUTOINCREMENT,
+    post_id TEXT NOT NULL,
+    deleted_at DATETIME DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE TRIGGER IF NOT EXISTS posts_tombstone AFTER DELETE ON posts
+BEGIN
+    INSERT INTO post_tombstones (post_id) VALUES (OLD.id);
+END;
+
+-- A post created again with the same ID is no longer deleted
+CREATE TRIGGER IF NOT EXISTS posts_untombstone AFTER INSERT ON posts
+BEGIN
+    DELETE FROM post_tombstones WHERE post_id = NEW.id;
+END;
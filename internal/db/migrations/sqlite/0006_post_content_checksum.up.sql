@@ -0,0 +1,4 @@
+-- md_content_hash used to be taken over the compressed content. It is now
+-- taken over the Markdown, and the checksum of the stored bytes is kept
+-- apart. Rows without a checksum are rehashed when the posts are loaded
+ALTER TABLE posts ADD COLUMN content_checksum TEXT NOT NULL DEFAULT '';
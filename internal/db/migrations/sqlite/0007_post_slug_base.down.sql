@@ -0,0 +1 @@
+ALTER TABLE post_slugs DROP COLUMN base;
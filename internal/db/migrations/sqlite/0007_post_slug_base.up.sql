@@ -0,0 +1,4 @@
+-- The slug base a current slug was derived from, so that posts whose title
+-- and slug front matter did not change are not assigned a slug again. Rows
+-- without one get it the next time their post is loaded
+ALTER TABLE post_slugs ADD COLUMN base TEXT NOT NULL DEFAULT '';
@@ -0,0 +1,5 @@
+DROP TRIGGER posts_change_seq_update;
+DROP TRIGGER posts_change_seq_insert;
+DROP INDEX idx_posts_change_seq;
+ALTER TABLE posts DROP COLUMN change_seq;
+DROP TABLE post_change_seq;
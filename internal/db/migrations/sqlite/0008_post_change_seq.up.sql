@@ -0,0 +1,25 @@
+-- Every insert and update of a post takes the next number of a single
+-- counter, so that other instances can read the posts changed since the last
+-- number they saw. Unlike modification dates, which are set by whichever
+-- instance wrote the post, the numbers follow the order writes commit in
+CREATE TABLE IF NOT EXISTS post_change_seq (
+    seq INTEGER NOT NULL
+);
+INSERT INTO post_change_seq (seq) VALUES (0);
+
+ALTER TABLE posts ADD COLUMN change_seq INTEGER NOT NULL DEFAULT 0;
+CREATE INDEX IF NOT EXISTS idx_posts_change_seq ON posts (change_seq);
+
+CREATE TRIGGER IF NOT EXISTS posts_change_seq_insert AFTER INSERT ON posts
+BEGIN
+    UPDATE post_change_seq SET seq = seq + 1;
+    UPDATE posts SET change_seq = (SELECT seq FROM post_change_seq) WHERE id = NEW.id;
+END;
+
+-- Setting the number is itself an update, which must not take another one
+CREATE TRIGGER IF NOT EXISTS posts_change_seq_update AFTER UPDATE ON posts
+WHEN NEW.change_seq = OLD.change_seq
+BEGIN
+    UPDATE post_change_seq SET seq = seq + 1;
+    UPDATE posts SET change_seq = (SELECT seq FROM post_change_seq) WHERE id = NEW.id;
+END;
@@ -0,0 +1,65 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/debemdeboas/the-archive/internal/config"
+	_ "github.com/lib/pq"
+)
+
+// Postgres is a PostgreSQL database. Queries are written with ? placeholders
+// like for SQLite and rewritten to $1, $2 and so on.
+type Postgres struct {
+	pool
+	cfg config.DatabaseConfig
+}
+
+func NewPostgres(cfg config.DatabaseConfig) *Postgres {
+	return &Postgres{
+		pool: pool{dialect: PostgresDialect},
+		cfg:  cfg,
+	}
+}
+
+// Open connects to the database without changing its schema.
+func (p *Postgres) Open() error {
+	if p.cfg.DSN == "" {
+		return errors.New("the postgres driver requires a DSN")
+	}
+
+	conn, err := sql.Open("postgres", p.cfg.DSN)
+	if err != nil {
+		return err
+	}
+	configurePool(conn, p.cfg)
+
+	// Connect right away so that a bad DSN is reported here
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return err
+	}
+
+	p.conn = conn
+	return nil
+}
+
+// InitDB opens the database and applies any pending migrations.
+func (p *Postgres) InitDB() error {
+	if err := p.Open(); err != nil {
+		return err
+	}
+
+	migrator, err := NewMigrator(p.conn, PostgresDialect)
+	if err != nil {
+		return err
+	}
+	applied, err := migrator.Up()
+	if err != nil {
+		return fmt.Errorf("error migrating database: %w", err)
+	}
+
+	dbLogger.Debug().Int("applied", applied).Int("version", migrator.Latest()).Msg("Database initialized")
+	return nil
+}
@@ -0,0 +1,152 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/debemdeboas/the-archive/internal/config"
+	"github.com/debemdeboas/the-archive/internal/pgtest"
+)
+
+func TestDialectRebind(t *testing.T) {
+	testCases := []struct {
+		query    string
+		sqlite   string
+		postgres string
+	}{
+		{"SELECT 1", "SELECT 1", "SELECT 1"},
+		{"SELECT * FROM posts WHERE id = ?", "SELECT * FROM posts WHERE id = ?", "SELECT * FROM posts WHERE id = $1"},
+		{"UPDATE t SET a = ?, b = ? WHERE c = ?", "UPDATE t SET a = ?, b = ? WHERE c = ?", "UPDATE t SET a = $1, b = $2 WHERE c = $3"},
+		{"SELECT '?', \"a?\" FROM t WHERE a = ?", "SELECT '?', \"a?\" FROM t WHERE a = ?", "SELECT '?', \"a?\" FROM t WHERE a = $1"},
+	}
+
+	for _, tc := range testCases {
+		if got := SQLiteDialect.Rebind(tc.query); got != tc.sqlite {
+			t.Errorf("SQLite: expected %q, got %q", tc.sqlite, got)
+		}
+		if got := PostgresDialect.Rebind(tc.query); got != tc.postgres {
+			t.Errorf("PostgreSQL: expected %q, got %q", tc.postgres, got)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	if database, err := New(config.DatabaseConfig{}); err != nil || database.Dialect() != SQLiteDialect {
+		t.Errorf("Expected SQLite by default, got %T (%v)", database, err)
+	}
+	if database, err := New(config.DatabaseConfig{Driver: "postgres"}); err != nil || database.Dialect() != PostgresDialect {
+		t.Errorf("Expected PostgreSQL, got %T (%v)", database, err)
+	}
+	if _, err := New(config.DatabaseConfig{Driver: "oracle"}); err == nil {
+		t.Error("Expected error for unknown driver")
+	}
+	if err := NewPostgres(config.DatabaseConfig{}).Open(); err == nil {
+		t.Error("Expected error opening PostgreSQL without a DSN")
+	}
+}
+
+func TestMigrationsMatchAcrossDialects(t *testing.T) {
+	sqlite, err := dialectMigrations(SQLiteDialect)
+	if err != nil {
+		t.Fatalf("Failed to load SQLite migrations: %v", err)
+	}
+	postgres, err := dialectMigrations(PostgresDialect)
+	if err != nil {
+		t.Fatalf("Failed to load PostgreSQL migrations: %v", err)
+	}
+
+	if len(sqlite) != len(postgres) {
+		t.Fatalf("Expected %d PostgreSQL migrations, got %d", len(sqlite), len(postgres))
+	}
+	for i := range sqlite {
+		if sqlite[i].Version != postgres[i].Version || sqlite[i].Name != postgres[i].Name {
+			t.Errorf("Migration %d (%s) is %d (%s) in PostgreSQL", sqlite[i].Version, sqlite[i].Name, postgres[i].Version, postgres[i].Name)
+		}
+		if postgres[i].Down == "" {
+			t.Errorf("Expected PostgreSQL migration %d to have a down script", postgres[i].Version)
+		}
+	}
+}
+
+func TestPostgres(t *testing.T) {
+	database := NewPostgres(config.DatabaseConfig{DSN: pgtest.DSN(t)})
+	if err := database.InitDB(); err != nil {
+		t.Fatalf(failedToInitDB, err)
+	}
+	t.Cleanup(func() { database.Close() })
+
+	modified := time.Date(2024, 5, 6, 7, 8, 9, 123456000, time.UTC)
+
+	t.Run("Placeholders and timestamps", func(t *testing.T) {
+		_, err := database.Exec(
+			`INSERT INTO posts (id, title, content, md_content_hash, created_at, modified_at, user_id) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			"post-1", "Post", []byte{0x28, 0xb5}, "hash", modified, modified, "user",
+		)
+		if err != nil {
+			t.Fatalf("Failed to insert post: %v", err)
+		}
+
+		var latest any
+		if err := database.QueryRow(`SELECT MAX(modified_at) FROM posts`).Scan(&latest); err != nil {
+			t.Fatalf("Failed to query latest modification: %v", err)
+		}
+		if ts, ok := latest.(time.Time); !ok || !ts.Equal(modified) {
+			t.Errorf("Expected native timestamp %v, got %v (%T)", modified, latest, latest)
+		}
+	})
+
+	t.Run("Deleted posts leave a tombstone", func(t *testing.T) {
+		if _, err := database.Exec(`DELETE FROM posts WHERE id = ?`, "post-1"); err != nil {
+			t.Fatalf("Failed to delete post: %v", err)
+		}
+		var count int
+		database.QueryRow(`SELECT COUNT(*) FROM post_tombstones WHERE post_id = ?`, "post-1").Scan(&count)
+		if count != 1 {
+			t.Errorf("Expected 1 tombstone, got %d", count)
+		}
+	})
+
+	t.Run("Transactions", func(t *testing.T) {
+		errRollback := errors.New("roll back")
+		err := database.WithTx(context.Background(), func(tx *Tx) error {
+			if _, err := tx.Exec(`INSERT INTO users (id, username) VALUES (?, ?)`, "user-1", "rolled-back"); err != nil {
+				t.Fatalf("Failed to insert user: %v", err)
+			}
+			return errRollback
+		})
+		if !errors.Is(err, errRollback) {
+			t.Fatalf("Expected the error of fn, got %v", err)
+		}
+
+		var count int
+		database.QueryRow(`SELECT COUNT(*) FROM users WHERE id = ?`, "user-1").Scan(&count)
+		if count != 0 {
+			t.Errorf("Expected the insert to be rolled back, got %d users", count)
+		}
+	})
+
+	t.Run("Migrations roll back and apply from each version", func(t *testing.T) {
+		migrator, err := NewMigrator(database.Get(), PostgresDialect)
+		if err != nil {
+			t.Fatalf("NewMigrator failed: %v", err)
+		}
+		for version := migrator.Latest() - 1; version >= 0; version-- {
+			if _, err := migrator.Down(1); err != nil {
+				t.Fatalf("Down to version %d failed: %v", version, err)
+			}
+			if got, _ := migrator.Version(); got != version {
+				t.Errorf("Expected version %d, got %d", version, got)
+			}
+		}
+		for version := 1; version <= migrator.Latest(); version++ {
+			if _, err := migrator.UpTo(version); err != nil {
+				t.Fatalf("UpTo(%d) failed: %v", version, err)
+			}
+		}
+		if got, _ := migrator.Version(); got != migrator.Latest() {
+			t.Errorf("Expected version %d, got %d", migrator.Latest(), got)
+		}
+	})
+}
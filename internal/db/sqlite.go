@@ -1,77 +1,110 @@
 package db
 
 import (
+	"context"
 	"database/sql"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/debemdeboas/the-archive/internal/config"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
 type SQLite struct {
-	conn *sql.DB
+	pool
+	cfg config.DatabaseConfig
 }
 
-func NewSQLite() *SQLite {
+func NewSQLite(cfg config.DatabaseConfig) *SQLite {
 	return &SQLite{
-		conn: nil,
+		pool: pool{dialect: SQLiteDialect},
+		cfg:  cfg,
 	}
 }
 
-func (s *SQLite) InitDB() error {
-	var err error
-	s.conn, err = sql.Open("sqlite3", "./database.db?_time_format=auto")
-	if err != nil {
-		return err
+// dsn returns the data source name for the configured database. Pragmas are
+// passed as driver parameters so that they apply to every pooled connection.
+func (s *SQLite) dsn() string {
+	if s.cfg.DSN != "" {
+		return s.cfg.DSN
 	}
 
-	res, err := s.conn.Exec(`
-PRAGMA foreign_keys = ON;
-
-CREATE TABLE IF NOT EXISTS users (
-    id TEXT PRIMARY KEY,
-    username TEXT UNIQUE,
-    email TEXT,
-    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-);
-
-CREATE TABLE IF NOT EXISTS drafts (
-    id TEXT PRIMARY KEY,
-    title TEXT,
-    content BLOB,
-    user_id TEXT,
-    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-);
-
-CREATE TABLE IF NOT EXISTS posts (
-    id TEXT PRIMARY KEY,
-    title TEXT,
-    content BLOB,
-    md_content_hash TEXT,
-    modified_at DATETIME,
-    user_id TEXT,
-    created_at DATETIME DEFAULT CURRENT_TIMESTAMP
-);`)
-
-	dbLogger.Debug().Any("db_result", res).Msg("Database initialized")
-	return err
-}
+	params := url.Values{}
+	params.Set("_time_format", "auto")
+	params.Set("_foreign_keys", "on")
+	if s.cfg.BusyTimeout > 0 {
+		params.Set("_busy_timeout", strconv.Itoa(s.cfg.BusyTimeout))
+	}
+	if s.cfg.Synchronous != "" {
+		params.Set("_synchronous", strings.ToUpper(s.cfg.Synchronous))
+	}
 
-func (s *SQLite) Get() *sql.DB {
-	return s.conn
+	if s.cfg.InMemory {
+		return "file::memory:?" + params.Encode()
+	}
+
+	if s.cfg.JournalMode != "" {
+		params.Set("_journal_mode", strings.ToUpper(s.cfg.JournalMode))
+	}
+	path := s.cfg.Path
+	if path == "" {
+		path = "database.db"
+	}
+	return "file:" + path + "?" + params.Encode()
 }
 
-func (s *SQLite) Close() error {
-	if s.conn != nil {
-		return s.conn.Close()
+// Open connects to the database without changing its schema.
+func (s *SQLite) Open() error {
+	conn, err := sql.Open("sqlite3", s.dsn())
+	if err != nil {
+		return err
+	}
+
+	if s.cfg.InMemory {
+		// Every connection to an in-memory database is a separate database,
+		// which is gone as soon as the connection is closed
+		conn.SetMaxOpenConns(1)
+		conn.SetMaxIdleConns(1)
+	} else {
+		configurePool(conn, s.cfg)
 	}
+
+	// Connect right away so that a bad path or DSN is reported here
+	if err := conn.Ping(); err != nil {
+		conn.Close()
+		return err
+	}
+
+	s.conn = conn
 	return nil
 }
 
-func (s *SQLite) Query(query string, args ...interface{}) (*sql.Rows, error) {
-	dbLogger.Debug().Str("query", query).Msg("Query")
-	return s.conn.Query(query, args...)
+// InitDB opens the database and applies any pending migrations.
+func (s *SQLite) InitDB() error {
+	if err := s.Open(); err != nil {
+		return err
+	}
+
+	migrator, err := NewMigrator(s.conn, SQLiteDialect)
+	if err != nil {
+		return err
+	}
+	applied, err := migrator.Up()
+	if err != nil {
+		return fmt.Errorf("error migrating database: %w", err)
+	}
+
+	dbLogger.Debug().Int("applied", applied).Int("version", migrator.Latest()).Msg("Database initialized")
+	return nil
 }
 
-func (s *SQLite) Exec(query string, args ...interface{}) (sql.Result, error) {
-	dbLogger.Debug().Str("query", query).Msg("Exec")
-	return s.conn.Exec(query, args...)
+// Snapshot writes a consistent copy of the database to path, which must not
+// exist, while it stays in use. The copy is vacuumed, so it is as small as
+// possible.
+func (s *SQLite) Snapshot(ctx context.Context, path string) error {
+	_, err := s.ExecContext(ctx, `VACUUM INTO ?`, path)
+	return err
 }
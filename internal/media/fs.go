@@ -0,0 +1,121 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"mime"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FSStore keeps media files in a local directory.
+type FSStore struct { // implements Store
+	dir string
+}
+
+func NewFSStore(dir string) *FSStore {
+	return &FSStore{dir: dir}
+}
+
+func (s *FSStore) Put(ctx context.Context, name string, data []byte, contentType string) error {
+	if err := validateName(name); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return fmt.Errorf("error creating media directory: %w", err)
+	}
+
+	// Write to a temporary file first so readers never see a partial file
+	tmp, err := os.CreateTemp(s.dir, "."+name+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("error creating media file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("error writing media file: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error writing media file: %w", err)
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return fmt.Errorf("error writing media file: %w", err)
+	}
+
+	if err := os.Rename(tmp.Name(), filepath.Join(s.dir, name)); err != nil {
+		return fmt.Errorf("error writing media file: %w", err)
+	}
+	return nil
+}
+
+func (s *FSStore) Get(ctx context.Context, name string) (*Object, error) {
+	if err := validateName(name); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(filepath.Join(s.dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("error opening media file: %w", err)
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, fmt.Errorf("error reading media file: %w", err)
+	}
+	if info.IsDir() {
+		f.Close()
+		return nil, ErrNotFound
+	}
+
+	return &Object{
+		Body:        f,
+		ContentType: mime.TypeByExtension(filepath.Ext(name)),
+		Size:        info.Size(),
+		ModTime:     info.ModTime(),
+	}, nil
+}
+
+func (s *FSStore) Delete(ctx context.Context, name string) error {
+	if err := validateName(name); err != nil {
+		return err
+	}
+
+	err := os.Remove(filepath.Join(s.dir, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	return err
+}
+
+func (s *FSStore) List(ctx context.Context) ([]ObjectInfo, error) {
+	entries, err := os.ReadDir(s.dir)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error listing media directory: %w", err)
+	}
+
+	var objects []ObjectInfo
+	for _, entry := range entries {
+		// Skip directories and in-flight temporary files
+		if entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		objects = append(objects, ObjectInfo{
+			Name:    entry.Name(),
+			Size:    info.Size(),
+			ModTime: info.ModTime(),
+		})
+	}
+	return objects, nil
+}
@@ -0,0 +1,49 @@
+package media
+
+import "encoding/binary"
+
+// gifFramePixels returns the number of pixels decoding every frame of a GIF
+// allocates, read from the image descriptors without decoding the frames.
+// Parsing stops at the first malformed block, leaving the error to the
+// decoder.
+func gifFramePixels(data []byte) int {
+	// Header and logical screen descriptor
+	if len(data) < 13 {
+		return 0
+	}
+	i := 13
+	if flags := data[10]; flags&0x80 != 0 {
+		i += 3 << (flags&0x07 + 1)
+	}
+
+	pixels := 0
+	for i < len(data) {
+		switch data[i] {
+		case 0x21: // Extension: label, then data sub-blocks
+			i += 2
+		case 0x2C: // Image descriptor
+			if i+10 > len(data) {
+				return pixels
+			}
+			width := int(binary.LittleEndian.Uint16(data[i+5:]))
+			height := int(binary.LittleEndian.Uint16(data[i+7:]))
+			pixels += width * height
+
+			flags := data[i+9]
+			i += 10
+			if flags&0x80 != 0 {
+				i += 3 << (flags&0x07 + 1)
+			}
+			// LZW minimum code size, then image data sub-blocks
+			i++
+		default: // Trailer or garbage
+			return pixels
+		}
+
+		for i < len(data) && data[i] != 0 {
+			i += int(data[i]) + 1
+		}
+		i++
+	}
+	return pixels
+}
@@ -0,0 +1,95 @@
+package media
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/debemdeboas/the-archive/internal/config"
+	"github.com/rs/zerolog"
+)
+
+// Uploaded names are random and never reused, so responses can be cached indefinitely.
+const immutableCacheControl = "public, max-age=31536000, immutable"
+
+type handler struct {
+	store Store
+}
+
+// NewHandler returns a handler that serves media from store. The object name
+// is the request path, so the handler is meant to be mounted behind http.StripPrefix.
+func NewHandler(store Store) http.Handler {
+	return &handler{store: store}
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	l := zerolog.Ctx(r.Context())
+
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+
+	name := strings.TrimPrefix(r.URL.Path, "/")
+	if err := validateName(name); err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	if redirector, ok := h.store.(Redirector); ok {
+		target, redirect, err := redirector.RedirectURL(r.Context(), name)
+		if err != nil {
+			l.Error().Err(err).Str("name", name).Msg("Failed to build media redirect URL")
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+		if redirect {
+			w.Header().Set(config.HCacheControl, "private, max-age=60")
+			http.Redirect(w, r, target, http.StatusFound)
+			return
+		}
+	}
+
+	obj, err := h.store.Get(r.Context(), name)
+	if errors.Is(err, ErrNotFound) {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		l.Error().Err(err).Str("name", name).Msg("Failed to read media")
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	defer obj.Body.Close()
+
+	if obj.ContentType != "" {
+		w.Header().Set(config.HCType, obj.ContentType)
+	}
+	if obj.ETag != "" {
+		w.Header().Set(config.HETag, obj.ETag)
+	}
+	w.Header().Set(config.HCacheControl, immutableCacheControl)
+
+	if rs, ok := obj.Body.(io.ReadSeeker); ok {
+		http.ServeContent(w, r, name, obj.ModTime, rs)
+		return
+	}
+
+	if obj.ETag != "" && r.Header.Get(config.HIfNoneMatch) == obj.ETag {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	if !obj.ModTime.IsZero() {
+		w.Header().Set(config.HLastModified, obj.ModTime.UTC().Format(http.TimeFormat))
+	}
+	if obj.Size > 0 {
+		w.Header().Set(config.HContentLength, strconv.FormatInt(obj.Size, 10))
+	}
+	w.WriteHeader(http.StatusOK)
+	if r.Method == http.MethodGet {
+		if _, err := io.Copy(w, obj.Body); err != nil {
+			l.Warn().Err(err).Str("name", name).Msg("Failed to stream media")
+		}
+	}
+}
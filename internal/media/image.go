@@ -0,0 +1,338 @@
+// Package media provides processing of uploaded media such as images.
+package media
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+
+	"github.com/debemdeboas/the-archive/internal/config"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("unsupported image format")
+	ErrImageTooLarge     = errors.New("image dimensions exceed the configured limit")
+)
+
+// Content types accepted by the upload pipeline, as reported by http.DetectContentType.
+const (
+	CTypeJPEG = "image/jpeg"
+	CTypePNG  = "image/png"
+	CTypeGIF  = "image/gif"
+	CTypeWebP = "image/webp"
+)
+
+// ImageOptions controls how uploaded images are validated and resized.
+type ImageOptions struct {
+	MaxWidth    int
+	MaxHeight   int
+	MaxPixels   int
+	JPEGQuality int
+
+	// Widths of the responsive variants. Widths greater than or equal to the
+	// (possibly downscaled) image width are skipped.
+	Widths []int
+}
+
+func ImageOptionsFromConfig(cfg config.MediaConfig) ImageOptions {
+	return ImageOptions{
+		MaxWidth:    cfg.MaxWidth,
+		MaxHeight:   cfg.MaxHeight,
+		MaxPixels:   cfg.MaxMegapixels * 1_000_000,
+		JPEGQuality: cfg.JPEGQuality,
+		Widths:      cfg.ResponsiveWidths,
+	}
+}
+
+// Variant is a single encoded rendition of an uploaded image.
+type Variant struct {
+	Name   string
+	Width  int
+	Height int
+	Data   []byte
+}
+
+// ProcessedImage is the result of running an upload through ProcessImage.
+// Original holds the full-size rendition; Variants are ordered by ascending width.
+type ProcessedImage struct {
+	ContentType string
+	Original    Variant
+	Variants    []Variant
+}
+
+// ProcessImage validates, normalizes and resizes an uploaded image.
+//
+// The content type is sniffed from the data rather than trusted from the client.
+// Every rendition is re-encoded from decoded pixels, which drops EXIF, GPS and
+// any other metadata. The EXIF orientation is applied beforehand so that photos
+// keep their intended rotation. WebP input is converted to JPEG, or PNG when it
+// has transparency, since there is no WebP encoder in the standard library.
+func ProcessImage(id string, data []byte, opts ImageOptions) (*ProcessedImage, error) {
+	contentType := http.DetectContentType(data)
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, contentType)
+	}
+	if opts.MaxPixels > 0 && cfg.Width*cfg.Height > opts.MaxPixels {
+		return nil, fmt.Errorf("%w: %dx%d", ErrImageTooLarge, cfg.Width, cfg.Height)
+	}
+
+	switch contentType {
+	case CTypeGIF:
+		return processGIF(id, data, cfg, opts)
+	case CTypeJPEG, CTypePNG, CTypeWebP:
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, contentType)
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding image: %w", err)
+	}
+
+	if contentType == CTypeJPEG {
+		img = applyOrientation(img, exifOrientation(data))
+	}
+
+	outType := contentType
+	if contentType == CTypeWebP {
+		outType = CTypeJPEG
+		if !isOpaque(img) {
+			outType = CTypePNG
+		}
+	}
+
+	bounds := img.Bounds()
+	width, height := fitWithin(bounds.Dx(), bounds.Dy(), opts.MaxWidth, opts.MaxHeight)
+	if width != bounds.Dx() || height != bounds.Dy() {
+		img = resize(img, width, height)
+	}
+
+	ext := extensionFor(outType)
+	encoded, err := encode(img, outType, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &ProcessedImage{
+		ContentType: outType,
+		Original: Variant{
+			Name:   ImageFileName(id, width, height, ext),
+			Width:  width,
+			Height: height,
+			Data:   encoded,
+		},
+	}
+
+	widths := slices.Clone(opts.Widths)
+	slices.Sort(widths)
+	for _, w := range slices.Compact(widths) {
+		if w <= 0 || w >= width {
+			continue
+		}
+		h := max(1, height*w/width)
+		data, err := encode(resize(img, w, h), outType, opts)
+		if err != nil {
+			return nil, err
+		}
+		res.Variants = append(res.Variants, Variant{
+			Name:   VariantFileName(id, width, height, w, ext),
+			Width:  w,
+			Height: h,
+			Data:   data,
+		})
+	}
+
+	return res, nil
+}
+
+// gifPixelsPerPixel is how many GIF frame pixels fit in the memory of one
+// pixel of a still image: frames decode to one byte per pixel, still images
+// to four.
+const gifPixelsPerPixel = 4
+
+// processGIF re-encodes every frame to strip comments and application
+// extensions. Animated GIFs are not resized, so GIFs larger than the maximum
+// dimensions are rejected, and get no responsive variants. The frames are
+// counted before decoding so that small, highly compressed animations cannot
+// decode into more memory than the largest allowed still image.
+func processGIF(id string, data []byte, cfg image.Config, opts ImageOptions) (*ProcessedImage, error) {
+	if (opts.MaxWidth > 0 && cfg.Width > opts.MaxWidth) || (opts.MaxHeight > 0 && cfg.Height > opts.MaxHeight) {
+		return nil, fmt.Errorf("%w: %dx%d", ErrImageTooLarge, cfg.Width, cfg.Height)
+	}
+	if pixels := gifFramePixels(data); opts.MaxPixels > 0 && pixels > opts.MaxPixels*gifPixelsPerPixel {
+		return nil, fmt.Errorf("%w: %d pixels in all frames", ErrImageTooLarge, pixels)
+	}
+
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("error decoding image: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		return nil, fmt.Errorf("error encoding image: %w", err)
+	}
+
+	width, height := g.Config.Width, g.Config.Height
+	return &ProcessedImage{
+		ContentType: CTypeGIF,
+		Original: Variant{
+			Name:   ImageFileName(id, width, height, ".gif"),
+			Width:  width,
+			Height: height,
+			Data:   buf.Bytes(),
+		},
+	}, nil
+}
+
+func encode(img image.Image, contentType string, opts ImageOptions) ([]byte, error) {
+	var buf bytes.Buffer
+	var err error
+
+	switch contentType {
+	case CTypeJPEG:
+		quality := opts.JPEGQuality
+		if quality <= 0 || quality > 100 {
+			quality = jpeg.DefaultQuality
+		}
+		err = jpeg.Encode(&buf, img, &jpeg.Options{Quality: quality})
+	case CTypePNG:
+		err = (&png.Encoder{CompressionLevel: png.BestCompression}).Encode(&buf, img)
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrUnsupportedFormat, contentType)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("error encoding image: %w", err)
+	}
+	return buf.Bytes(), nil
+}
+
+func resize(src image.Image, width, height int) image.Image {
+	dst := image.NewNRGBA(image.Rect(0, 0, width, height))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, src.Bounds(), draw.Src, nil)
+	return dst
+}
+
+// fitWithin scales width and height down, preserving the aspect ratio, so that
+// they fit in maxWidth by maxHeight. Non-positive limits are ignored.
+func fitWithin(width, height, maxWidth, maxHeight int) (int, int) {
+	if maxWidth > 0 && width > maxWidth {
+		height = max(1, height*maxWidth/width)
+		width = maxWidth
+	}
+	if maxHeight > 0 && height > maxHeight {
+		width = max(1, width*maxHeight/height)
+		height = maxHeight
+	}
+	return width, height
+}
+
+func isOpaque(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
+
+func extensionFor(contentType string) string {
+	switch contentType {
+	case CTypeJPEG:
+		return ".jpg"
+	case CTypePNG:
+		return ".png"
+	case CTypeGIF:
+		return ".gif"
+	}
+	return ""
+}
+
+// ImageFileName returns the stored name of an image. The dimensions are part
+// of the name so the renderer can emit width, height and srcset attributes
+// without looking the file up.
+func ImageFileName(id string, width, height int, ext string) string {
+	return fmt.Sprintf("%s.%dx%d%s", id, width, height, ext)
+}
+
+// VariantFileName returns the stored name of a responsive variant of an image.
+func VariantFileName(id string, width, height, variantWidth int, ext string) string {
+	return fmt.Sprintf("%s.%dx%d.%dw%s", id, width, height, variantWidth, ext)
+}
+
+var imageNameRegex = regexp.MustCompile(`^([0-9a-f]+)\.(\d+)x(\d+)(\.jpg|\.png|\.gif)$`)
+
+// ImageName is the parsed form of a name produced by ImageFileName.
+type ImageName struct {
+	ID     string
+	Width  int
+	Height int
+	Ext    string
+}
+
+// ParseImageName parses a name produced by ImageFileName.
+// It returns false for anything else, including variant names.
+func ParseImageName(name string) (ImageName, bool) {
+	m := imageNameRegex.FindStringSubmatch(name)
+	if m == nil {
+		return ImageName{}, false
+	}
+	width, err := strconv.Atoi(m[2])
+	if err != nil {
+		return ImageName{}, false
+	}
+	height, err := strconv.Atoi(m[3])
+	if err != nil {
+		return ImageName{}, false
+	}
+	return ImageName{ID: m[1], Width: width, Height: height, Ext: m[4]}, true
+}
+
+var variantNameRegex = regexp.MustCompile(`^([0-9a-f]+\.\d+x\d+)\.(\d+)w(\.jpg|\.png)$`)
+
+// OriginalName maps a stored name to the name of its original rendition.
+// Names of originals are returned unchanged; anything else returns false.
+func OriginalName(name string) (string, bool) {
+	if _, ok := ParseImageName(name); ok {
+		return name, true
+	}
+	if m := variantNameRegex.FindStringSubmatch(name); m != nil {
+		return m[1] + m[3], true
+	}
+	return "", false
+}
+
+// VariantWidths returns the widths of the responsive variants of this image
+// among the stored names, in ascending order. Variants are looked up rather
+// than derived from the configured widths, which may have changed since the
+// image was uploaded.
+func (n ImageName) VariantWidths(stored []string) []int {
+	var widths []int
+	for _, name := range stored {
+		m := variantNameRegex.FindStringSubmatch(name)
+		if m == nil || m[1]+m[3] != ImageFileName(n.ID, n.Width, n.Height, n.Ext) {
+			continue
+		}
+		if width, err := strconv.Atoi(m[2]); err == nil && width > 0 && width < n.Width {
+			widths = append(widths, width)
+		}
+	}
+	slices.Sort(widths)
+	return slices.Compact(widths)
+}
+
+// VariantName returns the file name of the variant with the given width.
+func (n ImageName) VariantName(width int) string {
+	return VariantFileName(n.ID, n.Width, n.Height, width, n.Ext)
+}
@@ -0,0 +1,333 @@
+package media
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/color"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"testing"
+)
+
+const testID = "0123456789abcdef"
+
+func testOptions() ImageOptions {
+	return ImageOptions{
+		MaxWidth:    1000,
+		MaxHeight:   1000,
+		MaxPixels:   10_000_000,
+		JPEGQuality: 80,
+		Widths:      []int{100, 400, 2000},
+	}
+}
+
+func newTestImage(width, height int, opaque bool) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			a := uint8(255)
+			if !opaque && x == 0 {
+				a = 0
+			}
+			img.SetNRGBA(x, y, color.NRGBA{R: uint8(x), G: uint8(y), B: 128, A: a})
+		}
+	}
+	return img
+}
+
+func encodeJPEG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatalf("Failed to encode JPEG: %v", err)
+	}
+	return buf.Bytes()
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("Failed to encode PNG: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// withExif inserts an APP1 EXIF segment holding the given orientation and a
+// recognizable GPS marker string right after the JPEG SOI marker.
+func withExif(jpegData []byte, orientation uint16) []byte {
+	var tiff bytes.Buffer
+	tiff.WriteString("MM")
+	binary.Write(&tiff, binary.BigEndian, uint16(0x2A))
+	binary.Write(&tiff, binary.BigEndian, uint32(8))
+	binary.Write(&tiff, binary.BigEndian, uint16(1))
+	binary.Write(&tiff, binary.BigEndian, uint16(exifOrientationTag))
+	binary.Write(&tiff, binary.BigEndian, uint16(3)) // SHORT
+	binary.Write(&tiff, binary.BigEndian, uint32(1))
+	binary.Write(&tiff, binary.BigEndian, orientation)
+	binary.Write(&tiff, binary.BigEndian, uint16(0))
+	binary.Write(&tiff, binary.BigEndian, uint32(0))
+	tiff.WriteString("GPSLatitude=48.8584")
+
+	payload := append([]byte("Exif\x00\x00"), tiff.Bytes()...)
+
+	var out bytes.Buffer
+	out.Write(jpegData[:2])
+	out.Write([]byte{0xFF, 0xE1})
+	binary.Write(&out, binary.BigEndian, uint16(len(payload)+2))
+	out.Write(payload)
+	out.Write(jpegData[2:])
+	return out.Bytes()
+}
+
+func TestProcessImage(t *testing.T) {
+	t.Run("JPEG is downscaled and gets responsive variants", func(t *testing.T) {
+		data := encodeJPEG(t, newTestImage(1200, 600, true))
+
+		res, err := ProcessImage(testID, data, testOptions())
+		if err != nil {
+			t.Fatalf("ProcessImage failed: %v", err)
+		}
+
+		if res.ContentType != CTypeJPEG {
+			t.Errorf("Expected content type %q, got %q", CTypeJPEG, res.ContentType)
+		}
+		if res.Original.Width != 1000 || res.Original.Height != 500 {
+			t.Errorf("Expected original to be 1000x500, got %dx%d", res.Original.Width, res.Original.Height)
+		}
+		if res.Original.Name != testID+".1000x500.jpg" {
+			t.Errorf("Unexpected original name %q", res.Original.Name)
+		}
+
+		// 2000 is wider than the image and must be skipped
+		if len(res.Variants) != 2 {
+			t.Fatalf("Expected 2 variants, got %d", len(res.Variants))
+		}
+		for i, want := range []int{100, 400} {
+			v := res.Variants[i]
+			if v.Width != want {
+				t.Errorf("Variant %d: expected width %d, got %d", i, want, v.Width)
+			}
+			if v.Height != want/2 {
+				t.Errorf("Variant %d: expected height %d, got %d", i, want/2, v.Height)
+			}
+			cfg, err := jpeg.DecodeConfig(bytes.NewReader(v.Data))
+			if err != nil {
+				t.Fatalf("Variant %d is not a valid JPEG: %v", i, err)
+			}
+			if cfg.Width != v.Width || cfg.Height != v.Height {
+				t.Errorf("Variant %d: encoded size %dx%d does not match %dx%d", i, cfg.Width, cfg.Height, v.Width, v.Height)
+			}
+		}
+	})
+
+	t.Run("EXIF metadata is stripped and orientation applied", func(t *testing.T) {
+		data := withExif(encodeJPEG(t, newTestImage(200, 100, true)), 6)
+		if exifOrientation(data) != 6 {
+			t.Fatalf("Test fixture should carry orientation 6")
+		}
+
+		res, err := ProcessImage(testID, data, testOptions())
+		if err != nil {
+			t.Fatalf("ProcessImage failed: %v", err)
+		}
+
+		if res.Original.Width != 100 || res.Original.Height != 200 {
+			t.Errorf("Expected rotated image to be 100x200, got %dx%d", res.Original.Width, res.Original.Height)
+		}
+		for _, v := range append(res.Variants, res.Original) {
+			if bytes.Contains(v.Data, []byte("Exif")) || bytes.Contains(v.Data, []byte("GPSLatitude")) {
+				t.Errorf("Expected metadata to be stripped from %s", v.Name)
+			}
+		}
+	})
+
+	t.Run("PNG keeps its format", func(t *testing.T) {
+		data := encodePNG(t, newTestImage(300, 300, false))
+
+		res, err := ProcessImage(testID, data, testOptions())
+		if err != nil {
+			t.Fatalf("ProcessImage failed: %v", err)
+		}
+		if res.ContentType != CTypePNG {
+			t.Errorf("Expected content type %q, got %q", CTypePNG, res.ContentType)
+		}
+		if _, err := png.Decode(bytes.NewReader(res.Original.Data)); err != nil {
+			t.Errorf("Expected a valid PNG: %v", err)
+		}
+		if len(res.Variants) != 1 || res.Variants[0].Width != 100 {
+			t.Errorf("Expected a single 100px variant, got %+v", res.Variants)
+		}
+	})
+
+	t.Run("GIF is re-encoded without variants", func(t *testing.T) {
+		palette := color.Palette{color.Black, color.White}
+		frame := image.NewPaletted(image.Rect(0, 0, 500, 50), palette)
+		var buf bytes.Buffer
+		if err := gif.EncodeAll(&buf, &gif.GIF{Image: []*image.Paletted{frame, frame}, Delay: []int{10, 10}}); err != nil {
+			t.Fatalf("Failed to encode GIF: %v", err)
+		}
+
+		res, err := ProcessImage(testID, buf.Bytes(), testOptions())
+		if err != nil {
+			t.Fatalf("ProcessImage failed: %v", err)
+		}
+		if res.ContentType != CTypeGIF {
+			t.Errorf("Expected content type %q, got %q", CTypeGIF, res.ContentType)
+		}
+		if len(res.Variants) != 0 {
+			t.Errorf("Expected no variants for GIF, got %d", len(res.Variants))
+		}
+		g, err := gif.DecodeAll(bytes.NewReader(res.Original.Data))
+		if err != nil {
+			t.Fatalf("Expected a valid GIF: %v", err)
+		}
+		if len(g.Image) != 2 {
+			t.Errorf("Expected animation frames to be kept, got %d", len(g.Image))
+		}
+	})
+
+	t.Run("GIF limits are enforced before decoding", func(t *testing.T) {
+		palette := color.Palette{color.Black, color.White}
+		encodeGIF := func(width, height, frames int) []byte {
+			frame := image.NewPaletted(image.Rect(0, 0, width, height), palette)
+			g := &gif.GIF{}
+			for range frames {
+				g.Image = append(g.Image, frame)
+				g.Delay = append(g.Delay, 10)
+			}
+			var buf bytes.Buffer
+			if err := gif.EncodeAll(&buf, g); err != nil {
+				t.Fatalf("Failed to encode GIF: %v", err)
+			}
+			return buf.Bytes()
+		}
+
+		opts := testOptions()
+		opts.MaxPixels = 100 * 100
+		if _, err := ProcessImage(testID, encodeGIF(100, 100, gifPixelsPerPixel), opts); err != nil {
+			t.Errorf("Expected frames within the limit to be accepted, got %v", err)
+		}
+		if _, err := ProcessImage(testID, encodeGIF(100, 100, gifPixelsPerPixel+1), opts); !errors.Is(err, ErrImageTooLarge) {
+			t.Errorf("Expected ErrImageTooLarge for too many frames, got %v", err)
+		}
+		if _, err := ProcessImage(testID, encodeGIF(1001, 10, 1), testOptions()); !errors.Is(err, ErrImageTooLarge) {
+			t.Errorf("Expected ErrImageTooLarge for a GIF wider than the maximum, got %v", err)
+		}
+	})
+
+	t.Run("Non-image content is rejected", func(t *testing.T) {
+		_, err := ProcessImage(testID, []byte("<html><body>not an image</body></html>"), testOptions())
+		if !errors.Is(err, ErrUnsupportedFormat) {
+			t.Errorf("Expected ErrUnsupportedFormat, got %v", err)
+		}
+	})
+
+	t.Run("Truncated image is rejected", func(t *testing.T) {
+		data := encodePNG(t, newTestImage(50, 50, true))
+		_, err := ProcessImage(testID, data[:len(data)/2], testOptions())
+		if err == nil {
+			t.Error("Expected error for truncated image")
+		}
+	})
+
+	t.Run("Pixel limit is enforced before decoding", func(t *testing.T) {
+		data := encodePNG(t, newTestImage(100, 100, true))
+		opts := testOptions()
+		opts.MaxPixels = 100 * 99
+
+		_, err := ProcessImage(testID, data, opts)
+		if !errors.Is(err, ErrImageTooLarge) {
+			t.Errorf("Expected ErrImageTooLarge, got %v", err)
+		}
+	})
+}
+
+func TestApplyOrientation(t *testing.T) {
+	src := newTestImage(3, 2, true)
+	topLeft := src.NRGBAAt(0, 0)
+
+	testCases := []struct {
+		orientation   int
+		width, height int
+		x, y          int // where the source top-left pixel ends up
+	}{
+		{1, 3, 2, 0, 0},
+		{2, 3, 2, 2, 0},
+		{3, 3, 2, 2, 1},
+		{4, 3, 2, 0, 1},
+		{5, 2, 3, 0, 0},
+		{6, 2, 3, 1, 0},
+		{7, 2, 3, 1, 2},
+		{8, 2, 3, 0, 2},
+	}
+
+	for _, tc := range testCases {
+		out := applyOrientation(src, tc.orientation)
+		b := out.Bounds()
+		if b.Dx() != tc.width || b.Dy() != tc.height {
+			t.Errorf("Orientation %d: expected %dx%d, got %dx%d", tc.orientation, tc.width, tc.height, b.Dx(), b.Dy())
+			continue
+		}
+		if got := color.NRGBAModel.Convert(out.At(tc.x, tc.y)); got != topLeft {
+			t.Errorf("Orientation %d: expected top-left pixel at (%d,%d)", tc.orientation, tc.x, tc.y)
+		}
+	}
+}
+
+func TestParseImageName(t *testing.T) {
+	t.Run("Round trip", func(t *testing.T) {
+		name, ok := ParseImageName(ImageFileName(testID, 1600, 900, ".jpg"))
+		if !ok {
+			t.Fatal("Expected name to parse")
+		}
+		if name.ID != testID || name.Width != 1600 || name.Height != 900 || name.Ext != ".jpg" {
+			t.Errorf("Unexpected parse result %+v", name)
+		}
+		if got := name.VariantName(480); got != testID+".1600x900.480w.jpg" {
+			t.Errorf("Unexpected variant name %q", got)
+		}
+	})
+
+	t.Run("Legacy and variant names are rejected", func(t *testing.T) {
+		for _, n := range []string{testID + ".jpg", testID + ".1600x900.480w.jpg", "../etc/passwd", ""} {
+			if _, ok := ParseImageName(n); ok {
+				t.Errorf("Expected %q not to parse", n)
+			}
+		}
+	})
+
+	t.Run("Variant widths", func(t *testing.T) {
+		name := ImageName{ID: testID, Width: 1000, Height: 500, Ext: ".png"}
+		got := name.VariantWidths([]string{
+			name.VariantName(960), name.VariantName(480), name.VariantName(480),
+			testID + ".1000x500.320w.jpg", "ffff.1000x500.320w.png", testID + ".1000x500.png",
+		})
+		if len(got) != 2 || got[0] != 480 || got[1] != 960 {
+			t.Errorf("Expected [480 960], got %v", got)
+		}
+
+		name.Ext = ".gif"
+		if got := name.VariantWidths([]string{testID + ".1000x500.480w.png"}); len(got) != 0 {
+			t.Errorf("Expected no variants for GIF, got %v", got)
+		}
+	})
+	t.Run("Original name", func(t *testing.T) {
+		cases := map[string]string{
+			testID + ".1600x900.jpg":      testID + ".1600x900.jpg",
+			testID + ".1600x900.480w.jpg": testID + ".1600x900.jpg",
+			testID + ".10x10.960w.png":    testID + ".10x10.png",
+		}
+		for in, want := range cases {
+			if got, ok := OriginalName(in); !ok || got != want {
+				t.Errorf("OriginalName(%q) = %q, %v; want %q", in, got, ok, want)
+			}
+		}
+		if _, ok := OriginalName(testID + ".jpg"); ok {
+			t.Error("Expected legacy name not to map to an original")
+		}
+	})
+}
@@ -0,0 +1,187 @@
+// Package library keeps the media library in sync with the posts that
+// reference uploaded files and finds uploads that are no longer used.
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/debemdeboas/the-archive/internal/media"
+	"github.com/debemdeboas/the-archive/internal/model"
+	"github.com/debemdeboas/the-archive/internal/render"
+	"github.com/debemdeboas/the-archive/internal/repository"
+)
+
+// SyncPostReferences records the uploads referenced by the post's Markdown.
+// It returns the referenced names.
+func SyncPostReferences(ctx context.Context, repo repository.MediaRepository, post *model.Post) ([]string, error) {
+	names := render.UploadedImageNames(post.Markdown)
+	ids := make([]model.MediaID, len(names))
+	for i, name := range names {
+		ids[i] = model.MediaID(name)
+	}
+	if err := repo.SetPostReferences(ctx, post.ID, ids); err != nil {
+		return nil, err
+	}
+	return names, nil
+}
+
+// Orphan is an upload that no post references.
+type Orphan struct {
+	// Name of the original rendition.
+	Name string
+
+	// Every stored file belonging to the upload, variants included.
+	Files []string
+	Size  int64
+
+	// Tracked is false for files that exist in the store but not in the media table,
+	// such as uploads made before the media library existed.
+	Tracked bool
+}
+
+// ErrNoPosts is returned when deleting orphans while no post can be read,
+// since every upload would look orphaned, as it does when the posts are read
+// from the wrong place.
+var ErrNoPosts = errors.New("no posts found, refusing to delete uploads")
+
+type Options struct {
+	// Uploads younger than MinAge are never reported, so that images uploaded
+	// into a post that has not been saved yet are not removed.
+	MinAge time.Duration
+
+	// Delete removes the orphaned files and their media rows. Otherwise the
+	// orphans are only reported.
+	Delete bool
+}
+
+type Report struct {
+	Posts int
+	// Removed posts whose references were dropped.
+	Pruned  int
+	Orphans []Orphan
+	Deleted int
+}
+
+// CollectGarbage refreshes the references of every post and reports, or
+// deletes, uploads that no post references.
+func CollectGarbage(ctx context.Context, posts repository.PostRepository, repo repository.MediaRepository, store media.Store, opts Options) (*Report, error) {
+	cutoff := time.Now().Add(-opts.MinAge)
+
+	postList, _, err := posts.GetPosts(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("error reading posts: %w", err)
+	}
+	if len(postList) == 0 && opts.Delete {
+		return nil, ErrNoPosts
+	}
+
+	referenced := make(map[string]bool)
+	ids := make([]model.PostID, len(postList))
+	for i := range postList {
+		ids[i] = postList[i].ID
+		names, err := SyncPostReferences(ctx, repo, &postList[i])
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			referenced[name] = true
+		}
+	}
+	// Removed posts keep no uploads alive
+	var pruned int
+	if len(ids) > 0 {
+		if pruned, err = repo.PruneReferences(ctx, ids); err != nil {
+			return nil, err
+		}
+	}
+
+	tracked, err := repo.ListMedia(ctx)
+	if err != nil {
+		return nil, err
+	}
+	objects, err := store.List(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Group stored files by the upload they belong to
+	groups := make(map[string][]media.ObjectInfo)
+	for _, obj := range objects {
+		name := obj.Name
+		if original, ok := media.OriginalName(name); ok {
+			name = original
+		}
+		groups[name] = append(groups[name], obj)
+	}
+
+	report := &Report{Posts: len(postList), Pruned: pruned}
+
+	for _, m := range tracked {
+		files := groups[string(m.ID)]
+		delete(groups, string(m.ID))
+		if referenced[string(m.ID)] || m.CreatedDate.After(cutoff) {
+			continue
+		}
+
+		orphan := Orphan{Name: string(m.ID), Files: m.Files(), Tracked: true}
+		for _, f := range files {
+			orphan.Size += f.Size
+			if !slices.Contains(orphan.Files, f.Name) {
+				orphan.Files = append(orphan.Files, f.Name)
+			}
+		}
+		report.Orphans = append(report.Orphans, orphan)
+	}
+
+	for name, files := range groups {
+		if referenced[name] {
+			continue
+		}
+
+		orphan := Orphan{Name: name}
+		recent := false
+		for _, f := range files {
+			orphan.Files = append(orphan.Files, f.Name)
+			orphan.Size += f.Size
+			recent = recent || f.ModTime.After(cutoff)
+		}
+		if !recent {
+			report.Orphans = append(report.Orphans, orphan)
+		}
+	}
+
+	slices.SortFunc(report.Orphans, func(a, b Orphan) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	if !opts.Delete {
+		return report, nil
+	}
+
+	for _, orphan := range report.Orphans {
+		if err := deleteOrphan(ctx, repo, store, orphan); err != nil {
+			return report, err
+		}
+		report.Deleted++
+	}
+	return report, nil
+}
+
+func deleteOrphan(ctx context.Context, repo repository.MediaRepository, store media.Store, orphan Orphan) error {
+	for _, name := range orphan.Files {
+		if err := store.Delete(ctx, name); err != nil && !errors.Is(err, media.ErrNotFound) {
+			return fmt.Errorf("error deleting %s: %w", name, err)
+		}
+	}
+	if orphan.Tracked {
+		if err := repo.DeleteMedia(ctx, model.MediaID(orphan.Name)); err != nil && !errors.Is(err, repository.ErrMediaNotFound) {
+			return err
+		}
+	}
+	return nil
+}
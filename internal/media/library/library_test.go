@@ -0,0 +1,192 @@
+package library
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/debemdeboas/the-archive/internal/db"
+	"github.com/debemdeboas/the-archive/internal/media"
+	"github.com/debemdeboas/the-archive/internal/model"
+	"github.com/debemdeboas/the-archive/internal/repository"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+type testDB struct {
+	*sql.DB
+}
+
+func (t *testDB) Open() error         { return nil }
+func (t *testDB) InitDB() error       { return nil }
+func (t *testDB) Dialect() db.Dialect { return db.SQLiteDialect }
+func (t *testDB) Get() *sql.DB        { return t.DB }
+
+func (t *testDB) WithTx(ctx context.Context, fn func(tx *db.Tx) error) error {
+	return db.RunTx(ctx, t.DB, db.SQLiteDialect, fn)
+}
+
+func setupTestDB(t *testing.T) *testDB {
+	t.Helper()
+	sqlDB, err := sql.Open("sqlite3", filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatalf("Failed to open test database: %v", err)
+	}
+	t.Cleanup(func() { sqlDB.Close() })
+
+	_, err = sqlDB.Exec(`
+		CREATE TABLE posts (
+			id TEXT PRIMARY KEY,
+			title TEXT,
+			content BLOB,
+			content_codec TEXT NOT NULL DEFAULT 'zstd',
+			content_checksum TEXT NOT NULL DEFAULT '',
+			md_content_hash TEXT,
+			created_at DATETIME,
+			modified_at DATETIME,
+			user_id TEXT,
+			change_seq INTEGER NOT NULL DEFAULT 0
+		);
+		CREATE TABLE media (
+			id TEXT PRIMARY KEY,
+			user_id TEXT,
+			content_type TEXT,
+			size INTEGER,
+			width INTEGER,
+			height INTEGER,
+			source_hash TEXT UNIQUE,
+			variants TEXT,
+			created_at DATETIME
+		);
+		CREATE TABLE media_references (
+			media_id TEXT NOT NULL,
+			post_id TEXT NOT NULL,
+			PRIMARY KEY (media_id, post_id)
+		);
+	`)
+	if err != nil {
+		t.Fatalf("Failed to create tables: %v", err)
+	}
+	return &testDB{DB: sqlDB}
+}
+
+func TestCollectGarbage(t *testing.T) {
+	ctx := context.Background()
+	database := setupTestDB(t)
+	posts := repository.NewDBPostRepository(database)
+	mediaRepo := repository.NewDBMediaRepository(database)
+	storeDir := t.TempDir()
+	store := media.NewFSStore(storeDir)
+
+	old := time.Now().Add(-48 * time.Hour)
+	upload := func(id string, created time.Time, variants ...string) {
+		t.Helper()
+		m := &model.Media{ID: model.MediaID(id), SourceHash: id, Variants: variants, CreatedDate: created}
+		for _, name := range m.Files() {
+			if err := store.Put(ctx, name, []byte("data"), media.CTypeJPEG); err != nil {
+				t.Fatalf("Put failed: %v", err)
+			}
+		}
+		if err := mediaRepo.SaveMedia(ctx, m); err != nil {
+			t.Fatalf("SaveMedia failed: %v", err)
+		}
+	}
+
+	upload("aaaa.100x50.jpg", old, "aaaa.100x50.48w.jpg")
+	upload("bbbb.100x50.jpg", old, "bbbb.100x50.48w.jpg")
+	upload("cccc.100x50.jpg", time.Now())
+
+	// Untracked files from before the media library existed
+	for _, name := range []string{"dddd.png", "eeee.png"} {
+		store.Put(ctx, name, []byte("legacy"), media.CTypePNG)
+		os.Chtimes(filepath.Join(storeDir, name), old, old)
+	}
+
+	post := posts.NewPost()
+	post.Markdown = []byte("![a](/static/uploads/aaaa.100x50.48w.jpg)\n\n![legacy](/static/uploads/dddd.png)\n")
+	if err := posts.SavePost(ctx, post); err != nil {
+		t.Fatalf("SavePost failed: %v", err)
+	}
+
+	// References of a post that was removed since
+	if err := mediaRepo.SetPostReferences(ctx, "removed-post", []model.MediaID{"bbbb.100x50.jpg"}); err != nil {
+		t.Fatalf("SetPostReferences failed: %v", err)
+	}
+
+	opts := Options{MinAge: 24 * time.Hour}
+
+	t.Run("Nothing is deleted without posts", func(t *testing.T) {
+		opts := opts
+		opts.Delete = true
+		empty := repository.NewFSPostRepository(t.TempDir())
+		if _, err := CollectGarbage(ctx, empty, mediaRepo, store, opts); !errors.Is(err, ErrNoPosts) {
+			t.Fatalf("Expected ErrNoPosts, got %v", err)
+		}
+		if m, err := mediaRepo.GetMedia(ctx, "bbbb.100x50.jpg"); err != nil || m.References != 1 {
+			t.Errorf("Expected references to be kept, got %+v, %v", m, err)
+		}
+	})
+
+	t.Run("Dry run reports orphans", func(t *testing.T) {
+		report, err := CollectGarbage(ctx, posts, mediaRepo, store, opts)
+		if err != nil {
+			t.Fatalf("CollectGarbage failed: %v", err)
+		}
+		if report.Posts != 1 || report.Pruned != 1 || report.Deleted != 0 {
+			t.Errorf("Unexpected report %+v", report)
+		}
+		if len(report.Orphans) != 2 {
+			t.Fatalf("Expected 2 orphans, got %+v", report.Orphans)
+		}
+
+		tracked, untracked := report.Orphans[0], report.Orphans[1]
+		if tracked.Name != "bbbb.100x50.jpg" || !tracked.Tracked || len(tracked.Files) != 2 || tracked.Size != 8 {
+			t.Errorf("Unexpected tracked orphan %+v", tracked)
+		}
+		if untracked.Name != "eeee.png" || untracked.Tracked {
+			t.Errorf("Unexpected untracked orphan %+v", untracked)
+		}
+
+		m, err := mediaRepo.GetMedia(ctx, "aaaa.100x50.jpg")
+		if err != nil || m.References != 1 {
+			t.Errorf("Expected references to be refreshed, got %+v, %v", m, err)
+		}
+		if m, err := mediaRepo.GetMedia(ctx, "bbbb.100x50.jpg"); err != nil || m.References != 0 {
+			t.Errorf("Expected the references of the removed post to be dropped, got %+v, %v", m, err)
+		}
+	})
+
+	t.Run("Delete removes files and rows", func(t *testing.T) {
+		opts := opts
+		opts.Delete = true
+		report, err := CollectGarbage(ctx, posts, mediaRepo, store, opts)
+		if err != nil {
+			t.Fatalf("CollectGarbage failed: %v", err)
+		}
+		if report.Deleted != 2 {
+			t.Errorf("Expected 2 deletions, got %d", report.Deleted)
+		}
+
+		objects, err := store.List(ctx)
+		if err != nil {
+			t.Fatalf("List failed: %v", err)
+		}
+		var names []string
+		for _, obj := range objects {
+			names = append(names, obj.Name)
+		}
+		slices.Sort(names)
+		want := []string{"aaaa.100x50.48w.jpg", "aaaa.100x50.jpg", "cccc.100x50.jpg", "dddd.png"}
+		if !slices.Equal(names, want) {
+			t.Errorf("Expected remaining files %v, got %v", want, names)
+		}
+
+		if _, err := mediaRepo.GetMedia(ctx, "bbbb.100x50.jpg"); err == nil {
+			t.Error("Expected orphaned media row to be deleted")
+		}
+	})
+}
@@ -0,0 +1,164 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/debemdeboas/the-archive/internal/config"
+	"github.com/debemdeboas/the-archive/internal/util/s3util"
+)
+
+// S3Store keeps media files in an S3-compatible bucket.
+type S3Store struct { // implements Store, Redirector
+	client  *s3.Client
+	presign *s3.PresignClient
+
+	bucket string
+	prefix string
+
+	serveMode string
+	publicURL string
+	expiry    time.Duration
+}
+
+func NewS3Store(client *s3.Client, cfg config.MediaStorageConfig) *S3Store {
+	return &S3Store{
+		client:  client,
+		presign: s3.NewPresignClient(client),
+
+		bucket: cfg.S3.Bucket,
+		prefix: cfg.S3.Prefix,
+
+		serveMode: cfg.ServeMode,
+		publicURL: strings.TrimSuffix(cfg.PublicURL, "/"),
+		expiry:    time.Duration(cfg.SignedURLExpiry) * time.Second,
+	}
+}
+
+func (s *S3Store) key(name string) string {
+	return s3util.Key(s.prefix, name)
+}
+
+func (s *S3Store) Put(ctx context.Context, name string, data []byte, contentType string) error {
+	if err := validateName(name); err != nil {
+		return err
+	}
+
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:        aws.String(s.bucket),
+		Key:           aws.String(s.key(name)),
+		Body:          bytes.NewReader(data),
+		ContentLength: aws.Int64(int64(len(data))),
+		ContentType:   aws.String(contentType),
+		CacheControl:  aws.String("public, max-age=31536000, immutable"),
+	})
+	if err != nil {
+		return fmt.Errorf("error uploading media object: %w", err)
+	}
+	return nil
+}
+
+func (s *S3Store) Get(ctx context.Context, name string) (*Object, error) {
+	if err := validateName(name); err != nil {
+		return nil, err
+	}
+
+	out, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(name)),
+	})
+	if err != nil {
+		var noSuchKey *types.NoSuchKey
+		if errors.As(err, &noSuchKey) {
+			return nil, ErrNotFound
+		}
+		return nil, fmt.Errorf("error fetching media object: %w", err)
+	}
+
+	return &Object{
+		Body:        out.Body,
+		ContentType: aws.ToString(out.ContentType),
+		Size:        aws.ToInt64(out.ContentLength),
+		ModTime:     aws.ToTime(out.LastModified),
+		ETag:        aws.ToString(out.ETag),
+	}, nil
+}
+
+func (s *S3Store) Delete(ctx context.Context, name string) error {
+	if err := validateName(name); err != nil {
+		return err
+	}
+
+	_, err := s.client.DeleteObject(ctx, &s3.DeleteObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(s.key(name)),
+	})
+	if err != nil {
+		return fmt.Errorf("error deleting media object: %w", err)
+	}
+	return nil
+}
+
+func (s *S3Store) List(ctx context.Context) ([]ObjectInfo, error) {
+	prefix := ""
+	if s.prefix != "" {
+		prefix = strings.TrimSuffix(s.prefix, "/") + "/"
+	}
+
+	var objects []ObjectInfo
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("error listing media objects: %w", err)
+		}
+		for _, obj := range page.Contents {
+			name := strings.TrimPrefix(aws.ToString(obj.Key), prefix)
+			// Objects in nested "directories" were not written by this store
+			if validateName(name) != nil {
+				continue
+			}
+			objects = append(objects, ObjectInfo{
+				Name:    name,
+				Size:    aws.ToInt64(obj.Size),
+				ModTime: aws.ToTime(obj.LastModified),
+			})
+		}
+	}
+	return objects, nil
+}
+
+func (s *S3Store) RedirectURL(ctx context.Context, name string) (string, bool, error) {
+	if err := validateName(name); err != nil {
+		return "", false, err
+	}
+
+	switch s.serveMode {
+	case "redirect":
+		if s.publicURL == "" {
+			return "", false, errors.New("redirect serve mode requires a public URL")
+		}
+		return s.publicURL + "/" + s.key(name), true, nil
+	case "signed":
+		req, err := s.presign.PresignGetObject(ctx, &s3.GetObjectInput{
+			Bucket: aws.String(s.bucket),
+			Key:    aws.String(s.key(name)),
+		}, s3.WithPresignExpires(s.expiry))
+		if err != nil {
+			return "", false, fmt.Errorf("error presigning media URL: %w", err)
+		}
+		return req.URL, true, nil
+	default:
+		return "", false, nil
+	}
+}
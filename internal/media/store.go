@@ -0,0 +1,75 @@
+package media
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/debemdeboas/the-archive/internal/config"
+	"github.com/debemdeboas/the-archive/internal/util/s3util"
+)
+
+var (
+	ErrNotFound    = errors.New("media not found")
+	ErrInvalidName = errors.New("invalid media name")
+)
+
+// Object is a stored media file opened for reading.
+// Body is an io.ReadSeeker when the backend supports it, which enables range requests.
+type Object struct {
+	Body        io.ReadCloser
+	ContentType string
+	Size        int64
+	ModTime     time.Time
+	ETag        string
+}
+
+// ObjectInfo describes a stored media file.
+type ObjectInfo struct {
+	Name    string
+	Size    int64
+	ModTime time.Time
+}
+
+// Store persists uploaded media files under flat names such as those produced by ImageFileName.
+type Store interface {
+	Put(ctx context.Context, name string, data []byte, contentType string) error
+	Get(ctx context.Context, name string) (*Object, error)
+	Delete(ctx context.Context, name string) error
+	List(ctx context.Context) ([]ObjectInfo, error)
+}
+
+// Redirector is implemented by stores that can send clients straight to the
+// backend instead of proxying the content through the server.
+type Redirector interface {
+	// RedirectURL returns the URL to redirect to, or false to proxy the content.
+	RedirectURL(ctx context.Context, name string) (string, bool, error)
+}
+
+// NewStoreFromConfig creates the store selected by cfg.Type.
+func NewStoreFromConfig(ctx context.Context, cfg config.MediaStorageConfig) (Store, error) {
+	switch cfg.Type {
+	case "", "fs":
+		return NewFSStore(cfg.Path), nil
+	case "s3":
+		client, err := s3util.NewClient(ctx, cfg.S3)
+		if err != nil {
+			return nil, err
+		}
+		return NewS3Store(client, cfg), nil
+	default:
+		return nil, fmt.Errorf("unknown media storage type: %s", cfg.Type)
+	}
+}
+
+// validateName rejects anything that is not a single, plain path element.
+func validateName(name string) error {
+	if name == "" || name == "." || name == ".." || strings.ContainsAny(name, `/\`) || path.Clean(name) != name {
+		return fmt.Errorf("%w: %q", ErrInvalidName, name)
+	}
+	return nil
+}
@@ -0,0 +1,291 @@
+package media
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/debemdeboas/the-archive/internal/config"
+	"github.com/debemdeboas/the-archive/internal/s3test"
+	"github.com/debemdeboas/the-archive/internal/util/s3util"
+)
+
+const testBucket = "media"
+
+func newTestS3Store(t *testing.T, mutate func(*config.MediaStorageConfig)) (*S3Store, *s3test.Server) {
+	t.Helper()
+	srv := s3test.NewServer(t, testBucket)
+	cfg := config.MediaStorageConfig{
+		Type:            "s3",
+		ServeMode:       "proxy",
+		SignedURLExpiry: 60,
+		S3:              srv.Config(testBucket),
+	}
+	cfg.S3.Prefix = "uploads"
+	if mutate != nil {
+		mutate(&cfg)
+	}
+
+	client, err := s3util.NewClient(context.Background(), cfg.S3)
+	if err != nil {
+		t.Fatalf("Failed to create S3 client: %v", err)
+	}
+	return NewS3Store(client, cfg), srv
+}
+
+func TestStores(t *testing.T) {
+	stores := map[string]func(t *testing.T) Store{
+		"fs": func(t *testing.T) Store {
+			return NewFSStore(t.TempDir())
+		},
+		"s3": func(t *testing.T) Store {
+			store, _ := newTestS3Store(t, nil)
+			return store
+		},
+	}
+
+	for name, newStore := range stores {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			store := newStore(t)
+			data := []byte("fake image data")
+
+			t.Run("Put and Get", func(t *testing.T) {
+				if err := store.Put(ctx, "abc.10x10.png", data, CTypePNG); err != nil {
+					t.Fatalf("Put failed: %v", err)
+				}
+
+				obj, err := store.Get(ctx, "abc.10x10.png")
+				if err != nil {
+					t.Fatalf("Get failed: %v", err)
+				}
+				defer obj.Body.Close()
+
+				got, err := io.ReadAll(obj.Body)
+				if err != nil {
+					t.Fatalf("Failed to read object: %v", err)
+				}
+				if !bytes.Equal(got, data) {
+					t.Errorf("Expected %q, got %q", data, got)
+				}
+				if obj.ContentType != CTypePNG {
+					t.Errorf("Expected content type %q, got %q", CTypePNG, obj.ContentType)
+				}
+				if obj.Size != int64(len(data)) {
+					t.Errorf("Expected size %d, got %d", len(data), obj.Size)
+				}
+			})
+
+			t.Run("Put overwrites", func(t *testing.T) {
+				if err := store.Put(ctx, "abc.10x10.png", []byte("new"), CTypePNG); err != nil {
+					t.Fatalf("Put failed: %v", err)
+				}
+				obj, err := store.Get(ctx, "abc.10x10.png")
+				if err != nil {
+					t.Fatalf("Get failed: %v", err)
+				}
+				defer obj.Body.Close()
+				if got, _ := io.ReadAll(obj.Body); string(got) != "new" {
+					t.Errorf("Expected overwritten content, got %q", got)
+				}
+			})
+
+			t.Run("List", func(t *testing.T) {
+				objects, err := store.List(ctx)
+				if err != nil {
+					t.Fatalf("List failed: %v", err)
+				}
+				if len(objects) != 1 || objects[0].Name != "abc.10x10.png" || objects[0].Size != 3 {
+					t.Errorf("Unexpected listing %+v", objects)
+				}
+			})
+
+			t.Run("Missing object", func(t *testing.T) {
+				if _, err := store.Get(ctx, "missing.png"); !errors.Is(err, ErrNotFound) {
+					t.Errorf("Expected ErrNotFound, got %v", err)
+				}
+			})
+
+			t.Run("Delete", func(t *testing.T) {
+				if err := store.Delete(ctx, "abc.10x10.png"); err != nil {
+					t.Fatalf("Delete failed: %v", err)
+				}
+				if _, err := store.Get(ctx, "abc.10x10.png"); !errors.Is(err, ErrNotFound) {
+					t.Errorf("Expected ErrNotFound after delete, got %v", err)
+				}
+			})
+
+			t.Run("Invalid names are rejected", func(t *testing.T) {
+				for _, n := range []string{"", ".", "..", "../x.png", "a/b.png", `a\b.png`} {
+					if err := store.Put(ctx, n, data, CTypePNG); !errors.Is(err, ErrInvalidName) {
+						t.Errorf("Put(%q): expected ErrInvalidName, got %v", n, err)
+					}
+					if _, err := store.Get(ctx, n); !errors.Is(err, ErrInvalidName) {
+						t.Errorf("Get(%q): expected ErrInvalidName, got %v", n, err)
+					}
+				}
+			})
+		})
+	}
+}
+
+func TestS3StoreUsesPrefix(t *testing.T) {
+	store, srv := newTestS3Store(t, nil)
+
+	if err := store.Put(context.Background(), "abc.png", []byte("x"), CTypePNG); err != nil {
+		t.Fatalf("Put failed: %v", err)
+	}
+	if _, ok := srv.Object(testBucket, "uploads/abc.png"); !ok {
+		t.Error("Expected object to be stored under the configured prefix")
+	}
+}
+
+func TestHandler(t *testing.T) {
+	serve := func(h http.Handler, method, target string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		rec := httptest.NewRecorder()
+		http.StripPrefix(config.UploadsURLPath, h).ServeHTTP(rec, req)
+		return rec
+	}
+
+	t.Run("Serves from filesystem store", func(t *testing.T) {
+		store := NewFSStore(t.TempDir())
+		store.Put(context.Background(), "abc.png", []byte("png data"), CTypePNG)
+		h := NewHandler(store)
+
+		rec := serve(h, http.MethodGet, config.UploadsURLPath+"abc.png")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", rec.Code)
+		}
+		if rec.Body.String() != "png data" {
+			t.Errorf("Unexpected body %q", rec.Body.String())
+		}
+		if rec.Header().Get("Content-Type") != CTypePNG {
+			t.Errorf("Unexpected content type %q", rec.Header().Get("Content-Type"))
+		}
+		if rec.Header().Get("Cache-Control") != immutableCacheControl {
+			t.Errorf("Unexpected cache control %q", rec.Header().Get("Cache-Control"))
+		}
+
+		if rec := serve(h, http.MethodGet, config.UploadsURLPath+"missing.png"); rec.Code != http.StatusNotFound {
+			t.Errorf("Expected 404 for missing file, got %d", rec.Code)
+		}
+		if rec := serve(h, http.MethodPost, config.UploadsURLPath+"abc.png"); rec.Code != http.StatusMethodNotAllowed {
+			t.Errorf("Expected 405 for POST, got %d", rec.Code)
+		}
+	})
+
+	t.Run("Proxies from S3", func(t *testing.T) {
+		store, _ := newTestS3Store(t, nil)
+		store.Put(context.Background(), "abc.jpg", []byte("jpeg data"), CTypeJPEG)
+		h := NewHandler(store)
+
+		rec := serve(h, http.MethodGet, config.UploadsURLPath+"abc.jpg")
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected 200, got %d", rec.Code)
+		}
+		if rec.Body.String() != "jpeg data" {
+			t.Errorf("Unexpected body %q", rec.Body.String())
+		}
+		etag := rec.Header().Get("ETag")
+		if etag == "" {
+			t.Fatal("Expected ETag to be forwarded")
+		}
+
+		req := httptest.NewRequest(http.MethodGet, config.UploadsURLPath+"abc.jpg", nil)
+		req.Header.Set("If-None-Match", etag)
+		rec = httptest.NewRecorder()
+		http.StripPrefix(config.UploadsURLPath, h).ServeHTTP(rec, req)
+		if rec.Code != http.StatusNotModified {
+			t.Errorf("Expected 304 for matching ETag, got %d", rec.Code)
+		}
+	})
+
+	t.Run("Redirects to public URL", func(t *testing.T) {
+		store, _ := newTestS3Store(t, func(cfg *config.MediaStorageConfig) {
+			cfg.ServeMode = "redirect"
+			cfg.PublicURL = "https://cdn.example.com/"
+		})
+
+		rec := serve(NewHandler(store), http.MethodGet, config.UploadsURLPath+"abc.jpg")
+		if rec.Code != http.StatusFound {
+			t.Fatalf("Expected 302, got %d", rec.Code)
+		}
+		if loc := rec.Header().Get("Location"); loc != "https://cdn.example.com/uploads/abc.jpg" {
+			t.Errorf("Unexpected redirect location %q", loc)
+		}
+	})
+
+	t.Run("Redirects to a working presigned URL", func(t *testing.T) {
+		store, _ := newTestS3Store(t, func(cfg *config.MediaStorageConfig) {
+			cfg.ServeMode = "signed"
+		})
+		store.Put(context.Background(), "abc.jpg", []byte("signed data"), CTypeJPEG)
+
+		rec := serve(NewHandler(store), http.MethodGet, config.UploadsURLPath+"abc.jpg")
+		if rec.Code != http.StatusFound {
+			t.Fatalf("Expected 302, got %d", rec.Code)
+		}
+
+		loc, err := url.Parse(rec.Header().Get("Location"))
+		if err != nil {
+			t.Fatalf("Invalid redirect location: %v", err)
+		}
+		if loc.Query().Get("X-Amz-Signature") == "" || loc.Query().Get("X-Amz-Expires") != "60" {
+			t.Errorf("Expected a presigned URL, got %s", loc)
+		}
+
+		resp, err := http.Get(loc.String())
+		if err != nil {
+			t.Fatalf("Failed to fetch presigned URL: %v", err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK || string(body) != "signed data" {
+			t.Errorf("Unexpected presigned response %d %q", resp.StatusCode, body)
+		}
+	})
+
+	t.Run("Path traversal is rejected", func(t *testing.T) {
+		dir := t.TempDir()
+		h := NewHandler(NewFSStore(dir))
+		for _, p := range []string{"../secret", "a/b.png", "..%2Fsecret"} {
+			rec := serve(h, http.MethodGet, config.UploadsURLPath+p)
+			if rec.Code != http.StatusNotFound {
+				t.Errorf("Expected 404 for %q, got %d", p, rec.Code)
+			}
+			if strings.Contains(rec.Body.String(), "secret") {
+				t.Errorf("Unexpected body for %q", p)
+			}
+		}
+	})
+}
+
+func TestNewStoreFromConfig(t *testing.T) {
+	store, err := NewStoreFromConfig(context.Background(), config.MediaStorageConfig{Type: "fs", Path: t.TempDir()})
+	if err != nil {
+		t.Fatalf("Expected fs store, got error: %v", err)
+	}
+	if _, ok := store.(*FSStore); !ok {
+		t.Errorf("Expected *FSStore, got %T", store)
+	}
+
+	srv := s3test.NewServer(t, testBucket)
+	store, err = NewStoreFromConfig(context.Background(), config.MediaStorageConfig{Type: "s3", S3: srv.Config(testBucket)})
+	if err != nil {
+		t.Fatalf("Expected s3 store, got error: %v", err)
+	}
+	if _, ok := store.(*S3Store); !ok {
+		t.Errorf("Expected *S3Store, got %T", store)
+	}
+
+	if _, err := NewStoreFromConfig(context.Background(), config.MediaStorageConfig{Type: "ftp"}); err == nil {
+		t.Error("Expected error for unknown storage type")
+	}
+}
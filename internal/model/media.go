@@ -0,0 +1,49 @@
+package model
+
+import (
+	"time"
+
+	"github.com/debemdeboas/the-archive/internal/config"
+)
+
+// MediaID is the stored file name of the original rendition of an upload.
+type MediaID string
+
+// Media is an uploaded file tracked by the media library.
+type Media struct {
+	ID MediaID
+
+	Owner       UserID
+	ContentType string
+	Size        int64
+	Width       int
+	Height      int
+
+	// Hash of the file as it was uploaded, used to deduplicate uploads.
+	SourceHash string
+
+	// Stored names of the responsive variants, ordered by ascending width.
+	Variants []string
+
+	CreatedDate time.Time
+
+	// Number of posts that reference this file.
+	References int
+}
+
+func (m *Media) URL() string {
+	return config.UploadsURLPath + string(m.ID)
+}
+
+// ThumbnailURL returns the URL of the smallest available rendition.
+func (m *Media) ThumbnailURL() string {
+	if len(m.Variants) > 0 {
+		return config.UploadsURLPath + m.Variants[0]
+	}
+	return m.URL()
+}
+
+// Files returns the stored names of every rendition, variants first.
+func (m *Media) Files() []string {
+	return append(append([]string{}, m.Variants...), string(m.ID))
+}
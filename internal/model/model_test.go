@@ -129,6 +129,36 @@ func TestPost(t *testing.T) {
 	})
 }
 
+func TestPostURLName(t *testing.T) {
+	t.Run("URLName prefers the slug", func(t *testing.T) {
+		post := &Post{ID: "abc-123", Slug: "hello-world"}
+		if result := post.URLName(); result != "hello-world" {
+			t.Errorf("Expected 'hello-world', got %s", result)
+		}
+	})
+
+	t.Run("URLName falls back to the ID", func(t *testing.T) {
+		post := &Post{ID: "abc-123"}
+		if result := post.URLName(); result != "abc-123" {
+			t.Errorf("Expected 'abc-123', got %s", result)
+		}
+	})
+}
+
+func TestPostEventString(t *testing.T) {
+	testCases := map[PostEvent]string{
+		PostCreated:  "created",
+		PostUpdated:  "updated",
+		PostDeleted:  "deleted",
+		PostEvent(0): "unknown",
+	}
+	for event, expected := range testCases {
+		if result := event.String(); result != expected {
+			t.Errorf("Expected %q, got %q", expected, result)
+		}
+	}
+}
+
 func TestPostGetTitle(t *testing.T) {
 	t.Run("GetTitle with no Info returns Title field", func(t *testing.T) {
 		post := &Post{
@@ -275,10 +305,10 @@ func TestPostGetTitle(t *testing.T) {
 
 func TestPageData(t *testing.T) {
 	// Set up config for tests
-	originalConfig := config.AppConfig
-	defer func() { config.AppConfig = originalConfig }()
+	originalConfig := config.Get()
+	defer func() { config.Set(originalConfig) }()
 
-	config.AppConfig = &config.Config{
+	config.Set(&config.Config{
 		Site: config.SiteConfig{
 			Name:        "Test Site",
 			Tagline:     "Test Tagline",
@@ -297,7 +327,7 @@ func TestPageData(t *testing.T) {
 				LivePreview: true,
 			},
 		},
-	}
+	})
 
 	t.Run("PageData struct creation", func(t *testing.T) {
 		pd := &PageData{
@@ -369,10 +399,10 @@ func TestPageData(t *testing.T) {
 
 func TestNewPageData(t *testing.T) {
 	// Set up config for tests
-	originalConfig := config.AppConfig
-	defer func() { config.AppConfig = originalConfig }()
+	originalConfig := config.Get()
+	defer func() { config.Set(originalConfig) }()
 
-	config.AppConfig = &config.Config{
+	config.Set(&config.Config{
 		Site: config.SiteConfig{
 			Name:        "Test Site",
 			Tagline:     "Test Tagline",
@@ -391,7 +421,7 @@ func TestNewPageData(t *testing.T) {
 				LivePreview: true,
 			},
 		},
-	}
+	})
 
 	t.Run("NewPageData creates PageData from request", func(t *testing.T) {
 		req := httptest.NewRequest("GET", "/test/path", nil)
@@ -466,10 +496,10 @@ func TestNewPageData(t *testing.T) {
 
 func TestPageDataIsPost(t *testing.T) {
 	// Set up config for URL path
-	originalConfig := config.AppConfig
-	defer func() { config.AppConfig = originalConfig }()
+	originalConfig := config.Get()
+	defer func() { config.Set(originalConfig) }()
 
-	config.AppConfig = &config.Config{}
+	config.Set(&config.Config{})
 
 	t.Run("IsPost with nil ShowToolbar checks URL", func(t *testing.T) {
 		pd := &PageData{
@@ -663,16 +693,16 @@ func TestModelPackageIntegration(t *testing.T) {
 		req := httptest.NewRequest("GET", "/posts/integration-test", nil)
 
 		// Set up minimal config
-		originalConfig := config.AppConfig
-		defer func() { config.AppConfig = originalConfig }()
+		originalConfig := config.Get()
+		defer func() { config.Set(originalConfig) }()
 
-		config.AppConfig = &config.Config{
+		config.Set(&config.Config{
 			Site: config.SiteConfig{Name: "Test Site"},
 			Meta: config.MetaConfig{Keywords: []string{"test"}},
 			Features: config.FeaturesConfig{
 				Editor: config.EditorConfig{Enabled: true},
 			},
-		}
+		})
 
 		pd := NewPageData(req)
 
@@ -54,20 +54,21 @@ func GetAuthStatus(ctx context.Context) bool {
 }
 
 func NewPageData(r *http.Request) *PageData {
+	cfg := config.Get()
 	syntaxtheme := theme.GetSyntaxThemeFromRequest(r)
 
 	return &PageData{
-		SiteName:            config.AppConfig.Site.Name,
-		SiteTagline:         config.AppConfig.Site.Tagline,
-		SiteDescription:     config.AppConfig.Site.Description,
-		SiteKeywords:        config.AppConfig.Meta.Keywords,
-		SiteAuthor:          config.AppConfig.Meta.Author,
+		SiteName:            cfg.Site.Name,
+		SiteTagline:         cfg.Site.Tagline,
+		SiteDescription:     cfg.Site.Description,
+		SiteKeywords:        cfg.Meta.Keywords,
+		SiteAuthor:          cfg.Meta.Author,
 		PageURL:             r.URL.Path,
 		Theme:               theme.GetThemeFromRequest(r),
-		AllowThemeSwitching: config.AppConfig.Theme.AllowSwitching,
-		EditorEnabled:       config.AppConfig.Features.Editor.Enabled,
-		DraftsEnabled:       config.AppConfig.Features.Editor.Enabled && config.AppConfig.Features.Editor.EnableDrafts,
-		LivePreviewEnabled:  config.AppConfig.Features.Editor.LivePreview,
+		AllowThemeSwitching: cfg.Theme.AllowSwitching,
+		EditorEnabled:       cfg.Features.Editor.Enabled,
+		DraftsEnabled:       cfg.Features.Editor.Enabled && cfg.Features.Editor.EnableDrafts,
+		LivePreviewEnabled:  cfg.Features.Editor.LivePreview,
 		IsAuthenticated:     GetAuthStatus(r.Context()),
 		SyntaxTheme:         syntaxtheme,
 		SyntaxThemes:        theme.GetSyntaxThemes(),
@@ -11,6 +11,28 @@ import (
 
 type PostID string
 
+// PostEvent is the kind of change a repository reports about a post.
+type PostEvent int
+
+const (
+	PostCreated PostEvent = iota + 1
+	PostUpdated
+	PostDeleted
+)
+
+func (e PostEvent) String() string {
+	switch e {
+	case PostCreated:
+		return "created"
+	case PostUpdated:
+		return "updated"
+	case PostDeleted:
+		return "deleted"
+	default:
+		return "unknown"
+	}
+}
+
 type Post struct {
 	ID PostID
 
@@ -18,8 +40,12 @@ type Post struct {
 	Content template.HTML
 	Path    string
 
+	// Human-readable name used in the post URL. Empty if none was assigned.
+	Slug string
+
 	// Used for cache busting.
 	// We cannot use the content hash because the content is already rendered.
+	// Taken with util.MarkdownHash, so it is the same in every repository.
 	MDContentHash string
 
 	Markdown     []byte
@@ -33,6 +59,15 @@ type Post struct {
 	Owner UserID
 }
 
+// URLName returns the name used to link to the post: its slug, or its ID if
+// it has no slug.
+func (p *Post) URLName() string {
+	if p.Slug != "" {
+		return p.Slug
+	}
+	return string(p.ID)
+}
+
 func (p *Post) GetTitle() string {
 	if p.Info != nil && p.Info.Title != "" {
 		var s strings.Builder
@@ -0,0 +1,120 @@
+// Package pgtest provides PostgreSQL databases for integration tests.
+//
+// Tests use the server given by the ARCHIVE_TEST_POSTGRES_DSN environment
+// variable, in a schema of their own that is dropped when they end. Without
+// it, a throwaway server is launched with the initdb and pg_ctl binaries
+// found on the PATH or in the usual install locations. If neither is
+// possible, the test is skipped.
+package pgtest
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	_ "github.com/lib/pq"
+)
+
+// EnvDSN names the environment variable holding the DSN of a server to test against.
+const EnvDSN = "ARCHIVE_TEST_POSTGRES_DSN"
+
+// DSN returns the data source name of an empty database, skipping the test
+// if no PostgreSQL server is available.
+func DSN(t testing.TB) string {
+	t.Helper()
+	if dsn := os.Getenv(EnvDSN); dsn != "" {
+		return withSchema(t, dsn)
+	}
+
+	initdb, pgctl, ok := binaries()
+	if !ok {
+		t.Skipf("PostgreSQL is not available: set %s or install initdb and pg_ctl", EnvDSN)
+	}
+	return launch(t, initdb, pgctl)
+}
+
+// withSchema creates a schema for the test and returns dsn with it as the search path.
+func withSchema(t testing.TB, dsn string) string {
+	t.Helper()
+	conn, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatalf("Failed to open %s: %v", EnvDSN, err)
+	}
+	defer conn.Close()
+
+	schema := fmt.Sprintf("archive_test_%d", time.Now().UnixNano())
+	if _, err := conn.Exec(`CREATE SCHEMA ` + schema); err != nil {
+		t.Fatalf("Failed to create schema: %v", err)
+	}
+	t.Cleanup(func() {
+		if conn, err := sql.Open("postgres", dsn); err == nil {
+			conn.Exec(`DROP SCHEMA ` + schema + ` CASCADE`)
+			conn.Close()
+		}
+	})
+
+	if strings.Contains(dsn, "://") {
+		u, err := url.Parse(dsn)
+		if err != nil {
+			t.Fatalf("Invalid %s: %v", EnvDSN, err)
+		}
+		q := u.Query()
+		q.Set("search_path", schema)
+		u.RawQuery = q.Encode()
+		return u.String()
+	}
+	return dsn + " search_path=" + schema
+}
+
+// binaries looks for the programs needed to run a server.
+func binaries() (initdb, pgctl string, ok bool) {
+	dirs := []string{""}
+	installed, _ := filepath.Glob("/usr/lib/postgresql/*/bin")
+	dirs = append(dirs, installed...)
+	for _, dir := range dirs {
+		// An empty directory searches the PATH
+		initdb, err1 := exec.LookPath(filepath.Join(dir, "initdb"))
+		pgctl, err2 := exec.LookPath(filepath.Join(dir, "pg_ctl"))
+		if err1 == nil && err2 == nil {
+			return initdb, pgctl, true
+		}
+	}
+	return "", "", false
+}
+
+// launch starts a server listening only on a Unix socket, which is stopped
+// when the test ends.
+func launch(t testing.TB, initdb, pgctl string) string {
+	t.Helper()
+	data := t.TempDir()
+
+	// Socket paths are limited in length, so they can't live under TempDir
+	socketDir, err := os.MkdirTemp("", "pgtest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(socketDir) })
+
+	if out, err := exec.Command(initdb, "-D", data, "-U", "postgres", "-A", "trust", "-E", "UTF8", "--no-sync").CombinedOutput(); err != nil {
+		// initdb refuses to run as root, among other things
+		t.Skipf("PostgreSQL is not available: initdb failed: %v\n%s", err, out)
+	}
+
+	options := fmt.Sprintf("-k %s -c listen_addresses='' -c fsync=off", socketDir)
+	logFile := filepath.Join(data, "server.log")
+	if out, err := exec.Command(pgctl, "-D", data, "-o", options, "-l", logFile, "-w", "start").CombinedOutput(); err != nil {
+		log, _ := os.ReadFile(logFile)
+		t.Fatalf("Failed to start PostgreSQL: %v\n%s\n%s", err, out, log)
+	}
+	t.Cleanup(func() {
+		exec.Command(pgctl, "-D", data, "-m", "immediate", "-w", "stop").Run()
+	})
+
+	return fmt.Sprintf("host=%s user=postgres dbname=postgres sslmode=disable", socketDir)
+}
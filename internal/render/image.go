@@ -0,0 +1,148 @@
+package render
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"slices"
+	"strings"
+
+	"github.com/debemdeboas/the-archive/internal/config"
+	"github.com/debemdeboas/the-archive/internal/media"
+	"github.com/gomarkdown/markdown/ast"
+	md_html "github.com/gomarkdown/markdown/html"
+)
+
+// VariantsFunc returns the stored names of the responsive variants generated
+// for the upload stored as name, or false if the upload is unknown.
+type VariantsFunc func(name string) ([]string, bool)
+
+var uploadVariants VariantsFunc
+
+// SetVariants sets where uploaded images find their responsive variants.
+// Until it is set, or for uploads it does not know, images get no srcset.
+func SetVariants(fn VariantsFunc) {
+	uploadVariants = fn
+}
+
+// storedVariantWidths returns the widths of the variants stored for name.
+func storedVariantWidths(name media.ImageName, stored string) []int {
+	if uploadVariants == nil {
+		return nil
+	}
+	variants, ok := uploadVariants(stored)
+	if !ok {
+		return nil
+	}
+	return name.VariantWidths(variants)
+}
+
+// renderUploadedImage renders images stored by the upload pipeline with their
+// intrinsic dimensions, a srcset of the responsive variants and lazy loading.
+// Any other image is left to the default renderer.
+func renderUploadedImage(w io.Writer, img *ast.Image, entering bool) (ast.WalkStatus, bool) {
+	cfg := config.Get()
+	if cfg == nil {
+		return ast.GoToNext, false
+	}
+
+	dest := string(img.Destination)
+	if !strings.HasPrefix(dest, config.UploadsURLPath) {
+		return ast.GoToNext, false
+	}
+	stored := strings.TrimPrefix(dest, config.UploadsURLPath)
+	name, ok := media.ParseImageName(stored)
+	if !ok {
+		return ast.GoToNext, false
+	}
+
+	// Children (the alt text) are skipped on enter, so there is nothing left to close
+	if !entering {
+		return ast.GoToNext, true
+	}
+
+	mediaConfig := cfg.Media
+
+	var tag strings.Builder
+	tag.WriteString(`<img src="`)
+	md_html.EscLink(&tag, img.Destination)
+	tag.WriteString(`" alt="`)
+	md_html.EscapeHTML(&tag, imageAltText(img))
+	tag.WriteString(`"`)
+
+	if img.Title != nil {
+		tag.WriteString(` title="`)
+		md_html.EscapeHTML(&tag, img.Title)
+		tag.WriteString(`"`)
+	}
+
+	fmt.Fprintf(&tag, ` width="%d" height="%d"`, name.Width, name.Height)
+
+	if widths := storedVariantWidths(name, stored); len(widths) > 0 {
+		srcset := make([]string, 0, len(widths)+1)
+		for _, width := range widths {
+			srcset = append(srcset, fmt.Sprintf("%s%s %dw", config.UploadsURLPath, name.VariantName(width), width))
+		}
+		srcset = append(srcset, fmt.Sprintf("%s %dw", dest, name.Width))
+
+		tag.WriteString(` srcset="`)
+		md_html.EscLink(&tag, []byte(strings.Join(srcset, ", ")))
+		tag.WriteString(`"`)
+
+		if mediaConfig.Sizes != "" {
+			tag.WriteString(` sizes="`)
+			md_html.EscapeHTML(&tag, []byte(mediaConfig.Sizes))
+			tag.WriteString(`"`)
+		}
+	}
+
+	if mediaConfig.LazyLoading {
+		tag.WriteString(` loading="lazy" decoding="async"`)
+	}
+
+	tag.WriteString(` />`)
+	io.WriteString(w, tag.String())
+
+	return ast.SkipChildren, true
+}
+
+func imageAltText(img *ast.Image) []byte {
+	var alt bytes.Buffer
+	ast.WalkFunc(img, func(node ast.Node, entering bool) ast.WalkStatus {
+		if leaf := node.AsLeaf(); leaf != nil && entering {
+			alt.Write(leaf.Literal)
+		}
+		return ast.GoToNext
+	})
+	return alt.Bytes()
+}
+
+// UploadedImageNames returns the stored names of the uploads that md references
+// through image nodes, sorted and without duplicates. References to responsive
+// variants are mapped to their original.
+func UploadedImageNames(md []byte) []string {
+	doc, _ := parseMarkdownMmark(md)
+
+	var names []string
+	ast.WalkFunc(doc, func(node ast.Node, entering bool) ast.WalkStatus {
+		img, ok := node.(*ast.Image)
+		if !ok || !entering {
+			return ast.GoToNext
+		}
+		dest := string(img.Destination)
+		if !strings.HasPrefix(dest, config.UploadsURLPath) {
+			return ast.GoToNext
+		}
+		name := strings.TrimPrefix(dest, config.UploadsURLPath)
+		if original, ok := media.OriginalName(name); ok {
+			name = original
+		}
+		if name != "" && !strings.ContainsAny(name, "/?#") {
+			names = append(names, name)
+		}
+		return ast.GoToNext
+	})
+
+	slices.Sort(names)
+	return slices.Compact(names)
+}
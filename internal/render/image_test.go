@@ -0,0 +1,139 @@
+package render
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/debemdeboas/the-archive/internal/config"
+)
+
+func setupMediaConfig(t *testing.T) {
+	t.Helper()
+	originalConfig := config.Get()
+	t.Cleanup(func() { config.Set(originalConfig) })
+
+	config.Set(&config.Config{})
+	config.ApplyDefaults(config.Get())
+	config.Get().Media.ResponsiveWidths = []int{480, 960, 1600}
+}
+
+// setVariants makes the uploads find the variants in stored, keyed by the
+// name of their original.
+func setVariants(t *testing.T, stored map[string][]string) {
+	t.Helper()
+	t.Cleanup(func() { SetVariants(nil) })
+	SetVariants(func(name string) ([]string, bool) {
+		variants, ok := stored[name]
+		return variants, ok
+	})
+}
+
+func TestRenderUploadedImage(t *testing.T) {
+	setupMediaConfig(t)
+
+	const id = "0123456789abcdef"
+	md := []byte("Some text\n\n![A cat photo](/static/uploads/" + id + `.1200x800.jpg "Title")` + "\n")
+	setVariants(t, map[string][]string{
+		id + ".1200x800.jpg": {id + ".1200x800.480w.jpg", id + ".1200x800.960w.jpg"},
+	})
+
+	renderers := map[string]func() string{
+		"mmark": func() string {
+			html, _ := RenderMarkdownMmark(md, "github")
+			return string(html)
+		},
+		"classic": func() string {
+			return string(RenderMarkdownClassic(md, "github"))
+		},
+	}
+
+	for name, render := range renderers {
+		t.Run(name, func(t *testing.T) {
+			html := render()
+
+			expected := []string{
+				`src="/static/uploads/` + id + `.1200x800.jpg"`,
+				`alt="A cat photo"`,
+				`title="Title"`,
+				`width="1200" height="800"`,
+				`srcset="/static/uploads/` + id + `.1200x800.480w.jpg 480w, /static/uploads/` + id + `.1200x800.960w.jpg 960w, /static/uploads/` + id + `.1200x800.jpg 1200w"`,
+				`sizes="(max-width: 800px) 100vw, 800px"`,
+				`loading="lazy"`,
+			}
+			for _, e := range expected {
+				if !strings.Contains(html, e) {
+					t.Errorf("Expected rendered HTML to contain %s, got:\n%s", e, html)
+				}
+			}
+			if strings.Count(html, "<img") != 1 {
+				t.Errorf("Expected exactly one img tag, got:\n%s", html)
+			}
+		})
+	}
+
+	t.Run("Lazy loading can be disabled", func(t *testing.T) {
+		config.Get().Media.LazyLoading = false
+		defer func() { config.Get().Media.LazyLoading = true }()
+
+		html, _ := RenderMarkdownMmark(md, "github")
+		if strings.Contains(string(html), "loading=") {
+			t.Errorf("Expected no loading attribute, got:\n%s", html)
+		}
+	})
+
+	t.Run("Variants are the stored ones, not the configured ones", func(t *testing.T) {
+		// Configured after the upload, which got variants of 480 and 960 pixels
+		config.Get().Media.ResponsiveWidths = []int{320, 640}
+		defer func() { config.Get().Media.ResponsiveWidths = []int{480, 960, 1600} }()
+
+		html, _ := RenderMarkdownMmark(md, "github")
+		if !strings.Contains(string(html), id+".1200x800.480w.jpg 480w, /static/uploads/"+id+".1200x800.960w.jpg 960w") {
+			t.Errorf("Expected the stored variants, got:\n%s", html)
+		}
+		if strings.Contains(string(html), "320w") || strings.Contains(string(html), "640w") {
+			t.Errorf("Expected no variants of the configured widths, got:\n%s", html)
+		}
+	})
+
+	t.Run("Unknown uploads get no srcset", func(t *testing.T) {
+		html, _ := RenderMarkdownMmark([]byte("![dog](/static/uploads/abcdef.1200x800.jpg)\n"), "github")
+		if !strings.Contains(string(html), `width="1200" height="800"`) || strings.Contains(string(html), "srcset") {
+			t.Errorf("Expected dimensions without a srcset, got:\n%s", html)
+		}
+	})
+
+	t.Run("Other images use the default renderer", func(t *testing.T) {
+		html, _ := RenderMarkdownMmark([]byte("![remote](https://example.com/cat.jpg)\n\n![legacy](/static/uploads/abc.jpg)\n"), "github")
+		if strings.Contains(string(html), "srcset") || strings.Contains(string(html), "width=") {
+			t.Errorf("Expected plain img tags, got:\n%s", html)
+		}
+		if strings.Count(string(html), "<img") != 2 {
+			t.Errorf("Expected two img tags, got:\n%s", html)
+		}
+	})
+}
+
+func TestUploadedImageNames(t *testing.T) {
+	const id = "0123456789abcdef"
+	md := []byte(`---
+title: Images
+---
+
+![first](/static/uploads/` + id + `.1200x800.jpg)
+
+![again](/static/uploads/` + id + `.1200x800.480w.jpg) and ![gif](/static/uploads/abcdef.10x10.gif)
+
+![external](https://example.com/x.1200x800.jpg) ![legacy](/static/uploads/` + id + `.png)
+
+` + "```\n![in code](/static/uploads/ffff.1x1.png)\n```\n")
+
+	got := UploadedImageNames(md)
+	want := []string{id + ".1200x800.jpg", id + ".png", "abcdef.10x10.gif"}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected %v, got %v", want, got)
+	}
+
+	if got := UploadedImageNames([]byte("No images here")); len(got) != 0 {
+		t.Errorf("Expected no names, got %v", got)
+	}
+}
@@ -101,6 +101,12 @@ func RenderMarkdownClassic(md []byte, highlightTheme string) []byte {
 				return ast.GoToNext, true
 			}
 
+			if img, ok := node.(*ast.Image); ok {
+				if status, handled := renderUploadedImage(w, img, entering); handled {
+					return status, true
+				}
+			}
+
 			if callout, ok := node.(*ast.Callout); ok && entering {
 				fmt.Fprintf(w, "<span class=\"callout\">%s</span>", callout.ID)
 				return ast.GoToNext, true
@@ -122,32 +128,7 @@ func RenderMarkdownClassic(md []byte, highlightTheme string) []byte {
 }
 
 func RenderMarkdownMmark(md []byte, highlightTheme string) ([]byte, *util.ExtendedTitleData) {
-	md = markdown.NormalizeNewlines(md)
-
-	mparser.Extensions |= parser.NoIntraEmphasis
-
-	p := parser.NewWithExtensions(mparser.Extensions)
-
-	init := mparser.NewInitial("")
-	info, err := util.GetFrontMatter(md)
-	if err != nil {
-		info = &util.ExtendedTitleData{
-			TitleData: &mast.TitleData{
-				Title:    "Untitled",
-				Language: "en",
-			},
-			Consumed: 0,
-		}
-	}
-
-	p.Opts = parser.Options{
-		ReadIncludeFn: init.ReadInclude,
-		Flags:         parser.FlagsNone,
-	}
-
-	doc := markdown.Parse(md[info.Consumed:], p)
-
-	mparser.AddIndex(doc)
+	doc, info := parseMarkdownMmark(md)
 
 	mhtmlOpts := mhtml.RendererOptions{
 		Language: lang.New(info.Language),
@@ -166,6 +147,12 @@ func RenderMarkdownMmark(md []byte, highlightTheme string) ([]byte, *util.Extend
 				return ast.GoToNext, true
 			}
 
+			if img, ok := node.(*ast.Image); ok {
+				if status, handled := renderUploadedImage(w, img, entering); handled {
+					return status, true
+				}
+			}
+
 			return mhtmlOpts.RenderHook(w, node, entering)
 		},
 		Flags: md_html.CommonFlags | md_html.FootnoteNoHRTag | md_html.FootnoteReturnLinks,
@@ -178,6 +165,38 @@ func RenderMarkdownMmark(md []byte, highlightTheme string) ([]byte, *util.Extend
 	return x, info
 }
 
+// parseMarkdownMmark parses md with the Mmark extensions, skipping the front matter.
+func parseMarkdownMmark(md []byte) (ast.Node, *util.ExtendedTitleData) {
+	md = markdown.NormalizeNewlines(md)
+
+	mparser.Extensions |= parser.NoIntraEmphasis
+
+	p := parser.NewWithExtensions(mparser.Extensions)
+
+	init := mparser.NewInitial("")
+	info, err := util.GetFrontMatter(md)
+	if err != nil {
+		info = &util.ExtendedTitleData{
+			TitleData: &mast.TitleData{
+				Title:    "Untitled",
+				Language: "en",
+			},
+			Consumed: 0,
+		}
+	}
+
+	p.Opts = parser.Options{
+		ReadIncludeFn: init.ReadInclude,
+		Flags:         parser.FlagsNone,
+	}
+
+	doc := markdown.Parse(md[info.Consumed:], p)
+
+	mparser.AddIndex(doc)
+
+	return doc, info
+}
+
 // WarmCache pre-renders markdown content asynchronously to warm the cache
 func WarmCache(md []byte, contentHash, highlightTheme string) {
 	renderLogger.Debug().Str("contentHash", contentHash).Str("highlightTheme", highlightTheme).Msg("Starting cache warming")
@@ -1,12 +1,14 @@
 package repository
 
 import (
-	"database/sql"
+	"context"
 	"fmt"
+	"os"
 	"slices"
+	"sync"
 	"time"
 
-	"github.com/debemdeboas/the-archive/internal/cache"
+	"github.com/debemdeboas/the-archive/internal/config"
 	"github.com/debemdeboas/the-archive/internal/db"
 	"github.com/debemdeboas/the-archive/internal/model"
 	"github.com/debemdeboas/the-archive/internal/util"
@@ -15,51 +17,158 @@ import (
 )
 
 type DBPostRepository struct { // implements PostRepository
-	postsCache       *cache.Cache[string, *model.Post]
-	postsCacheSorted []model.Post
+	cache postCache
 
 	reloadTimeout  time.Duration
-	reloadNotifier func(model.PostID)
+	reloadNotifier func(model.PostID, model.PostEvent)
+	loop           reloadLoop
 
-	lastModifiedTime *time.Time // Track the latest modification time
+	// Reloads only read what changed since these watermarks: posts changed
+	// after lastChange, and tombstones after lastTombstone.
+	reloadMu      sync.Mutex
+	lastChange    int64
+	lastTombstone int64
 
-	db         db.DB
+	db db.DB
+
+	// Posts are written with compressor, and read with the codec recorded
+	// next to each of them
 	compressor compression.Compressor
+	codecs     compression.Codecs
 }
 
 func NewDBPostRepository(db db.DB) *DBPostRepository {
 	return &DBPostRepository{
-		postsCache: cache.NewCache[string, *model.Post](),
-
 		reloadTimeout: 10 * time.Second,
 
 		db:         db,
 		compressor: compression.ZstdCompressor{},
+		codecs:     compression.NewCodecs(),
+	}
+}
+
+// NewDBPostRepositoryFromConfig creates a DBPostRepository compressing posts
+// as configured by cfg.
+func NewDBPostRepositoryFromConfig(db db.DB, cfg config.DBPostsConfig) (*DBPostRepository, error) {
+	repo := NewDBPostRepository(db)
+
+	var dictionary *compression.ZstdDictCompressor
+	if cfg.Dictionary != "" {
+		data, err := os.ReadFile(cfg.Dictionary)
+		if err != nil {
+			return nil, fmt.Errorf("error reading zstd dictionary: %w", err)
+		}
+		if dictionary, err = compression.NewZstdDictCompressor(data); err != nil {
+			return nil, err
+		}
+		// Posts compressed with the dictionary stay readable even when
+		// another codec is used for new ones
+		repo.AddCodec(dictionary)
+	}
+
+	switch cfg.Compression {
+	case "", compression.Zstd:
+		if dictionary != nil {
+			repo.SetCompressor(dictionary)
+		}
+	default:
+		compressor, err := repo.codecs.Get(cfg.Compression)
+		if err != nil {
+			return nil, err
+		}
+		repo.SetCompressor(compressor)
+	}
+	return repo, nil
+}
+
+// SetCompressor sets the codec new and edited posts are compressed with.
+// Posts compressed with other codecs can still be read.
+func (r *DBPostRepository) SetCompressor(compressor compression.Compressor) {
+	r.compressor = compressor
+	r.codecs.Add(compressor)
+}
+
+// AddCodec allows reading posts compressed with compressor, such as a zstd
+// dictionary that is no longer used to write.
+func (r *DBPostRepository) AddCodec(compressor compression.Compressor) {
+	r.codecs.Add(compressor)
+}
+
+func (r *DBPostRepository) Start(ctx context.Context) error {
+	if err := r.load(ctx); err != nil {
+		return err
 	}
+	r.loop.start(ctx, func(ctx context.Context) {
+		poll(ctx, r.reloadTimeout, r.reload)
+	})
+	return nil
+}
+
+func (r *DBPostRepository) Stop() {
+	r.loop.stop()
 }
 
-func (r *DBPostRepository) Init() {
-	posts, postMap, err := r.GetPosts()
+// load reads every post into the cache.
+func (r *DBPostRepository) load(ctx context.Context) error {
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
+
+	if n, err := r.Rehash(ctx, 100); err != nil {
+		repoLogger.Error().Err(err).Msg("Error rehashing posts")
+	} else if n > 0 {
+		repoLogger.Info().Int("posts", n).Msg("Rehashed posts stored with the old content hash")
+	}
+
+	lastTombstone, err := r.getLatestTombstone(ctx)
 	if err != nil {
-		repoLogger.Fatal().Err(err).Msg("Error initializing posts")
+		return fmt.Errorf("error initializing posts: %w", err)
+	}
+	lastChange, err := r.getLatestChange(ctx)
+	if err != nil {
+		return fmt.Errorf("error initializing posts: %w", err)
+	}
+	posts, err := r.readPosts(ctx, -1)
+	if err != nil {
+		return fmt.Errorf("error initializing posts: %w", err)
 	}
 
-	r.postsCacheSorted = posts
-	r.postsCache.SetTo(postMap)
+	r.lastChange = lastChange
+	r.lastTombstone = lastTombstone
+	r.cache.store(posts)
+	return nil
+}
 
-	go r.ReloadPosts()
+func (r *DBPostRepository) SetReloadNotifier(notifier func(model.PostID, model.PostEvent)) {
+	r.reloadNotifier = notifier
+}
+
+func (r *DBPostRepository) notifyPostReload(postID model.PostID, event model.PostEvent) {
+	if r.reloadNotifier != nil {
+		r.reloadNotifier(postID, event)
+	}
 }
 
-func (r *DBPostRepository) GetLatestModifiedTime() (*time.Time, error) {
-	var latestTimeStr sql.NullString
-	row := r.db.Get().QueryRow(`SELECT MAX(modified_at) FROM posts`)
-	err := row.Scan(&latestTimeStr)
+func (r *DBPostRepository) GetLatestModifiedTime(ctx context.Context) (*time.Time, error) {
+	var latest any
+	row := r.db.QueryRowContext(ctx, `SELECT MAX(modified_at) FROM posts`)
+	err := row.Scan(&latest)
 	if err != nil {
 		return nil, fmt.Errorf("error scanning latest modified time: %w", err)
 	}
 
-	if !latestTimeStr.Valid {
+	var latestTimeStr string
+	switch v := latest.(type) {
+	case nil:
 		return nil, nil // It was NULL, so no posts or no valid timestamps.
+	case time.Time:
+		// Drivers with native timestamps, such as PostgreSQL's
+		return &v, nil
+	case string:
+		latestTimeStr = v
+	case []byte:
+		latestTimeStr = string(v)
+	default:
+		return nil, fmt.Errorf("unexpected latest modified time %v (%T)", v, v)
 	}
 
 	// The go-sqlite3 driver returns a string for MAX(), so we must parse it.
@@ -73,68 +182,129 @@ func (r *DBPostRepository) GetLatestModifiedTime() (*time.Time, error) {
 	var latestTime time.Time
 	var parseErr error
 	for _, format := range timeFormats {
-		latestTime, parseErr = time.Parse(format, latestTimeStr.String)
+		latestTime, parseErr = time.Parse(format, latestTimeStr)
 		if parseErr == nil {
 			return &latestTime, nil
 		}
 	}
 
-	return nil, fmt.Errorf("error parsing latest modified time '%s' with any known format: %w", latestTimeStr.String, parseErr)
+	return nil, fmt.Errorf("error parsing latest modified time '%s' with any known format: %w", latestTimeStr, parseErr)
 }
 
-func (r *DBPostRepository) GetPosts() ([]model.Post, map[string]*model.Post, error) {
-	rows, err := r.db.Query(`SELECT id, title, content, md_content_hash, created_at, modified_at, user_id FROM posts`)
+func (r *DBPostRepository) GetPosts(ctx context.Context) ([]model.Post, map[string]*model.Post, error) {
+	posts, err := r.readPosts(ctx, -1)
 	if err != nil {
-		return nil, nil, fmt.Errorf("error querying posts: %w", err)
+		return nil, nil, err
+	}
+	return posts, postMap(posts), nil
+}
+
+// getLatestChange returns the number of the latest insert or update of a
+// post. The numbers follow the order the writes were committed in, so every
+// change up to it is visible to the queries that follow.
+func (r *DBPostRepository) getLatestChange(ctx context.Context) (int64, error) {
+	var seq int64
+	err := r.db.QueryRowContext(ctx, `SELECT seq FROM post_change_seq`).Scan(&seq)
+	if err != nil {
+		return 0, fmt.Errorf("error querying latest post change: %w", err)
+	}
+	return seq, nil
+}
+
+// readPosts reads the posts changed after the change numbered after, sorted
+// by modification date. Posts not changed since changes were first numbered
+// are numbered 0, so an after of -1 reads every post.
+func (r *DBPostRepository) readPosts(ctx context.Context, after int64) ([]model.Post, error) {
+	rows, err := r.db.QueryContext(ctx,
+		`SELECT id, title, content, content_codec, content_checksum, md_content_hash, created_at, modified_at, user_id FROM posts WHERE change_seq > ?`,
+		after,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("error querying posts: %w", err)
 	}
 	defer rows.Close()
 
 	posts := make([]model.Post, 0)
-	postMap := make(map[string]*model.Post)
-	var latestModTime *time.Time
-
 	for rows.Next() {
 		var post model.Post
-		var compressed []byte
+		var stored storedPost
 
-		err := rows.Scan(&post.ID, &post.Title, &compressed, &post.MDContentHash, &post.CreatedDate, &post.ModifiedDate, &post.Owner)
+		err := rows.Scan(&post.ID, &post.Title, &stored.content, &stored.codec, &stored.checksum, &post.MDContentHash, &post.CreatedDate, &post.ModifiedDate, &post.Owner)
 		if err != nil {
-			return nil, nil, fmt.Errorf("error scanning post: %w", err)
+			return nil, fmt.Errorf("error scanning post: %w", err)
 		}
 
-		// Track the latest modification time
-		if latestModTime == nil || post.ModifiedDate.After(*latestModTime) {
-			latestModTime = &post.ModifiedDate
-		}
-
-		// Decompress the content
-		content, err := r.compressor.Decompress(compressed)
+		stored.id = post.ID
+		content, err := r.unpack(stored)
 		if err != nil {
-			return nil, nil, fmt.Errorf("error decompressing content: %w", err)
+			return nil, err
 		}
 		post.Markdown = content
 
 		posts = append(posts, post)
-		postMap[string(post.ID)] = &post
 	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error querying posts: %w", err)
+	}
+
+	sortDBPosts(posts)
+	return posts, nil
+}
+
+// decompress decompresses data compressed with the codec called name.
+func (r *DBPostRepository) decompress(name string, data []byte) ([]byte, error) {
+	codec, err := r.codecs.Get(name)
+	if err != nil {
+		return nil, err
+	}
+	return codec.Decompress(data)
+}
+
+// getLatestTombstone returns the sequence number of the latest tombstone, or
+// 0 if no post was ever deleted.
+func (r *DBPostRepository) getLatestTombstone(ctx context.Context) (int64, error) {
+	var seq int64
+	err := r.db.QueryRowContext(ctx, `SELECT COALESCE(MAX(seq), 0) FROM post_tombstones`).Scan(&seq)
+	if err != nil {
+		return 0, fmt.Errorf("error querying latest tombstone: %w", err)
+	}
+	return seq, nil
+}
 
-	// Update our tracked modification time
-	r.lastModifiedTime = latestModTime
+// readTombstones returns the posts deleted after the tombstone with sequence
+// number after, and the sequence number of the last one.
+func (r *DBPostRepository) readTombstones(ctx context.Context, after int64) ([]model.PostID, int64, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT seq, post_id FROM post_tombstones WHERE seq > ? ORDER BY seq`, after)
+	if err != nil {
+		return nil, 0, fmt.Errorf("error querying tombstones: %w", err)
+	}
+	defer rows.Close()
 
-	// Sort the posts by creation date
+	var deleted []model.PostID
+	last := after
+	for rows.Next() {
+		var postID model.PostID
+		if err := rows.Scan(&last, &postID); err != nil {
+			return nil, 0, fmt.Errorf("error scanning tombstone: %w", err)
+		}
+		deleted = append(deleted, postID)
+	}
+	return deleted, last, rows.Err()
+}
+
+// sortDBPosts sorts posts by modification date, newest first.
+func sortDBPosts(posts []model.Post) {
 	slices.SortStableFunc(posts, func(a, b model.Post) int {
 		return -a.ModifiedDate.Compare(b.ModifiedDate)
 	})
-
-	return posts, postMap, nil
 }
 
 func (r *DBPostRepository) GetPostList() []model.Post {
-	return r.postsCacheSorted
+	return r.cache.load().list()
 }
 
 func (r *DBPostRepository) ReadPost(id any) (*model.Post, error) {
-	post, ok := r.postsCache.Get(id.(string))
+	post, ok := r.cache.load().get(id.(string))
 	if !ok {
 		return nil, fmt.Errorf("post not found: %s", id)
 	}
@@ -142,110 +312,121 @@ func (r *DBPostRepository) ReadPost(id any) (*model.Post, error) {
 }
 
 func (r *DBPostRepository) GetAdjacentPosts(id any) (prev *model.Post, next *model.Post) {
-	idStr := id.(string)
-
-	// Find the current post in the sorted list
-	for i, post := range r.postsCacheSorted {
-		if string(post.ID) == idStr {
-			// Get previous post (if exists)
-			if i > 0 {
-				prev = &r.postsCacheSorted[i-1]
-			}
-			// Get next post (if exists)
-			if i < len(r.postsCacheSorted)-1 {
-				next = &r.postsCacheSorted[i+1]
-			}
-			break
-		}
-	}
-
-	return prev, next
+	return r.cache.load().adjacent(id.(string))
 }
 
 func (r *DBPostRepository) SetReloadTimeout(timeout time.Duration) {
 	r.reloadTimeout = timeout
 }
 
-func (r *DBPostRepository) ReloadPosts() {
-	sleepFunc := func() {
-		time.Sleep(r.reloadTimeout)
+// reload applies the posts modified and deleted since the last reload to the
+// cache and notifies about each of them.
+func (r *DBPostRepository) reload(ctx context.Context) {
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
+
+	// First, do a lightweight check to see if anything has changed
+	lastChange, err := r.getLatestChange(ctx)
+	if err != nil {
+		repoLogger.Error().Err(err).Msg("Error checking post changes")
+		return
+	}
+	lastTombstone, err := r.getLatestTombstone(ctx)
+	if err != nil {
+		repoLogger.Error().Err(err).Msg("Error checking deleted posts")
+		return
 	}
 
-	for {
-		// First, do a lightweight check to see if anything has changed
-		latestTime, err := r.GetLatestModifiedTime()
-		if err != nil {
-			repoLogger.Error().Err(err).Msg("Error checking latest modification time")
-			sleepFunc()
-			continue
-		}
+	// The first reload reads every post, even those never numbered
+	initial := r.cache.load() == nil
+	after := r.lastChange
+	if initial {
+		after = -1
+	}
+	modified := initial || lastChange > r.lastChange
+	if !modified && lastTombstone <= r.lastTombstone {
+		repoLogger.Debug().Msg("No posts modified, skipping reload")
+		return
+	}
 
-		// If we have a cached time and nothing has changed, skip
-		if r.lastModifiedTime != nil && latestTime != nil && !latestTime.After(*r.lastModifiedTime) {
-			repoLogger.Debug().Msg("No posts modified, skipping reload")
-			sleepFunc()
-			continue
+	// Posts are read before tombstones, so a post deleted in between is
+	// removed by this reload instead of lingering until the next one. Posts
+	// changed after lastChange was read are read as well, and again by the
+	// next reload, which finds them unchanged.
+	var changed []model.Post
+	if modified {
+		changed, err = r.readPosts(ctx, after)
+		if err != nil {
+			repoLogger.Error().Err(err).Msg("Error reloading posts")
+			return
 		}
+	}
+	deleted, lastTombstone, err := r.readTombstones(ctx, r.lastTombstone)
+	if err != nil {
+		repoLogger.Error().Err(err).Msg("Error reloading deleted posts")
+		return
+	}
 
-		repoLogger.Debug().Msg("Posts may have changed, performing full reload")
+	repoLogger.Debug().Int("modified", len(changed)).Int("deleted", len(deleted)).Msg("Applying post changes")
 
-		// Something changed, do the full reload
-		posts, postMap, err := r.GetPosts()
-		if err != nil {
-			repoLogger.Error().Err(err).Msg("Error reloading posts")
-		} else {
-			// Check if any posts have changed by comparing content hashes
-			hasChanges := false
+	type change struct {
+		postID model.PostID
+		event  model.PostEvent
+	}
+	var changes []change
 
-			// Create a map of current cached posts for quick lookup
-			cachedPosts := make(map[string]*model.Post)
-			for i := range r.postsCacheSorted {
-				cachedPosts[string(r.postsCacheSorted[i].ID)] = &r.postsCacheSorted[i]
-			}
+	r.cache.update(func(posts []model.Post) []model.Post {
+		index := make(map[model.PostID]int, len(posts))
+		for i := range posts {
+			index[posts[i].ID] = i
+		}
 
-			// Check for new or modified posts
-			for _, newPost := range posts {
-				if cachedPost, exists := cachedPosts[string(newPost.ID)]; exists {
-					// Compare content hashes to detect changes
-					if newPost.MDContentHash != cachedPost.MDContentHash {
-						hasChanges = true
-						repoLogger.Info().
-							Str("post_id", string(newPost.ID)).
-							Str("title", newPost.Title).
-							Msg("Post content changed, reloading")
-						if r.reloadNotifier != nil {
-							go r.reloadNotifier(newPost.ID)
-						}
-					}
-				} else {
-					// New post detected
-					hasChanges = true
-					repoLogger.Info().
-						Str("post_id", string(newPost.ID)).
-						Str("title", newPost.Title).
-						Msg("New post detected")
-				}
+		for _, post := range changed {
+			i, ok := index[post.ID]
+			if !ok {
+				repoLogger.Info().
+					Str("post_id", string(post.ID)).
+					Str("title", post.Title).
+					Msg("New post detected")
+				changes = append(changes, change{post.ID, model.PostCreated})
+				index[post.ID] = len(posts)
+				posts = append(posts, post)
+				continue
 			}
-
-			// Check for deleted posts
-			if len(posts) != len(r.postsCacheSorted) {
-				hasChanges = true
-				repoLogger.Info().Msg("Number of posts changed")
+			if posts[i].MDContentHash != post.MDContentHash {
+				repoLogger.Info().
+					Str("post_id", string(post.ID)).
+					Str("title", post.Title).
+					Msg("Post content changed, reloading")
+				changes = append(changes, change{post.ID, model.PostUpdated})
 			}
+			posts[i] = post
+		}
 
-			if hasChanges {
-				repoLogger.Info().Msg("Posts have changed, updating cache")
-				r.postsCacheSorted = posts
-				r.postsCache.SetTo(postMap)
+		for _, postID := range deleted {
+			if _, ok := index[postID]; !ok {
+				continue
 			}
+			repoLogger.Info().Str("post_id", string(postID)).Msg("Post deleted")
+			changes = append(changes, change{postID, model.PostDeleted})
+			delete(index, postID)
+			posts = slices.DeleteFunc(posts, func(p model.Post) bool { return p.ID == postID })
 		}
 
-		sleepFunc()
-	}
-}
+		sortDBPosts(posts)
+		return posts
+	})
 
-func (r *DBPostRepository) SetReloadNotifier(notifier func(model.PostID)) {
-	r.reloadNotifier = notifier
+	r.lastChange = lastChange
+	r.lastTombstone = lastTombstone
+
+	if initial {
+		// Nothing changed, the posts were just loaded
+		return
+	}
+	for _, c := range changes {
+		go r.notifyPostReload(c.postID, c.event)
+	}
 }
 
 func (r *DBPostRepository) NewPost() *model.Post {
@@ -259,45 +440,58 @@ func (r *DBPostRepository) NewPost() *model.Post {
 	}
 }
 
-func (r *DBPostRepository) SetPostContent(post *model.Post) error {
+func (r *DBPostRepository) SetPostContent(ctx context.Context, post *model.Post) error {
 	// Compress the content
 	compressed, err := r.compressor.Compress([]byte(post.Markdown))
 	if err != nil {
 		return fmt.Errorf("error compressing content: %w", err)
 	}
 
-	// Calculate the content hash for the compressed content
-	post.MDContentHash = util.ContentHash(compressed)
+	post.MDContentHash = util.MarkdownHash(post.Markdown)
+
+	// Hold off reloads so they don't replace the cache with posts read
+	// before the update
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
 
 	// Save the post
-	res, err := r.db.Exec(
-		`UPDATE posts SET title = ?, content = ?, md_content_hash = ?, modified_at = ? WHERE id = ?`,
-		post.Title, compressed, post.MDContentHash, time.Now().UTC(), post.ID,
+	post.ModifiedDate = time.Now().UTC()
+	res, err := r.db.ExecContext(ctx,
+		`UPDATE posts SET title = ?, content = ?, content_codec = ?, content_checksum = ?, md_content_hash = ?, modified_at = ? WHERE id = ?`,
+		post.Title, compressed, r.compressor.Name(), util.ContentHash(compressed), post.MDContentHash, post.ModifiedDate, post.ID,
 	)
 
 	if err != nil {
 		return fmt.Errorf("error saving post: %w", err)
 	}
 
+	if n, err := res.RowsAffected(); err == nil && n == 0 {
+		return fmt.Errorf("post not found: %s", post.ID)
+	}
+
 	repoLogger.Debug().Interface("result", res).Msg("Post content set")
 
+	r.cachePost(post)
+	go r.notifyPostReload(post.ID, model.PostUpdated)
 	return nil
 }
 
-func (r *DBPostRepository) SavePost(post *model.Post) error {
+func (r *DBPostRepository) SavePost(ctx context.Context, post *model.Post) error {
 	// Compress the content
 	compressed, err := r.compressor.Compress([]byte(post.Markdown))
 	if err != nil {
 		return fmt.Errorf("error compressing content: %w", err)
 	}
 
-	// Calculate the content hash for the compressed content
-	post.MDContentHash = util.ContentHash(compressed)
+	post.MDContentHash = util.MarkdownHash(post.Markdown)
+
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
 
 	// Save the post
-	res, err := r.db.Exec(
-		`INSERT INTO posts (id, title, content, md_content_hash, created_at, modified_at, user_id) VALUES (?, ?, ?, ?, ?, ?, ?)`,
-		post.ID, post.Title, compressed, post.MDContentHash, post.CreatedDate, post.ModifiedDate, post.Owner,
+	res, err := r.db.ExecContext(ctx,
+		`INSERT INTO posts (id, title, content, content_codec, content_checksum, md_content_hash, created_at, modified_at, user_id) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		post.ID, post.Title, compressed, r.compressor.Name(), util.ContentHash(compressed), post.MDContentHash, post.CreatedDate, post.ModifiedDate, post.Owner,
 	)
 
 	if err != nil {
@@ -306,5 +500,138 @@ func (r *DBPostRepository) SavePost(post *model.Post) error {
 
 	repoLogger.Debug().Interface("result", res).Msg("Post saved")
 
+	r.cachePost(post)
+	go r.notifyPostReload(post.ID, model.PostCreated)
 	return nil
 }
+
+// Recompress rewrites the posts compressed with another codec than the
+// current compressor, batch posts per transaction, and returns how many were
+// rewritten. Their modification dates are kept, as their content is the same.
+func (r *DBPostRepository) Recompress(ctx context.Context, batch int) (int, error) {
+	name := r.compressor.Name()
+	return r.rewritePosts(ctx, batch, `content_codec <> ?`, []any{name}, func(tx *db.Tx, p storedPost) error {
+		content, err := r.unpack(p)
+		if err != nil {
+			return err
+		}
+		compressed, err := r.compressor.Compress(content)
+		if err != nil {
+			return fmt.Errorf("error compressing post %s: %w", p.id, err)
+		}
+		_, err = tx.Exec(`UPDATE posts SET content = ?, content_codec = ?, content_checksum = ? WHERE id = ?`,
+			compressed, name, util.ContentHash(compressed), p.id)
+		return err
+	})
+}
+
+// Rehash fills in the hashes of the posts stored before md_content_hash was
+// taken over their Markdown, batch posts per transaction, and returns how
+// many were rehashed. Init runs it, and only finds posts to rehash once.
+func (r *DBPostRepository) Rehash(ctx context.Context, batch int) (int, error) {
+	return r.rewritePosts(ctx, batch, `content_checksum = ''`, nil, func(tx *db.Tx, p storedPost) error {
+		content, err := r.unpack(p)
+		if err != nil {
+			return err
+		}
+		_, err = tx.Exec(`UPDATE posts SET md_content_hash = ?, content_checksum = ? WHERE id = ?`,
+			util.MarkdownHash(content), util.ContentHash(p.content), p.id)
+		return err
+	})
+}
+
+// storedPost is the stored content of a post.
+type storedPost struct {
+	id       model.PostID
+	codec    string
+	checksum string
+	content  []byte
+}
+
+// unpack verifies and decompresses the stored content of a post.
+func (r *DBPostRepository) unpack(p storedPost) ([]byte, error) {
+	if p.checksum != "" && util.ContentHash(p.content) != p.checksum {
+		return nil, fmt.Errorf("post %s does not match its checksum", p.id)
+	}
+	content, err := r.decompress(p.codec, p.content)
+	if err != nil {
+		return nil, fmt.Errorf("error decompressing post %s: %w", p.id, err)
+	}
+	return content, nil
+}
+
+// rewritePosts calls rewrite for every post matching the condition where,
+// batch posts per transaction, until none are left. rewrite must update the
+// post so that it no longer matches. It returns how many posts were
+// rewritten.
+func (r *DBPostRepository) rewritePosts(ctx context.Context, batch int, where string, args []any, rewrite func(tx *db.Tx, p storedPost) error) (int, error) {
+	if batch <= 0 {
+		return 0, fmt.Errorf("invalid batch size %d", batch)
+	}
+
+	total := 0
+	for {
+		n := 0
+		err := r.db.WithTx(ctx, func(tx *db.Tx) error {
+			rows, err := tx.Query(`SELECT id, content_codec, content_checksum, content FROM posts WHERE `+where+` ORDER BY id LIMIT ?`, slices.Concat(args, []any{batch})...)
+			if err != nil {
+				return fmt.Errorf("error querying posts: %w", err)
+			}
+			var pending []storedPost
+			for rows.Next() {
+				var p storedPost
+				if err := rows.Scan(&p.id, &p.codec, &p.checksum, &p.content); err != nil {
+					rows.Close()
+					return fmt.Errorf("error scanning post: %w", err)
+				}
+				pending = append(pending, p)
+			}
+			rows.Close()
+			if err := rows.Err(); err != nil {
+				return fmt.Errorf("error querying posts: %w", err)
+			}
+
+			for _, p := range pending {
+				if err := rewrite(tx, p); err != nil {
+					return fmt.Errorf("error rewriting post %s: %w", p.id, err)
+				}
+			}
+			n = len(pending)
+			return nil
+		})
+		if err != nil {
+			return total, err
+		}
+		total += n
+		if n < batch {
+			return total, nil
+		}
+	}
+}
+
+// cachePost writes a saved post through to the cache, so it can be read
+// right away instead of after the next reload.
+func (r *DBPostRepository) cachePost(post *model.Post) {
+	cached := model.Post{
+		ID:            post.ID,
+		Title:         post.Title,
+		Markdown:      post.Markdown,
+		MDContentHash: post.MDContentHash,
+		CreatedDate:   post.CreatedDate,
+		ModifiedDate:  post.ModifiedDate,
+		Owner:         post.Owner,
+	}
+
+	r.cache.update(func(posts []model.Post) []model.Post {
+		i := slices.IndexFunc(posts, func(p model.Post) bool { return p.ID == cached.ID })
+		if i < 0 {
+			posts = append(posts, cached)
+		} else {
+			cached.CreatedDate = posts[i].CreatedDate
+			cached.Owner = posts[i].Owner
+			posts[i] = cached
+		}
+		sortDBPosts(posts)
+		return posts
+	})
+}
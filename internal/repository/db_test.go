@@ -1,10 +1,22 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
 
+	"github.com/debemdeboas/the-archive/internal/config"
+	"github.com/debemdeboas/the-archive/internal/db"
 	"github.com/debemdeboas/the-archive/internal/model"
+	"github.com/debemdeboas/the-archive/internal/pgtest"
+	"github.com/debemdeboas/the-archive/internal/util"
+	"github.com/debemdeboas/the-archive/internal/util/compression"
 	_ "github.com/mattn/go-sqlite3"
 )
 
@@ -21,6 +33,18 @@ func (t *testDB) Exec(query string, args ...interface{}) (sql.Result, error) {
 	return t.DB.Exec(query, args...)
 }
 
+func (t *testDB) WithTx(ctx context.Context, fn func(tx *db.Tx) error) error {
+	return db.RunTx(ctx, t.DB, db.SQLiteDialect, fn)
+}
+
+func (t *testDB) Dialect() db.Dialect {
+	return db.SQLiteDialect
+}
+
+func (t *testDB) Open() error {
+	return nil
+}
+
 func (t *testDB) Get() *sql.DB {
 	return t.DB
 }
@@ -35,11 +59,48 @@ func (t *testDB) InitDB() error {
 			id TEXT PRIMARY KEY,
 			title TEXT,
 			content BLOB,
+			content_codec TEXT NOT NULL DEFAULT 'zstd',
+			content_checksum TEXT NOT NULL DEFAULT '',
 			md_content_hash TEXT,
 			created_at DATETIME,
 			modified_at DATETIME,
-			user_id TEXT
-		)
+			user_id TEXT,
+			change_seq INTEGER NOT NULL DEFAULT 0
+		);
+
+		CREATE TABLE IF NOT EXISTS post_change_seq (
+			seq INTEGER NOT NULL
+		);
+		INSERT INTO post_change_seq (seq) SELECT 0 WHERE NOT EXISTS (SELECT 1 FROM post_change_seq);
+
+		CREATE TRIGGER IF NOT EXISTS posts_change_seq_insert AFTER INSERT ON posts
+		BEGIN
+			UPDATE post_change_seq SET seq = seq + 1;
+			UPDATE posts SET change_seq = (SELECT seq FROM post_change_seq) WHERE id = NEW.id;
+		END;
+
+		CREATE TRIGGER IF NOT EXISTS posts_change_seq_update AFTER UPDATE ON posts
+		WHEN NEW.change_seq = OLD.change_seq
+		BEGIN
+			UPDATE post_change_seq SET seq = seq + 1;
+			UPDATE posts SET change_seq = (SELECT seq FROM post_change_seq) WHERE id = NEW.id;
+		END;
+
+		CREATE TABLE IF NOT EXISTS post_tombstones (
+			seq INTEGER PRIMARY KEY AUTOINCREMENT,
+			post_id TEXT NOT NULL,
+			deleted_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+
+		CREATE TRIGGER IF NOT EXISTS posts_tombstone AFTER DELETE ON posts
+		BEGIN
+			INSERT INTO post_tombstones (post_id) VALUES (OLD.id);
+		END;
+
+		CREATE TRIGGER IF NOT EXISTS posts_untombstone AFTER INSERT ON posts
+		BEGIN
+			DELETE FROM post_tombstones WHERE post_id = NEW.id;
+		END;
 	`)
 	return err
 }
@@ -60,6 +121,7 @@ func setupTestDB() (*testDB, error) {
 }
 
 func TestReloadPostsHashComparison(t *testing.T) {
+	ctx := context.Background()
 	// Setup test database
 	testDB, err := setupTestDB()
 	if err != nil {
@@ -67,176 +129,113 @@ func TestReloadPostsHashComparison(t *testing.T) {
 	}
 	defer testDB.Close()
 
-	// Create repository
+	// The repository under test, and a second one standing in for another
+	// process writing to the same database
 	repo := NewDBPostRepository(testDB)
+	writer := NewDBPostRepository(testDB)
+	notified := notifications(repo)
+	repo.reload(context.Background())
 
-	// Create initial post
-	post1 := repo.NewPost()
+	post1 := writer.NewPost()
 	post1.Title = "Test Post 1"
 	post1.Markdown = []byte("# Hello World")
 	post1.Owner = model.UserID("test-user")
 
-	err = repo.SavePost(post1)
-	if err != nil {
-		t.Fatalf("Failed to save initial post: %v", err)
-	}
-
-	// Initialize repository cache
-	posts, postMap, err := repo.GetPosts()
-	if err != nil {
-		t.Fatalf("Failed to get posts: %v", err)
-	}
-	repo.postsCacheSorted = posts
-	repo.postsCache.SetTo(postMap)
+	t.Run("NewPost", func(t *testing.T) {
+		if err := writer.SavePost(ctx, post1); err != nil {
+			t.Fatalf("Failed to save initial post: %v", err)
+		}
 
-	if len(posts) != 1 {
-		t.Fatalf("Expected 1 post, got %d", len(posts))
-	}
+		repo.reload(context.Background())
 
-	// Track reload notifications
-	reloadCalled := false
-	var reloadedPostID model.PostID
-	repo.SetReloadNotifier(func(postID model.PostID) {
-		reloadCalled = true
-		reloadedPostID = postID
+		if len(repo.GetPostList()) != 1 {
+			t.Fatalf("Expected 1 post, got %d", len(repo.GetPostList()))
+		}
+		expectNotifications(t, notified, postNotification{post1.ID, model.PostCreated})
 	})
 
-	// Test 1: No changes should not trigger reload
 	t.Run("NoChanges", func(t *testing.T) {
-		reloadCalled = false
+		before := repo.GetPostList()
+		repo.reload(context.Background())
 
-		// Simulate one iteration of ReloadPosts logic
-		newPosts, _, err := repo.GetPosts()
-		if err != nil {
-			t.Fatalf("Failed to get posts: %v", err)
-		}
-
-		// Check if any posts have changed by comparing content hashes
-		hasChanges := false
-		cachedPosts := make(map[string]*model.Post)
-		for i := range repo.postsCacheSorted {
-			cachedPosts[string(repo.postsCacheSorted[i].ID)] = &repo.postsCacheSorted[i]
-		}
-
-		for _, newPost := range newPosts {
-			if cachedPost, exists := cachedPosts[string(newPost.ID)]; exists {
-				if newPost.MDContentHash != cachedPost.MDContentHash {
-					hasChanges = true
-					break
-				}
-			} else {
-				hasChanges = true
-				break
-			}
-		}
-
-		if hasChanges {
-			t.Error("Expected no changes, but changes were detected")
-		}
-		if reloadCalled {
-			t.Error("Reload notification should not have been called")
+		if !sameList(before, repo.GetPostList()) {
+			t.Error("Expected the cached posts to be kept")
 		}
+		expectNotifications(t, notified)
 	})
 
-	// Test 2: Content change should trigger reload
 	t.Run("ContentChange", func(t *testing.T) {
-		reloadCalled = false
-
-		// Modify the post content
 		post1.Markdown = []byte("# Hello World Modified!")
-		err = repo.SetPostContent(post1)
-		if err != nil {
+		if err := writer.SetPostContent(ctx, post1); err != nil {
 			t.Fatalf("Failed to update post: %v", err)
 		}
 
-		// Simulate one iteration of ReloadPosts logic
-		newPosts, newPostMap, err := repo.GetPosts()
-		if err != nil {
-			t.Fatalf("Failed to get posts: %v", err)
-		}
-
-		// Check if any posts have changed by comparing content hashes
-		hasChanges := false
-		cachedPosts := make(map[string]*model.Post)
-		for i := range repo.postsCacheSorted {
-			cachedPosts[string(repo.postsCacheSorted[i].ID)] = &repo.postsCacheSorted[i]
-		}
-
-		var changedPostID model.PostID
-		for _, newPost := range newPosts {
-			if cachedPost, exists := cachedPosts[string(newPost.ID)]; exists {
-				if newPost.MDContentHash != cachedPost.MDContentHash {
-					hasChanges = true
-					changedPostID = newPost.ID
-					// Simulate the reload notification
-					if repo.reloadNotifier != nil {
-						repo.reloadNotifier(newPost.ID)
-					}
-					break
-				}
-			}
+		repo.reload(context.Background())
+
+		cached, err := repo.ReadPost(string(post1.ID))
+		if err != nil || cached.MDContentHash != post1.MDContentHash {
+			t.Errorf("Expected the cached post to be updated, got %+v (%v)", cached, err)
 		}
+		expectNotifications(t, notified, postNotification{post1.ID, model.PostUpdated})
+	})
 
-		if !hasChanges {
-			t.Error("Expected changes to be detected, but none were found")
+	t.Run("OlderModificationDate", func(t *testing.T) {
+		// Written by an instance whose clock is behind, after the reload
+		// saw newer posts
+		skewed := writer.NewPost()
+		skewed.Markdown = []byte("# Late")
+		skewed.ModifiedDate = post1.ModifiedDate.Add(-time.Hour)
+		if err := writer.SavePost(ctx, skewed); err != nil {
+			t.Fatalf("Failed to save post: %v", err)
 		}
-		if !reloadCalled {
-			t.Error("Reload notification should have been called")
+
+		repo.reload(context.Background())
+
+		if _, err := repo.ReadPost(string(skewed.ID)); err != nil {
+			t.Errorf("Expected the post to be reloaded: %v", err)
 		}
-		if reloadedPostID != changedPostID {
-			t.Errorf("Expected reload notification for post %s, got %s", changedPostID, reloadedPostID)
+		expectNotifications(t, notified, postNotification{skewed.ID, model.PostCreated})
+	})
+
+	t.Run("Deletion", func(t *testing.T) {
+		if _, err := testDB.Exec("DELETE FROM posts WHERE id = ?", post1.ID); err != nil {
+			t.Fatalf("Failed to delete post: %v", err)
 		}
 
-		// Update the cache to reflect changes
-		repo.postsCacheSorted = newPosts
-		repo.postsCache.SetTo(newPostMap)
-	})
+		repo.reload(context.Background())
 
-	// Test 3: New post should trigger reload
-	t.Run("NewPost", func(t *testing.T) {
-		reloadCalled = false
+		if _, err := repo.ReadPost(string(post1.ID)); err == nil {
+			t.Error("Expected the deleted post to be removed from the cache")
+		}
+		expectNotifications(t, notified, postNotification{post1.ID, model.PostDeleted})
+	})
 
-		// Create a new post
+	t.Run("OwnWrites", func(t *testing.T) {
 		post2 := repo.NewPost()
 		post2.Title = "Test Post 2"
 		post2.Markdown = []byte("# Another Post")
 		post2.Owner = model.UserID("test-user")
 
-		err = repo.SavePost(post2)
-		if err != nil {
+		if err := repo.SavePost(ctx, post2); err != nil {
 			t.Fatalf("Failed to save new post: %v", err)
 		}
-
-		// Simulate one iteration of ReloadPosts logic
-		newPosts, _, err := repo.GetPosts()
-		if err != nil {
-			t.Fatalf("Failed to get posts: %v", err)
-		}
-
-		// Check for new posts
-		cachedPosts := make(map[string]*model.Post)
-		for i := range repo.postsCacheSorted {
-			cachedPosts[string(repo.postsCacheSorted[i].ID)] = &repo.postsCacheSorted[i]
-		}
-
-		hasNewPosts := false
-		for _, newPost := range newPosts {
-			if _, exists := cachedPosts[string(newPost.ID)]; !exists {
-				hasNewPosts = true
-				break
-			}
+		post2.Markdown = []byte("# Another Post, edited")
+		if err := repo.SetPostContent(ctx, post2); err != nil {
+			t.Fatalf("Failed to update post: %v", err)
 		}
+		expectNotifications(t, notified,
+			postNotification{post2.ID, model.PostCreated},
+			postNotification{post2.ID, model.PostUpdated},
+		)
 
-		if !hasNewPosts {
-			t.Error("Expected new post to be detected, but none were found")
-		}
-		if len(newPosts) != 2 {
-			t.Errorf("Expected 2 posts, got %d", len(newPosts))
-		}
+		// The writes were already cached, so reloading them is silent
+		repo.reload(context.Background())
+		expectNotifications(t, notified)
 	})
 }
 
 func TestHashComparison(t *testing.T) {
+	ctx := context.Background()
 	// Test that different content produces different hashes
 	testDB, err := setupTestDB()
 	if err != nil {
@@ -256,17 +255,17 @@ func TestHashComparison(t *testing.T) {
 	post2.Markdown = []byte("Content 2")
 	post2.Owner = model.UserID("test")
 
-	err = repo.SavePost(post1)
+	err = repo.SavePost(ctx, post1)
 	if err != nil {
 		t.Fatalf("Failed to save post1: %v", err)
 	}
 
-	err = repo.SavePost(post2)
+	err = repo.SavePost(ctx, post2)
 	if err != nil {
 		t.Fatalf("Failed to save post2: %v", err)
 	}
 
-	posts, _, err := repo.GetPosts()
+	posts, _, err := repo.GetPosts(ctx)
 	if err != nil {
 		t.Fatalf("Failed to get posts: %v", err)
 	}
@@ -275,6 +274,12 @@ func TestHashComparison(t *testing.T) {
 		t.Fatalf("Expected 2 posts, got %d", len(posts))
 	}
 
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+	if _, _, err := repo.GetPosts(canceled); !errors.Is(err, context.Canceled) {
+		t.Errorf("Expected GetPosts to stop with the context, got %v", err)
+	}
+
 	// Different content should have different hashes
 	if posts[0].MDContentHash == posts[1].MDContentHash {
 		t.Error("Different content should produce different hashes")
@@ -286,12 +291,12 @@ func TestHashComparison(t *testing.T) {
 	post3.Markdown = []byte("Content 1") // Same as post1
 	post3.Owner = model.UserID("test")
 
-	err = repo.SavePost(post3)
+	err = repo.SavePost(ctx, post3)
 	if err != nil {
 		t.Fatalf("Failed to save post3: %v", err)
 	}
 
-	posts, _, err = repo.GetPosts()
+	posts, _, err = repo.GetPosts(context.Background())
 	if err != nil {
 		t.Fatalf("Failed to get posts: %v", err)
 	}
@@ -311,3 +316,268 @@ func TestHashComparison(t *testing.T) {
 		t.Error("Same content should produce same hashes")
 	}
 }
+
+func TestDBPostRepositoryCodecs(t *testing.T) {
+	ctx := context.Background()
+	testDB, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+	defer testDB.Close()
+
+	// Posts written with every codec, as if the configuration changed over
+	// time
+	var samples [][]byte
+	for i := range 100 {
+		samples = append(samples, []byte(fmt.Sprintf("---\ntitle: Note %d\n---\n\n# Note %d\n\nSome words about note number %d.\n", i, i, i)))
+	}
+	dictionary, err := compression.TrainZstdDictionary(samples, 4<<10)
+	if err != nil {
+		t.Fatalf("Failed to train dictionary: %v", err)
+	}
+	dictPath := filepath.Join(t.TempDir(), "posts.dict")
+	os.WriteFile(dictPath, dictionary, 0o600)
+
+	configs := []config.DBPostsConfig{
+		{Compression: "zstd"},
+		{Compression: "gzip"},
+		{Compression: "none"},
+		{Compression: "zstd", Dictionary: dictPath},
+	}
+	want := map[model.PostID]string{}
+	codecs := map[string]bool{}
+	for i, cfg := range configs {
+		writer, err := NewDBPostRepositoryFromConfig(testDB, cfg)
+		if err != nil {
+			t.Fatalf("NewDBPostRepositoryFromConfig(%+v) failed: %v", cfg, err)
+		}
+		codecs[writer.compressor.Name()] = true
+
+		post := writer.NewPost()
+		post.Title = cfg.Compression
+		post.Markdown = samples[i]
+		if err := writer.SavePost(ctx, post); err != nil {
+			t.Fatalf("Failed to save post: %v", err)
+		}
+		want[post.ID] = string(post.Markdown)
+	}
+	if len(codecs) != len(configs) {
+		t.Fatalf("Expected every post to use a different codec, got %v", codecs)
+	}
+
+	readAll := func(t *testing.T, repo *DBPostRepository) {
+		t.Helper()
+		posts, _, err := repo.GetPosts(context.Background())
+		if err != nil {
+			t.Fatalf("GetPosts failed: %v", err)
+		}
+		if len(posts) != len(want) {
+			t.Fatalf("Expected %d posts, got %d", len(want), len(posts))
+		}
+		for _, post := range posts {
+			if string(post.Markdown) != want[post.ID] {
+				t.Errorf("Post %s: expected %q, got %q", post.Title, want[post.ID], post.Markdown)
+			}
+		}
+	}
+
+	t.Run("Every codec is read", func(t *testing.T) {
+		repo, err := NewDBPostRepositoryFromConfig(testDB, config.DBPostsConfig{Compression: "gzip", Dictionary: dictPath})
+		if err != nil {
+			t.Fatal(err)
+		}
+		readAll(t, repo)
+	})
+
+	t.Run("Posts need their dictionary", func(t *testing.T) {
+		if _, _, err := NewDBPostRepository(testDB).GetPosts(context.Background()); err == nil || !strings.Contains(err.Error(), "unknown compression codec") {
+			t.Errorf("Expected an unknown codec error, got %v", err)
+		}
+	})
+
+	t.Run("Recompress", func(t *testing.T) {
+		repo := NewDBPostRepository(testDB)
+		repo.AddCodec(mustDictCompressor(t, dictPath))
+		before, _ := repo.GetLatestModifiedTime(ctx)
+
+		n, err := repo.Recompress(ctx, 2)
+		if err != nil || n != 3 {
+			t.Fatalf("Expected 3 posts to be recompressed, got %d (%v)", n, err)
+		}
+		var left int
+		testDB.QueryRow(`SELECT COUNT(*) FROM posts WHERE content_codec <> 'zstd'`).Scan(&left)
+		if left != 0 {
+			t.Errorf("Expected every post to use zstd, %d do not", left)
+		}
+		if after, _ := repo.GetLatestModifiedTime(ctx); !after.Equal(*before) {
+			t.Errorf("Expected the modification dates to be kept, got %v instead of %v", after, before)
+		}
+
+		// Without the dictionary now
+		readAll(t, NewDBPostRepository(testDB))
+		if n, err := repo.Recompress(ctx, 2); err != nil || n != 0 {
+			t.Errorf("Expected nothing left to recompress, got %d (%v)", n, err)
+		}
+	})
+
+	t.Run("Invalid configuration", func(t *testing.T) {
+		for _, cfg := range []config.DBPostsConfig{{Compression: "lz4"}, {Dictionary: filepath.Join(t.TempDir(), "missing")}} {
+			if _, err := NewDBPostRepositoryFromConfig(testDB, cfg); err == nil {
+				t.Errorf("Expected an error for %+v", cfg)
+			}
+		}
+	})
+}
+
+func TestDBPostRepositoryContentHash(t *testing.T) {
+	ctx := context.Background()
+	testDB, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+	defer testDB.Close()
+
+	markdown := "%%%\ntitle = \"Hashed\"\n%%%\n\n# Hashed\n\nThe same everywhere.\n"
+	want := util.MarkdownHash([]byte(markdown))
+
+	t.Run("Backends and codecs agree", func(t *testing.T) {
+		dir := t.TempDir()
+		os.WriteFile(filepath.Join(dir, "hashed.md"), []byte(markdown), 0o644)
+		posts, _, err := NewFSPostRepository(dir).GetPosts(context.Background())
+		if err != nil || len(posts) != 1 || posts[0].MDContentHash != want {
+			t.Fatalf("Expected the fs post to hash to %s, got %+v (%v)", want, posts, err)
+		}
+
+		for _, codec := range []string{"zstd", "gzip", "none"} {
+			repo, _ := NewDBPostRepositoryFromConfig(testDB, config.DBPostsConfig{Compression: codec})
+			post := repo.NewPost()
+			post.Markdown = []byte(strings.ReplaceAll(markdown, "\n", "\r\n"))
+			if err := repo.SavePost(ctx, post); err != nil {
+				t.Fatalf("Failed to save post: %v", err)
+			}
+			if post.MDContentHash != want {
+				t.Errorf("%s: expected hash %s, got %s", codec, want, post.MDContentHash)
+			}
+		}
+	})
+
+	var checksums []string
+	rows, _ := testDB.Query(`SELECT content_checksum FROM posts`)
+	for rows.Next() {
+		var checksum string
+		rows.Scan(&checksum)
+		checksums = append(checksums, checksum)
+	}
+	rows.Close()
+	if len(checksums) != 3 || checksums[0] == "" || checksums[0] == checksums[1] || checksums[1] == checksums[2] {
+		t.Errorf("Expected each stored post to have its own checksum, got %v", checksums)
+	}
+
+	t.Run("Recompressing keeps the hash", func(t *testing.T) {
+		repo := NewDBPostRepository(testDB)
+		if _, err := repo.Recompress(ctx, 10); err != nil {
+			t.Fatalf("Recompress failed: %v", err)
+		}
+		posts, _, err := repo.GetPosts(context.Background())
+		if err != nil {
+			t.Fatalf("GetPosts failed: %v", err)
+		}
+		for _, post := range posts {
+			if post.MDContentHash != want {
+				t.Errorf("Expected hash %s, got %s", want, post.MDContentHash)
+			}
+		}
+	})
+
+	t.Run("Posts stored with the old hash are rehashed", func(t *testing.T) {
+		compressed, _ := compression.ZstdCompressor{}.Compress([]byte(markdown))
+		_, err := testDB.Exec(`INSERT INTO posts (id, title, content, md_content_hash, created_at, modified_at, user_id) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+			"legacy", "Legacy", compressed, util.ContentHash(compressed), time.Now().UTC(), time.Now().UTC(), "test-user")
+		if err != nil {
+			t.Fatalf("Failed to insert post: %v", err)
+		}
+
+		repo := NewDBPostRepository(testDB)
+		if n, err := repo.Rehash(ctx, 2); err != nil || n != 1 {
+			t.Fatalf("Expected 1 post to be rehashed, got %d (%v)", n, err)
+		}
+		var hash, checksum string
+		testDB.QueryRow(`SELECT md_content_hash, content_checksum FROM posts WHERE id = 'legacy'`).Scan(&hash, &checksum)
+		if hash != want || checksum != util.ContentHash(compressed) {
+			t.Errorf("Expected hash %s and checksum %s, got %s and %s", want, util.ContentHash(compressed), hash, checksum)
+		}
+		if n, err := repo.Rehash(ctx, 2); err != nil || n != 0 {
+			t.Errorf("Expected nothing left to rehash, got %d (%v)", n, err)
+		}
+	})
+
+	t.Run("Corrupt content is detected", func(t *testing.T) {
+		testDB.Exec(`UPDATE posts SET content = ? WHERE id = 'legacy'`, []byte("garbage"))
+		if _, _, err := NewDBPostRepository(testDB).GetPosts(context.Background()); err == nil || !strings.Contains(err.Error(), "checksum") {
+			t.Errorf("Expected a checksum error, got %v", err)
+		}
+	})
+}
+
+func mustDictCompressor(t *testing.T, path string) *compression.ZstdDictCompressor {
+	t.Helper()
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	compressor, err := compression.NewZstdDictCompressor(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return compressor
+}
+
+func TestDBPostRepositoryPostgres(t *testing.T) {
+	ctx := context.Background()
+	database := db.NewPostgres(config.DatabaseConfig{DSN: pgtest.DSN(t)})
+	if err := database.InitDB(); err != nil {
+		t.Fatalf("Failed to initialize database: %v", err)
+	}
+	defer database.Close()
+
+	repo := NewDBPostRepository(database)
+	writer := NewDBPostRepository(database)
+	notified := notifications(repo)
+	repo.reload(context.Background())
+
+	post := writer.NewPost()
+	post.Title = "Postgres Post"
+	post.Markdown = []byte("# Hello Postgres")
+	post.Owner = model.UserID("test-user")
+	if err := writer.SavePost(ctx, post); err != nil {
+		t.Fatalf("Failed to save post: %v", err)
+	}
+
+	latest, err := repo.GetLatestModifiedTime(ctx)
+	if err != nil || latest == nil || !latest.Equal(post.ModifiedDate.Truncate(time.Microsecond)) {
+		t.Errorf("Expected latest modification %v, got %v (%v)", post.ModifiedDate, latest, err)
+	}
+
+	repo.reload(context.Background())
+	cached, err := repo.ReadPost(string(post.ID))
+	if err != nil || string(cached.Markdown) != "# Hello Postgres" {
+		t.Fatalf("Expected the saved post to be read back, got %+v (%v)", cached, err)
+	}
+	expectNotifications(t, notified, postNotification{post.ID, model.PostCreated})
+
+	post.Markdown = []byte("# Hello again")
+	if err := writer.SetPostContent(ctx, post); err != nil {
+		t.Fatalf("Failed to update post: %v", err)
+	}
+	repo.reload(context.Background())
+	expectNotifications(t, notified, postNotification{post.ID, model.PostUpdated})
+
+	if _, err := database.Exec("DELETE FROM posts WHERE id = ?", post.ID); err != nil {
+		t.Fatalf("Failed to delete post: %v", err)
+	}
+	repo.reload(context.Background())
+	if _, err := repo.ReadPost(string(post.ID)); err == nil {
+		t.Error("Expected the deleted post to be removed from the cache")
+	}
+	expectNotifications(t, notified, postNotification{post.ID, model.PostDeleted})
+}
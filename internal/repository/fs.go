@@ -1,165 +1,368 @@
 package repository
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"maps"
 	"os"
 	"path/filepath"
 	"slices"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
-	"github.com/debemdeboas/the-archive/internal/cache"
 	"github.com/debemdeboas/the-archive/internal/model"
 	"github.com/debemdeboas/the-archive/internal/util"
 	"github.com/mmarkdown/mmark/v2/mast"
 )
 
+// FSPostRepository serves posts from the Markdown files of a directory. Files
+// are written atomically and changes made by other programs are picked up
+// as soon as they happen on Linux, or on every reload timeout elsewhere.
+//
+// The owner and creation date of posts saved through the repository are kept
+// in a hidden metadata file next to the post. Files written by other programs
+// have none, so they have no owner and are created when last modified.
 type FSPostRepository struct { // implements PostRepository
 	postsPath string
 
-	postsCache       *cache.Cache[string, *model.Post]
-	postsCacheSorted []model.Post
+	// debounce is how long to wait for more changes before reloading, so a
+	// burst of writes to the same file results in a single reload.
+	debounce time.Duration
+
+	writeMu  sync.Mutex
+	reloadMu sync.Mutex
+
+	mu    sync.RWMutex
+	files map[string]model.Post // keyed by file name without extension
+	cache postCache
 
 	reloadTimeout  time.Duration
-	reloadNotifier func(model.PostID)
+	reloadNotifier func(model.PostID, model.PostEvent)
+	loop           reloadLoop
 }
 
 func NewFSPostRepository(postsPath string) *FSPostRepository {
 	return &FSPostRepository{
-		postsPath:  postsPath,
-		postsCache: cache.NewCache[string, *model.Post](),
+		postsPath: postsPath,
+		debounce:  100 * time.Millisecond,
+		files:     make(map[string]model.Post),
+
+		reloadTimeout: 10 * time.Second,
 	}
 }
 
-func (r *FSPostRepository) SetReloadNotifier(notifier func(model.PostID)) {
+func (r *FSPostRepository) SetReloadNotifier(notifier func(model.PostID, model.PostEvent)) {
 	r.reloadNotifier = notifier
 }
 
-func (r *FSPostRepository) notifyPostReload(postID model.PostID) {
+func (r *FSPostRepository) notifyPostReload(postID model.PostID, event model.PostEvent) {
 	if r.reloadNotifier != nil {
-		r.reloadNotifier(postID)
+		r.reloadNotifier(postID, event)
 	}
 }
 
-func (r *FSPostRepository) Init() {
-	posts, postMap, err := r.GetPosts()
-	if err != nil {
-		repoLogger.Fatal().Err(err).Msg("Error initializing posts")
+func (r *FSPostRepository) Start(ctx context.Context) error {
+	if err := os.MkdirAll(r.postsPath, 0755); err != nil {
+		return fmt.Errorf("error creating posts directory: %w", err)
+	}
+	if err := r.reload(nil); err != nil {
+		return fmt.Errorf("error initializing posts: %w", err)
 	}
 
-	r.postsCacheSorted = posts
-	r.postsCache.SetTo(postMap)
+	r.loop.start(ctx, r.watch)
+	return nil
+}
 
-	go r.ReloadPosts()
+func (r *FSPostRepository) Stop() {
+	r.loop.stop()
 }
 
 func (r *FSPostRepository) GetPostList() []model.Post {
-	return r.postsCacheSorted
+	return r.cache.load().list()
 }
 
-func (r *FSPostRepository) GetPosts() ([]model.Post, map[string]*model.Post, error) {
-	entries, err := os.ReadDir(r.postsPath)
+// isPostFile reports whether name is a post, skipping hidden and temporary files.
+func isPostFile(name string) bool {
+	return strings.HasSuffix(name, ".md") && !strings.HasPrefix(name, ".")
+}
+
+// fsPostMeta is the data of a post that is not part of its Markdown.
+type fsPostMeta struct {
+	Owner   model.UserID `json:"owner"`
+	Created time.Time    `json:"created"`
+}
+
+// metaPath returns the path of the hidden metadata file of the post name.
+func (r *FSPostRepository) metaPath(name string) string {
+	return filepath.Join(r.postsPath, "."+name+".meta")
+}
+
+// readMeta reads the metadata of the post name. Posts without metadata
+// return false.
+func (r *FSPostRepository) readMeta(name string) (fsPostMeta, bool) {
+	var meta fsPostMeta
+	data, err := os.ReadFile(r.metaPath(name))
 	if err != nil {
-		return nil, nil, err
+		if !errors.Is(err, fs.ErrNotExist) {
+			repoLogger.Warn().Err(err).Str("file", name+".md").Msg("Error reading post metadata")
+		}
+		return meta, false
 	}
+	if err := json.Unmarshal(data, &meta); err != nil {
+		repoLogger.Warn().Err(err).Str("file", name+".md").Msg("Invalid post metadata")
+		return meta, false
+	}
+	return meta, true
+}
 
-	var posts []model.Post
-	postsMap := make(map[string]*model.Post)
-	for _, entry := range entries {
-		if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".md") {
-			name := strings.TrimSuffix(entry.Name(), ".md")
+// writeMeta atomically replaces the metadata of the post name.
+func (r *FSPostRepository) writeMeta(name string, meta fsPostMeta) error {
+	data, err := json.Marshal(meta)
+	if err != nil {
+		return err
+	}
+	tmp, err := r.writeTemp(name, data)
+	if err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, r.metaPath(name)); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return nil
+}
 
-			mdContent, err := os.ReadFile(filepath.Join(r.postsPath, name+".md"))
-			if err != nil {
-				return nil, nil, err
-			}
+func (r *FSPostRepository) GetPosts(ctx context.Context) ([]model.Post, map[string]*model.Post, error) {
+	files, err := r.readFiles()
+	if err != nil {
+		return nil, nil, err
+	}
+	posts := postsFromFSFiles(files)
+	return posts, postMap(posts), nil
+}
 
-			fileInfo, err := entry.Info()
-			if err != nil {
-				return nil, nil, err
-			}
+func (r *FSPostRepository) readFiles() (map[string]model.Post, error) {
+	entries, err := os.ReadDir(r.postsPath)
+	if err != nil {
+		return nil, err
+	}
 
-			info, err := util.GetFrontMatter(mdContent)
-			if err != nil {
-				info = &util.ExtendedTitleData{
-					TitleData: &mast.TitleData{
-						Title: name,
-					},
-					ToolbarTitle: name,
-				}
-			}
+	files := make(map[string]model.Post)
+	for _, entry := range entries {
+		if entry.IsDir() || !isPostFile(entry.Name()) {
+			continue
+		}
+		name := strings.TrimSuffix(entry.Name(), ".md")
+		post, err := r.readPost(name)
+		if errors.Is(err, fs.ErrNotExist) {
+			// Removed since the directory was listed
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+		files[name] = post
+	}
+	return files, nil
+}
 
-			post := model.Post{
-				ID:            model.PostID(util.ContentHashString(name)),
-				Title:         name,
-				Markdown:      mdContent,
-				MDContentHash: util.ContentHash(mdContent),
-				ModifiedDate:  fileInfo.ModTime(),
-				Info:          info,
-			}
+func (r *FSPostRepository) readPost(name string) (model.Post, error) {
+	path := filepath.Join(r.postsPath, name+".md")
+	mdContent, err := os.ReadFile(path)
+	if err != nil {
+		return model.Post{}, err
+	}
+
+	fileInfo, err := os.Stat(path)
+	if err != nil {
+		return model.Post{}, err
+	}
 
-			posts = append(posts, post)
-			postsMap[string(post.ID)] = &post
+	info, err := util.GetFrontMatter(mdContent)
+	if err != nil {
+		info = &util.ExtendedTitleData{
+			TitleData: &mast.TitleData{
+				Title: name,
+			},
+			ToolbarTitle: name,
 		}
 	}
 
+	post := model.Post{
+		ID:            model.PostID(util.ContentHashString(name)),
+		Title:         name,
+		Path:          name,
+		Markdown:      mdContent,
+		MDContentHash: util.MarkdownHash(mdContent),
+		CreatedDate:   fileInfo.ModTime(),
+		ModifiedDate:  fileInfo.ModTime(),
+		Info:          info,
+	}
+	if info.Title != "" {
+		post.Title = info.Title
+	}
+	if meta, ok := r.readMeta(name); ok {
+		post.Owner = meta.Owner
+		post.CreatedDate = meta.Created
+	}
+	return post, nil
+}
+
+func postsFromFSFiles(files map[string]model.Post) []model.Post {
+	posts := slices.Collect(maps.Values(files))
 	slices.SortStableFunc(posts, func(a, b model.Post) int {
-		return -a.ModifiedDate.Compare(b.ModifiedDate)
+		if c := -a.ModifiedDate.Compare(b.ModifiedDate); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Path, b.Path)
 	})
-
-	return posts, postsMap, nil
+	return posts
 }
 
 func (r *FSPostRepository) ReadPost(id any) (*model.Post, error) {
-	if post, ok := r.postsCache.Get(id.(string)); ok && post.Markdown != nil {
+	if post, ok := r.cache.load().get(id.(string)); ok && post.Markdown != nil {
 		return post, nil
 	}
 	return nil, os.ErrNotExist
 }
 
 func (r *FSPostRepository) GetAdjacentPosts(id any) (prev *model.Post, next *model.Post) {
-	idStr := id.(string)
-
-	// Find the current post in the sorted list
-	for i, post := range r.postsCacheSorted {
-		if string(post.ID) == idStr {
-			// Get previous post (if exists)
-			if i > 0 {
-				prev = &r.postsCacheSorted[i-1]
-			}
-			// Get next post (if exists)
-			if i < len(r.postsCacheSorted)-1 {
-				next = &r.postsCacheSorted[i+1]
+	return r.cache.load().adjacent(id.(string))
+}
+
+// watch watches the posts directory and reloads changed files until ctx is
+// done. If the directory cannot be watched, it falls back to rereading it
+// periodically.
+func (r *FSPostRepository) watch(ctx context.Context) {
+	w, err := watchDir(r.postsPath)
+	if err != nil {
+		repoLogger.Warn().Err(err).Msg("Cannot watch posts directory, polling for changes instead")
+		poll(ctx, r.reloadTimeout, func(context.Context) {
+			if err := r.reload(nil); err != nil {
+				repoLogger.Error().Err(err).Msg("Error reloading posts")
 			}
-			break
-		}
+		})
+		return
 	}
+	defer w.Close()
 
-	return prev, next
+	r.handleEvents(ctx, w.events)
 }
 
-func (r *FSPostRepository) ReloadPosts() {
+// handleEvents reloads the files named on events once no new events arrived
+// for the debounce interval, until events is closed or ctx is done. An empty
+// name reloads the whole directory.
+func (r *FSPostRepository) handleEvents(ctx context.Context, events <-chan string) {
+	pending := make(map[string]struct{})
+	rescan := false
+
+	timer := time.NewTimer(r.debounce)
+	timer.Stop()
+
 	for {
-		posts, postMap, err := r.GetPosts()
-		if err != nil {
-			repoLogger.Error().Err(err).Msg("Error reloading posts")
-		} else {
-			for _, post := range r.postsCacheSorted {
-				if newPost, ok := postMap[string(post.ID)]; ok {
-					if newPost.MDContentHash != post.MDContentHash {
-						repoLogger.Info().
-							Str("post_id", string(post.ID)).
-							Str("title", post.Title).
-							Msg("Reloading post")
-						go r.notifyPostReload(post.ID)
-					}
-				}
+		select {
+		case <-ctx.Done():
+			return
+		case name, ok := <-events:
+			if !ok {
+				return
+			}
+			if name == "" {
+				rescan = true
+			} else if isPostFile(name) {
+				pending[strings.TrimSuffix(name, ".md")] = struct{}{}
+			} else {
+				continue
+			}
+			timer.Reset(r.debounce)
+		case <-timer.C:
+			var names []string
+			if !rescan {
+				names = slices.Collect(maps.Keys(pending))
 			}
+			if err := r.reload(names); err != nil {
+				repoLogger.Error().Err(err).Msg("Error reloading posts")
+			}
+			clear(pending)
+			rescan = false
+		}
+	}
+}
 
-			r.postsCacheSorted = posts
-			r.postsCache.SetTo(postMap)
+// reload rereads the named files, or the whole directory if names is nil,
+// and notifies about posts that were created, changed or removed. Files that no longer exist
+// are removed, which also covers the old name of a renamed file.
+func (r *FSPostRepository) reload(names []string) error {
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
+
+	r.mu.RLock()
+	previous := r.files
+	r.mu.RUnlock()
+
+	var files map[string]model.Post
+	if names == nil {
+		var err error
+		if files, err = r.readFiles(); err != nil {
+			return err
+		}
+	} else {
+		files = maps.Clone(previous)
+		for _, name := range names {
+			post, err := r.readPost(name)
+			if errors.Is(err, fs.ErrNotExist) {
+				delete(files, name)
+				continue
+			} else if err != nil {
+				return err
+			}
+			files[name] = post
 		}
-		time.Sleep(r.reloadTimeout)
 	}
+
+	r.mu.Lock()
+	r.files = files
+	r.mu.Unlock()
+	if previous := r.cache.store(postsFromFSFiles(files)); previous == nil {
+		// Nothing changed, the posts were just loaded
+		return nil
+	}
+
+	for name, post := range files {
+		old, ok := previous[name]
+		if !ok {
+			repoLogger.Info().
+				Str("post_id", string(post.ID)).
+				Str("title", post.Title).
+				Msg("New post detected")
+			go r.notifyPostReload(post.ID, model.PostCreated)
+		} else if old.MDContentHash != post.MDContentHash {
+			repoLogger.Info().
+				Str("post_id", string(post.ID)).
+				Str("title", post.Title).
+				Msg("Reloading post")
+			go r.notifyPostReload(post.ID, model.PostUpdated)
+		}
+	}
+	for name, post := range previous {
+		if _, ok := files[name]; !ok {
+			// A new file with the same name is not the same post
+			if err := os.Remove(r.metaPath(name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+				repoLogger.Warn().Err(err).Str("file", name+".md").Msg("Error removing post metadata")
+			}
+			repoLogger.Info().
+				Str("post_id", string(post.ID)).
+				Str("title", post.Title).
+				Msg("Post removed")
+			go r.notifyPostReload(post.ID, model.PostDeleted)
+		}
+	}
+
+	return nil
 }
 
 func (r *FSPostRepository) SetReloadTimeout(timeout time.Duration) {
@@ -167,13 +370,114 @@ func (r *FSPostRepository) SetReloadTimeout(timeout time.Duration) {
 }
 
 func (r *FSPostRepository) NewPost() *model.Post {
-	return &model.Post{}
+	now := time.Now().UTC()
+
+	return &model.Post{
+		CreatedDate:  now,
+		ModifiedDate: now,
+	}
 }
 
-func (r *FSPostRepository) SetPostContent(post *model.Post) error {
-	return nil
+// SetPostContent atomically replaces the file of an existing post.
+func (r *FSPostRepository) SetPostContent(ctx context.Context, post *model.Post) error {
+	r.mu.RLock()
+	name := ""
+	for n, p := range r.files {
+		if p.ID == post.ID {
+			name = n
+			break
+		}
+	}
+	r.mu.RUnlock()
+
+	if name == "" {
+		return fmt.Errorf("post not found: %s", post.ID)
+	}
+
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+
+	tmp, err := r.writeTemp(name, post.Markdown)
+	if err != nil {
+		return fmt.Errorf("error saving post: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(r.postsPath, name+".md")); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("error saving post: %w", err)
+	}
+
+	// Reloading the file also notifies about the new content
+	return r.reload([]string{name})
 }
 
-func (r *FSPostRepository) SavePost(post *model.Post) error {
+// SavePost writes a new post to a file named after the slug of its title,
+// adding a numeric suffix if the name is taken. The post ID is derived from
+// the file name, like for every other file in the directory.
+func (r *FSPostRepository) SavePost(ctx context.Context, post *model.Post) error {
+	slug := util.Slugify(post.Title)
+	if slug == "" {
+		slug = "post"
+	}
+
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+
+	tmp, err := r.writeTemp(slug, post.Markdown)
+	if err != nil {
+		return fmt.Errorf("error saving post: %w", err)
+	}
+	defer os.Remove(tmp)
+
+	// Linking fails if the target exists, so an existing post is never replaced
+	name := slug
+	for i := 2; ; i++ {
+		err := os.Link(tmp, filepath.Join(r.postsPath, name+".md"))
+		if err == nil {
+			break
+		} else if !errors.Is(err, fs.ErrExist) {
+			return fmt.Errorf("error saving post: %w", err)
+		}
+		name = slug + "-" + strconv.Itoa(i)
+	}
+
+	if err := r.writeMeta(name, fsPostMeta{Owner: post.Owner, Created: post.CreatedDate}); err != nil {
+		os.Remove(filepath.Join(r.postsPath, name+".md"))
+		return fmt.Errorf("error saving post: %w", err)
+	}
+
+	if err := r.reload([]string{name}); err != nil {
+		return err
+	}
+
+	r.mu.RLock()
+	*post = r.files[name]
+	r.mu.RUnlock()
+
+	repoLogger.Info().Str("post_id", string(post.ID)).Str("file", name+".md").Msg("Post saved")
 	return nil
 }
+
+// writeTemp writes content to a hidden temporary file in the posts directory
+// and returns its path. The caller renames or links it into place.
+func (r *FSPostRepository) writeTemp(name string, content []byte) (string, error) {
+	f, err := os.CreateTemp(r.postsPath, "."+name+".*.tmp")
+	if err != nil {
+		return "", err
+	}
+
+	_, err = f.Write(content)
+	if err == nil {
+		err = f.Sync()
+	}
+	if err == nil {
+		err = f.Chmod(0644)
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
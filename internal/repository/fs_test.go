@@ -0,0 +1,227 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/debemdeboas/the-archive/internal/model"
+	"github.com/debemdeboas/the-archive/internal/util"
+)
+
+func newTestFSPostRepository(t *testing.T, files map[string]string) (*FSPostRepository, string) {
+	t.Helper()
+	dir := t.TempDir()
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	repo := NewFSPostRepository(dir)
+	if err := repo.reload(nil); err != nil {
+		t.Fatalf("Failed to load posts: %v", err)
+	}
+	return repo, dir
+}
+
+func TestFSPostRepositoryWriteBack(t *testing.T) {
+	ctx := context.Background()
+	repo, dir := newTestFSPostRepository(t, map[string]string{"existing.md": "# Existing"})
+	notified := notifications(repo)
+
+	post := repo.NewPost()
+	post.Owner = "admin"
+	post.Title = "Hello, World!"
+	post.Markdown = []byte("%%%\ntitle = \"Hello, World!\"\n%%%\n\nHello")
+	if err := repo.SavePost(ctx, post); err != nil {
+		t.Fatalf("SavePost failed: %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, "hello-world.md"))
+	if err != nil || string(data) != string(post.Markdown) {
+		t.Fatalf("Expected post to be written to hello-world.md, got %q (%v)", data, err)
+	}
+	if post.ID != model.PostID(util.ContentHashString("hello-world")) {
+		t.Errorf("Expected the ID to be derived from the file name, got %s", post.ID)
+	}
+	if _, err := repo.ReadPost(string(post.ID)); err != nil {
+		t.Errorf("Expected saved post to be readable immediately: %v", err)
+	}
+	expectNotifications(t, notified, postNotification{post.ID, model.PostCreated})
+
+	t.Run("Names are unique", func(t *testing.T) {
+		other := repo.NewPost()
+		other.Title = "Hello world"
+		other.Markdown = []byte("Another hello")
+		if err := repo.SavePost(ctx, other); err != nil {
+			t.Fatalf("SavePost failed: %v", err)
+		}
+		if other.Path != "hello-world-2" {
+			t.Errorf("Expected a numbered file name, got %q", other.Path)
+		}
+		if data, _ := os.ReadFile(filepath.Join(dir, "hello-world.md")); string(data) != string(post.Markdown) {
+			t.Errorf("Expected the first post to be kept, got %q", data)
+		}
+		expectNotifications(t, notified, postNotification{other.ID, model.PostCreated})
+	})
+
+	t.Run("SetPostContent replaces the file", func(t *testing.T) {
+		post.Markdown = []byte("Updated content")
+		if err := repo.SetPostContent(ctx, post); err != nil {
+			t.Fatalf("SetPostContent failed: %v", err)
+		}
+		if data, _ := os.ReadFile(filepath.Join(dir, "hello-world.md")); string(data) != "Updated content" {
+			t.Errorf("Expected updated file, got %q", data)
+		}
+		cached, _ := repo.ReadPost(string(post.ID))
+		if string(cached.Markdown) != "Updated content" {
+			t.Errorf("Expected cache to be updated, got %q", cached.Markdown)
+		}
+
+		expectNotifications(t, notified, postNotification{post.ID, model.PostUpdated})
+	})
+
+	t.Run("Owner and creation date survive a fresh load", func(t *testing.T) {
+		fresh := NewFSPostRepository(dir)
+		if err := fresh.reload(nil); err != nil {
+			t.Fatalf("Failed to load posts: %v", err)
+		}
+		loaded, err := fresh.ReadPost(string(post.ID))
+		if err != nil {
+			t.Fatalf("ReadPost failed: %v", err)
+		}
+		if loaded.Owner != "admin" || !loaded.CreatedDate.Equal(post.CreatedDate) {
+			t.Errorf("Expected owner admin created %v, got %q created %v", post.CreatedDate, loaded.Owner, loaded.CreatedDate)
+		}
+
+		existing, err := fresh.ReadPost(util.ContentHashString("existing"))
+		if err != nil || existing.Owner != "" || !existing.CreatedDate.Equal(existing.ModifiedDate) {
+			t.Errorf("Expected a file written elsewhere to have no owner, got %+v (%v)", existing, err)
+		}
+	})
+
+	t.Run("No temporary files are left behind", func(t *testing.T) {
+		entries, _ := os.ReadDir(dir)
+		for _, entry := range entries {
+			if name := entry.Name(); !isPostFile(name) && !(strings.HasPrefix(name, ".") && strings.HasSuffix(name, ".meta")) {
+				t.Errorf("Unexpected file %s", entry.Name())
+			}
+		}
+	})
+
+	t.Run("Unknown post", func(t *testing.T) {
+		if err := repo.SetPostContent(ctx, &model.Post{ID: "missing"}); err == nil {
+			t.Error("Expected error updating an unknown post")
+		}
+	})
+
+	t.Run("Removing a post removes its metadata", func(t *testing.T) {
+		os.Remove(filepath.Join(dir, "hello-world.md"))
+		if err := repo.reload([]string{"hello-world"}); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := os.Stat(repo.metaPath("hello-world")); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("Expected the metadata to be removed, got %v", err)
+		}
+		expectNotifications(t, notified, postNotification{post.ID, model.PostDeleted})
+	})
+}
+
+func TestFSPostRepositoryWatch(t *testing.T) {
+	repo, dir := newTestFSPostRepository(t, map[string]string{
+		"a.md": "# A",
+		"b.md": "# B",
+	})
+	repo.debounce = 20 * time.Millisecond
+
+	w, err := watchDir(dir)
+	if errors.Is(err, errors.ErrUnsupported) {
+		t.Skip("Watching directories is not supported on this platform")
+	} else if err != nil {
+		t.Fatalf("Failed to watch directory: %v", err)
+	}
+	t.Cleanup(func() { w.Close() })
+	go repo.handleEvents(context.Background(), w.events)
+
+	notified := notifications(repo)
+
+	idA := util.ContentHashString("a")
+	idC := util.ContentHashString("c")
+	waitFor := func(t *testing.T, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatal("Timed out waiting for the change to be picked up")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+
+	t.Run("Bursts of writes are debounced", func(t *testing.T) {
+		for _, content := range []string{"# A1", "# A2", "# A3"} {
+			if err := os.WriteFile(filepath.Join(dir, "a.md"), []byte(content), 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		expectNotifications(t, notified, postNotification{model.PostID(idA), model.PostUpdated})
+
+		if post, _ := repo.ReadPost(idA); post == nil || string(post.Markdown) != "# A3" {
+			t.Errorf("Expected the last write, got %+v", post)
+		}
+	})
+
+	t.Run("New files are added", func(t *testing.T) {
+		os.WriteFile(filepath.Join(dir, "c.md"), []byte("# C"), 0644)
+		os.WriteFile(filepath.Join(dir, "notes.txt"), []byte("not a post"), 0644)
+		waitFor(t, func() bool { return len(repo.GetPostList()) == 3 })
+		expectNotifications(t, notified, postNotification{model.PostID(idC), model.PostCreated})
+	})
+
+	t.Run("Renames replace the old post", func(t *testing.T) {
+		if err := os.Rename(filepath.Join(dir, "b.md"), filepath.Join(dir, "renamed.md")); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, func() bool {
+			_, err := repo.ReadPost(util.ContentHashString("renamed"))
+			return err == nil
+		})
+		if _, err := repo.ReadPost(util.ContentHashString("b")); err == nil {
+			t.Error("Expected the old name to be gone")
+		}
+		if n := len(repo.GetPostList()); n != 3 {
+			t.Errorf("Expected 3 posts, got %d", n)
+		}
+		expectNotifications(t, notified,
+			postNotification{model.PostID(util.ContentHashString("renamed")), model.PostCreated},
+			postNotification{model.PostID(util.ContentHashString("b")), model.PostDeleted},
+		)
+	})
+
+	t.Run("Editors saving through a rename are picked up", func(t *testing.T) {
+		tmp := filepath.Join(dir, ".a.md.swp")
+		os.WriteFile(tmp, []byte("# A, saved atomically"), 0644)
+		if err := os.Rename(tmp, filepath.Join(dir, "a.md")); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, func() bool {
+			post, _ := repo.ReadPost(idA)
+			return post != nil && string(post.Markdown) == "# A, saved atomically"
+		})
+		expectNotifications(t, notified, postNotification{model.PostID(idA), model.PostUpdated})
+	})
+
+	t.Run("Deleted files are removed", func(t *testing.T) {
+		if err := os.Remove(filepath.Join(dir, "c.md")); err != nil {
+			t.Fatal(err)
+		}
+		waitFor(t, func() bool { return len(repo.GetPostList()) == 2 })
+		expectNotifications(t, notified, postNotification{model.PostID(idC), model.PostDeleted})
+	})
+}
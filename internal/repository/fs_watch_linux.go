@@ -0,0 +1,100 @@
+//go:build linux
+
+package repository
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const fsWatchMask = unix.IN_CREATE | unix.IN_CLOSE_WRITE | unix.IN_DELETE |
+	unix.IN_MOVED_FROM | unix.IN_MOVED_TO | unix.IN_DELETE_SELF | unix.IN_MOVE_SELF
+
+// fsWatcher reports changes to the files of a single directory using inotify.
+type fsWatcher struct {
+	file   *os.File
+	events chan string
+	done   chan struct{}
+}
+
+// watchDir starts watching dir. The names of changed files are sent on the
+// events channel; an empty name means that events were lost and the whole
+// directory must be read again. The channel is closed when the watcher stops.
+func watchDir(dir string) (*fsWatcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, fmt.Errorf("inotify_init1: %w", err)
+	}
+	if _, err := unix.InotifyAddWatch(fd, dir, fsWatchMask); err != nil {
+		unix.Close(fd)
+		return nil, fmt.Errorf("inotify_add_watch %s: %w", dir, err)
+	}
+
+	// The descriptor is non-blocking, so reads go through the runtime poller
+	// and Close unblocks a pending read.
+	w := &fsWatcher{
+		file:   os.NewFile(uintptr(fd), "inotify"),
+		events: make(chan string, 64),
+		done:   make(chan struct{}),
+	}
+	go w.readEvents()
+	return w, nil
+}
+
+// Close stops the watcher and waits for it to close the events channel.
+func (w *fsWatcher) Close() error {
+	close(w.done)
+	err := w.file.Close()
+	for range w.events {
+	}
+	return err
+}
+
+// send sends name on the events channel, unless the watcher is closed first.
+func (w *fsWatcher) send(name string) bool {
+	select {
+	case w.events <- name:
+		return true
+	case <-w.done:
+		return false
+	}
+}
+
+func (w *fsWatcher) readEvents() {
+	defer close(w.events)
+
+	buf := make([]byte, 64*(unix.SizeofInotifyEvent+unix.NAME_MAX+1))
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			return
+		}
+
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameStart := offset + unix.SizeofInotifyEvent
+			offset = nameStart + int(event.Len)
+
+			switch {
+			case event.Mask&unix.IN_Q_OVERFLOW != 0:
+				if !w.send("") {
+					return
+				}
+			case event.Mask&(unix.IN_DELETE_SELF|unix.IN_MOVE_SELF|unix.IN_IGNORED) != 0:
+				// The directory itself is gone, there is nothing left to watch
+				repoLogger.Error().Msg("Posts directory was removed or moved, no longer watching for changes")
+				w.send("")
+				return
+			case event.Len > 0:
+				name := buf[nameStart : nameStart+int(event.Len)]
+				if !w.send(string(bytes.TrimRight(name, "\x00"))) {
+					return
+				}
+			}
+		}
+	}
+}
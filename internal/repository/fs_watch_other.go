@@ -0,0 +1,18 @@
+//go:build !linux
+
+package repository
+
+import "errors"
+
+type fsWatcher struct {
+	events chan string
+}
+
+// watchDir is only implemented on Linux. Other platforms fall back to polling.
+func watchDir(dir string) (*fsWatcher, error) {
+	return nil, errors.ErrUnsupported
+}
+
+func (w *fsWatcher) Close() error {
+	return nil
+}
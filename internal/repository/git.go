@@ -0,0 +1,697 @@
+package repository
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/debemdeboas/the-archive/internal/config"
+	"github.com/debemdeboas/the-archive/internal/model"
+	"github.com/debemdeboas/the-archive/internal/util"
+	"github.com/google/uuid"
+	"github.com/mmarkdown/mmark/v2/mast"
+)
+
+// Identity used as the committer of commits created by the repository. The
+// author is the user who saved the post.
+const (
+	gitCommitterName  = "The Archive"
+	gitCommitterEmail = "the-archive@localhost"
+)
+
+var errDirtyWorktree = errors.New("working tree has uncommitted changes, not updating it")
+
+type gitFile struct {
+	blob string
+	post model.Post
+}
+
+// GitPostRepository serves posts from Markdown files committed to a branch of
+// a local git repository. Posts are read from the branch rather than the
+// working tree, and every save is a commit on that branch. It shells out to
+// the git executable.
+type GitPostRepository struct { // implements PostRepository
+	repoPath    string
+	branch      string
+	postsDir    string
+	emailDomain string
+
+	// worktree is set when the repository has a working tree that needs to be
+	// kept in sync with commits made through the repository.
+	worktree bool
+
+	writeMu sync.Mutex
+
+	mu    sync.RWMutex
+	head  string
+	files map[string]gitFile // keyed by path relative to the repository root
+	cache postCache
+
+	reloadTimeout  time.Duration
+	reloadNotifier func(model.PostID, model.PostEvent)
+	loop           reloadLoop
+}
+
+func NewGitPostRepository(cfg config.GitConfig) *GitPostRepository {
+	return &GitPostRepository{
+		repoPath:    cfg.Path,
+		branch:      cfg.Branch,
+		postsDir:    strings.Trim(path.Clean("/"+cfg.PostsDir), "/"),
+		emailDomain: cfg.AuthorEmailDomain,
+
+		files: make(map[string]gitFile),
+
+		reloadTimeout: 10 * time.Second,
+	}
+}
+
+func (r *GitPostRepository) SetReloadNotifier(notifier func(model.PostID, model.PostEvent)) {
+	r.reloadNotifier = notifier
+}
+
+func (r *GitPostRepository) notifyPostReload(postID model.PostID, event model.PostEvent) {
+	if r.reloadNotifier != nil {
+		r.reloadNotifier(postID, event)
+	}
+}
+
+func (r *GitPostRepository) SetReloadTimeout(timeout time.Duration) {
+	r.reloadTimeout = timeout
+}
+
+func (r *GitPostRepository) Start(ctx context.Context) error {
+	if err := r.open(); err != nil {
+		return fmt.Errorf("error opening git repository: %w", err)
+	}
+	r.reload(ctx)
+
+	r.loop.start(ctx, func(ctx context.Context) {
+		poll(ctx, r.reloadTimeout, r.reload)
+	})
+	return nil
+}
+
+func (r *GitPostRepository) Stop() {
+	r.loop.stop()
+}
+
+// open creates the repository if it does not exist yet.
+func (r *GitPostRepository) open() error {
+	if _, err := os.Stat(r.repoPath); errors.Is(err, os.ErrNotExist) {
+		if err := os.MkdirAll(r.repoPath, 0755); err != nil {
+			return fmt.Errorf("error creating git repository: %w", err)
+		}
+		if _, err := r.git(nil, nil, "init", "-q", "-b", r.branch); err != nil {
+			return err
+		}
+		repoLogger.Info().Str("path", r.repoPath).Msg("Created git repository for posts")
+	}
+
+	out, err := r.git(nil, nil, "rev-parse", "--is-inside-work-tree")
+	if err != nil {
+		return err
+	}
+	r.worktree = strings.TrimSpace(string(out)) == "true"
+	return nil
+}
+
+// git runs a git command in the repository and returns its standard output.
+func (r *GitPostRepository) git(env []string, stdin []byte, args ...string) ([]byte, error) {
+	return r.gitContext(context.Background(), env, stdin, args...)
+}
+
+// gitContext is like git, but kills the command when ctx is done.
+func (r *GitPostRepository) gitContext(ctx context.Context, env []string, stdin []byte, args ...string) ([]byte, error) {
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-c", "core.quotepath=off"}, args...)...)
+	cmd.Dir = r.repoPath
+	cmd.Env = append(os.Environ(), env...)
+	if stdin != nil {
+		cmd.Stdin = bytes.NewReader(stdin)
+	}
+
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("git %s: %w: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+// resolveHead returns the commit the branch points to, or "" if it has no commits yet.
+func (r *GitPostRepository) resolveHead(ctx context.Context) (string, error) {
+	out, err := r.gitContext(ctx, nil, nil, "rev-parse", "--verify", "-q", "refs/heads/"+r.branch+"^{commit}")
+	if err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+			return "", nil
+		}
+		return "", err
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// listBlobs returns the blob of every Markdown file under the posts directory at commit.
+func (r *GitPostRepository) listBlobs(ctx context.Context, commit string) (map[string]string, error) {
+	args := []string{"ls-tree", "-r", "-z", commit}
+	if r.postsDir != "" {
+		args = append(args, "--", r.postsDir+"/")
+	}
+	out, err := r.gitContext(ctx, nil, nil, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	blobs := make(map[string]string)
+	for _, entry := range strings.Split(string(out), "\x00") {
+		// <mode> SP <type> SP <object> TAB <file>
+		meta, file, ok := strings.Cut(entry, "\t")
+		if !ok || !strings.HasSuffix(file, ".md") {
+			continue
+		}
+		fields := strings.Fields(meta)
+		if len(fields) != 3 || fields[1] != "blob" {
+			continue
+		}
+		blobs[file] = fields[2]
+	}
+	return blobs, nil
+}
+
+// readBlobs reads the content of several blobs with a single git process.
+func (r *GitPostRepository) readBlobs(ctx context.Context, ids []string) (map[string][]byte, error) {
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	out, err := r.gitContext(ctx, nil, []byte(strings.Join(ids, "\n")+"\n"), "cat-file", "--batch")
+	if err != nil {
+		return nil, err
+	}
+
+	contents := make(map[string][]byte, len(ids))
+	for len(out) > 0 {
+		// <object> SP <type> SP <size> LF <contents> LF
+		header, rest, ok := bytes.Cut(out, []byte("\n"))
+		if !ok {
+			return nil, errors.New("git cat-file: truncated output")
+		}
+		fields := strings.Fields(string(header))
+		if len(fields) != 3 {
+			return nil, fmt.Errorf("git cat-file: unexpected header %q", header)
+		}
+		size, err := strconv.Atoi(fields[2])
+		if err != nil || size+1 > len(rest) {
+			return nil, fmt.Errorf("git cat-file: unexpected header %q", header)
+		}
+		contents[fields[0]] = rest[:size]
+		out = rest[size+1:]
+	}
+	return contents, nil
+}
+
+type gitHistory struct {
+	created time.Time
+	updated time.Time
+	author  string
+}
+
+// readHistory returns when each file under the posts directory was first and
+// last changed, and who first committed it.
+func (r *GitPostRepository) readHistory(ctx context.Context, commit string) (map[string]*gitHistory, error) {
+	args := []string{"log", "--format=%x01%at %an", "--name-only", "--no-renames", "-z", commit}
+	if r.postsDir != "" {
+		args = append(args, "--", r.postsDir+"/")
+	}
+	out, err := r.gitContext(ctx, nil, nil, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	history := make(map[string]*gitHistory)
+	var date time.Time
+	var author string
+	afterHeader := false
+	// Commits are listed newest first, as a NUL-terminated header followed by
+	// a newline and the NUL-terminated, unquoted names of the files changed
+	for _, field := range strings.Split(string(out), "\x00") {
+		if header, ok := strings.CutPrefix(field, "\x01"); ok {
+			ts, name, _ := strings.Cut(header, " ")
+			secs, err := strconv.ParseInt(ts, 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("git log: unexpected header %q", header)
+			}
+			date, author = time.Unix(secs, 0).UTC(), name
+			afterHeader = true
+			continue
+		}
+		file := field
+		if afterHeader {
+			file = strings.TrimPrefix(file, "\n")
+			afterHeader = false
+		}
+		if file == "" {
+			continue
+		}
+		h, ok := history[file]
+		if !ok {
+			h = &gitHistory{updated: date}
+			history[file] = h
+		}
+		h.created, h.author = date, author
+	}
+	return history, nil
+}
+
+var gitPostIDRegex = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// gitPostID derives a post ID from a file name. Names that are safe to use in
+// URLs are used as-is so that new posts keep the ID they were created with.
+func gitPostID(name string) model.PostID {
+	if gitPostIDRegex.MatchString(name) {
+		return model.PostID(name)
+	}
+	return model.PostID(util.ContentHashString(name))
+}
+
+// postName returns the name of a post file relative to the posts directory, without extension.
+func (r *GitPostRepository) postName(file string) string {
+	return strings.TrimSuffix(strings.TrimPrefix(file, r.postsDir+"/"), ".md")
+}
+
+func (r *GitPostRepository) newPostFromFile(file string, content []byte, h *gitHistory) model.Post {
+	name := r.postName(file)
+
+	info, err := util.GetFrontMatter(content)
+	if err != nil {
+		info = &util.ExtendedTitleData{
+			TitleData: &mast.TitleData{
+				Title: name,
+			},
+			ToolbarTitle: name,
+		}
+	}
+
+	post := model.Post{
+		ID:            gitPostID(name),
+		Title:         name,
+		Path:          name,
+		Markdown:      content,
+		MDContentHash: util.MarkdownHash(content),
+		Info:          info,
+	}
+	if info.Title != "" {
+		post.Title = info.Title
+	}
+	if h != nil {
+		post.CreatedDate = h.created
+		post.ModifiedDate = h.updated
+		post.Owner = model.UserID(h.author)
+	}
+	return post
+}
+
+// GetPosts reads the posts at the tip of the branch. Only files whose blob
+// changed since the last reload are read again. The posts are not stored, so
+// the next reload still notifies about the changes.
+func (r *GitPostRepository) GetPosts(ctx context.Context) ([]model.Post, map[string]*model.Post, error) {
+	_, files, err := r.readFiles(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	posts := postsFromGitFiles(files)
+	return posts, postMap(posts), nil
+}
+
+func (r *GitPostRepository) readFiles(ctx context.Context) (string, map[string]gitFile, error) {
+	head, err := r.resolveHead(ctx)
+	if err != nil || head == "" {
+		return "", make(map[string]gitFile), err
+	}
+
+	blobs, err := r.listBlobs(ctx, head)
+	if err != nil {
+		return "", nil, err
+	}
+	history, err := r.readHistory(ctx, head)
+	if err != nil {
+		return "", nil, err
+	}
+
+	r.mu.RLock()
+	cached := r.files
+	r.mu.RUnlock()
+
+	var toRead []string
+	for file, blob := range blobs {
+		if c, ok := cached[file]; !ok || c.blob != blob {
+			toRead = append(toRead, blob)
+		}
+	}
+	contents, err := r.readBlobs(ctx, toRead)
+	if err != nil {
+		return "", nil, err
+	}
+
+	files := make(map[string]gitFile, len(blobs))
+	for file, blob := range blobs {
+		content, ok := contents[blob]
+		if !ok {
+			content = cached[file].post.Markdown
+		}
+		files[file] = gitFile{blob: blob, post: r.newPostFromFile(file, content, history[file])}
+	}
+	return head, files, nil
+}
+
+func postsFromGitFiles(files map[string]gitFile) []model.Post {
+	posts := make([]model.Post, 0, len(files))
+	for _, f := range files {
+		posts = append(posts, f.post)
+	}
+
+	slices.SortStableFunc(posts, func(a, b model.Post) int {
+		if c := -a.ModifiedDate.Compare(b.ModifiedDate); c != 0 {
+			return c
+		}
+		return strings.Compare(string(a.ID), string(b.ID))
+	})
+	return posts
+}
+
+func (r *GitPostRepository) GetPostList() []model.Post {
+	return r.cache.load().list()
+}
+
+func (r *GitPostRepository) ReadPost(id any) (*model.Post, error) {
+	post, ok := r.cache.load().get(id.(string))
+	if !ok {
+		return nil, fmt.Errorf("post not found: %s", id)
+	}
+	return post, nil
+}
+
+func (r *GitPostRepository) GetAdjacentPosts(id any) (prev *model.Post, next *model.Post) {
+	return r.cache.load().adjacent(id.(string))
+}
+
+// reload reads the branch if it moved and notifies about posts that were
+// created, changed or removed.
+func (r *GitPostRepository) reload(ctx context.Context) {
+	head, err := r.resolveHead(ctx)
+	if err != nil {
+		repoLogger.Error().Err(err).Msg("Error checking git branch")
+		return
+	}
+
+	r.mu.RLock()
+	unchanged := head == r.head && r.cache.load() != nil
+	previous := r.files
+	r.mu.RUnlock()
+
+	if unchanged {
+		repoLogger.Debug().Str("head", head).Msg("No new commits, skipping reload")
+		return
+	}
+
+	head, current, err := r.readFiles(ctx)
+	if err != nil {
+		repoLogger.Error().Err(err).Msg("Error reloading posts")
+		return
+	}
+
+	r.mu.Lock()
+	r.head = head
+	r.files = current
+	r.mu.Unlock()
+	if previous := r.cache.store(postsFromGitFiles(current)); previous == nil {
+		// Nothing changed, the posts were just loaded
+		return
+	}
+
+	for file, f := range current {
+		old, ok := previous[file]
+		if !ok {
+			repoLogger.Info().
+				Str("post_id", string(f.post.ID)).
+				Str("title", f.post.Title).
+				Msg("New post detected")
+			go r.notifyPostReload(f.post.ID, model.PostCreated)
+			continue
+		}
+		if old.blob != f.blob {
+			repoLogger.Info().
+				Str("post_id", string(f.post.ID)).
+				Str("title", f.post.Title).
+				Msg("Reloading post")
+			go r.notifyPostReload(f.post.ID, model.PostUpdated)
+		}
+	}
+	for file, f := range previous {
+		if _, ok := current[file]; !ok {
+			repoLogger.Info().
+				Str("post_id", string(f.post.ID)).
+				Str("title", f.post.Title).
+				Msg("Post removed")
+			go r.notifyPostReload(f.post.ID, model.PostDeleted)
+		}
+	}
+}
+
+func (r *GitPostRepository) NewPost() *model.Post {
+	now := time.Now().UTC()
+
+	return &model.Post{
+		ID: model.PostID(uuid.New().String()),
+
+		CreatedDate:  now,
+		ModifiedDate: now,
+	}
+}
+
+// SavePost commits a new post to the branch, authored by the post's owner.
+func (r *GitPostRepository) SavePost(ctx context.Context, post *model.Post) error {
+	file := path.Join(r.postsDir, string(post.ID)+".md")
+	if gitPostID(string(post.ID)) != post.ID {
+		return fmt.Errorf("invalid post ID for a git repository: %s", post.ID)
+	}
+	if err := r.commitPost(ctx, file, "", post, "Create "+post.Title); err != nil {
+		return err
+	}
+
+	go r.notifyPostReload(post.ID, model.PostCreated)
+	return nil
+}
+
+// SetPostContent commits new content for an existing post, authored by the
+// post's owner. It fails with ErrPostConflict if the file changed on the
+// branch since it was last read.
+func (r *GitPostRepository) SetPostContent(ctx context.Context, post *model.Post) error {
+	r.mu.RLock()
+	file, blob := "", ""
+	for f, gf := range r.files {
+		if gf.post.ID == post.ID {
+			file, blob = f, gf.blob
+			break
+		}
+	}
+	r.mu.RUnlock()
+
+	if file == "" {
+		return fmt.Errorf("post not found: %s", post.ID)
+	}
+
+	if err := r.commitPost(ctx, file, blob, post, "Update "+post.Title); err != nil {
+		return err
+	}
+
+	go r.notifyPostReload(post.ID, model.PostUpdated)
+	return nil
+}
+
+// commitPost writes the post to file in a new commit on the branch without
+// touching the index of the working tree. expectedBlob is the blob the file
+// must currently have, or "" if it must not exist.
+func (r *GitPostRepository) commitPost(ctx context.Context, file, expectedBlob string, post *model.Post, message string) error {
+	r.writeMu.Lock()
+	defer r.writeMu.Unlock()
+
+	out, err := r.gitContext(ctx, nil, post.Markdown, "hash-object", "-w", "--stdin")
+	if err != nil {
+		return fmt.Errorf("error saving post: %w", err)
+	}
+	blob := strings.TrimSpace(string(out))
+
+	indexDir, err := os.MkdirTemp("", "archive-git-index-")
+	if err != nil {
+		return fmt.Errorf("error saving post: %w", err)
+	}
+	defer os.RemoveAll(indexDir)
+	indexEnv := []string{"GIT_INDEX_FILE=" + path.Join(indexDir, "index")}
+
+	author := string(post.Owner)
+	if author == "" {
+		author = gitCommitterName
+	}
+	commitEnv := append(indexEnv,
+		"GIT_AUTHOR_NAME="+author,
+		"GIT_AUTHOR_EMAIL="+author+"@"+r.emailDomain,
+		"GIT_COMMITTER_NAME="+gitCommitterName,
+		"GIT_COMMITTER_EMAIL="+gitCommitterEmail,
+	)
+
+	// Other processes may commit to the branch at the same time. Retry when
+	// the branch moved, as long as the post itself was not changed.
+	var head, commit string
+	for attempt := 0; ; attempt++ {
+		head, err = r.resolveHead(ctx)
+		if err != nil {
+			return fmt.Errorf("error saving post: %w", err)
+		}
+
+		currentBlob := ""
+		if head != "" {
+			if out, err := r.gitContext(ctx, nil, nil, "rev-parse", "-q", "--verify", head+":"+file); err == nil {
+				currentBlob = strings.TrimSpace(string(out))
+			}
+		}
+		if currentBlob != expectedBlob {
+			return fmt.Errorf("error saving post %s: %w", post.ID, ErrPostConflict)
+		}
+
+		readTree := []string{"read-tree", "--empty"}
+		if head != "" {
+			readTree = []string{"read-tree", head}
+		}
+		if _, err := r.gitContext(ctx, indexEnv, nil, readTree...); err != nil {
+			return fmt.Errorf("error saving post: %w", err)
+		}
+		if _, err := r.gitContext(ctx, indexEnv, nil, "update-index", "--add", "--cacheinfo", "100644,"+blob+","+file); err != nil {
+			return fmt.Errorf("error saving post: %w", err)
+		}
+		out, err := r.gitContext(ctx, indexEnv, nil, "write-tree")
+		if err != nil {
+			return fmt.Errorf("error saving post: %w", err)
+		}
+
+		commitTree := []string{"commit-tree", strings.TrimSpace(string(out)), "-m", message}
+		if head != "" {
+			commitTree = append(commitTree, "-p", head)
+		}
+		out, err = r.gitContext(ctx, commitEnv, nil, commitTree...)
+		if err != nil {
+			return fmt.Errorf("error saving post: %w", err)
+		}
+		commit = strings.TrimSpace(string(out))
+
+		// Only move the branch if nobody else did in the meantime
+		_, err = r.gitContext(ctx, nil, nil, "update-ref", "refs/heads/"+r.branch, commit, head)
+		if err == nil {
+			break
+		}
+		if attempt == 2 {
+			return fmt.Errorf("error saving post: %w", err)
+		}
+	}
+
+	if err := r.syncWorktree(file, head); err != nil {
+		repoLogger.Warn().Err(err).Str("file", file).Msg("Error updating git working tree")
+	}
+
+	repoLogger.Info().Str("post_id", string(post.ID)).Str("commit", commit).Str("author", author).Msg("Post committed")
+
+	now := time.Now().UTC()
+	saved := *post
+	saved.Path = r.postName(file)
+	saved.MDContentHash = util.MarkdownHash(post.Markdown)
+	saved.ModifiedDate = now
+	if info, err := util.GetFrontMatter(post.Markdown); err == nil {
+		saved.Info = info
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	files := make(map[string]gitFile, len(r.files)+1)
+	for f, gf := range r.files {
+		files[f] = gf
+	}
+	if old, ok := files[file]; ok {
+		saved.CreatedDate = old.post.CreatedDate
+		saved.Owner = old.post.Owner
+	}
+	files[file] = gitFile{blob: blob, post: saved}
+
+	// Only skip the next reload if no other commit was made in between
+	if r.head == head {
+		r.head = commit
+	}
+	r.files = files
+	r.cache.store(postsFromGitFiles(files))
+
+	*post = saved
+	return nil
+}
+
+// syncWorktree updates file in the working tree and its index when the branch
+// is checked out, so the commit does not show up as a pending change. parent
+// is the commit the branch pointed to before; if the file has uncommitted
+// changes relative to it, they are left alone rather than overwritten.
+func (r *GitPostRepository) syncWorktree(file, parent string) error {
+	if !r.worktree {
+		return nil
+	}
+	out, err := r.git(nil, nil, "symbolic-ref", "-q", "HEAD")
+	if err != nil || strings.TrimSpace(string(out)) != "refs/heads/"+r.branch {
+		return nil
+	}
+
+	dirty, err := r.worktreeChanged(file, parent)
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("%w: %s", errDirtyWorktree, file)
+	}
+	_, err = r.git(nil, nil, "checkout", "HEAD", "--", file)
+	return err
+}
+
+// worktreeChanged reports whether file differs in the index or the working
+// tree from its version at commit, or exists at all if commit lacks it.
+func (r *GitPostRepository) worktreeChanged(file, commit string) (bool, error) {
+	tracked := false
+	if commit != "" {
+		_, err := r.git(nil, nil, "cat-file", "-e", commit+":"+file)
+		tracked = err == nil
+	}
+	if !tracked {
+		_, err := os.Lstat(path.Join(r.repoPath, file))
+		return err == nil, nil
+	}
+
+	for _, args := range [][]string{
+		{"diff", "--quiet", "--cached", commit, "--", file},
+		{"diff", "--quiet", commit, "--", file},
+	} {
+		if _, err := r.git(nil, nil, args...); err != nil {
+			var exitErr *exec.ExitError
+			if errors.As(err, &exitErr) && exitErr.ExitCode() == 1 {
+				return true, nil
+			}
+			return false, err
+		}
+	}
+	return false, nil
+}
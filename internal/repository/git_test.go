@@ -0,0 +1,338 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/debemdeboas/the-archive/internal/config"
+	"github.com/debemdeboas/the-archive/internal/model"
+	"github.com/debemdeboas/the-archive/internal/util"
+)
+
+type testGitRepo struct {
+	t   *testing.T
+	dir string
+}
+
+func newTestGitRepo(t *testing.T) *testGitRepo {
+	t.Helper()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	r := &testGitRepo{t: t, dir: t.TempDir()}
+	r.run(time.Time{}, "", "init", "-q", "-b", "main")
+	return r
+}
+
+func (r *testGitRepo) run(date time.Time, author string, args ...string) string {
+	r.t.Helper()
+	cmd := exec.Command("git", args...)
+	cmd.Dir = r.dir
+	cmd.Env = append(os.Environ(),
+		"GIT_COMMITTER_NAME=tester",
+		"GIT_COMMITTER_EMAIL=tester@example.com",
+		"GIT_AUTHOR_NAME="+author,
+		"GIT_AUTHOR_EMAIL="+author+"@example.com",
+	)
+	if !date.IsZero() {
+		stamp := date.Format(time.RFC3339)
+		cmd.Env = append(cmd.Env, "GIT_AUTHOR_DATE="+stamp, "GIT_COMMITTER_DATE="+stamp)
+	}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		r.t.Fatalf("git %s failed: %v: %s", strings.Join(args, " "), err, out)
+	}
+	return strings.TrimSpace(string(out))
+}
+
+// commit writes files to the working tree and commits them.
+func (r *testGitRepo) commit(date time.Time, author string, files map[string]string) {
+	r.t.Helper()
+	for name, content := range files {
+		p := filepath.Join(r.dir, name)
+		if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+			r.t.Fatal(err)
+		}
+		if err := os.WriteFile(p, []byte(content), 0644); err != nil {
+			r.t.Fatal(err)
+		}
+	}
+	r.run(date, author, "add", "-A")
+	r.run(date, author, "commit", "-q", "-m", "test commit")
+}
+
+func (r *testGitRepo) repository() *GitPostRepository {
+	repo := NewGitPostRepository(config.GitConfig{Path: r.dir, Branch: "main", PostsDir: "notes", AuthorEmailDomain: "example.com"})
+	if err := repo.open(); err != nil {
+		r.t.Fatalf("Failed to open repository: %v", err)
+	}
+	return repo
+}
+
+func TestGitPostRepositoryGetPosts(t *testing.T) {
+	g := newTestGitRepo(t)
+	created := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	modified := created.Add(48 * time.Hour)
+
+	g.commit(created, "alice", map[string]string{
+		"notes/first.md":       "%%%\ntitle = \"First post\"\n%%%\n\nHello",
+		"notes/second.md":      "# Second",
+		"notes/sub/my note.md": "# Nested",
+		"notes/\"quoted\".md":  "# Quoted",
+		"notes/image.png":      "not a post",
+		"README.md":            "outside the posts directory",
+	})
+	g.commit(modified, "bob", map[string]string{"notes/second.md": "# Second, edited"})
+
+	// Commits on other branches are ignored
+	g.run(time.Time{}, "", "checkout", "-q", "-b", "drafts")
+	g.commit(modified.Add(time.Hour), "carol", map[string]string{"notes/draft.md": "# Draft"})
+	g.run(time.Time{}, "", "checkout", "-q", "main")
+
+	posts, postMap, err := g.repository().GetPosts(context.Background())
+	if err != nil {
+		t.Fatalf("GetPosts failed: %v", err)
+	}
+	if len(posts) != 4 || len(postMap) != 4 {
+		t.Fatalf("Expected 4 posts, got %d", len(posts))
+	}
+
+	second := postMap["second"]
+	if second == nil || &posts[0] != second {
+		t.Fatalf("Expected the most recently modified post first, got %+v", posts[0])
+	}
+	if !second.CreatedDate.Equal(created) || !second.ModifiedDate.Equal(modified) {
+		t.Errorf("Expected dates from history, got created %v modified %v", second.CreatedDate, second.ModifiedDate)
+	}
+	if second.Owner != "alice" {
+		t.Errorf("Expected the first author to own the post, got %q", second.Owner)
+	}
+	if string(second.Markdown) != "# Second, edited" {
+		t.Errorf("Unexpected content %q", second.Markdown)
+	}
+
+	if first := postMap["first"]; first == nil || first.Title != "First post" {
+		t.Errorf("Expected title from front matter, got %+v", first)
+	}
+
+	// Names that are not URL-safe are hashed
+	if nested := postMap[util.ContentHashString("sub/my note")]; nested == nil || nested.Path != "sub/my note" {
+		t.Errorf("Expected a hashed ID for the nested post, got %+v", nested)
+	}
+	// and git quotes them unless asked for NUL-separated names
+	if quoted := postMap[util.ContentHashString(`"quoted"`)]; quoted == nil || quoted.Owner != "alice" || !quoted.CreatedDate.Equal(created) {
+		t.Errorf("Expected the history of the quoted post, got %+v", quoted)
+	}
+}
+
+func TestGitPostRepositoryReload(t *testing.T) {
+	g := newTestGitRepo(t)
+	g.commit(time.Now(), "alice", map[string]string{
+		"notes/a.md": "# A",
+		"notes/b.md": "# B",
+	})
+
+	repo := g.repository()
+	repo.reload(context.Background())
+
+	notified := notifications(repo)
+
+	// Without new commits nothing is read
+	repo.reload(context.Background())
+	expectNotifications(t, notified)
+
+	g.commit(time.Now(), "bob", map[string]string{
+		"notes/b.md": "# B, edited",
+		"notes/c.md": "# C",
+	})
+
+	// Reading the posts leaves the changes to the reload, and canceled
+	// reloads run no git commands
+	if posts, _, err := repo.GetPosts(context.Background()); err != nil || len(posts) != 3 {
+		t.Fatalf("Expected GetPosts to see 3 posts, got %d (%v)", len(posts), err)
+	}
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	repo.reload(canceled)
+	if posts := repo.GetPostList(); len(posts) != 2 {
+		t.Fatalf("Expected a canceled reload to keep the posts, got %d", len(posts))
+	}
+	repo.reload(context.Background())
+
+	if posts := repo.GetPostList(); len(posts) != 3 {
+		t.Fatalf("Expected 3 posts after reload, got %d", len(posts))
+	}
+	expectNotifications(t, notified,
+		postNotification{"b", model.PostUpdated},
+		postNotification{"c", model.PostCreated},
+	)
+
+	g.run(time.Time{}, "", "rm", "-q", "notes/a.md")
+	g.run(time.Now(), "bob", "commit", "-q", "-m", "Remove a")
+	repo.reload(context.Background())
+
+	if posts := repo.GetPostList(); len(posts) != 2 {
+		t.Fatalf("Expected 2 posts after reload, got %d", len(posts))
+	}
+	expectNotifications(t, notified, postNotification{"a", model.PostDeleted})
+}
+
+func TestGitPostRepositoryWriteBack(t *testing.T) {
+	ctx := context.Background()
+	g := newTestGitRepo(t)
+	g.commit(time.Now(), "alice", map[string]string{"notes/existing.md": "# Existing"})
+
+	repo := g.repository()
+	repo.reload(context.Background())
+
+	post := repo.NewPost()
+	post.Owner = "admin"
+	post.Title = "Hello"
+	post.Markdown = []byte("%%%\ntitle = \"Hello\"\n%%%\n\nHello from git")
+
+	if err := repo.SavePost(ctx, post); err != nil {
+		t.Fatalf("SavePost failed: %v", err)
+	}
+
+	file := "notes/" + string(post.ID) + ".md"
+	if got := g.run(time.Time{}, "", "log", "-1", "--format=%an <%ae>|%s", "main"); got != "admin <admin@example.com>|Create Hello" {
+		t.Errorf("Unexpected commit %q", got)
+	}
+	if got := g.run(time.Time{}, "", "show", "main:"+file); got != string(post.Markdown) {
+		t.Errorf("Expected post to be committed, got %q", got)
+	}
+	if status := g.run(time.Time{}, "", "status", "--porcelain"); status != "" {
+		t.Errorf("Expected a clean working tree, got %q", status)
+	}
+	if _, err := repo.ReadPost(string(post.ID)); err != nil {
+		t.Errorf("Expected saved post to be readable immediately: %v", err)
+	}
+
+	t.Run("Saving the same post twice fails", func(t *testing.T) {
+		if err := repo.SavePost(ctx, post); err == nil {
+			t.Error("Expected error saving an existing post")
+		}
+	})
+
+	t.Run("History survives a fresh load", func(t *testing.T) {
+		fresh := g.repository()
+		fresh.reload(context.Background())
+
+		loaded, err := fresh.ReadPost(string(post.ID))
+		if err != nil {
+			t.Fatalf("Expected post to be found by its ID: %v", err)
+		}
+		if loaded.Owner != "admin" || loaded.Title != "Hello" {
+			t.Errorf("Unexpected post %+v", loaded)
+		}
+	})
+
+	t.Run("SetPostContent commits the change", func(t *testing.T) {
+		post.Markdown = []byte("Updated content")
+		if err := repo.SetPostContent(ctx, post); err != nil {
+			t.Fatalf("SetPostContent failed: %v", err)
+		}
+		if got := g.run(time.Time{}, "", "show", "main:"+file); got != "Updated content" {
+			t.Errorf("Expected updated file, got %q", got)
+		}
+		if got := g.run(time.Time{}, "", "rev-list", "--count", "main"); got != "3" {
+			t.Errorf("Expected a commit per save, got %s commits", got)
+		}
+
+		cached, _ := repo.ReadPost(string(post.ID))
+		if string(cached.Markdown) != "Updated content" {
+			t.Errorf("Expected cache to be updated, got %q", cached.Markdown)
+		}
+	})
+
+	t.Run("Unrelated commits do not conflict", func(t *testing.T) {
+		g.commit(time.Now(), "bob", map[string]string{"notes/other.md": "# Other"})
+
+		post.Markdown = []byte("Updated again")
+		if err := repo.SetPostContent(ctx, post); err != nil {
+			t.Fatalf("SetPostContent failed: %v", err)
+		}
+		if got := g.run(time.Time{}, "", "show", "main:notes/other.md"); got != "# Other" {
+			t.Errorf("Expected the other commit to be kept, got %q", got)
+		}
+	})
+
+	t.Run("Uncommitted changes in the working tree are kept", func(t *testing.T) {
+		local := filepath.Join(g.dir, file)
+		if err := os.WriteFile(local, []byte("Local edit"), 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		post.Markdown = []byte("Saved from the editor")
+		if err := repo.SetPostContent(ctx, post); err != nil {
+			t.Fatalf("SetPostContent failed: %v", err)
+		}
+		if got := g.run(time.Time{}, "", "show", "main:"+file); got != "Saved from the editor" {
+			t.Errorf("Expected the post to be committed, got %q", got)
+		}
+		if data, _ := os.ReadFile(local); string(data) != "Local edit" {
+			t.Errorf("Expected the local edit to be kept, got %q", data)
+		}
+	})
+
+	t.Run("Concurrent modification is detected", func(t *testing.T) {
+		g.commit(time.Now(), "bob", map[string]string{file: "Changed elsewhere"})
+
+		post.Markdown = []byte("Stale edit")
+		err := repo.SetPostContent(ctx, post)
+		if !errors.Is(err, ErrPostConflict) {
+			t.Errorf("Expected ErrPostConflict, got %v", err)
+		}
+		if got := g.run(time.Time{}, "", "show", "main:"+file); got != "Changed elsewhere" {
+			t.Errorf("Expected the other change to be kept, got %q", got)
+		}
+	})
+
+	t.Run("Unknown post", func(t *testing.T) {
+		if err := repo.SetPostContent(ctx, &model.Post{ID: "missing"}); err == nil {
+			t.Error("Expected error updating an unknown post")
+		}
+	})
+}
+
+func TestGitPostRepositoryCreatesRepository(t *testing.T) {
+	ctx := context.Background()
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+	dir := filepath.Join(t.TempDir(), "posts")
+
+	repo, err := NewPostRepositoryFromConfig(context.Background(), config.PostsConfig{Repository: "git", Git: config.GitConfig{Path: dir, Branch: "main", AuthorEmailDomain: "localhost"}}, config.RepositoriesConfig{}, nil)
+	if err != nil {
+		t.Fatalf("Expected git repository, got error: %v", err)
+	}
+	gitRepo, ok := repo.(*GitPostRepository)
+	if !ok {
+		t.Fatalf("Expected *GitPostRepository, got %T", repo)
+	}
+	if err := gitRepo.open(); err != nil {
+		t.Fatalf("Failed to create repository: %v", err)
+	}
+	gitRepo.reload(context.Background())
+	if posts := gitRepo.GetPostList(); len(posts) != 0 {
+		t.Errorf("Expected no posts in a new repository, got %d", len(posts))
+	}
+
+	// The first post is the root commit
+	post := gitRepo.NewPost()
+	post.Owner = "admin"
+	post.Markdown = []byte("# First")
+	if err := gitRepo.SavePost(ctx, post); err != nil {
+		t.Fatalf("SavePost failed: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, string(post.ID)+".md")); err != nil {
+		t.Errorf("Expected the checked out branch to be updated: %v", err)
+	}
+}
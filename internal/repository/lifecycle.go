@@ -0,0 +1,68 @@
+package repository
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// reloadLoop runs the background reload of a repository between its Start
+// and Stop.
+type reloadLoop struct {
+	mu     sync.Mutex
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// start runs reload in a goroutine until ctx is done or stop is called. It
+// does nothing if the loop is already running.
+func (l *reloadLoop) start(ctx context.Context, reload func(ctx context.Context)) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.cancel != nil {
+		return
+	}
+
+	ctx, l.cancel = context.WithCancel(ctx)
+	l.done = make(chan struct{})
+	go func(done chan struct{}) {
+		defer close(done)
+		reload(ctx)
+	}(l.done)
+}
+
+// stop cancels the loop and waits for it to return. The loop can be started
+// again afterwards.
+func (l *reloadLoop) stop() {
+	l.mu.Lock()
+	cancel, done := l.cancel, l.done
+	l.cancel, l.done = nil, nil
+	l.mu.Unlock()
+
+	if cancel == nil {
+		return
+	}
+	cancel()
+	<-done
+}
+
+// poll calls reload with ctx every interval until ctx is done, so that
+// stopping the loop also cancels the reload in progress. Intervals of zero or
+// less disable polling, so poll only waits for ctx.
+func poll(ctx context.Context, interval time.Duration, reload func(ctx context.Context)) {
+	if interval <= 0 {
+		<-ctx.Done()
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			reload(ctx)
+		}
+	}
+}
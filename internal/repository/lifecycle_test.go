@@ -0,0 +1,184 @@
+package repository
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// expectGoroutines fails t unless the number of goroutines goes back to at
+// most n shortly.
+func expectGoroutines(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > n {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			t.Fatalf("Expected at most %d goroutines, got %d:\n%s", n, runtime.NumGoroutine(), buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestReloadLoop(t *testing.T) {
+	var runs atomic.Int32
+	reload := func(ctx context.Context) {
+		runs.Add(1)
+		<-ctx.Done()
+	}
+
+	t.Run("Stop waits for the loop", func(t *testing.T) {
+		before := runtime.NumGoroutine()
+		var loop reloadLoop
+		defer loop.stop()
+
+		loop.start(context.Background(), reload)
+		loop.start(context.Background(), reload)
+		expectGoroutines(t, before+1)
+		loop.stop()
+		expectGoroutines(t, before)
+		loop.stop()
+	})
+
+	t.Run("Canceled contexts stop the loop", func(t *testing.T) {
+		before := runtime.NumGoroutine()
+		var loop reloadLoop
+		defer loop.stop()
+
+		ctx, cancel := context.WithCancel(context.Background())
+		loop.start(ctx, reload)
+		cancel()
+		expectGoroutines(t, before)
+	})
+
+	t.Run("Loops can be restarted", func(t *testing.T) {
+		var loop reloadLoop
+		defer loop.stop()
+
+		runs.Store(0)
+		for range 2 {
+			loop.start(context.Background(), reload)
+			loop.stop()
+		}
+		if runs.Load() != 2 {
+			t.Errorf("Expected 2 runs, got %d", runs.Load())
+		}
+	})
+
+	t.Run("Polling", func(t *testing.T) {
+		var loop reloadLoop
+		defer loop.stop()
+
+		var polls atomic.Int32
+		loop.start(context.Background(), func(ctx context.Context) {
+			poll(ctx, time.Millisecond, func(context.Context) { polls.Add(1) })
+		})
+		for polls.Load() < 3 {
+			time.Sleep(time.Millisecond)
+		}
+		loop.stop()
+		n := polls.Load()
+		time.Sleep(10 * time.Millisecond)
+		if polls.Load() != n {
+			t.Error("Expected polling to stop")
+		}
+	})
+
+	t.Run("Stopping cancels the reload in progress", func(t *testing.T) {
+		var loop reloadLoop
+		defer loop.stop()
+
+		started := make(chan struct{})
+		var once sync.Once
+		loop.start(context.Background(), func(ctx context.Context) {
+			poll(ctx, time.Millisecond, func(ctx context.Context) {
+				once.Do(func() { close(started) })
+				<-ctx.Done()
+			})
+		})
+		<-started
+
+		stopped := make(chan struct{})
+		go func() {
+			loop.stop()
+			close(stopped)
+		}()
+		select {
+		case <-stopped:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Stop waited for a reload that ignores cancellation")
+		}
+	})
+}
+
+func TestDBPostRepositoryZeroReloadTimeout(t *testing.T) {
+	testDB, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+	defer testDB.Close()
+
+	before := runtime.NumGoroutine()
+	repo := NewDBPostRepository(testDB)
+	repo.SetReloadTimeout(0)
+	if err := repo.Start(context.Background()); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	repo.Stop()
+	expectGoroutines(t, before)
+}
+
+func TestFSPostRepositoryStartStop(t *testing.T) {
+	before := runtime.NumGoroutine()
+	repo, dir := newTestFSPostRepository(t, map[string]string{"a.md": "# A"})
+	repo.debounce = 10 * time.Millisecond
+
+	for range 2 {
+		if err := repo.Start(context.Background()); err != nil {
+			t.Fatalf("Start failed: %v", err)
+		}
+		if len(repo.GetPostList()) == 0 {
+			t.Error("Expected the posts to be loaded")
+		}
+		repo.Stop()
+		expectGoroutines(t, before)
+	}
+
+	// Changes made while stopped are not picked up
+	os.WriteFile(filepath.Join(dir, "b.md"), []byte("# B"), 0644)
+	time.Sleep(50 * time.Millisecond)
+	if len(repo.GetPostList()) != 1 {
+		t.Errorf("Expected the stopped repository not to reload, got %d posts", len(repo.GetPostList()))
+	}
+}
+
+func TestMultiPostRepositoryStartFailure(t *testing.T) {
+	before := runtime.NumGoroutine()
+	mainRepo, _ := newTestFSPostRepository(t, map[string]string{"a.md": "# A"})
+
+	// A file where the posts directory should be
+	notesPath := filepath.Join(t.TempDir(), "notes")
+	if err := os.WriteFile(notesPath, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	repo, err := NewMultiPostRepository([]PostSource{
+		{Name: "main", Repository: mainRepo, Root: true},
+		{Name: "notes", Repository: NewFSPostRepository(notesPath)},
+	}, "")
+	if err != nil {
+		t.Fatalf("NewMultiPostRepository failed: %v", err)
+	}
+
+	err = repo.Start(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "post source notes") {
+		t.Fatalf("Expected the notes source to fail, got %v", err)
+	}
+	expectGoroutines(t, before)
+}
@@ -0,0 +1,187 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/debemdeboas/the-archive/internal/db"
+	"github.com/debemdeboas/the-archive/internal/model"
+)
+
+var ErrMediaNotFound = errors.New("media not found")
+
+// MediaRepository tracks uploaded media files and the posts that reference them.
+type MediaRepository interface {
+	SaveMedia(ctx context.Context, m *model.Media) error
+	GetMedia(ctx context.Context, id model.MediaID) (*model.Media, error)
+	GetMediaBySourceHash(ctx context.Context, hash string) (*model.Media, error)
+
+	// ListMedia returns every tracked file with its reference count, newest first.
+	ListMedia(ctx context.Context) ([]model.Media, error)
+	DeleteMedia(ctx context.Context, id model.MediaID) error
+
+	// SetPostReferences replaces the set of media referenced by a post.
+	SetPostReferences(ctx context.Context, postID model.PostID, ids []model.MediaID) error
+
+	// PruneReferences drops the references of every post not in posts, and
+	// returns how many posts it dropped references for.
+	PruneReferences(ctx context.Context, posts []model.PostID) (int, error)
+}
+
+type DBMediaRepository struct { // implements MediaRepository
+	db db.DB
+}
+
+func NewDBMediaRepository(db db.DB) *DBMediaRepository {
+	return &DBMediaRepository{db: db}
+}
+
+const mediaColumns = `m.id, m.user_id, m.content_type, m.size, m.width, m.height, m.source_hash, m.variants, m.created_at,
+	(SELECT COUNT(*) FROM media_references r WHERE r.media_id = m.id)`
+
+func scanMedia(row interface{ Scan(dest ...any) error }) (*model.Media, error) {
+	var m model.Media
+	var variants string
+	err := row.Scan(&m.ID, &m.Owner, &m.ContentType, &m.Size, &m.Width, &m.Height, &m.SourceHash, &variants, &m.CreatedDate, &m.References)
+	if err != nil {
+		return nil, err
+	}
+	if variants != "" {
+		m.Variants = strings.Split(variants, ",")
+	}
+	return &m, nil
+}
+
+func (r *DBMediaRepository) SaveMedia(ctx context.Context, m *model.Media) error {
+	_, err := r.db.ExecContext(ctx,
+		`INSERT INTO media (id, user_id, content_type, size, width, height, source_hash, variants, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+		m.ID, m.Owner, m.ContentType, m.Size, m.Width, m.Height, m.SourceHash, strings.Join(m.Variants, ","), m.CreatedDate,
+	)
+	if err != nil {
+		return fmt.Errorf("error saving media: %w", err)
+	}
+	return nil
+}
+
+func (r *DBMediaRepository) getOne(ctx context.Context, where string, arg any) (*model.Media, error) {
+	row := r.db.QueryRowContext(ctx, `SELECT `+mediaColumns+` FROM media m WHERE `+where, arg)
+	m, err := scanMedia(row)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrMediaNotFound
+	} else if err != nil {
+		return nil, fmt.Errorf("error scanning media: %w", err)
+	}
+	return m, nil
+}
+
+func (r *DBMediaRepository) GetMedia(ctx context.Context, id model.MediaID) (*model.Media, error) {
+	return r.getOne(ctx, `m.id = ?`, id)
+}
+
+func (r *DBMediaRepository) GetMediaBySourceHash(ctx context.Context, hash string) (*model.Media, error) {
+	return r.getOne(ctx, `m.source_hash = ?`, hash)
+}
+
+func (r *DBMediaRepository) ListMedia(ctx context.Context) ([]model.Media, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT `+mediaColumns+` FROM media m ORDER BY m.created_at DESC, m.id`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying media: %w", err)
+	}
+	defer rows.Close()
+
+	media := make([]model.Media, 0)
+	for rows.Next() {
+		m, err := scanMedia(rows)
+		if err != nil {
+			return nil, fmt.Errorf("error scanning media: %w", err)
+		}
+		media = append(media, *m)
+	}
+	return media, rows.Err()
+}
+
+func (r *DBMediaRepository) DeleteMedia(ctx context.Context, id model.MediaID) error {
+	return r.db.WithTx(ctx, func(tx *db.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM media_references WHERE media_id = ?`, id); err != nil {
+			return fmt.Errorf("error deleting media references: %w", err)
+		}
+		res, err := tx.Exec(`DELETE FROM media WHERE id = ?`, id)
+		if err != nil {
+			return fmt.Errorf("error deleting media: %w", err)
+		}
+		if n, _ := res.RowsAffected(); n == 0 {
+			return ErrMediaNotFound
+		}
+		return nil
+	})
+}
+
+func (r *DBMediaRepository) SetPostReferences(ctx context.Context, postID model.PostID, ids []model.MediaID) error {
+	err := r.db.WithTx(ctx, func(tx *db.Tx) error {
+		if _, err := tx.Exec(`DELETE FROM media_references WHERE post_id = ?`, postID); err != nil {
+			return err
+		}
+		for _, id := range ids {
+			if _, err := tx.Exec(`INSERT INTO media_references (media_id, post_id) VALUES (?, ?) ON CONFLICT DO NOTHING`, id, postID); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error updating media references: %w", err)
+	}
+
+	repoLogger.Debug().Str("post_id", string(postID)).Int("references", len(ids)).Msg("Media references updated")
+	return nil
+}
+
+func (r *DBMediaRepository) PruneReferences(ctx context.Context, posts []model.PostID) (int, error) {
+	keep := make(map[model.PostID]bool, len(posts))
+	for _, id := range posts {
+		keep[id] = true
+	}
+
+	var pruned int
+	err := r.db.WithTx(ctx, func(tx *db.Tx) error {
+		pruned = 0
+		rows, err := tx.Query(`SELECT DISTINCT post_id FROM media_references`)
+		if err != nil {
+			return err
+		}
+		var stale []model.PostID
+		for rows.Next() {
+			var id model.PostID
+			if err := rows.Scan(&id); err != nil {
+				rows.Close()
+				return err
+			}
+			if !keep[id] {
+				stale = append(stale, id)
+			}
+		}
+		rows.Close()
+		if err := rows.Err(); err != nil {
+			return err
+		}
+
+		for _, id := range stale {
+			if _, err := tx.Exec(`DELETE FROM media_references WHERE post_id = ?`, id); err != nil {
+				return err
+			}
+		}
+		pruned = len(stale)
+		return nil
+	})
+	if err != nil {
+		return 0, fmt.Errorf("error pruning media references: %w", err)
+	}
+
+	if pruned > 0 {
+		repoLogger.Debug().Int("posts", pruned).Msg("Media references of removed posts pruned")
+	}
+	return pruned, nil
+}
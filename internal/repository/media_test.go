@@ -0,0 +1,162 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/debemdeboas/the-archive/internal/model"
+)
+
+func setupMediaTestDB(t *testing.T) *testDB {
+	t.Helper()
+	testDB, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+	t.Cleanup(func() { testDB.Close() })
+
+	_, err = testDB.Exec(`
+		CREATE TABLE media (
+			id TEXT PRIMARY KEY,
+			user_id TEXT,
+			content_type TEXT,
+			size INTEGER,
+			width INTEGER,
+			height INTEGER,
+			source_hash TEXT UNIQUE,
+			variants TEXT,
+			created_at DATETIME
+		);
+		CREATE TABLE media_references (
+			media_id TEXT NOT NULL,
+			post_id TEXT NOT NULL,
+			PRIMARY KEY (media_id, post_id)
+		);
+	`)
+	if err != nil {
+		t.Fatalf("Failed to create media tables: %v", err)
+	}
+	return testDB
+}
+
+func TestDBMediaRepository(t *testing.T) {
+	ctx := context.Background()
+	repo := NewDBMediaRepository(setupMediaTestDB(t))
+	now := time.Now().UTC().Truncate(time.Second)
+
+	older := &model.Media{
+		ID:          "aaaa.100x50.jpg",
+		Owner:       "admin",
+		ContentType: "image/jpeg",
+		Size:        1234,
+		Width:       100,
+		Height:      50,
+		SourceHash:  "hash-a",
+		Variants:    []string{"aaaa.100x50.48w.jpg"},
+		CreatedDate: now.Add(-time.Hour),
+	}
+	newer := &model.Media{
+		ID:          "bbbb.10x10.gif",
+		Owner:       "admin",
+		ContentType: "image/gif",
+		SourceHash:  "hash-b",
+		CreatedDate: now,
+	}
+	for _, m := range []*model.Media{older, newer} {
+		if err := repo.SaveMedia(ctx, m); err != nil {
+			t.Fatalf("SaveMedia failed: %v", err)
+		}
+	}
+
+	t.Run("Duplicate source hash is rejected", func(t *testing.T) {
+		dup := *newer
+		dup.ID = "cccc.10x10.gif"
+		if err := repo.SaveMedia(ctx, &dup); err == nil {
+			t.Error("Expected error saving media with a duplicate source hash")
+		}
+	})
+
+	t.Run("Get", func(t *testing.T) {
+		m, err := repo.GetMedia(ctx, older.ID)
+		if err != nil {
+			t.Fatalf("GetMedia failed: %v", err)
+		}
+		if m.Width != 100 || m.Height != 50 || m.Size != 1234 || len(m.Variants) != 1 || m.Variants[0] != older.Variants[0] {
+			t.Errorf("Unexpected media %+v", m)
+		}
+		if !m.CreatedDate.Equal(older.CreatedDate) {
+			t.Errorf("Expected created date %v, got %v", older.CreatedDate, m.CreatedDate)
+		}
+
+		m, err = repo.GetMediaBySourceHash(ctx, "hash-b")
+		if err != nil || m.ID != newer.ID || len(m.Variants) != 0 {
+			t.Errorf("Unexpected lookup by hash: %+v, %v", m, err)
+		}
+
+		if _, err := repo.GetMedia(ctx, "missing"); !errors.Is(err, ErrMediaNotFound) {
+			t.Errorf("Expected ErrMediaNotFound, got %v", err)
+		}
+	})
+
+	t.Run("References", func(t *testing.T) {
+		if err := repo.SetPostReferences(ctx, "post-1", []model.MediaID{older.ID, newer.ID}); err != nil {
+			t.Fatalf("SetPostReferences failed: %v", err)
+		}
+		if err := repo.SetPostReferences(ctx, "post-2", []model.MediaID{older.ID}); err != nil {
+			t.Fatalf("SetPostReferences failed: %v", err)
+		}
+		// Replacing the references of a post drops the ones no longer used
+		if err := repo.SetPostReferences(ctx, "post-1", []model.MediaID{older.ID}); err != nil {
+			t.Fatalf("SetPostReferences failed: %v", err)
+		}
+
+		list, err := repo.ListMedia(ctx)
+		if err != nil {
+			t.Fatalf("ListMedia failed: %v", err)
+		}
+		if len(list) != 2 || list[0].ID != newer.ID || list[1].ID != older.ID {
+			t.Fatalf("Expected newest first, got %+v", list)
+		}
+		if list[0].References != 0 || list[1].References != 2 {
+			t.Errorf("Unexpected reference counts %d and %d", list[0].References, list[1].References)
+		}
+	})
+
+	t.Run("Cancelled requests leave the references alone", func(t *testing.T) {
+		cancelled, cancel := context.WithCancel(ctx)
+		cancel()
+		if err := repo.SetPostReferences(cancelled, "post-1", nil); !errors.Is(err, context.Canceled) {
+			t.Errorf("Expected context.Canceled, got %v", err)
+		}
+		if m, err := repo.GetMedia(ctx, older.ID); err != nil || m.References != 2 {
+			t.Errorf("Expected 2 references to be kept, got %+v (%v)", m, err)
+		}
+	})
+
+	t.Run("Prune", func(t *testing.T) {
+		pruned, err := repo.PruneReferences(ctx, []model.PostID{"post-1"})
+		if err != nil || pruned != 1 {
+			t.Fatalf("Expected the references of post-2 to be pruned, got %d (%v)", pruned, err)
+		}
+		if m, err := repo.GetMedia(ctx, older.ID); err != nil || m.References != 1 {
+			t.Errorf("Expected the reference of post-1 to be kept, got %+v (%v)", m, err)
+		}
+		if pruned, err := repo.PruneReferences(ctx, []model.PostID{"post-1"}); err != nil || pruned != 0 {
+			t.Errorf("Expected nothing left to prune, got %d (%v)", pruned, err)
+		}
+	})
+
+	t.Run("Delete", func(t *testing.T) {
+		if err := repo.DeleteMedia(ctx, older.ID); err != nil {
+			t.Fatalf("DeleteMedia failed: %v", err)
+		}
+		if _, err := repo.GetMedia(ctx, older.ID); !errors.Is(err, ErrMediaNotFound) {
+			t.Errorf("Expected ErrMediaNotFound after delete, got %v", err)
+		}
+		if err := repo.DeleteMedia(ctx, older.ID); !errors.Is(err, ErrMediaNotFound) {
+			t.Errorf("Expected ErrMediaNotFound deleting twice, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,299 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/debemdeboas/the-archive/internal/model"
+)
+
+var ErrReadOnlySource = errors.New("post source is read-only")
+
+// multiSeparator separates the source name from the post ID in the IDs of a
+// MultiPostRepository.
+const multiSeparator = ":"
+
+var sourceNameRegex = regexp.MustCompile(`^[a-z0-9_-]+$`)
+
+// PostSource is a repository mounted into a MultiPostRepository.
+type PostSource struct {
+	Name       string
+	Repository PostRepository
+
+	// Root sources keep their original post IDs instead of being prefixed
+	// with their name. At most one source can be the root.
+	Root bool
+
+	ReadOnly bool
+}
+
+// id returns the ID a post of the source has in the merged repository.
+func (s *PostSource) id(id model.PostID) model.PostID {
+	if s.Root {
+		return id
+	}
+	return model.PostID(s.Name + multiSeparator + string(id))
+}
+
+func (s *PostSource) post(post model.Post) model.Post {
+	post.ID = s.id(post.ID)
+	return post
+}
+
+// MultiPostRepository merges the posts of several repositories into a single
+// list. Reads are routed by the source name in the post ID, new posts are
+// saved to the writable source, and reload notifications of every source are
+// forwarded.
+type MultiPostRepository struct { // implements PostRepository
+	sources  []*PostSource
+	writable *PostSource
+
+	merged atomic.Pointer[mergedSnapshot]
+
+	reloadNotifier func(model.PostID, model.PostEvent)
+}
+
+// NewMultiPostRepository mounts sources. writable names the source new posts
+// are saved to; if empty, the first source that is not read-only is used.
+func NewMultiPostRepository(sources []PostSource, writable string) (*MultiPostRepository, error) {
+	r := &MultiPostRepository{}
+
+	names := make(map[string]bool)
+	root := false
+	for _, src := range sources {
+		if !sourceNameRegex.MatchString(src.Name) {
+			return nil, fmt.Errorf("invalid post source name %q: only lowercase letters, digits, dashes and underscores are allowed", src.Name)
+		}
+		if names[src.Name] {
+			return nil, fmt.Errorf("duplicate post source name %q", src.Name)
+		}
+		names[src.Name] = true
+
+		if src.Root {
+			if root {
+				return nil, fmt.Errorf("post source %q: only one source can be the root", src.Name)
+			}
+			root = true
+		}
+
+		s := &src
+		s.Repository.SetReloadNotifier(func(id model.PostID, event model.PostEvent) {
+			r.notifyPostReload(s.id(id), event)
+		})
+		r.sources = append(r.sources, s)
+
+		if (writable == "" && r.writable == nil && !s.ReadOnly) || writable == s.Name {
+			r.writable = s
+		}
+	}
+
+	if writable != "" {
+		if r.writable == nil {
+			return nil, fmt.Errorf("unknown writable post source %q", writable)
+		}
+		if r.writable.ReadOnly {
+			return nil, fmt.Errorf("writable post source %q is read-only", writable)
+		}
+	}
+
+	return r, nil
+}
+
+func (r *MultiPostRepository) SetReloadNotifier(notifier func(model.PostID, model.PostEvent)) {
+	r.reloadNotifier = notifier
+}
+
+func (r *MultiPostRepository) notifyPostReload(postID model.PostID, event model.PostEvent) {
+	if r.reloadNotifier != nil {
+		r.reloadNotifier(postID, event)
+	}
+}
+
+func (r *MultiPostRepository) SetReloadTimeout(timeout time.Duration) {
+	for _, src := range r.sources {
+		src.Repository.SetReloadTimeout(timeout)
+	}
+}
+
+// Start starts every source, which reload themselves. If a source fails to
+// start, the ones started before it are stopped.
+func (r *MultiPostRepository) Start(ctx context.Context) error {
+	for i, src := range r.sources {
+		if err := src.Repository.Start(ctx); err != nil {
+			for _, started := range r.sources[:i] {
+				started.Repository.Stop()
+			}
+			return fmt.Errorf("post source %s: %w", src.Name, err)
+		}
+		repoLogger.Info().Str("source", src.Name).Int("posts", len(src.Repository.GetPostList())).Msg("Post source mounted")
+	}
+	return nil
+}
+
+// Stop stops every source.
+func (r *MultiPostRepository) Stop() {
+	for _, src := range r.sources {
+		src.Repository.Stop()
+	}
+}
+
+// route returns the source a post ID belongs to and the ID within that source.
+func (r *MultiPostRepository) route(id string) (*PostSource, model.PostID, bool) {
+	if name, localID, ok := strings.Cut(id, multiSeparator); ok {
+		for _, src := range r.sources {
+			if !src.Root && src.Name == name {
+				return src, model.PostID(localID), true
+			}
+		}
+	}
+	for _, src := range r.sources {
+		if src.Root {
+			return src, model.PostID(id), true
+		}
+	}
+	return nil, "", false
+}
+
+func sortMergedPosts(posts []model.Post) {
+	slices.SortStableFunc(posts, func(a, b model.Post) int {
+		if c := -a.ModifiedDate.Compare(b.ModifiedDate); c != 0 {
+			return c
+		}
+		return strings.Compare(string(a.ID), string(b.ID))
+	})
+}
+
+func (r *MultiPostRepository) GetPosts(ctx context.Context) ([]model.Post, map[string]*model.Post, error) {
+	var posts []model.Post
+	var errs []error
+	for _, src := range r.sources {
+		srcPosts, _, err := src.Repository.GetPosts(ctx)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("post source %s: %w", src.Name, err))
+			continue
+		}
+		for _, post := range srcPosts {
+			posts = append(posts, src.post(post))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, nil, errors.Join(errs...)
+	}
+
+	sortMergedPosts(posts)
+	return posts, postMap(posts), nil
+}
+
+// mergedSnapshot is the merged list of posts along with the source lists it
+// was built from.
+type mergedSnapshot struct {
+	lists [][]model.Post
+	*postSnapshot
+}
+
+// sameList reports whether a and b are the same slice. The lists of the
+// sources are snapshots that are replaced, not modified, when posts change.
+func sameList(a, b []model.Post) bool {
+	return len(a) == len(b) && (len(a) == 0 || &a[0] == &b[0])
+}
+
+// snapshot returns the merged posts, merging them again only if the list of
+// a source changed since the last call.
+func (r *MultiPostRepository) snapshot() *postSnapshot {
+	lists := make([][]model.Post, len(r.sources))
+	for i, src := range r.sources {
+		lists[i] = src.Repository.GetPostList()
+	}
+	if m := r.merged.Load(); m != nil && slices.EqualFunc(m.lists, lists, sameList) {
+		return m.postSnapshot
+	}
+
+	var posts []model.Post
+	for i, src := range r.sources {
+		for _, post := range lists[i] {
+			posts = append(posts, src.post(post))
+		}
+	}
+	sortMergedPosts(posts)
+
+	snap := newPostSnapshot(posts)
+	r.merged.Store(&mergedSnapshot{lists: lists, postSnapshot: snap})
+	return snap
+}
+
+func (r *MultiPostRepository) GetPostList() []model.Post {
+	return r.snapshot().list()
+}
+
+// ReadPost returns a copy of the post with its ID in the merged repository.
+func (r *MultiPostRepository) ReadPost(id any) (*model.Post, error) {
+	src, localID, ok := r.route(id.(string))
+	if !ok {
+		return nil, fmt.Errorf("post not found: %s", id)
+	}
+
+	post, err := src.Repository.ReadPost(string(localID))
+	if err != nil {
+		return nil, err
+	}
+	merged := src.post(*post)
+	return &merged, nil
+}
+
+func (r *MultiPostRepository) GetAdjacentPosts(id any) (prev *model.Post, next *model.Post) {
+	return r.snapshot().adjacent(id.(string))
+}
+
+func (r *MultiPostRepository) NewPost() *model.Post {
+	if r.writable == nil {
+		return &model.Post{}
+	}
+
+	post := r.writable.Repository.NewPost()
+	post.ID = r.writable.id(post.ID)
+	return post
+}
+
+// write calls save with a copy of post that has the ID the source knows it
+// by, and copies the result back.
+func (r *MultiPostRepository) write(ctx context.Context, src *PostSource, localID model.PostID, post *model.Post, save func(context.Context, *model.Post) error) error {
+	if src.ReadOnly {
+		return fmt.Errorf("error saving post %s: %w", post.ID, ErrReadOnlySource)
+	}
+
+	local := *post
+	local.ID = localID
+	if err := save(ctx, &local); err != nil {
+		return err
+	}
+
+	*post = src.post(local)
+	return nil
+}
+
+func (r *MultiPostRepository) SavePost(ctx context.Context, post *model.Post) error {
+	src := r.writable
+	if src == nil {
+		return fmt.Errorf("error saving post %s: %w", post.ID, ErrReadOnlySource)
+	}
+
+	localID := post.ID
+	if !src.Root {
+		localID = model.PostID(strings.TrimPrefix(string(post.ID), src.Name+multiSeparator))
+	}
+	return r.write(ctx, src, localID, post, src.Repository.SavePost)
+}
+
+func (r *MultiPostRepository) SetPostContent(ctx context.Context, post *model.Post) error {
+	src, localID, ok := r.route(string(post.ID))
+	if !ok {
+		return fmt.Errorf("post not found: %s", post.ID)
+	}
+	return r.write(ctx, src, localID, post, src.Repository.SetPostContent)
+}
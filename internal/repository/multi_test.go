@@ -0,0 +1,239 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/debemdeboas/the-archive/internal/config"
+	"github.com/debemdeboas/the-archive/internal/model"
+	"github.com/debemdeboas/the-archive/internal/util"
+)
+
+func TestMultiPostRepository(t *testing.T) {
+	ctx := context.Background()
+	old := time.Now().Add(-time.Hour)
+
+	mainRepo, mainDir := newTestFSPostRepository(t, map[string]string{"a.md": "# A"})
+	notesRepo, notesDir := newTestFSPostRepository(t, map[string]string{"note.md": "# Note"})
+	os.Chtimes(filepath.Join(mainDir, "a.md"), old, old)
+	mainRepo.reload(nil)
+
+	repo, err := NewMultiPostRepository([]PostSource{
+		{Name: "main", Repository: mainRepo, Root: true},
+		{Name: "notes", Repository: notesRepo, ReadOnly: true},
+	}, "")
+	if err != nil {
+		t.Fatalf("NewMultiPostRepository failed: %v", err)
+	}
+
+	notified := notifications(repo)
+
+	idA := model.PostID(util.ContentHashString("a"))
+	idNote := model.PostID("notes:" + util.ContentHashString("note"))
+
+	t.Run("Lists are merged and IDs namespaced", func(t *testing.T) {
+		posts := repo.GetPostList()
+		if len(posts) != 2 {
+			t.Fatalf("Expected 2 posts, got %d", len(posts))
+		}
+		if posts[0].ID != idNote || posts[1].ID != idA {
+			t.Errorf("Expected the newest post first with namespaced IDs, got %s, %s", posts[0].ID, posts[1].ID)
+		}
+
+		_, postMap, err := repo.GetPosts(context.Background())
+		if err != nil || postMap[string(idNote)] == nil || postMap[string(idA)] == nil {
+			t.Errorf("Expected GetPosts to merge sources, got %v (%v)", postMap, err)
+		}
+	})
+
+	t.Run("Merged list is reused until a source changes", func(t *testing.T) {
+		if !sameList(repo.GetPostList(), repo.GetPostList()) {
+			t.Error("Expected the merged list to be reused")
+		}
+
+		before := repo.GetPostList()
+		os.WriteFile(filepath.Join(notesDir, "other.md"), []byte("# Other"), 0644)
+		notesRepo.reload([]string{"other"})
+		if after := repo.GetPostList(); sameList(before, after) || len(after) != 3 {
+			t.Errorf("Expected the merged list to be rebuilt, got %d posts", len(after))
+		}
+
+		os.Remove(filepath.Join(notesDir, "other.md"))
+		notesRepo.reload([]string{"other"})
+
+		idOther := model.PostID("notes:" + util.ContentHashString("other"))
+		expectNotifications(t, notified,
+			postNotification{idOther, model.PostCreated},
+			postNotification{idOther, model.PostDeleted},
+		)
+	})
+
+	t.Run("Reads are routed by namespace", func(t *testing.T) {
+		post, err := repo.ReadPost(string(idNote))
+		if err != nil || string(post.Markdown) != "# Note" || post.ID != idNote {
+			t.Errorf("Unexpected post %+v (%v)", post, err)
+		}
+		if post, err := repo.ReadPost(string(idA)); err != nil || string(post.Markdown) != "# A" {
+			t.Errorf("Expected root post by its original ID, got %+v (%v)", post, err)
+		}
+		if _, err := repo.ReadPost("other:" + string(idA)); err == nil {
+			t.Error("Expected error for an unknown namespace")
+		}
+
+		prev, next := repo.GetAdjacentPosts(string(idA))
+		if prev == nil || prev.ID != idNote || next != nil {
+			t.Errorf("Expected adjacency across sources, got %v, %v", prev, next)
+		}
+	})
+
+	t.Run("New posts go to the writable source", func(t *testing.T) {
+		post := repo.NewPost()
+		post.Title = "Fresh"
+		post.Markdown = []byte("# Fresh")
+		if err := repo.SavePost(ctx, post); err != nil {
+			t.Fatalf("SavePost failed: %v", err)
+		}
+		if _, err := os.Stat(filepath.Join(mainDir, "fresh.md")); err != nil {
+			t.Errorf("Expected post in the writable source: %v", err)
+		}
+		if _, err := repo.ReadPost(string(post.ID)); err != nil {
+			t.Errorf("Expected the saved post to be readable by its ID: %v", err)
+		}
+		expectNotifications(t, notified, postNotification{post.ID, model.PostCreated})
+	})
+
+	t.Run("Read-only sources reject edits", func(t *testing.T) {
+		post, _ := repo.ReadPost(string(idNote))
+		post.Markdown = []byte("# Edited")
+		if err := repo.SetPostContent(ctx, post); !errors.Is(err, ErrReadOnlySource) {
+			t.Errorf("Expected ErrReadOnlySource, got %v", err)
+		}
+		if data, _ := os.ReadFile(filepath.Join(notesDir, "note.md")); string(data) != "# Note" {
+			t.Errorf("Expected the note to be unchanged, got %q", data)
+		}
+	})
+
+	t.Run("Edits are routed to their source", func(t *testing.T) {
+		post, _ := repo.ReadPost(string(idA))
+		post.Markdown = []byte("# A, edited")
+		if err := repo.SetPostContent(ctx, post); err != nil {
+			t.Fatalf("SetPostContent failed: %v", err)
+		}
+		if post.ID != idA {
+			t.Errorf("Expected the ID to be kept, got %s", post.ID)
+		}
+		if data, _ := os.ReadFile(filepath.Join(mainDir, "a.md")); string(data) != "# A, edited" {
+			t.Errorf("Expected the file to be updated, got %q", data)
+		}
+		expectNotifications(t, notified, postNotification{idA, model.PostUpdated})
+	})
+
+	t.Run("Notifications are namespaced", func(t *testing.T) {
+		os.WriteFile(filepath.Join(notesDir, "note.md"), []byte("# Note, changed on disk"), 0644)
+		notesRepo.reload([]string{"note"})
+
+		expectNotifications(t, notified, postNotification{idNote, model.PostUpdated})
+	})
+}
+
+func TestNewMultiPostRepositoryErrors(t *testing.T) {
+	ctx := context.Background()
+	newRepo := func() PostRepository { return NewFSPostRepository(t.TempDir()) }
+
+	testCases := map[string]struct {
+		sources  []PostSource
+		writable string
+	}{
+		"Invalid name": {
+			sources: []PostSource{{Name: "Not Valid", Repository: newRepo()}},
+		},
+		"Duplicate name": {
+			sources: []PostSource{{Name: "a", Repository: newRepo()}, {Name: "a", Repository: newRepo()}},
+		},
+		"Two roots": {
+			sources: []PostSource{{Name: "a", Repository: newRepo(), Root: true}, {Name: "b", Repository: newRepo(), Root: true}},
+		},
+		"Unknown writable source": {
+			sources:  []PostSource{{Name: "a", Repository: newRepo()}},
+			writable: "b",
+		},
+		"Read-only writable source": {
+			sources:  []PostSource{{Name: "a", Repository: newRepo(), ReadOnly: true}},
+			writable: "a",
+		},
+	}
+
+	for name, tc := range testCases {
+		t.Run(name, func(t *testing.T) {
+			if _, err := NewMultiPostRepository(tc.sources, tc.writable); err == nil {
+				t.Error("Expected an error")
+			}
+		})
+	}
+
+	t.Run("No writable source", func(t *testing.T) {
+		repo, err := NewMultiPostRepository([]PostSource{{Name: "a", Repository: newRepo(), ReadOnly: true}}, "")
+		if err != nil {
+			t.Fatalf("NewMultiPostRepository failed: %v", err)
+		}
+		if err := repo.SavePost(ctx, repo.NewPost()); !errors.Is(err, ErrReadOnlySource) {
+			t.Errorf("Expected ErrReadOnlySource, got %v", err)
+		}
+	})
+}
+
+func TestNewPostRepositoryFromConfigSources(t *testing.T) {
+	repo, err := NewPostRepositoryFromConfig(context.Background(), config.PostsConfig{Repository: "s3"}, config.RepositoriesConfig{
+		Writable: "db",
+		Sources: []config.PostSourceConfig{
+			{Name: "db", Type: "db", Root: true},
+			{Name: "notes", Type: "fs", ReadOnly: true, FS: config.FSConfig{Path: t.TempDir()}},
+		},
+	}, nil)
+	if err != nil {
+		t.Fatalf("Expected multi repository, got error: %v", err)
+	}
+
+	multi, ok := repo.(*MultiPostRepository)
+	if !ok {
+		t.Fatalf("Expected *MultiPostRepository, got %T", repo)
+	}
+	if len(multi.sources) != 2 || multi.writable.Name != "db" {
+		t.Errorf("Unexpected sources %+v", multi.sources)
+	}
+	if _, ok := multi.sources[1].Repository.(*FSPostRepository); !ok {
+		t.Errorf("Expected *FSPostRepository, got %T", multi.sources[1].Repository)
+	}
+
+	_, err = NewPostRepositoryFromConfig(context.Background(), config.PostsConfig{}, config.RepositoriesConfig{
+		Sources: []config.PostSourceConfig{{Name: "bad", Type: "ftp"}},
+	}, nil)
+	if err == nil {
+		t.Error("Expected error for unknown source type")
+	}
+
+	dir := t.TempDir()
+	for name, sources := range map[string][]config.PostSourceConfig{
+		"db": {
+			{Name: "posts", Type: "db", Root: true},
+			{Name: "drafts", Type: "db"},
+		},
+		"fs": {
+			{Name: "posts", Type: "fs", FS: config.FSConfig{Path: dir}},
+			{Name: "notes", Type: "fs", FS: config.FSConfig{Path: dir + "/."}},
+		},
+		"fs and git": {
+			{Name: "posts", Type: "git", Git: config.GitConfig{Path: dir}},
+			{Name: "notes", Type: "fs", FS: config.FSConfig{Path: dir}},
+		},
+	} {
+		_, err := NewPostRepositoryFromConfig(context.Background(), config.PostsConfig{}, config.RepositoriesConfig{Sources: sources}, nil)
+		if err == nil {
+			t.Errorf("Expected error for %s sources in the same place", name)
+		}
+	}
+}
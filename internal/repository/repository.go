@@ -2,31 +2,140 @@
 package repository
 
 import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
 	"time"
 
+	"github.com/debemdeboas/the-archive/internal/config"
+	"github.com/debemdeboas/the-archive/internal/db"
 	"github.com/debemdeboas/the-archive/internal/model"
+	"github.com/debemdeboas/the-archive/internal/util/s3util"
 	"github.com/rs/zerolog"
 )
 
 type PostRepository interface {
-	Init()
-	GetPosts() ([]model.Post, map[string]*model.Post, error)
+	// Start loads the posts and keeps reloading them in the background until
+	// ctx is done or Stop is called.
+	Start(ctx context.Context) error
+	// Stop stops reloading the posts and waits for the reload in progress.
+	Stop()
+
+	// GetPosts reads every post from storage, bypassing the cache.
+	GetPosts(ctx context.Context) ([]model.Post, map[string]*model.Post, error)
+
+	// GetPostList returns the cached posts, newest first. The slice is shared
+	// by every caller and must not be modified.
 	GetPostList() []model.Post
+
+	// ReadPost and GetAdjacentPosts return copies of cached posts, which
+	// callers may change.
 	ReadPost(id any) (*model.Post, error)
 	GetAdjacentPosts(id any) (prev *model.Post, next *model.Post)
-	ReloadPosts()
 
 	NewPost() *model.Post
-	SavePost(post *model.Post) error
-	SetPostContent(post *model.Post) error
+	SavePost(ctx context.Context, post *model.Post) error
+	SetPostContent(ctx context.Context, post *model.Post) error
 
-	// SetReloadNotifier sets a function that will be called when the posts are reloaded.
-	SetReloadNotifier(notifier func(model.PostID))
+	// SetReloadNotifier sets a function that will be called when a post is
+	// created, updated or deleted, whether through the repository or not.
+	SetReloadNotifier(notifier func(model.PostID, model.PostEvent))
 
 	// SetReloadTimeout sets the timeout for reloading posts.
 	SetReloadTimeout(timeout time.Duration)
 }
 
+// NewPostRepositoryFromConfig creates the post repository selected by
+// cfg.Repository, or a MultiPostRepository if repos declares any sources.
+// Sources that store their posts in the same place are rejected, since every
+// post would be listed once per source.
+func NewPostRepositoryFromConfig(ctx context.Context, cfg config.PostsConfig, repos config.RepositoriesConfig, database db.DB) (PostRepository, error) {
+	var repo PostRepository
+	if len(repos.Sources) > 0 {
+		sources := make([]PostSource, 0, len(repos.Sources))
+		locations := make(map[string]string, len(repos.Sources))
+		for _, src := range repos.Sources {
+			location := sourceLocation(src)
+			if other, ok := locations[location]; ok {
+				return nil, fmt.Errorf("post sources %s and %s store their posts in the same place", other, src.Name)
+			}
+			locations[location] = src.Name
+
+			srcRepo, err := newPostRepository(ctx, src.Type, config.PostsConfig{DB: src.DB, FS: src.FS, S3: src.S3, Git: src.Git}, database)
+			if err != nil {
+				return nil, fmt.Errorf("post source %s: %w", src.Name, err)
+			}
+			sources = append(sources, PostSource{
+				Name:       src.Name,
+				Repository: srcRepo,
+				Root:       src.Root,
+				ReadOnly:   src.ReadOnly,
+			})
+		}
+
+		multi, err := NewMultiPostRepository(sources, repos.Writable)
+		if err != nil {
+			return nil, err
+		}
+		repo = multi
+	} else {
+		var err error
+		if repo, err = newPostRepository(ctx, cfg.Repository, cfg, database); err != nil {
+			return nil, err
+		}
+	}
+
+	repo.SetReloadTimeout(time.Duration(cfg.ReloadTimeout) * time.Second)
+	return repo, nil
+}
+
+// sourceLocation identifies where a source stores its posts. Directories are
+// compared by absolute path, whether they are plain or git repositories.
+func sourceLocation(src config.PostSourceConfig) string {
+	dir := func(path string) string {
+		if abs, err := filepath.Abs(path); err == nil {
+			path = abs
+		}
+		return "dir:" + filepath.Clean(path)
+	}
+
+	switch src.Type {
+	case "fs":
+		return dir(src.FS.Path)
+	case "git":
+		return dir(src.Git.Path)
+	case "s3":
+		return "s3:" + src.S3.Endpoint + "/" + src.S3.Bucket + "/" + strings.Trim(src.S3.Prefix, "/")
+	default:
+		// Every db source reads the posts table of the same database
+		return "db"
+	}
+}
+
+func newPostRepository(ctx context.Context, kind string, cfg config.PostsConfig, database db.DB) (PostRepository, error) {
+	switch kind {
+	case "", "db":
+		repo, err := NewDBPostRepositoryFromConfig(database, cfg.DB)
+		if err != nil {
+			return nil, err
+		}
+		return repo, nil
+	case "fs":
+		return NewFSPostRepository(cfg.FS.Path), nil
+	case "s3":
+		client, err := s3util.NewClient(ctx, cfg.S3)
+		if err != nil {
+			return nil, err
+		}
+		return NewS3PostRepository(client, cfg.S3), nil
+	case "git":
+		return NewGitPostRepository(cfg.Git), nil
+	default:
+		return nil, fmt.Errorf("unknown post repository: %s", kind)
+	}
+}
+
 var repoLogger zerolog.Logger
 
 func SetLogger(logger zerolog.Logger) {
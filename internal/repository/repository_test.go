@@ -0,0 +1,63 @@
+package repository
+
+import (
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/debemdeboas/the-archive/internal/model"
+)
+
+type postNotification struct {
+	id    model.PostID
+	event model.PostEvent
+}
+
+// notifications sets the reload notifier of repo to send to the returned channel.
+func notifications(repo PostRepository) chan postNotification {
+	notified := make(chan postNotification, 100)
+	repo.SetReloadNotifier(func(id model.PostID, event model.PostEvent) {
+		notified <- postNotification{id, event}
+	})
+	return notified
+}
+
+// expectNotifications waits for the expected notifications, in any order,
+// and fails if any other arrives.
+func expectNotifications(t *testing.T, notified chan postNotification, expected ...postNotification) {
+	t.Helper()
+
+	var got []postNotification
+	timeout := time.After(time.Second)
+	for len(got) < len(expected) {
+		select {
+		case n := <-notified:
+			got = append(got, n)
+		case <-timeout:
+			t.Fatalf("Expected notifications %v, got %v", expected, got)
+		}
+	}
+
+	extra := time.After(50 * time.Millisecond)
+	for done := false; !done; {
+		select {
+		case n := <-notified:
+			got = append(got, n)
+		case <-extra:
+			done = true
+		}
+	}
+
+	compare := func(a, b postNotification) int {
+		if c := strings.Compare(string(a.id), string(b.id)); c != 0 {
+			return c
+		}
+		return int(a.event - b.event)
+	}
+	slices.SortFunc(got, compare)
+	slices.SortFunc(expected, compare)
+	if !slices.Equal(got, expected) {
+		t.Errorf("Expected notifications %v, got %v", expected, got)
+	}
+}
@@ -1,141 +1,473 @@
 package repository
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
+	"maps"
 	"slices"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
-	"github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
-	"github.com/debemdeboas/the-archive/internal/cache"
+	"github.com/aws/smithy-go"
+	"github.com/debemdeboas/the-archive/internal/config"
 	"github.com/debemdeboas/the-archive/internal/model"
+	"github.com/debemdeboas/the-archive/internal/util"
+	"github.com/debemdeboas/the-archive/internal/util/s3util"
+	"github.com/google/uuid"
+	"github.com/mmarkdown/mmark/v2/mast"
 )
 
+// Object metadata keys used to keep post data that is not part of the Markdown.
+// Objects uploaded by other tools don't have them and fall back to values
+// derived from the object key and its modification time.
+const (
+	s3MetaPostID  = "post-id"
+	s3MetaOwner   = "owner"
+	s3MetaCreated = "created"
+)
+
+var ErrPostConflict = errors.New("post was modified concurrently")
+
+type s3Object struct {
+	etag string
+	post model.Post
+	// written is the write that stored the object, 0 if it was fetched
+	written uint64
+}
+
+// S3PostRepository serves posts stored as Markdown objects in an S3-compatible bucket.
 type S3PostRepository struct { // implements PostRepository
 	client *s3.Client
+	bucket string
+	prefix string
+
+	// Page size for listing and maximum number of concurrent object downloads
+	pageSize         int32
+	fetchConcurrency int
 
-	postsCache       *cache.Cache[string, *model.Post]
-	postsCacheSorted []model.Post
+	// reloadMu serializes loads, which alone advance objects and the cache
+	reloadMu sync.Mutex
 
-	reloadNotifier func(model.PostID)
+	mu      sync.RWMutex
+	objects map[string]s3Object // keyed by object key, used to skip unchanged objects
+	writes  uint64              // number of objects written, guarded by mu
+	cache   postCache
+
+	reloadTimeout  time.Duration
+	reloadNotifier func(model.PostID, model.PostEvent)
+	loop           reloadLoop
 }
 
-func NewS3PostRepository(accessKeyID, accessKeySecret, baseEndpoint string) *S3PostRepository {
-	cfg, err := config.LoadDefaultConfig(context.TODO(),
-		config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(accessKeyID, accessKeySecret, "")),
-		config.WithRegion("auto"),
-	)
-	if err != nil {
-		repoLogger.Fatal().Err(err).Msg("Error initializing S3 client")
+func NewS3PostRepository(client *s3.Client, cfg config.S3Config) *S3PostRepository {
+	prefix := ""
+	if cfg.Prefix != "" {
+		prefix = strings.TrimSuffix(cfg.Prefix, "/") + "/"
 	}
 
-	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
-		o.BaseEndpoint = aws.String(baseEndpoint)
-	})
-
 	return &S3PostRepository{
 		client: client,
+		bucket: cfg.Bucket,
+		prefix: prefix,
+
+		pageSize:         1000,
+		fetchConcurrency: 8,
+
+		objects: make(map[string]s3Object),
 
-		postsCache: cache.NewCache[string, *model.Post](),
+		reloadTimeout: 10 * time.Second,
 	}
 }
 
-func (r *S3PostRepository) SetReloadNotifier(notifier func(model.PostID)) {
+func (r *S3PostRepository) SetReloadNotifier(notifier func(model.PostID, model.PostEvent)) {
 	r.reloadNotifier = notifier
 }
 
-func (r *S3PostRepository) notifyPostReload(postID model.PostID) {
+func (r *S3PostRepository) notifyPostReload(postID model.PostID, event model.PostEvent) {
 	if r.reloadNotifier != nil {
-		r.reloadNotifier(postID)
+		r.reloadNotifier(postID, event)
 	}
 }
 
-func (r *S3PostRepository) Init() {
-	posts, postMap, err := r.GetPosts()
-	if err != nil {
-		repoLogger.Fatal().Err(err).Msg("Error initializing posts")
+func (r *S3PostRepository) SetReloadTimeout(timeout time.Duration) {
+	r.reloadTimeout = timeout
+}
+
+func (r *S3PostRepository) Start(ctx context.Context) error {
+	if _, _, err := r.load(ctx); err != nil {
+		return fmt.Errorf("error initializing posts: %w", err)
 	}
 
-	r.postsCacheSorted = posts
-	r.postsCache.SetTo(postMap)
+	r.loop.start(ctx, func(ctx context.Context) {
+		poll(ctx, r.reloadTimeout, r.reload)
+	})
+	return nil
+}
 
-	go r.ReloadPosts()
+func (r *S3PostRepository) Stop() {
+	r.loop.stop()
 }
 
 func (r *S3PostRepository) GetPostList() []model.Post {
-	return r.postsCacheSorted
+	return r.cache.load().list()
 }
 
-func (r *S3PostRepository) GetPosts() ([]model.Post, map[string]*model.Post, error) {
-	entries, err := r.client.ListObjectsV2(context.TODO(), &s3.ListObjectsV2Input{})
+// GetPosts lists the Markdown objects in the bucket and downloads the ones
+// whose ETag changed since the last reload. It does not store them, so the
+// next reload still notifies about the changes.
+func (r *S3PostRepository) GetPosts(ctx context.Context) ([]model.Post, map[string]*model.Post, error) {
+	objects, writes, err := r.fetch(ctx)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	var posts []model.Post
-	postsMap := make(map[string]*model.Post)
-	for _, entry := range entries.Contents {
-		fmt.Println(entry)
-		// if !entry.IsDir() && strings.HasSuffix(entry.Name(), ".md") {
-		// 	name := strings.TrimSuffix(entry.Name(), ".md")
+	r.mu.RLock()
+	r.mergeWrites(objects, writes)
+	r.mu.RUnlock()
+
+	posts := postsFromObjects(objects)
+	return posts, postMap(posts), nil
+}
 
-		// 	mdContent, err := r.ReadPost(name)
-		// 	if err != nil {
-		// 		return nil, nil, err
-		// 	}
+// load fetches the objects and stores them and their posts. It returns the
+// posts and the snapshot they replaced.
+func (r *S3PostRepository) load(ctx context.Context) ([]model.Post, *postSnapshot, error) {
+	r.reloadMu.Lock()
+	defer r.reloadMu.Unlock()
 
-		// 	fileInfo, err := entry.Info()
-		// 	if err != nil {
-		// 		return nil, nil, err
-		// 	}
+	objects, writes, err := r.fetch(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
 
-		// 	post := model.Post{
-		// 		Title:         name,
-		// 		Path:          name,
-		// 		MDContentHash: util.ContentHash(mdContent),
-		// 		ModifiedDate:  fileInfo.ModTime(),
-		// 	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.mergeWrites(objects, writes)
+	r.objects = objects
+	posts := postsFromObjects(objects)
+	return posts, r.cache.store(posts), nil
+}
 
-		// 	posts = append(posts, post)
-		// 	postsMap[name] = &post
-		// }
+// fetch fetches the objects within the reload timeout. It also returns the
+// number of writes made before fetching, for mergeWrites.
+func (r *S3PostRepository) fetch(ctx context.Context) (map[string]s3Object, uint64, error) {
+	if r.reloadTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.reloadTimeout)
+		defer cancel()
 	}
 
-	slices.SortStableFunc(posts, func(a, b model.Post) int {
-		return -a.ModifiedDate.Compare(b.ModifiedDate)
-	})
+	r.mu.RLock()
+	writes := r.writes
+	r.mu.RUnlock()
 
-	return posts, postsMap, nil
+	objects, err := r.fetchObjects(ctx)
+	return objects, writes, err
 }
 
-func (r *S3PostRepository) ReadPost(path any) ([]byte, error) {
-	return path.([]byte), nil
+// mergeWrites adds the objects written after the first writes to objects.
+// Objects written while fetching may have been listed before the write or not
+// at all, and the write knows their latest ETag. r.mu must be held.
+func (r *S3PostRepository) mergeWrites(objects map[string]s3Object, writes uint64) {
+	for key, obj := range r.objects {
+		if obj.written > writes {
+			objects[key] = obj
+		}
+	}
 }
 
-func (r *S3PostRepository) ReloadPosts() {
-	for {
-		posts, postMap, err := r.GetPosts()
+func (r *S3PostRepository) fetchObjects(ctx context.Context) (map[string]s3Object, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	r.mu.RLock()
+	cached := r.objects
+	r.mu.RUnlock()
+
+	objects := make(map[string]s3Object)
+	var toFetch []string
+
+	paginator := s3.NewListObjectsV2Paginator(r.client, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(r.bucket),
+		Prefix:  aws.String(r.prefix),
+		MaxKeys: aws.Int32(r.pageSize),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
 		if err != nil {
-			repoLogger.Error().Err(err).Msg("Error reloading posts")
-		} else {
-			for _, post := range r.postsCacheSorted {
-				if newPost, ok := postMap[post.Path]; ok {
-					if newPost.MDContentHash != post.MDContentHash {
-						repoLogger.Info().
-							Str("post_id", string(post.ID)).
-							Str("title", post.Title).
-							Msg("Reloading post")
-						go r.notifyPostReload(model.PostID(post.Path))
-					}
-				}
+			return nil, fmt.Errorf("error listing posts: %w", err)
+		}
+		for _, obj := range page.Contents {
+			key := aws.ToString(obj.Key)
+			if !strings.HasSuffix(key, ".md") {
+				continue
 			}
+			etag := aws.ToString(obj.ETag)
+			if c, ok := cached[key]; ok && etag != "" && c.etag == etag {
+				objects[key] = c
+				continue
+			}
+			toFetch = append(toFetch, key)
+		}
+	}
+
+	if len(toFetch) > 0 {
+		repoLogger.Debug().Int("objects", len(toFetch)).Int("unchanged", len(objects)).Msg("Fetching changed posts from S3")
+	}
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var errs []error
+	sem := make(chan struct{}, max(1, r.fetchConcurrency))
+
+	for _, key := range toFetch {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			obj, err := r.fetchObject(ctx, key)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, err)
+				cancel()
+				return
+			}
+			objects[key] = *obj
+		}()
+	}
+	wg.Wait()
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return objects, nil
+}
+
+func (r *S3PostRepository) fetchObject(ctx context.Context, key string) (*s3Object, error) {
+	out, err := r.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(r.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error fetching post %s: %w", key, err)
+	}
+	defer out.Body.Close()
+
+	content, err := io.ReadAll(out.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading post %s: %w", key, err)
+	}
+
+	return &s3Object{
+		etag: aws.ToString(out.ETag),
+		post: r.newPostFromObject(key, content, out.Metadata, aws.ToTime(out.LastModified)),
+	}, nil
+}
+
+func (r *S3PostRepository) newPostFromObject(key string, content []byte, metadata map[string]string, modTime time.Time) model.Post {
+	name := strings.TrimSuffix(strings.TrimPrefix(key, r.prefix), ".md")
+
+	info, err := util.GetFrontMatter(content)
+	if err != nil {
+		info = &util.ExtendedTitleData{
+			TitleData: &mast.TitleData{
+				Title: name,
+			},
+			ToolbarTitle: name,
+		}
+	}
+
+	post := model.Post{
+		ID:            model.PostID(util.ContentHashString(name)),
+		Title:         name,
+		Path:          name,
+		Markdown:      content,
+		MDContentHash: util.MarkdownHash(content),
+		CreatedDate:   modTime,
+		ModifiedDate:  modTime,
+		Info:          info,
+		Owner:         model.UserID(metadata[s3MetaOwner]),
+	}
+
+	if info.Title != "" {
+		post.Title = info.Title
+	}
+	if id := metadata[s3MetaPostID]; id != "" {
+		post.ID = model.PostID(id)
+	}
+	if created, err := time.Parse(time.RFC3339Nano, metadata[s3MetaCreated]); err == nil {
+		post.CreatedDate = created
+	}
+
+	return post
+}
+
+func postsFromObjects(objects map[string]s3Object) []model.Post {
+	posts := make([]model.Post, 0, len(objects))
+	for _, obj := range objects {
+		posts = append(posts, obj.post)
+	}
+
+	slices.SortStableFunc(posts, func(a, b model.Post) int {
+		if c := -a.ModifiedDate.Compare(b.ModifiedDate); c != 0 {
+			return c
+		}
+		return strings.Compare(string(a.ID), string(b.ID))
+	})
+	return posts
+}
+
+func (r *S3PostRepository) ReadPost(id any) (*model.Post, error) {
+	post, ok := r.cache.load().get(id.(string))
+	if !ok {
+		return nil, fmt.Errorf("post not found: %s", id)
+	}
+	return post, nil
+}
 
-			r.postsCacheSorted = posts
-			r.postsCache.SetTo(postMap)
+func (r *S3PostRepository) GetAdjacentPosts(id any) (prev *model.Post, next *model.Post) {
+	return r.cache.load().adjacent(id.(string))
+}
+
+// reload refreshes the cache and notifies about posts that were created,
+// changed or removed.
+func (r *S3PostRepository) reload(ctx context.Context) {
+	posts, previous, err := r.load(ctx)
+	if err != nil {
+		repoLogger.Error().Err(err).Msg("Error reloading posts")
+		return
+	}
+	if previous == nil {
+		// Nothing changed, the posts were just loaded
+		return
+	}
+	current := postMap(posts)
+
+	for _, post := range posts {
+		cached, ok := previous.get(string(post.ID))
+		if !ok {
+			repoLogger.Info().
+				Str("post_id", string(post.ID)).
+				Str("title", post.Title).
+				Msg("New post detected")
+			go r.notifyPostReload(post.ID, model.PostCreated)
+			continue
+		}
+		if cached.MDContentHash != post.MDContentHash {
+			repoLogger.Info().
+				Str("post_id", string(post.ID)).
+				Str("title", post.Title).
+				Msg("Reloading post")
+			go r.notifyPostReload(post.ID, model.PostUpdated)
 		}
-		time.Sleep(5 * time.Second)
 	}
+	for _, post := range previous.list() {
+		if _, ok := current[string(post.ID)]; !ok {
+			repoLogger.Info().
+				Str("post_id", string(post.ID)).
+				Str("title", post.Title).
+				Msg("Post removed")
+			go r.notifyPostReload(post.ID, model.PostDeleted)
+		}
+	}
+}
+
+func (r *S3PostRepository) NewPost() *model.Post {
+	now := time.Now().UTC()
+
+	return &model.Post{
+		ID: model.PostID(uuid.New().String()),
+
+		CreatedDate:  now,
+		ModifiedDate: now,
+	}
+}
+
+// SavePost uploads a new post. It fails if an object already exists for the post.
+func (r *S3PostRepository) SavePost(ctx context.Context, post *model.Post) error {
+	key := s3util.Key(r.prefix, string(post.ID)+".md")
+	if err := r.putPost(ctx, key, post, &s3.PutObjectInput{IfNoneMatch: aws.String("*")}); err != nil {
+		return err
+	}
+
+	go r.notifyPostReload(post.ID, model.PostCreated)
+	return nil
+}
+
+// SetPostContent uploads the new content of an existing post. The write is
+// conditional on the object not having changed since it was last read.
+func (r *S3PostRepository) SetPostContent(ctx context.Context, post *model.Post) error {
+	r.mu.RLock()
+	key, etag, ok := "", "", false
+	for k, obj := range r.objects {
+		if obj.post.ID == post.ID {
+			key, etag, ok = k, obj.etag, true
+			break
+		}
+	}
+	r.mu.RUnlock()
+
+	if !ok {
+		return fmt.Errorf("post not found: %s", post.ID)
+	}
+
+	post.ModifiedDate = time.Now().UTC()
+	if err := r.putPost(ctx, key, post, &s3.PutObjectInput{IfMatch: aws.String(etag)}); err != nil {
+		return err
+	}
+
+	go r.notifyPostReload(post.ID, model.PostUpdated)
+	return nil
+}
+
+func (r *S3PostRepository) putPost(ctx context.Context, key string, post *model.Post, input *s3.PutObjectInput) error {
+	input.Bucket = aws.String(r.bucket)
+	input.Key = aws.String(key)
+	input.Body = bytes.NewReader(post.Markdown)
+	input.ContentLength = aws.Int64(int64(len(post.Markdown)))
+	input.ContentType = aws.String("text/markdown; charset=utf-8")
+	input.Metadata = map[string]string{
+		s3MetaPostID:  string(post.ID),
+		s3MetaOwner:   string(post.Owner),
+		s3MetaCreated: post.CreatedDate.UTC().Format(time.RFC3339Nano),
+	}
+
+	out, err := r.client.PutObject(ctx, input)
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "PreconditionFailed" {
+			return fmt.Errorf("error saving post %s: %w", post.ID, ErrPostConflict)
+		}
+		return fmt.Errorf("error saving post %s: %w", post.ID, err)
+	}
+
+	post.MDContentHash = util.MarkdownHash(post.Markdown)
+	if info, err := util.GetFrontMatter(post.Markdown); err == nil {
+		post.Info = info
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// The map may be read by a concurrent reload, so replace it instead of
+	// writing to it. The post is copied so later changes by the caller don't
+	// leak into the cache.
+	r.writes++
+	objects := maps.Clone(r.objects)
+	objects[key] = s3Object{etag: aws.ToString(out.ETag), post: *post, written: r.writes}
+	r.objects = objects
+	r.cache.store(postsFromObjects(objects))
+
+	repoLogger.Debug().Str("post_id", string(post.ID)).Str("key", key).Msg("Post saved to S3")
+
+	return nil
 }
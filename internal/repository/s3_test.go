@@ -0,0 +1,314 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/debemdeboas/the-archive/internal/config"
+	"github.com/debemdeboas/the-archive/internal/model"
+	"github.com/debemdeboas/the-archive/internal/s3test"
+	"github.com/debemdeboas/the-archive/internal/util/s3util"
+)
+
+const testPostsBucket = "posts"
+
+func newTestS3PostRepository(t *testing.T, srv *s3test.Server) *S3PostRepository {
+	t.Helper()
+	cfg := srv.Config(testPostsBucket)
+	cfg.Prefix = "content"
+
+	client, err := s3util.NewClient(context.Background(), cfg)
+	if err != nil {
+		t.Fatalf("Failed to create S3 client: %v", err)
+	}
+	return NewS3PostRepository(client, cfg)
+}
+
+func TestS3PostRepositoryGetPosts(t *testing.T) {
+	srv := s3test.NewServer(t, testPostsBucket)
+	base := time.Now().UTC().Add(-time.Hour)
+	for i := range 5 {
+		srv.PutObjectAt(testPostsBucket, fmt.Sprintf("content/post-%d.md", i), []byte(fmt.Sprintf("# Post %d", i)), base.Add(time.Duration(i)*time.Minute))
+	}
+	srv.PutObject(testPostsBucket, "content/image.png", []byte("not a post"))
+	srv.PutObject(testPostsBucket, "elsewhere/post.md", []byte("outside the prefix"))
+
+	repo := newTestS3PostRepository(t, srv)
+	repo.pageSize = 2
+
+	posts, postMap, err := repo.GetPosts(context.Background())
+	if err != nil {
+		t.Fatalf("GetPosts failed: %v", err)
+	}
+	if len(posts) != 5 || len(postMap) != 5 {
+		t.Fatalf("Expected 5 posts, got %d", len(posts))
+	}
+	if list := repo.GetPostList(); len(list) != 0 {
+		t.Errorf("Expected GetPosts to leave the post list to reloads, got %d posts", len(list))
+	}
+	if lists := srv.Count("ListObjectsV2"); lists != 3 {
+		t.Errorf("Expected 3 list pages, got %d", lists)
+	}
+
+	// Sorted by modification date, newest first
+	if posts[0].Path != "post-4" || posts[4].Path != "post-0" {
+		t.Errorf("Unexpected order: %s ... %s", posts[0].Path, posts[4].Path)
+	}
+	if string(posts[0].Markdown) != "# Post 4" || posts[0].MDContentHash == "" {
+		t.Errorf("Unexpected post %+v", posts[0])
+	}
+	if postMap[string(posts[0].ID)] != &posts[0] {
+		t.Error("Expected the map to point into the sorted list")
+	}
+
+	t.Run("Unchanged objects are not downloaded again", func(t *testing.T) {
+		repo.reload(context.Background())
+		gets := srv.Count("GetObject")
+		if _, _, err := repo.GetPosts(context.Background()); err != nil {
+			t.Fatalf("GetPosts failed: %v", err)
+		}
+		if got := srv.Count("GetObject"); got != gets {
+			t.Errorf("Expected no downloads, got %d", got-gets)
+		}
+
+		srv.PutObject(testPostsBucket, "content/post-2.md", []byte("# Post 2, edited"))
+		posts, _, err := repo.GetPosts(context.Background())
+		if err != nil {
+			t.Fatalf("GetPosts failed: %v", err)
+		}
+		if got := srv.Count("GetObject"); got != gets+1 {
+			t.Errorf("Expected a single download, got %d", got-gets)
+		}
+		if string(posts[0].Markdown) != "# Post 2, edited" {
+			t.Errorf("Expected the edited post first, got %q", posts[0].Markdown)
+		}
+	})
+
+	t.Run("Deleted objects are dropped", func(t *testing.T) {
+		srv.DeleteObject(testPostsBucket, "content/post-0.md")
+		posts, _, err := repo.GetPosts(context.Background())
+		if err != nil {
+			t.Fatalf("GetPosts failed: %v", err)
+		}
+		if len(posts) != 4 {
+			t.Errorf("Expected 4 posts, got %d", len(posts))
+		}
+	})
+}
+
+func TestS3PostRepositoryReload(t *testing.T) {
+	srv := s3test.NewServer(t, testPostsBucket)
+	srv.PutObject(testPostsBucket, "content/a.md", []byte("# A"))
+	srv.PutObject(testPostsBucket, "content/b.md", []byte("# B"))
+
+	repo := newTestS3PostRepository(t, srv)
+	repo.reload(context.Background())
+
+	notified := notifications(repo)
+
+	srv.PutObject(testPostsBucket, "content/b.md", []byte("# B, edited"))
+	srv.PutObject(testPostsBucket, "content/c.md", []byte("# C"))
+	// Reading the posts leaves the changes to the reload
+	if posts, _, err := repo.GetPosts(context.Background()); err != nil || len(posts) != 3 {
+		t.Fatalf("Expected GetPosts to see 3 posts, got %d (%v)", len(posts), err)
+	}
+	repo.reload(context.Background())
+
+	posts := repo.GetPostList()
+	if len(posts) != 3 {
+		t.Fatalf("Expected 3 posts after reload, got %d", len(posts))
+	}
+
+	ids := make(map[string]model.PostID)
+	for i := range posts {
+		ids[posts[i].Path] = posts[i].ID
+	}
+	if edited, _ := repo.ReadPost(string(ids["b"])); edited == nil || string(edited.Markdown) != "# B, edited" {
+		t.Fatalf("Expected the edited post to be reloaded, got %+v", edited)
+	}
+	expectNotifications(t, notified,
+		postNotification{ids["b"], model.PostUpdated},
+		postNotification{ids["c"], model.PostCreated},
+	)
+
+	srv.DeleteObject(testPostsBucket, "content/a.md")
+	repo.reload(context.Background())
+
+	if posts := repo.GetPostList(); len(posts) != 2 {
+		t.Fatalf("Expected 2 posts after reload, got %d", len(posts))
+	}
+	expectNotifications(t, notified, postNotification{ids["a"], model.PostDeleted})
+}
+
+func TestS3PostRepositoryWriteBack(t *testing.T) {
+	ctx := context.Background()
+	srv := s3test.NewServer(t, testPostsBucket)
+	repo := newTestS3PostRepository(t, srv)
+	repo.reload(context.Background())
+
+	post := repo.NewPost()
+	post.Owner = "admin"
+	post.Markdown = []byte("%%%\ntitle = \"Hello\"\n%%%\n\nHello from S3")
+
+	if err := repo.SavePost(ctx, post); err != nil {
+		t.Fatalf("SavePost failed: %v", err)
+	}
+	data, ok := srv.Object(testPostsBucket, "content/"+string(post.ID)+".md")
+	if !ok || string(data) != string(post.Markdown) {
+		t.Fatalf("Expected post to be uploaded, got %q", data)
+	}
+	if _, err := repo.ReadPost(string(post.ID)); err != nil {
+		t.Errorf("Expected saved post to be readable immediately: %v", err)
+	}
+
+	t.Run("Saving the same post twice fails", func(t *testing.T) {
+		if err := repo.SavePost(ctx, post); err == nil {
+			t.Error("Expected error saving an existing post")
+		}
+	})
+
+	t.Run("Metadata survives a fresh load", func(t *testing.T) {
+		fresh := newTestS3PostRepository(t, srv)
+		fresh.reload(context.Background())
+
+		loaded, err := fresh.ReadPost(string(post.ID))
+		if err != nil {
+			t.Fatalf("Expected post to be found by its ID: %v", err)
+		}
+		if loaded.Owner != "admin" || loaded.Title != "Hello" {
+			t.Errorf("Unexpected post %+v", loaded)
+		}
+		if !loaded.CreatedDate.Equal(post.CreatedDate) {
+			t.Errorf("Expected created date %v, got %v", post.CreatedDate, loaded.CreatedDate)
+		}
+	})
+
+	t.Run("SetPostContent updates the object", func(t *testing.T) {
+		post.Markdown = []byte("Updated content")
+		if err := repo.SetPostContent(ctx, post); err != nil {
+			t.Fatalf("SetPostContent failed: %v", err)
+		}
+		data, _ := srv.Object(testPostsBucket, "content/"+string(post.ID)+".md")
+		if string(data) != "Updated content" {
+			t.Errorf("Expected updated object, got %q", data)
+		}
+
+		cached, _ := repo.ReadPost(string(post.ID))
+		if string(cached.Markdown) != "Updated content" {
+			t.Errorf("Expected cache to be updated, got %q", cached.Markdown)
+		}
+	})
+
+	t.Run("Concurrent modification is detected", func(t *testing.T) {
+		srv.PutObject(testPostsBucket, "content/"+string(post.ID)+".md", []byte("Changed elsewhere"))
+
+		post.Markdown = []byte("Stale edit")
+		err := repo.SetPostContent(ctx, post)
+		if !errors.Is(err, ErrPostConflict) {
+			t.Errorf("Expected ErrPostConflict, got %v", err)
+		}
+		data, _ := srv.Object(testPostsBucket, "content/"+string(post.ID)+".md")
+		if !strings.Contains(string(data), "Changed elsewhere") {
+			t.Errorf("Expected the other change to be kept, got %q", data)
+		}
+	})
+
+	t.Run("Unknown post", func(t *testing.T) {
+		if err := repo.SetPostContent(ctx, &model.Post{ID: "missing"}); err == nil {
+			t.Error("Expected error updating an unknown post")
+		}
+	})
+}
+
+func TestS3PostRepositoryWriteDuringReload(t *testing.T) {
+	ctx := context.Background()
+	srv := s3test.NewServer(t, testPostsBucket)
+	srv.PutObject(testPostsBucket, "content/other.md", []byte("# Other"))
+	repo := newTestS3PostRepository(t, srv)
+
+	post := repo.NewPost()
+	post.Markdown = []byte("First")
+	if err := repo.SavePost(ctx, post); err != nil {
+		t.Fatalf("SavePost failed: %v", err)
+	}
+
+	// Save the post again once the reload listed it, before it stores what
+	// it listed
+	srv.PutObject(testPostsBucket, "content/other.md", []byte("# Other, edited"))
+	var once sync.Once
+	srv.SetHook(func(r *http.Request) {
+		if r.Method != http.MethodGet || !strings.HasSuffix(r.URL.Path, "/other.md") {
+			return
+		}
+		once.Do(func() {
+			post.Markdown = []byte("Second")
+			if err := repo.SetPostContent(ctx, post); err != nil {
+				t.Errorf("SetPostContent failed: %v", err)
+			}
+		})
+	})
+	repo.reload(ctx)
+	srv.SetHook(nil)
+
+	if cached, _ := repo.ReadPost(string(post.ID)); cached == nil || string(cached.Markdown) != "Second" {
+		t.Errorf("Expected the concurrent write to be kept, got %+v", cached)
+	}
+	post.Markdown = []byte("Third")
+	if err := repo.SetPostContent(ctx, post); err != nil {
+		t.Errorf("Expected the ETag of the concurrent write to be kept, got %v", err)
+	}
+}
+
+func TestS3PostRepositoryGetPostsTimeout(t *testing.T) {
+	srv := s3test.NewServer(t, testPostsBucket)
+	repo := newTestS3PostRepository(t, srv)
+	repo.SetReloadTimeout(50 * time.Millisecond)
+
+	release := make(chan struct{})
+	defer close(release)
+	srv.SetHook(func(*http.Request) { <-release })
+
+	done := make(chan error, 1)
+	go func() {
+		_, _, err := repo.GetPosts(context.Background())
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("Expected the reload timeout to expire, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("GetPosts hung on an unresponsive endpoint")
+	}
+}
+
+func TestNewPostRepositoryFromConfig(t *testing.T) {
+	srv := s3test.NewServer(t, testPostsBucket)
+
+	repo, err := NewPostRepositoryFromConfig(context.Background(), config.PostsConfig{Repository: "s3", S3: srv.Config(testPostsBucket)}, config.RepositoriesConfig{}, nil)
+	if err != nil {
+		t.Fatalf("Expected s3 repository, got error: %v", err)
+	}
+	if _, ok := repo.(*S3PostRepository); !ok {
+		t.Errorf("Expected *S3PostRepository, got %T", repo)
+	}
+
+	repo, err = NewPostRepositoryFromConfig(context.Background(), config.PostsConfig{Repository: "db"}, config.RepositoriesConfig{}, nil)
+	if err != nil {
+		t.Fatalf("Expected db repository, got error: %v", err)
+	}
+	if _, ok := repo.(*DBPostRepository); !ok {
+		t.Errorf("Expected *DBPostRepository, got %T", repo)
+	}
+
+	if _, err := NewPostRepositoryFromConfig(context.Background(), config.PostsConfig{Repository: "ftp"}, config.RepositoriesConfig{}, nil); err == nil {
+		t.Error("Expected error for unknown repository")
+	}
+}
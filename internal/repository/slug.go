@@ -0,0 +1,380 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"github.com/debemdeboas/the-archive/internal/db"
+	"github.com/debemdeboas/the-archive/internal/model"
+	"github.com/debemdeboas/the-archive/internal/util"
+)
+
+var ErrSlugNotFound = errors.New("slug not found")
+
+// PostSlug is the current slug of a post and the base it was derived from.
+type PostSlug struct {
+	Slug string
+	Base string
+}
+
+// SlugRepository stores the slugs used in post URLs. Each post has one
+// current slug; the slugs it had before are kept so old URLs keep working.
+type SlugRepository interface {
+	// GetSlugs returns the current slug of every post.
+	GetSlugs(ctx context.Context) (map[model.PostID]PostSlug, error)
+
+	// ResolveSlug returns the post a slug belongs to and whether it is the
+	// post's current slug.
+	ResolveSlug(ctx context.Context, slug string) (model.PostID, bool, error)
+
+	// SetSlug makes base the current slug of a post, or base with the first
+	// numeric suffix not used by another post, and returns it.
+	SetSlug(ctx context.Context, postID model.PostID, base string) (string, error)
+}
+
+type DBSlugRepository struct { // implements SlugRepository
+	db db.DB
+}
+
+func NewDBSlugRepository(db db.DB) *DBSlugRepository {
+	return &DBSlugRepository{db: db}
+}
+
+func (r *DBSlugRepository) GetSlugs(ctx context.Context) (map[model.PostID]PostSlug, error) {
+	rows, err := r.db.QueryContext(ctx, `SELECT post_id, slug, base FROM post_slugs WHERE is_current = 1`)
+	if err != nil {
+		return nil, fmt.Errorf("error querying slugs: %w", err)
+	}
+	defer rows.Close()
+
+	slugs := make(map[model.PostID]PostSlug)
+	for rows.Next() {
+		var postID model.PostID
+		var slug PostSlug
+		if err := rows.Scan(&postID, &slug.Slug, &slug.Base); err != nil {
+			return nil, fmt.Errorf("error scanning slug: %w", err)
+		}
+		slugs[postID] = slug
+	}
+	return slugs, rows.Err()
+}
+
+func (r *DBSlugRepository) ResolveSlug(ctx context.Context, slug string) (model.PostID, bool, error) {
+	var postID model.PostID
+	var current bool
+	err := r.db.QueryRowContext(ctx, `SELECT post_id, is_current FROM post_slugs WHERE slug = ?`, slug).Scan(&postID, &current)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, ErrSlugNotFound
+	} else if err != nil {
+		return "", false, fmt.Errorf("error resolving slug: %w", err)
+	}
+	return postID, current, nil
+}
+
+func (r *DBSlugRepository) SetSlug(ctx context.Context, postID model.PostID, base string) (string, error) {
+	var slug string
+	err := r.db.WithTx(ctx, func(tx *db.Tx) error {
+		// Slugs are never reused by another post, not even old ones, so that a
+		// redirect never starts pointing somewhere else
+		for i := 1; slug == ""; i++ {
+			candidate := base
+			if i > 1 {
+				candidate = base + "-" + strconv.Itoa(i)
+			}
+
+			var owner model.PostID
+			err := tx.QueryRow(`SELECT post_id FROM post_slugs WHERE slug = ?`, candidate).Scan(&owner)
+			if errors.Is(err, sql.ErrNoRows) || (err == nil && owner == postID) {
+				slug = candidate
+			} else if err != nil {
+				return err
+			}
+		}
+
+		if _, err := tx.Exec(`UPDATE post_slugs SET is_current = 0 WHERE post_id = ? AND slug != ?`, postID, slug); err != nil {
+			return err
+		}
+		_, err := tx.Exec(
+			`INSERT INTO post_slugs (slug, post_id, is_current, base) VALUES (?, ?, 1, ?) ON CONFLICT (slug) DO UPDATE SET is_current = 1, base = excluded.base`,
+			slug, postID, base,
+		)
+		return err
+	})
+	if err != nil {
+		return "", fmt.Errorf("error setting slug: %w", err)
+	}
+	return slug, nil
+}
+
+// postSlugBase returns the slug a post asks for: the slug front matter key if
+// set, otherwise its title.
+func postSlugBase(post *model.Post) string {
+	slug := ""
+	if info, err := util.GetFrontMatter(post.Markdown); err == nil && info.Slug != "" {
+		slug = util.Slugify(info.Slug)
+	}
+	if slug == "" {
+		slug = util.Slugify(post.Title)
+	}
+	if slug == "" {
+		slug = "post"
+	}
+	return slug
+}
+
+type slugEntry struct {
+	slug string
+	base string
+
+	// source identifies the title and content the base was derived from, so
+	// it is only derived again when either changes.
+	source string
+}
+
+// SluggedPostRepository assigns slugs to the posts of another repository and
+// lets them be read by slug as well as by ID. Slugs are assigned when the
+// repository starts, when posts are saved and when the other repository
+// reloads them; reading posts never writes slugs.
+type SluggedPostRepository struct { // implements PostRepository
+	PostRepository
+	slugs SlugRepository
+
+	mu     sync.RWMutex
+	byPost map[model.PostID]slugEntry
+	byName map[string]model.PostID
+
+	// changes counts slug changes, guarded by mu
+	changes uint64
+
+	// list caches the slugged post list until the inner list or a slug changes
+	list atomic.Pointer[sluggedList]
+
+	reloadNotifier func(model.PostID, model.PostEvent)
+}
+
+type sluggedList struct {
+	inner   []model.Post
+	changes uint64
+	posts   []model.Post
+}
+
+func NewSluggedPostRepository(repo PostRepository, slugs SlugRepository) *SluggedPostRepository {
+	r := &SluggedPostRepository{
+		PostRepository: repo,
+		slugs:          slugs,
+		byPost:         make(map[model.PostID]slugEntry),
+		byName:         make(map[string]model.PostID),
+	}
+	repo.SetReloadNotifier(r.handleReload)
+	return r
+}
+
+func (r *SluggedPostRepository) SetReloadNotifier(notifier func(model.PostID, model.PostEvent)) {
+	r.reloadNotifier = notifier
+}
+
+// handleReload assigns a slug to posts the other repository created or
+// changed before passing its notifications on.
+func (r *SluggedPostRepository) handleReload(postID model.PostID, event model.PostEvent) {
+	if event != model.PostDeleted {
+		if post, err := r.PostRepository.ReadPost(string(postID)); err == nil {
+			r.ensureSlug(context.Background(), post)
+		}
+	}
+	if r.reloadNotifier != nil {
+		r.reloadNotifier(postID, event)
+	}
+}
+
+func (r *SluggedPostRepository) Start(ctx context.Context) error {
+	current, err := r.slugs.GetSlugs(ctx)
+	if err != nil {
+		repoLogger.Error().Err(err).Msg("Error loading post slugs")
+	}
+
+	r.mu.Lock()
+	for postID, slug := range current {
+		r.byPost[postID] = slugEntry{slug: slug.Slug, base: slug.Base}
+		r.byName[slug.Slug] = postID
+	}
+	r.mu.Unlock()
+
+	if err := r.PostRepository.Start(ctx); err != nil {
+		return err
+	}
+
+	// Assign slugs up front so every post can be found by slug right away
+	for _, post := range r.PostRepository.GetPostList() {
+		r.ensureSlug(ctx, &post)
+	}
+	return nil
+}
+
+// slugOf returns the slug assigned to a post, or "" if it has none yet.
+func (r *SluggedPostRepository) slugOf(postID model.PostID) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.byPost[postID].slug
+}
+
+// ensureSlug returns the slug of a post, assigning a new one if the post is
+// new or its title or slug front matter changed.
+func (r *SluggedPostRepository) ensureSlug(ctx context.Context, post *model.Post) string {
+	source := post.MDContentHash + "\x00" + post.Title
+
+	r.mu.RLock()
+	entry, ok := r.byPost[post.ID]
+	r.mu.RUnlock()
+
+	if ok && entry.source == source {
+		return entry.slug
+	}
+
+	base := postSlugBase(post)
+	if ok && entry.base == base {
+		// The content changed, but not what the slug is derived from
+		r.mu.Lock()
+		if current, ok := r.byPost[post.ID]; ok && current.slug == entry.slug {
+			r.byPost[post.ID] = slugEntry{slug: entry.slug, base: base, source: source}
+		}
+		r.mu.Unlock()
+		return entry.slug
+	}
+
+	slug, err := r.slugs.SetSlug(ctx, post.ID, base)
+	if err != nil {
+		repoLogger.Error().Err(err).Str("post_id", string(post.ID)).Msg("Error assigning post slug")
+		return entry.slug
+	}
+
+	r.mu.Lock()
+	if entry.slug != slug {
+		delete(r.byName, entry.slug)
+	}
+	r.byPost[post.ID] = slugEntry{slug: slug, base: base, source: source}
+	r.byName[slug] = post.ID
+	r.changes++
+	r.mu.Unlock()
+
+	if entry.slug != "" && entry.slug != slug {
+		repoLogger.Info().
+			Str("post_id", string(post.ID)).
+			Str("old_slug", entry.slug).
+			Str("slug", slug).
+			Msg("Post slug changed")
+	}
+	return slug
+}
+
+// withSlugs returns a copy of posts with their slugs set.
+func (r *SluggedPostRepository) withSlugs(posts []model.Post) []model.Post {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	slugged := make([]model.Post, len(posts))
+	for i, post := range posts {
+		post.Slug = r.byPost[post.ID].slug
+		slugged[i] = post
+	}
+	return slugged
+}
+
+func (r *SluggedPostRepository) GetPosts(ctx context.Context) ([]model.Post, map[string]*model.Post, error) {
+	posts, _, err := r.PostRepository.GetPosts(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	posts = r.withSlugs(posts)
+	return posts, postMap(posts), nil
+}
+
+func (r *SluggedPostRepository) GetPostList() []model.Post {
+	r.mu.RLock()
+	changes := r.changes
+	r.mu.RUnlock()
+
+	inner := r.PostRepository.GetPostList()
+	if l := r.list.Load(); l != nil && l.changes == changes && sameList(l.inner, inner) {
+		return l.posts
+	}
+
+	posts := r.withSlugs(inner)
+	r.list.Store(&sluggedList{inner: inner, changes: changes, posts: posts})
+	return posts
+}
+
+// ReadPost reads a post by ID or by its current slug.
+func (r *SluggedPostRepository) ReadPost(id any) (*model.Post, error) {
+	post, err := r.PostRepository.ReadPost(id)
+	if err != nil {
+		r.mu.RLock()
+		postID, ok := r.byName[id.(string)]
+		r.mu.RUnlock()
+		if !ok {
+			return nil, err
+		}
+		if post, err = r.PostRepository.ReadPost(string(postID)); err != nil {
+			return nil, err
+		}
+	}
+
+	if slug := r.slugOf(post.ID); post.Slug != slug {
+		p := *post
+		p.Slug = slug
+		post = &p
+	}
+	return post, nil
+}
+
+func (r *SluggedPostRepository) GetAdjacentPosts(id any) (prev *model.Post, next *model.Post) {
+	if post, err := r.ReadPost(id); err == nil {
+		id = string(post.ID)
+	}
+
+	prev, next = r.PostRepository.GetAdjacentPosts(id)
+	if prev != nil {
+		p := *prev
+		p.Slug = r.slugOf(p.ID)
+		prev = &p
+	}
+	if next != nil {
+		n := *next
+		n.Slug = r.slugOf(n.ID)
+		next = &n
+	}
+	return prev, next
+}
+
+func (r *SluggedPostRepository) SavePost(ctx context.Context, post *model.Post) error {
+	if err := r.PostRepository.SavePost(ctx, post); err != nil {
+		return err
+	}
+	post.Slug = r.ensureSlug(ctx, post)
+	return nil
+}
+
+func (r *SluggedPostRepository) SetPostContent(ctx context.Context, post *model.Post) error {
+	if err := r.PostRepository.SetPostContent(ctx, post); err != nil {
+		return err
+	}
+	post.Slug = r.ensureSlug(ctx, post)
+	return nil
+}
+
+// Redirect returns the current slug of the post that used to have slug, so
+// that old URLs can be redirected after a post's title changes.
+func (r *SluggedPostRepository) Redirect(ctx context.Context, slug string) (string, bool) {
+	postID, current, err := r.slugs.ResolveSlug(ctx, slug)
+	if err != nil || current {
+		return "", false
+	}
+
+	newSlug := r.slugOf(postID)
+	return newSlug, newSlug != ""
+}
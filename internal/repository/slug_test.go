@@ -0,0 +1,234 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+
+	"github.com/debemdeboas/the-archive/internal/model"
+	"github.com/debemdeboas/the-archive/internal/util"
+)
+
+func setupSlugTestDB(t *testing.T) *testDB {
+	t.Helper()
+	testDB, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+	t.Cleanup(func() { testDB.Close() })
+
+	_, err = testDB.Exec(`
+		CREATE TABLE post_slugs (
+			slug TEXT PRIMARY KEY,
+			post_id TEXT NOT NULL,
+			is_current INTEGER NOT NULL DEFAULT 0,
+			base TEXT NOT NULL DEFAULT '',
+			created_at DATETIME DEFAULT CURRENT_TIMESTAMP
+		);
+	`)
+	if err != nil {
+		t.Fatalf("Failed to create slug table: %v", err)
+	}
+	return testDB
+}
+
+func TestDBSlugRepository(t *testing.T) {
+	ctx := context.Background()
+	repo := NewDBSlugRepository(setupSlugTestDB(t))
+
+	slug, err := repo.SetSlug(ctx, "post-1", "hello")
+	if err != nil || slug != "hello" {
+		t.Fatalf("Expected slug hello, got %q (%v)", slug, err)
+	}
+
+	t.Run("Slugs are unique per post", func(t *testing.T) {
+		slug, err := repo.SetSlug(ctx, "post-2", "hello")
+		if err != nil || slug != "hello-2" {
+			t.Fatalf("Expected slug hello-2, got %q (%v)", slug, err)
+		}
+
+		// Assigning the same base again keeps the suffix
+		if slug, _ := repo.SetSlug(ctx, "post-2", "hello"); slug != "hello-2" {
+			t.Errorf("Expected slug to be stable, got %q", slug)
+		}
+	})
+
+	t.Run("Old slugs are kept", func(t *testing.T) {
+		if slug, _ := repo.SetSlug(ctx, "post-1", "goodbye"); slug != "goodbye" {
+			t.Fatalf("Expected slug goodbye, got %q", slug)
+		}
+
+		postID, current, err := repo.ResolveSlug(ctx, "hello")
+		if err != nil || postID != "post-1" || current {
+			t.Errorf("Expected hello to be an old slug of post-1, got %q %v (%v)", postID, current, err)
+		}
+		postID, current, _ = repo.ResolveSlug(ctx, "goodbye")
+		if postID != "post-1" || !current {
+			t.Errorf("Expected goodbye to be the current slug of post-1, got %q %v", postID, current)
+		}
+
+		// Old slugs are not given to other posts
+		if slug, _ := repo.SetSlug(ctx, "post-3", "hello"); slug != "hello-3" {
+			t.Errorf("Expected slug hello-3, got %q", slug)
+		}
+	})
+
+	t.Run("Reverting restores an old slug", func(t *testing.T) {
+		if slug, _ := repo.SetSlug(ctx, "post-1", "hello"); slug != "hello" {
+			t.Fatalf("Expected slug hello, got %q", slug)
+		}
+		if _, current, _ := repo.ResolveSlug(ctx, "goodbye"); current {
+			t.Error("Expected goodbye to no longer be current")
+		}
+	})
+
+	t.Run("GetSlugs returns current slugs", func(t *testing.T) {
+		slugs, err := repo.GetSlugs(ctx)
+		if err != nil {
+			t.Fatalf("GetSlugs failed: %v", err)
+		}
+		expected := map[model.PostID]PostSlug{
+			"post-1": {Slug: "hello", Base: "hello"},
+			"post-2": {Slug: "hello-2", Base: "hello"},
+			"post-3": {Slug: "hello-3", Base: "hello"},
+		}
+		if len(slugs) != len(expected) {
+			t.Fatalf("Expected %d slugs, got %v", len(expected), slugs)
+		}
+		for postID, slug := range expected {
+			if slugs[postID] != slug {
+				t.Errorf("Expected %s to have slug %+v, got %+v", postID, slug, slugs[postID])
+			}
+		}
+	})
+
+	t.Run("Unknown slug", func(t *testing.T) {
+		if _, _, err := repo.ResolveSlug(ctx, "missing"); !errors.Is(err, ErrSlugNotFound) {
+			t.Errorf("Expected ErrSlugNotFound, got %v", err)
+		}
+	})
+}
+
+// countingSlugRepository counts the slugs assigned through it.
+type countingSlugRepository struct {
+	SlugRepository
+	sets atomic.Int32
+}
+
+func (r *countingSlugRepository) SetSlug(ctx context.Context, postID model.PostID, base string) (string, error) {
+	r.sets.Add(1)
+	return r.SlugRepository.SetSlug(ctx, postID, base)
+}
+
+func TestSluggedPostRepository(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "a.md"), []byte("%%%\ntitle = \"First Post\"\n%%%\n\nA"), 0644)
+	os.WriteFile(filepath.Join(dir, "b.md"), []byte("%%%\ntitle = \"Ignored\"\nslug = \"Custom Slug\"\n%%%\n\nB"), 0644)
+
+	slugDB := NewDBSlugRepository(setupSlugTestDB(t))
+	fsRepo := NewFSPostRepository(dir)
+	repo := NewSluggedPostRepository(fsRepo, slugDB)
+	if err := repo.Start(ctx); err != nil {
+		t.Fatalf("Failed to start repository: %v", err)
+	}
+	defer repo.Stop()
+
+	slugs := make(map[string]model.PostID)
+	for _, post := range repo.GetPostList() {
+		slugs[post.Slug] = post.ID
+	}
+	idA := model.PostID(util.ContentHashString("a"))
+	if slugs["first-post"] != idA || slugs["custom-slug"] == "" {
+		t.Fatalf("Expected slugs from the title and front matter, got %v", slugs)
+	}
+
+	t.Run("Posts resolve by ID and slug", func(t *testing.T) {
+		for _, id := range []string{string(idA), "first-post"} {
+			post, err := repo.ReadPost(id)
+			if err != nil {
+				t.Fatalf("ReadPost(%q) failed: %v", id, err)
+			}
+			if post.ID != idA || post.Slug != "first-post" {
+				t.Errorf("ReadPost(%q) returned %+v", id, post)
+			}
+		}
+		if _, err := repo.ReadPost("missing"); err == nil {
+			t.Error("Expected error for unknown post")
+		}
+	})
+
+	t.Run("Changing the title redirects the old slug", func(t *testing.T) {
+		post, _ := repo.ReadPost("first-post")
+		post.Title = "Renamed Post"
+		post.Markdown = []byte("%%%\ntitle = \"Renamed Post\"\n%%%\n\nA")
+		if err := repo.SetPostContent(ctx, post); err != nil {
+			t.Fatalf("SetPostContent failed: %v", err)
+		}
+		if post.Slug != "renamed-post" {
+			t.Errorf("Expected the slug to follow the title, got %q", post.Slug)
+		}
+
+		if _, err := repo.ReadPost("first-post"); err == nil {
+			t.Error("Expected the old slug to no longer resolve directly")
+		}
+		if target, ok := repo.Redirect(ctx, "first-post"); !ok || target != "renamed-post" {
+			t.Errorf("Expected a redirect to renamed-post, got %q %v", target, ok)
+		}
+		if _, ok := repo.Redirect(ctx, "renamed-post"); ok {
+			t.Error("Expected no redirect for a current slug")
+		}
+	})
+
+	t.Run("Restarting and reading assign no slugs", func(t *testing.T) {
+		counting := &countingSlugRepository{SlugRepository: slugDB}
+		restarted := NewSluggedPostRepository(NewFSPostRepository(dir), counting)
+		if err := restarted.Start(ctx); err != nil {
+			t.Fatalf("Failed to start repository: %v", err)
+		}
+		defer restarted.Stop()
+
+		for _, post := range restarted.GetPostList() {
+			if post.Slug == "" {
+				t.Errorf("Expected post %s to have a slug", post.ID)
+			}
+			restarted.ReadPost(string(post.ID))
+			restarted.GetAdjacentPosts(string(post.ID))
+		}
+		if _, _, err := restarted.GetPosts(context.Background()); err != nil {
+			t.Fatalf("GetPosts failed: %v", err)
+		}
+		if n := counting.sets.Load(); n != 0 {
+			t.Errorf("Expected no slugs to be assigned, got %d", n)
+		}
+	})
+
+	t.Run("Reloaded posts get a slug", func(t *testing.T) {
+		os.WriteFile(filepath.Join(dir, "c.md"), []byte("%%%\ntitle = \"Third Post\"\n%%%\n\nC"), 0644)
+		if err := fsRepo.reload([]string{"c"}); err != nil {
+			t.Fatalf("Failed to reload posts: %v", err)
+		}
+		idC := model.PostID(util.ContentHashString("c"))
+		repo.handleReload(idC, model.PostCreated)
+
+		post, err := repo.ReadPost("third-post")
+		if err != nil || post.ID != idC {
+			t.Fatalf("Expected the new post to resolve by slug, got %+v (%v)", post, err)
+		}
+	})
+
+	t.Run("New posts get a slug", func(t *testing.T) {
+		post := repo.NewPost()
+		post.Title = "Renamed Post"
+		post.Markdown = []byte("Another post with the same title")
+		if err := repo.SavePost(ctx, post); err != nil {
+			t.Fatalf("SavePost failed: %v", err)
+		}
+		if post.Slug != "renamed-post-2" {
+			t.Errorf("Expected a unique slug, got %q", post.Slug)
+		}
+	})
+}
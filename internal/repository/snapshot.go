@@ -0,0 +1,111 @@
+package repository
+
+import (
+	"slices"
+	"sync"
+	"sync/atomic"
+
+	"github.com/debemdeboas/the-archive/internal/model"
+)
+
+// postSnapshot is an immutable view of the posts of a repository. A new
+// snapshot is built on every change and swapped in whole, so readers never
+// take a lock and never see a cache that is only partly updated.
+//
+// A nil snapshot is empty.
+type postSnapshot struct {
+	posts []model.Post // sorted in the order of GetPostList
+
+	// index holds the position of every post in posts, which also gives
+	// its neighbours.
+	index map[string]int
+}
+
+// newPostSnapshot takes ownership of posts, which must already be sorted.
+func newPostSnapshot(posts []model.Post) *postSnapshot {
+	index := make(map[string]int, len(posts))
+	for i := range posts {
+		index[string(posts[i].ID)] = i
+	}
+	return &postSnapshot{posts: posts, index: index}
+}
+
+// list returns the sorted posts. The slice is shared and must not be modified.
+func (s *postSnapshot) list() []model.Post {
+	if s == nil {
+		return nil
+	}
+	return s.posts
+}
+
+// get returns a copy of a post, so callers can change it freely.
+func (s *postSnapshot) get(id string) (*model.Post, bool) {
+	if s == nil {
+		return nil, false
+	}
+	i, ok := s.index[id]
+	if !ok {
+		return nil, false
+	}
+	post := s.posts[i]
+	return &post, true
+}
+
+// adjacent returns copies of the posts before and after a post.
+func (s *postSnapshot) adjacent(id string) (prev *model.Post, next *model.Post) {
+	if s == nil {
+		return nil, nil
+	}
+	i, ok := s.index[id]
+	if !ok {
+		return nil, nil
+	}
+	if i > 0 {
+		p := s.posts[i-1]
+		prev = &p
+	}
+	if i < len(s.posts)-1 {
+		n := s.posts[i+1]
+		next = &n
+	}
+	return prev, next
+}
+
+// postCache holds the current snapshot of a repository.
+type postCache struct {
+	current atomic.Pointer[postSnapshot]
+
+	// writeMu serializes writers, so an update never overwrites a snapshot
+	// stored after the one it started from.
+	writeMu sync.Mutex
+}
+
+// load returns the current snapshot, which is nil until the first store.
+func (c *postCache) load() *postSnapshot {
+	return c.current.Load()
+}
+
+// store replaces the snapshot with one of posts, which must already be
+// sorted, and returns the previous snapshot.
+func (c *postCache) store(posts []model.Post) *postSnapshot {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	return c.current.Swap(newPostSnapshot(posts))
+}
+
+// update replaces the snapshot with the result of fn, which is called with
+// a copy of the current posts that it may modify and must return sorted.
+func (c *postCache) update(fn func(posts []model.Post) []model.Post) {
+	c.writeMu.Lock()
+	defer c.writeMu.Unlock()
+	c.current.Store(newPostSnapshot(fn(slices.Clone(c.current.Load().list()))))
+}
+
+// postMap indexes posts by ID, pointing into the slice.
+func postMap(posts []model.Post) map[string]*model.Post {
+	m := make(map[string]*model.Post, len(posts))
+	for i := range posts {
+		m[string(posts[i].ID)] = &posts[i]
+	}
+	return m
+}
@@ -0,0 +1,206 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/debemdeboas/the-archive/internal/model"
+)
+
+func TestPostSnapshot(t *testing.T) {
+	snap := newPostSnapshot([]model.Post{{ID: "a"}, {ID: "b"}, {ID: "c"}})
+
+	t.Run("Adjacent posts", func(t *testing.T) {
+		testCases := map[string][2]model.PostID{
+			"a": {"", "b"},
+			"b": {"a", "c"},
+			"c": {"b", ""},
+			"x": {"", ""},
+		}
+		for id, expected := range testCases {
+			prev, next := snap.adjacent(id)
+			var prevID, nextID model.PostID
+			if prev != nil {
+				prevID = prev.ID
+			}
+			if next != nil {
+				nextID = next.ID
+			}
+			if prevID != expected[0] || nextID != expected[1] {
+				t.Errorf("adjacent(%q) = %q, %q, expected %q, %q", id, prevID, nextID, expected[0], expected[1])
+			}
+		}
+	})
+
+	t.Run("Reads return copies", func(t *testing.T) {
+		post, ok := snap.get("b")
+		if !ok {
+			t.Fatal("Expected post b")
+		}
+		post.Title = "Changed"
+		prev, _ := snap.adjacent("c")
+		prev.Path = "changed"
+
+		if snap.list()[1].Title != "" || snap.list()[1].Path != "" {
+			t.Errorf("Expected the snapshot to be unchanged, got %+v", snap.list()[1])
+		}
+	})
+
+	t.Run("Nil snapshot is empty", func(t *testing.T) {
+		var empty *postSnapshot
+		if _, ok := empty.get("a"); ok || empty.list() != nil {
+			t.Error("Expected no posts")
+		}
+		if prev, next := empty.adjacent("a"); prev != nil || next != nil {
+			t.Error("Expected no adjacent posts")
+		}
+	})
+}
+
+// stressPostRepository reads from repo in several goroutines while write is
+// called, and checks that every read sees a consistent set of posts. It is
+// meant to be run with the race detector.
+func stressPostRepository(t *testing.T, repo PostRepository, writes int, write func(i int)) {
+	t.Helper()
+
+	stop := make(chan struct{})
+	errs := make(chan error, 8)
+	var wg sync.WaitGroup
+
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+
+				posts := repo.GetPostList()
+				if !slices.IsSortedFunc(posts, func(a, b model.Post) int { return -a.ModifiedDate.Compare(b.ModifiedDate) }) {
+					errs <- fmt.Errorf("post list is not sorted")
+					return
+				}
+				for _, p := range posts {
+					post, err := repo.ReadPost(string(p.ID))
+					if err != nil {
+						errs <- fmt.Errorf("post %s from the list cannot be read: %w", p.ID, err)
+						return
+					}
+					// Handlers fill in rendering fields on the posts they read
+					post.Path = "mutated"
+					post.Markdown = nil
+
+					// Posts may have moved since the list was read, but none
+					// are removed, so there is always a neighbour
+					prev, next := repo.GetAdjacentPosts(string(p.ID))
+					if len(posts) > 1 && prev == nil && next == nil {
+						errs <- fmt.Errorf("post %s has no neighbours", p.ID)
+						return
+					}
+				}
+			}
+		}()
+	}
+
+	for i := range writes {
+		write(i)
+	}
+	close(stop)
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		t.Error(err)
+	}
+	for _, post := range repo.GetPostList() {
+		if post.Path == "mutated" || post.Markdown == nil {
+			t.Errorf("Changes to a read post leaked into the cache: %+v", post)
+		}
+	}
+}
+
+func TestDBPostRepositoryConcurrentReads(t *testing.T) {
+	ctx := context.Background()
+	testDB, err := setupTestDB()
+	if err != nil {
+		t.Fatalf("Failed to setup test database: %v", err)
+	}
+	defer testDB.Close()
+	// Every connection to :memory: opens a separate database
+	testDB.SetMaxOpenConns(1)
+
+	repo := NewDBPostRepository(testDB)
+	for i := range 10 {
+		post := repo.NewPost()
+		post.Title = fmt.Sprintf("Post %d", i)
+		post.Markdown = []byte(post.Title)
+		if err := repo.SavePost(ctx, post); err != nil {
+			t.Fatalf("SavePost failed: %v", err)
+		}
+	}
+	repo.reload(context.Background())
+
+	stressPostRepository(t, repo, 100, func(i int) {
+		posts := repo.GetPostList()
+		switch i % 3 {
+		case 0:
+			post, _ := repo.ReadPost(string(posts[i%len(posts)].ID))
+			post.Markdown = fmt.Appendf(nil, "Edit %d", i)
+			if err := repo.SetPostContent(ctx, post); err != nil {
+				t.Errorf("SetPostContent failed: %v", err)
+			}
+		case 1:
+			post := repo.NewPost()
+			post.Title = fmt.Sprintf("New post %d", i)
+			post.Markdown = []byte(post.Title)
+			if err := repo.SavePost(ctx, post); err != nil {
+				t.Errorf("SavePost failed: %v", err)
+			}
+		case 2:
+			// A change made by another instance, picked up by the reload
+			_, err := testDB.Exec(`UPDATE posts SET md_content_hash = ?, modified_at = ? WHERE id = ?`,
+				fmt.Sprintf("external-%d", i), time.Now().UTC(), posts[len(posts)-1].ID)
+			if err != nil {
+				t.Errorf("Failed to update post: %v", err)
+			}
+			repo.reload(context.Background())
+		}
+	})
+}
+
+func TestFSPostRepositoryConcurrentReads(t *testing.T) {
+	ctx := context.Background()
+	repo, dir := newTestFSPostRepository(t, map[string]string{"a.md": "# A", "b.md": "# B", "c.md": "# C"})
+
+	stressPostRepository(t, repo, 60, func(i int) {
+		switch i % 3 {
+		case 0:
+			post, _ := repo.ReadPost(string(repo.GetPostList()[0].ID))
+			post.Markdown = fmt.Appendf(nil, "# Edit %d", i)
+			if err := repo.SetPostContent(ctx, post); err != nil {
+				t.Errorf("SetPostContent failed: %v", err)
+			}
+		case 1:
+			post := repo.NewPost()
+			post.Title = fmt.Sprintf("New post %d", i)
+			post.Markdown = []byte(post.Title)
+			if err := repo.SavePost(ctx, post); err != nil {
+				t.Errorf("SavePost failed: %v", err)
+			}
+		case 2:
+			os.WriteFile(filepath.Join(dir, "b.md"), fmt.Appendf(nil, "# B %d", i), 0644)
+			if err := repo.reload(nil); err != nil {
+				t.Errorf("reload failed: %v", err)
+			}
+		}
+	})
+}
@@ -26,10 +26,13 @@ const (
 	EditPost             = "/edit/post/"
 	PartialsPostPreview  = "/partials/post/preview"
 	PartialsDraftPreview = "/partials/draft/preview"
+	PartialsMedia        = "/partials/media"
 
 	// API
-	APIPosts  = "/api/posts/{id}"
-	APIImages = "/api/images"
+	APIPrefix  = "/api/"
+	APIPosts   = "/api/posts/{id}"
+	APIImages  = "/api/images"
+	APIBackups = "/api/admin/backups"
 
 	// Auth routes
 	AuthChallenge = "/auth/challenge"
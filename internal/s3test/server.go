@@ -0,0 +1,328 @@
+// Package s3test provides an in-process S3-compatible server for tests.
+//
+// It implements the subset of the S3 REST API the application uses with
+// path-style addressing: bucket creation, ListObjectsV2 with pagination, and
+// object PUT, GET, HEAD and DELETE with user metadata and conditional
+// requests. Requests are not authenticated.
+package s3test
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/debemdeboas/the-archive/internal/config"
+)
+
+type object struct {
+	data        []byte
+	contentType string
+	etag        string
+	modTime     time.Time
+	metadata    http.Header
+}
+
+// Server is a fake S3 endpoint backed by memory.
+type Server struct {
+	*httptest.Server
+
+	mu      sync.Mutex
+	buckets map[string]map[string]*object
+	counts  map[string]int
+	hook    func(r *http.Request)
+}
+
+// NewServer starts a server with the given buckets already created.
+// The server is closed when the test ends.
+func NewServer(t interface{ Cleanup(func()) }, buckets ...string) *Server {
+	s := &Server{
+		buckets: make(map[string]map[string]*object),
+		counts:  make(map[string]int),
+	}
+	for _, b := range buckets {
+		s.buckets[b] = make(map[string]*object)
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	t.Cleanup(s.Close)
+	return s
+}
+
+// Config returns S3 settings that point at this server.
+func (s *Server) Config(bucket string) config.S3Config {
+	return config.S3Config{
+		Endpoint:        s.URL,
+		Region:          "us-east-1",
+		Bucket:          bucket,
+		AccessKeyID:     "test",
+		SecretAccessKey: "test",
+		UsePathStyle:    true,
+	}
+}
+
+// PutObject stores an object directly, bypassing the HTTP API.
+func (s *Server) PutObject(bucket, key string, data []byte) {
+	s.PutObjectAt(bucket, key, data, time.Now().UTC())
+}
+
+// PutObjectAt stores an object with an explicit modification time.
+func (s *Server) PutObjectAt(bucket, key string, data []byte, modTime time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bucket(bucket)[key] = newObject(data, "", modTime)
+}
+
+// Object returns the content of an object, bypassing the HTTP API.
+func (s *Server) Object(bucket, key string) ([]byte, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	obj, ok := s.bucket(bucket)[key]
+	if !ok {
+		return nil, false
+	}
+	return slices.Clone(obj.data), true
+}
+
+// DeleteObject removes an object directly, bypassing the HTTP API.
+func (s *Server) DeleteObject(bucket, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.bucket(bucket), key)
+}
+
+// SetHook sets a function called with each request before it is handled,
+// which may block it to interleave other requests.
+func (s *Server) SetHook(hook func(r *http.Request)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.hook = hook
+}
+
+// Count returns how many requests of an operation (for example "GetObject"
+// or "ListObjectsV2") the server has handled.
+func (s *Server) Count(op string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.counts[op]
+}
+
+func (s *Server) bucket(name string) map[string]*object {
+	b, ok := s.buckets[name]
+	if !ok {
+		b = make(map[string]*object)
+		s.buckets[name] = b
+	}
+	return b
+}
+
+func newObject(data []byte, contentType string, modTime time.Time) *object {
+	sum := md5.Sum(data)
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+	return &object{
+		data:        data,
+		contentType: contentType,
+		etag:        `"` + hex.EncodeToString(sum[:]) + `"`,
+		modTime:     modTime.Truncate(time.Second),
+	}
+}
+
+// metadataPrefix is the canonical form of the header prefix of user metadata.
+const metadataPrefix = "X-Amz-Meta-"
+
+func (s *Server) serveHTTP(w http.ResponseWriter, r *http.Request) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+
+	s.mu.Lock()
+	hook := s.hook
+	s.mu.Unlock()
+	if hook != nil {
+		hook(r)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	objects, ok := s.buckets[bucket]
+	if !ok && !(key == "" && r.Method == http.MethodPut) {
+		s.counts["Error"]++
+		writeError(w, http.StatusNotFound, "NoSuchBucket", bucket)
+		return
+	}
+
+	if key == "" {
+		switch r.Method {
+		case http.MethodPut:
+			s.counts["CreateBucket"]++
+			s.bucket(bucket)
+		case http.MethodHead:
+			s.counts["HeadBucket"]++
+		case http.MethodGet:
+			s.counts["ListObjectsV2"]++
+			s.list(w, r, bucket, objects)
+		default:
+			writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method)
+		}
+		return
+	}
+
+	switch r.Method {
+	case http.MethodPut:
+		s.counts["PutObject"]++
+		if r.Header.Get("If-None-Match") == "*" && objects[key] != nil {
+			writeError(w, http.StatusPreconditionFailed, "PreconditionFailed", key)
+			return
+		}
+		if match := r.Header.Get("If-Match"); match != "" && (objects[key] == nil || objects[key].etag != match) {
+			writeError(w, http.StatusPreconditionFailed, "PreconditionFailed", key)
+			return
+		}
+		data, err := io.ReadAll(r.Body)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "IncompleteBody", err.Error())
+			return
+		}
+		obj := newObject(data, r.Header.Get("Content-Type"), time.Now().UTC())
+		obj.metadata = make(http.Header)
+		for name, values := range r.Header {
+			if strings.HasPrefix(name, metadataPrefix) {
+				obj.metadata[name] = values
+			}
+		}
+		objects[key] = obj
+		w.Header().Set("ETag", obj.etag)
+		w.WriteHeader(http.StatusOK)
+
+	case http.MethodGet, http.MethodHead:
+		if r.Method == http.MethodGet {
+			s.counts["GetObject"]++
+		} else {
+			s.counts["HeadObject"]++
+		}
+		obj, ok := objects[key]
+		if !ok {
+			if r.Method == http.MethodHead {
+				w.WriteHeader(http.StatusNotFound)
+				return
+			}
+			writeError(w, http.StatusNotFound, "NoSuchKey", key)
+			return
+		}
+		w.Header().Set("ETag", obj.etag)
+		w.Header().Set("Last-Modified", obj.modTime.Format(http.TimeFormat))
+		for name, values := range obj.metadata {
+			w.Header()[name] = values
+		}
+		if match := r.Header.Get("If-None-Match"); match != "" && match == obj.etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", obj.contentType)
+		w.Header().Set("Content-Length", strconv.Itoa(len(obj.data)))
+		w.WriteHeader(http.StatusOK)
+		if r.Method == http.MethodGet {
+			w.Write(obj.data)
+		}
+
+	case http.MethodDelete:
+		s.counts["DeleteObject"]++
+		delete(objects, key)
+		w.WriteHeader(http.StatusNoContent)
+
+	default:
+		writeError(w, http.StatusMethodNotAllowed, "MethodNotAllowed", r.Method)
+	}
+}
+
+type listEntry struct {
+	Key          string `xml:"Key"`
+	LastModified string `xml:"LastModified"`
+	ETag         string `xml:"ETag"`
+	Size         int    `xml:"Size"`
+	StorageClass string `xml:"StorageClass"`
+}
+
+type listResult struct {
+	XMLName               xml.Name    `xml:"http://s3.amazonaws.com/doc/2006-03-01/ ListBucketResult"`
+	Name                  string      `xml:"Name"`
+	Prefix                string      `xml:"Prefix"`
+	KeyCount              int         `xml:"KeyCount"`
+	MaxKeys               int         `xml:"MaxKeys"`
+	IsTruncated           bool        `xml:"IsTruncated"`
+	ContinuationToken     string      `xml:"ContinuationToken,omitempty"`
+	NextContinuationToken string      `xml:"NextContinuationToken,omitempty"`
+	Contents              []listEntry `xml:"Contents"`
+}
+
+func (s *Server) list(w http.ResponseWriter, r *http.Request, bucket string, objects map[string]*object) {
+	q := r.URL.Query()
+	prefix := q.Get("prefix")
+
+	maxKeys := 1000
+	if v, err := strconv.Atoi(q.Get("max-keys")); err == nil && v > 0 && v < maxKeys {
+		maxKeys = v
+	}
+
+	after := q.Get("start-after")
+	token := q.Get("continuation-token")
+	if token != "" {
+		decoded, err := base64.StdEncoding.DecodeString(token)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "InvalidArgument", "invalid continuation token")
+			return
+		}
+		after = string(decoded)
+	}
+
+	keys := make([]string, 0, len(objects))
+	for k := range objects {
+		if strings.HasPrefix(k, prefix) && k > after {
+			keys = append(keys, k)
+		}
+	}
+	slices.Sort(keys)
+
+	res := listResult{
+		Name:              bucket,
+		Prefix:            prefix,
+		MaxKeys:           maxKeys,
+		ContinuationToken: token,
+	}
+	if len(keys) > maxKeys {
+		keys = keys[:maxKeys]
+		res.IsTruncated = true
+		res.NextContinuationToken = base64.StdEncoding.EncodeToString([]byte(keys[len(keys)-1]))
+	}
+	for _, k := range keys {
+		obj := objects[k]
+		res.Contents = append(res.Contents, listEntry{
+			Key:          k,
+			LastModified: obj.modTime.Format("2006-01-02T15:04:05.000Z"),
+			ETag:         obj.etag,
+			Size:         len(obj.data),
+			StorageClass: "STANDARD",
+		})
+	}
+	res.KeyCount = len(res.Contents)
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(http.StatusOK)
+	io.WriteString(w, xml.Header)
+	xml.NewEncoder(w).Encode(res)
+}
+
+func writeError(w http.ResponseWriter, status int, code, msg string) {
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	fmt.Fprintf(w, "%s<Error><Code>%s</Code><Message>%s</Message></Error>", xml.Header, code, msg)
+}
@@ -15,14 +15,36 @@ type Client struct {
 type SSEClients struct {
 	clients map[*Client]bool
 	mu      sync.RWMutex
+
+	shutdown     chan struct{}
+	shutdownOnce sync.Once
 }
 
 func NewSSEClients() *SSEClients {
 	return &SSEClients{
-		clients: make(map[*Client]bool),
+		clients:  make(map[*Client]bool),
+		shutdown: make(chan struct{}),
 	}
 }
 
+// Shutdown tells every client, connected or not, that the server is shutting
+// down. Handlers should send a final event and return once Done is closed.
+func (s *SSEClients) Shutdown() {
+	s.shutdownOnce.Do(func() { close(s.shutdown) })
+}
+
+// Done returns a channel that is closed by Shutdown.
+func (s *SSEClients) Done() <-chan struct{} {
+	return s.shutdown
+}
+
+// Len returns the number of connected clients.
+func (s *SSEClients) Len() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return len(s.clients)
+}
+
 func (s *SSEClients) Add(client *Client) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -18,13 +18,14 @@ func GetThemeFromRequest(r *http.Request) string {
 	if cookie, err := r.Cookie(config.CookieTheme); err == nil {
 		return cookie.Value
 	}
-	return config.AppConfig.Theme.Default
+	return config.Get().Theme.Default
 }
 
 func GetDefaultSyntaxTheme(theme string) string {
+	defaults := config.Get().Theme.SyntaxHighlighting
 	return map[string]string{
-		config.LightTheme: config.AppConfig.Theme.SyntaxHighlighting.DefaultLight,
-		config.DarkTheme:  config.AppConfig.Theme.SyntaxHighlighting.DefaultDark,
+		config.LightTheme: defaults.DefaultLight,
+		config.DarkTheme:  defaults.DefaultDark,
 	}[theme]
 }
 
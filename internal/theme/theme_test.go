@@ -142,7 +142,7 @@ func TestGetThemeFromRequest(t *testing.T) {
 		{
 			name:          "No cookie - use default",
 			hasCookie:     false,
-			expectedTheme: config.AppConfig.Theme.Default,
+			expectedTheme: config.Get().Theme.Default,
 		},
 		{
 			name:          "Valid light theme cookie",
@@ -198,7 +198,7 @@ func TestGetSyntaxThemeFromRequest(t *testing.T) {
 			name:            "No cookies - use default for default theme",
 			hasThemeCookie:  false,
 			hasSyntaxCookie: false,
-			expectedTheme:   GetDefaultSyntaxTheme(config.AppConfig.Theme.Default),
+			expectedTheme:   GetDefaultSyntaxTheme(config.Get().Theme.Default),
 		},
 		{
 			name:            "Only theme cookie - use default syntax for that theme",
@@ -260,12 +260,12 @@ func TestGetDefaultSyntaxTheme(t *testing.T) {
 		{
 			name:          "Light theme",
 			theme:         config.LightTheme,
-			expectedTheme: config.AppConfig.Theme.SyntaxHighlighting.DefaultLight,
+			expectedTheme: config.Get().Theme.SyntaxHighlighting.DefaultLight,
 		},
 		{
 			name:          "Dark theme",
 			theme:         config.DarkTheme,
-			expectedTheme: config.AppConfig.Theme.SyntaxHighlighting.DefaultDark,
+			expectedTheme: config.Get().Theme.SyntaxHighlighting.DefaultDark,
 		},
 		{
 			name:          "Unknown theme",
@@ -326,8 +326,8 @@ func TestGetThemeIcon(t *testing.T) {
 // Helper functions for testing
 
 func setupMockConfig() {
-	if config.AppConfig == nil {
-		config.AppConfig = &config.Config{
+	if config.Get() == nil {
+		config.Set(&config.Config{
 			Theme: config.ThemeConfig{
 				Default: "dark",
 				SyntaxHighlighting: config.SyntaxConfig{
@@ -335,7 +335,7 @@ func setupMockConfig() {
 					DefaultLight: "catppuccin-latte",
 				},
 			},
-		}
+		})
 	}
 }
 
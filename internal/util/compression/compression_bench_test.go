@@ -0,0 +1,77 @@
+package compression
+
+import (
+	"testing"
+)
+
+// benchmarkCodecs runs fn for each codec over small notes, reporting the
+// compressed size relative to the input.
+func benchmarkCodecs(b *testing.B, fn func(b *testing.B, c Compressor, samples [][]byte)) {
+	samples := notes(500)
+	codecs := []Compressor{NoneCompressor{}, GzipCompressor{}, ZstdCompressor{}, dictCompressor(b, samples[:250])}
+	samples = samples[250:]
+
+	var total int
+	for _, s := range samples {
+		total += len(s)
+	}
+
+	for _, c := range codecs {
+		name := c.Name()
+		if _, ok := c.(*ZstdDictCompressor); ok {
+			// Without the random dictionary ID, so runs can be compared
+			name = "zstd-dict"
+		}
+		b.Run(name, func(b *testing.B) {
+			var compressed int
+			for _, s := range samples {
+				out, _ := c.Compress(s)
+				compressed += len(out)
+			}
+			b.SetBytes(int64(total / len(samples)))
+			b.ResetTimer()
+
+			fn(b, c, samples)
+			b.ReportMetric(float64(compressed)/float64(total), "ratio")
+		})
+	}
+}
+
+func BenchmarkCompress(b *testing.B) {
+	benchmarkCodecs(b, func(b *testing.B, c Compressor, samples [][]byte) {
+		for i := range b.N {
+			if _, err := c.Compress(samples[i%len(samples)]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkDecompress(b *testing.B) {
+	benchmarkCodecs(b, func(b *testing.B, c Compressor, samples [][]byte) {
+		compressed := make([][]byte, len(samples))
+		for i, s := range samples {
+			compressed[i], _ = c.Compress(s)
+		}
+		b.ResetTimer()
+
+		for i := range b.N {
+			if _, err := c.Decompress(compressed[i%len(compressed)]); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+func BenchmarkCompressParallel(b *testing.B) {
+	benchmarkCodecs(b, func(b *testing.B, c Compressor, samples [][]byte) {
+		b.RunParallel(func(pb *testing.PB) {
+			for i := 0; pb.Next(); i++ {
+				if _, err := c.Compress(samples[i%len(samples)]); err != nil {
+					b.Error(err)
+					return
+				}
+			}
+		})
+	})
+}
@@ -0,0 +1,156 @@
+package compression
+
+import (
+	"bytes"
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// notes returns n small Markdown notes that share their structure but not
+// their text, like the posts of an archive.
+func notes(n int) [][]byte {
+	rng := rand.New(rand.NewSource(1))
+	words := strings.Fields(`the archive post note markdown server render draft
+		go code block link image list table quote heading summary idea today week
+		read write fix bug test build deploy config theme search tag slug media`)
+	sentence := func() string {
+		s := make([]string, 6+rng.Intn(10))
+		for i := range s {
+			s[i] = words[rng.Intn(len(words))]
+		}
+		return strings.Join(s, " ") + "."
+	}
+
+	out := make([][]byte, n)
+	for i := range out {
+		var b strings.Builder
+		fmt.Fprintf(&b, "---\ntitle: Note %d\ntags: [%s, %s]\ndate: 2024-01-%02d\n---\n\n", i, words[rng.Intn(len(words))], words[rng.Intn(len(words))], 1+i%28)
+		fmt.Fprintf(&b, "# %s\n\n", sentence())
+		for range 1 + rng.Intn(3) {
+			fmt.Fprintf(&b, "%s %s\n\n", sentence(), sentence())
+		}
+		fmt.Fprintf(&b, "- %s\n- %s\n\n```go\nfmt.Println(%q)\n```\n", sentence(), sentence(), sentence())
+		out[i] = []byte(b.String())
+	}
+	return out
+}
+
+func dictCompressor(t testing.TB, samples [][]byte) *ZstdDictCompressor {
+	t.Helper()
+	dictionary, err := TrainZstdDictionary(samples, 16<<10)
+	if err != nil {
+		t.Fatalf("TrainZstdDictionary failed: %v", err)
+	}
+	compressor, err := NewZstdDictCompressor(dictionary)
+	if err != nil {
+		t.Fatalf("NewZstdDictCompressor failed: %v", err)
+	}
+	return compressor
+}
+
+func TestCompressors(t *testing.T) {
+	samples := notes(50)
+	compressors := []Compressor{NoneCompressor{}, GzipCompressor{}, ZstdCompressor{}, dictCompressor(t, samples)}
+
+	for _, c := range compressors {
+		t.Run(c.Name(), func(t *testing.T) {
+			for _, data := range [][]byte{samples[0], {}, bytes.Repeat([]byte("archive "), 10000)} {
+				compressed, err := c.Compress(data)
+				if err != nil {
+					t.Fatalf("Compress failed: %v", err)
+				}
+				got, err := c.Decompress(compressed)
+				if err != nil {
+					t.Fatalf("Decompress failed: %v", err)
+				}
+				if !bytes.Equal(got, data) {
+					t.Errorf("Expected %d bytes back, got %d", len(data), len(got))
+				}
+			}
+		})
+	}
+
+	t.Run("Concurrent use", func(t *testing.T) {
+		var wg sync.WaitGroup
+		for _, c := range compressors {
+			for _, data := range samples[:20] {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					compressed, err := c.Compress(data)
+					if err != nil {
+						t.Errorf("%s: Compress failed: %v", c.Name(), err)
+						return
+					}
+					if got, err := c.Decompress(compressed); err != nil || !bytes.Equal(got, data) {
+						t.Errorf("%s: Round trip failed: %v", c.Name(), err)
+					}
+				}()
+			}
+		}
+		wg.Wait()
+	})
+
+	t.Run("Corrupt data", func(t *testing.T) {
+		for _, c := range compressors[1:] {
+			if _, err := c.Decompress([]byte("not compressed")); err == nil {
+				t.Errorf("%s: Expected an error", c.Name())
+			}
+		}
+	})
+}
+
+func TestZstdDictCompressor(t *testing.T) {
+	samples := notes(150)
+	compressor := dictCompressor(t, samples[:50])
+
+	if !strings.HasPrefix(compressor.Name(), "zstd-dict-") {
+		t.Errorf("Expected the name to include the dictionary, got %s", compressor.Name())
+	}
+	if other := dictCompressor(t, samples[50:100]); other.Name() == compressor.Name() {
+		t.Errorf("Expected dictionaries to have different names, both are %s", other.Name())
+	}
+
+	t.Run("Small notes compress better", func(t *testing.T) {
+		var plain, withDict int
+		for _, note := range samples[100:] {
+			a, _ := ZstdCompressor{}.Compress(note)
+			b, _ := compressor.Compress(note)
+			plain += len(a)
+			withDict += len(b)
+		}
+		if withDict >= plain {
+			t.Errorf("Expected the dictionary to help, got %d bytes against %d", withDict, plain)
+		}
+	})
+
+	t.Run("The dictionary is needed", func(t *testing.T) {
+		compressed, _ := compressor.Compress(samples[120])
+		if _, err := (ZstdCompressor{}).Decompress(compressed); err == nil {
+			t.Error("Expected an error decompressing without the dictionary")
+		}
+	})
+
+	t.Run("Invalid dictionaries", func(t *testing.T) {
+		if _, err := NewZstdDictCompressor([]byte("not a dictionary")); err == nil {
+			t.Error("Expected an error")
+		}
+	})
+}
+
+func TestCodecs(t *testing.T) {
+	compressor := dictCompressor(t, notes(50))
+	codecs := NewCodecs(compressor)
+
+	for _, name := range []string{None, Gzip, Zstd, compressor.Name()} {
+		if c, err := codecs.Get(name); err != nil || c.Name() != name {
+			t.Errorf("Expected codec %s, got %v (%v)", name, c, err)
+		}
+	}
+	if _, err := codecs.Get("lz4"); err == nil {
+		t.Error("Expected an error for an unknown codec")
+	}
+}
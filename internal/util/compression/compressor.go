@@ -1,7 +1,63 @@
 // Package compression provides compression interfaces for data compression/decompression.
 package compression
 
+import "fmt"
+
+// Codec names, as recorded next to compressed data.
+const (
+	None = "none"
+	Gzip = "gzip"
+	Zstd = "zstd"
+)
+
 type Compressor interface {
+	// Name identifies the format data is compressed in, so that it can be
+	// decompressed by the same compressor later.
+	Name() string
 	Compress(data []byte) ([]byte, error)
 	Decompress(data []byte) ([]byte, error)
 }
+
+// NoneCompressor stores data as is.
+type NoneCompressor struct{}
+
+func (NoneCompressor) Name() string { return None }
+
+func (NoneCompressor) Compress(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+func (NoneCompressor) Decompress(data []byte) ([]byte, error) {
+	return data, nil
+}
+
+// Codecs looks compressors up by name, to decompress data compressed by any
+// of them. The built-in codecs are always known.
+type Codecs map[string]Compressor
+
+// NewCodecs returns the built-in codecs and compressors.
+func NewCodecs(compressors ...Compressor) Codecs {
+	codecs := Codecs{
+		None: NoneCompressor{},
+		Gzip: GzipCompressor{},
+		Zstd: ZstdCompressor{},
+	}
+	for _, c := range compressors {
+		codecs.Add(c)
+	}
+	return codecs
+}
+
+// Add registers c under its name.
+func (c Codecs) Add(compressor Compressor) {
+	c[compressor.Name()] = compressor
+}
+
+// Get returns the compressor called name.
+func (c Codecs) Get(name string) (Compressor, error) {
+	compressor, ok := c[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown compression codec %q", name)
+	}
+	return compressor, nil
+}
@@ -4,13 +4,26 @@ import (
 	"bytes"
 	"compress/gzip"
 	"io"
+	"sync"
+)
+
+// Gzip writers and readers allocate large buffers, so they are reset and
+// reused instead.
+var (
+	gzipWriters = sync.Pool{New: func() any { return gzip.NewWriter(nil) }}
+	gzipReaders sync.Pool
 )
 
 type GzipCompressor struct{}
 
+func (g GzipCompressor) Name() string { return Gzip }
+
 func (g GzipCompressor) Compress(data []byte) ([]byte, error) {
 	var b bytes.Buffer
-	writer := gzip.NewWriter(&b)
+	writer := gzipWriters.Get().(*gzip.Writer)
+	defer gzipWriters.Put(writer)
+	writer.Reset(&b)
+
 	_, err := writer.Write(data)
 	if err != nil {
 		writer.Close()
@@ -24,10 +37,17 @@ func (g GzipCompressor) Compress(data []byte) ([]byte, error) {
 }
 
 func (g GzipCompressor) Decompress(data []byte) ([]byte, error) {
-	reader, err := gzip.NewReader(bytes.NewReader(data))
+	var reader *gzip.Reader
+	var err error
+	if pooled, ok := gzipReaders.Get().(*gzip.Reader); ok {
+		reader, err = pooled, pooled.Reset(bytes.NewReader(data))
+	} else {
+		reader, err = gzip.NewReader(bytes.NewReader(data))
+	}
 	if err != nil {
 		return nil, err
 	}
+	defer gzipReaders.Put(reader)
 	defer reader.Close()
 	return io.ReadAll(reader)
 }
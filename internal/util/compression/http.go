@@ -0,0 +1,261 @@
+package compression
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// MinSize is the size under which responses are not worth compressing.
+const MinSize = 1024
+
+// streamEncoder is a compressing writer that can be reused for another
+// response.
+type streamEncoder interface {
+	io.WriteCloser
+	Flush() error
+	Reset(w io.Writer)
+}
+
+// Encoders allocate large buffers, so they are reused across responses.
+var encoders = map[string]*sync.Pool{
+	Zstd: {New: func() any {
+		// Browsers only decode windows of up to 8 MB, and each response is
+		// compressed by its own goroutine
+		encoder, _ := zstd.NewWriter(nil, zstd.WithWindowSize(1<<20), zstd.WithEncoderConcurrency(1))
+		return encoder
+	}},
+	Gzip: {New: func() any { return gzip.NewWriter(nil) }},
+}
+
+// Negotiate returns the encoding of the Accept-Encoding header to compress a
+// response with, preferring zstd to gzip, or "" if the client accepts
+// neither.
+func Negotiate(acceptEncoding string) string {
+	return negotiate(acceptEncoding, []string{Zstd, Gzip})
+}
+
+// negotiate returns the first of encodings the Accept-Encoding header gives
+// the highest quality, or "" if it accepts none of them.
+func negotiate(acceptEncoding string, encodings []string) string {
+	quality := map[string]float64{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				q = 0
+			}
+		}
+		if name == "x-gzip" {
+			name = Gzip
+		}
+		quality[name] = q
+	}
+
+	best, bestQuality := "", 0.0
+	for _, name := range encodings {
+		q, ok := quality[name]
+		if !ok {
+			q = quality["*"]
+		}
+		if q > bestQuality {
+			best, bestQuality = name, q
+		}
+	}
+	return best
+}
+
+// Compressible reports whether responses of contentType are worth
+// compressing. Event streams are not, so that their events are sent as they
+// are written, and neither are media types that are compressed already.
+func Compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case mediaType == "text/event-stream":
+		return false
+	case strings.HasPrefix(mediaType, "text/"),
+		strings.HasSuffix(mediaType, "+json"),
+		strings.HasSuffix(mediaType, "+xml"):
+		return true
+	}
+	switch mediaType {
+	case "application/json", "application/javascript", "application/xml",
+		"application/wasm", "font/ttf", "font/otf", "image/x-icon", "image/bmp":
+		return true
+	}
+	return false
+}
+
+// addVary adds Accept-Encoding to the Vary header of h.
+func addVary(h http.Header) {
+	for _, value := range h.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			if strings.EqualFold(strings.TrimSpace(field), "Accept-Encoding") {
+				return
+			}
+		}
+	}
+	h.Add("Vary", "Accept-Encoding")
+}
+
+// Middleware compresses the responses of next with zstd or gzip when the
+// client accepts it, their content type is Compressible and they are at
+// least MinSize bytes long. Responses that set their own Content-Encoding
+// are left alone.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		encoding := Negotiate(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead || r.Header.Get("Range") != "" {
+			// Still tell caches that other clients may get another encoding
+			addVary(w.Header())
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// compressWriter buffers the start of a response until it knows whether to
+// compress it: once MinSize bytes were written, the response is flushed, or
+// the handler returns.
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+
+	status      int
+	wroteHeader bool
+	decided     bool
+	buf         []byte
+	encoder     streamEncoder
+}
+
+func (cw *compressWriter) WriteHeader(status int) {
+	if cw.wroteHeader {
+		return
+	}
+	if status < http.StatusOK {
+		// Informational responses such as 103 Early Hints go out as is
+		cw.ResponseWriter.WriteHeader(status)
+		return
+	}
+	cw.status, cw.wroteHeader = status, true
+}
+
+func (cw *compressWriter) Write(p []byte) (int, error) {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if cw.decided {
+		if cw.encoder != nil {
+			return cw.encoder.Write(p)
+		}
+		return cw.ResponseWriter.Write(p)
+	}
+
+	cw.buf = append(cw.buf, p...)
+	if len(cw.buf) >= MinSize {
+		if err := cw.decide(); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+// decide sends the header, starting to compress the response if it should
+// be, and writes the buffered start of the body.
+func (cw *compressWriter) decide() error {
+	cw.decided = true
+	h := cw.Header()
+	if h.Get("Content-Type") == "" && len(cw.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(cw.buf))
+	}
+
+	compress := len(cw.buf) >= MinSize &&
+		cw.status != http.StatusPartialContent && cw.status != http.StatusNoContent && cw.status != http.StatusNotModified &&
+		h.Get("Content-Encoding") == "" && Compressible(h.Get("Content-Type"))
+	if h.Get("Content-Encoding") == "" {
+		addVary(h)
+	}
+
+	if compress {
+		h.Set("Content-Encoding", cw.encoding)
+		h.Del("Content-Length")
+		h.Del("Accept-Ranges")
+		// The compressed body is not the one a strong validator was made for
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		cw.encoder = encoders[cw.encoding].Get().(streamEncoder)
+		cw.encoder.Reset(cw.ResponseWriter)
+	}
+
+	cw.ResponseWriter.WriteHeader(cw.status)
+	buf := cw.buf
+	cw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if cw.encoder != nil {
+		_, err = cw.encoder.Write(buf)
+	} else {
+		_, err = cw.ResponseWriter.Write(buf)
+	}
+	return err
+}
+
+// Flush sends what was written so far, deciding whether to compress the
+// response if that was not done yet.
+func (cw *compressWriter) Flush() {
+	if !cw.wroteHeader {
+		cw.WriteHeader(http.StatusOK)
+	}
+	if !cw.decided {
+		cw.decide()
+	}
+	if cw.encoder != nil {
+		cw.encoder.Flush()
+	}
+	http.NewResponseController(cw.ResponseWriter).Flush()
+}
+
+// Close finishes the response once the handler returned.
+func (cw *compressWriter) Close() error {
+	if !cw.wroteHeader {
+		// Nothing was written, the server sends the default response
+		return nil
+	}
+	if !cw.decided {
+		if err := cw.decide(); err != nil {
+			return err
+		}
+	}
+	if cw.encoder == nil {
+		return nil
+	}
+	err := cw.encoder.Close()
+	cw.encoder.Reset(nil)
+	encoders[cw.encoding].Put(cw.encoder)
+	cw.encoder = nil
+	return err
+}
+
+// Unwrap returns the underlying writer, for http.ResponseController.
+func (cw *compressWriter) Unwrap() http.ResponseWriter {
+	return cw.ResponseWriter
+}
@@ -0,0 +1,57 @@
+package compression
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+)
+
+// benchmarkEncodings serves h to clients accepting each encoding, reporting
+// the size of the response relative to the body.
+func benchmarkEncodings(b *testing.B, h http.Handler, path string, size int) {
+	for _, encoding := range []string{"identity", Gzip, Zstd} {
+		b.Run(encoding, func(b *testing.B) {
+			r := httptest.NewRequest(http.MethodGet, path, nil)
+			r.Header.Set("Accept-Encoding", encoding)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+			sent := w.Body.Len()
+
+			b.SetBytes(int64(size))
+			b.ReportAllocs()
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					h.ServeHTTP(discard{header: http.Header{}}, r)
+				}
+			})
+			b.ReportMetric(float64(sent)/float64(size), "ratio")
+		})
+	}
+}
+
+// discard is a ResponseWriter that drops the response.
+type discard struct{ header http.Header }
+
+func (d discard) Header() http.Header         { return d.header }
+func (d discard) Write(p []byte) (int, error) { return len(p), nil }
+func (d discard) WriteHeader(int)             {}
+
+func BenchmarkMiddleware(b *testing.B) {
+	html := page(50)
+	h := Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(html)
+	}))
+	benchmarkEncodings(b, h, "/", len(html))
+}
+
+func BenchmarkPrecompressed(b *testing.B) {
+	html := page(50)
+	p, err := NewPrecompressed(fstest.MapFS{"post.html": {Data: html}})
+	if err != nil {
+		b.Fatal(err)
+	}
+	benchmarkEncodings(b, p, "/post.html", len(html))
+}
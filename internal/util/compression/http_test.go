@@ -0,0 +1,290 @@
+package compression
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// page returns an HTML page of the notes, like a rendered post.
+func page(n int) []byte {
+	var b bytes.Buffer
+	b.WriteString("<!DOCTYPE html><html><body>")
+	for _, note := range notes(n) {
+		b.WriteString("<article><pre>")
+		b.Write(note)
+		b.WriteString("</pre></article>")
+	}
+	b.WriteString("</body></html>")
+	return b.Bytes()
+}
+
+// decode returns the body of res, decoded from its Content-Encoding.
+func decode(t testing.TB, res *http.Response) []byte {
+	t.Helper()
+	var r io.Reader = res.Body
+	switch encoding := res.Header.Get("Content-Encoding"); encoding {
+	case Zstd:
+		decoder, err := zstd.NewReader(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer decoder.Close()
+		r = decoder
+	case Gzip:
+		reader, err := gzip.NewReader(res.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r = reader
+	case "":
+	default:
+		t.Fatalf("Unexpected encoding %q", encoding)
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Failed to decode %s body: %v", res.Header.Get("Content-Encoding"), err)
+	}
+	return body
+}
+
+func hasVary(h http.Header) bool {
+	return strings.Contains(strings.Join(h.Values("Vary"), ","), "Accept-Encoding")
+}
+
+func TestNegotiate(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		want           string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", Gzip},
+		{"x-gzip", Gzip},
+		{"gzip, deflate, br, zstd", Zstd},
+		{"GZIP, ZSTD", Zstd},
+		{"zstd;q=0.5, gzip", Gzip},
+		{"zstd;q=0, gzip;q=0.1", Gzip},
+		{"zstd;q=0, gzip;q=0", ""},
+		{"*", Zstd},
+		{"*;q=0.5, zstd;q=0", Gzip},
+		{"gzip;q=invalid", ""},
+	}
+	for _, tt := range tests {
+		if got := Negotiate(tt.acceptEncoding); got != tt.want {
+			t.Errorf("Negotiate(%q) = %q, expected %q", tt.acceptEncoding, got, tt.want)
+		}
+	}
+}
+
+func TestCompressible(t *testing.T) {
+	tests := map[string]bool{
+		"text/html; charset=utf-8":  true,
+		"text/css":                  true,
+		"application/json":          true,
+		"application/manifest+json": true,
+		"image/svg+xml":             true,
+		"text/event-stream":         false,
+		"image/png":                 false,
+		"application/zstd":          false,
+		"":                          false,
+	}
+	for contentType, want := range tests {
+		if got := Compressible(contentType); got != want {
+			t.Errorf("Compressible(%q) = %v, expected %v", contentType, got, want)
+		}
+	}
+}
+
+func TestMiddleware(t *testing.T) {
+	html := page(20)
+	serve := func(h http.HandlerFunc, acceptEncoding string) *http.Response {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if acceptEncoding != "" {
+			r.Header.Set("Accept-Encoding", acceptEncoding)
+		}
+		w := httptest.NewRecorder()
+		Middleware(h).ServeHTTP(w, r)
+		return w.Result()
+	}
+	content := func(contentType string, body []byte) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", contentType)
+			w.Header().Set("Content-Length", "1")
+			w.Header().Set("ETag", `"v1"`)
+			// In small writes, to cross MinSize in the middle of one
+			for chunk := range slices(body, 100) {
+				w.Write(chunk)
+			}
+		}
+	}
+
+	for _, encoding := range []string{Zstd, Gzip} {
+		t.Run("Compresses with "+encoding, func(t *testing.T) {
+			res := serve(content("text/html; charset=utf-8", html), encoding)
+			if got := res.Header.Get("Content-Encoding"); got != encoding {
+				t.Fatalf("Expected %s encoding, got %q", encoding, got)
+			}
+			if !hasVary(res.Header) || res.Header.Get("Content-Length") != "" || res.Header.Get("ETag") != `W/"v1"` {
+				t.Errorf("Unexpected headers %v", res.Header)
+			}
+			if body := decode(t, res); !bytes.Equal(body, html) {
+				t.Errorf("Expected the page back, got %d bytes", len(body))
+			}
+		})
+	}
+
+	tests := []struct {
+		name           string
+		handler        http.HandlerFunc
+		acceptEncoding string
+		want           []byte
+	}{
+		{"Not accepted", content("text/html", html), "identity", html},
+		{"Small", content("text/html", html[:MinSize-1]), Zstd, html[:MinSize-1]},
+		{"Already compressed media", content("image/png", html), Zstd, html},
+		{"Event stream", content("text/event-stream", html), Zstd, html},
+		{"Already encoded", func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Encoding", Gzip)
+			w.Write(html)
+		}, Zstd, html},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := serve(tt.handler, tt.acceptEncoding)
+			if got := res.Header.Get("Content-Encoding"); got != "" && got != Gzip {
+				t.Errorf("Expected no compression, got %q", got)
+			}
+			if body, _ := io.ReadAll(res.Body); !bytes.Equal(body, tt.want) {
+				t.Errorf("Expected the body as is, got %d bytes", len(body))
+			}
+		})
+	}
+
+	t.Run("Status without a body", func(t *testing.T) {
+		res := serve(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotModified)
+		}, Zstd)
+		if res.StatusCode != http.StatusNotModified || res.Header.Get("Content-Encoding") != "" {
+			t.Errorf("Expected an unencoded 304, got %d %v", res.StatusCode, res.Header)
+		}
+	})
+
+	t.Run("Flush", func(t *testing.T) {
+		flushed := make(chan struct{})
+		server := httptest.NewServer(Middleware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			w.Write([]byte("<p>First</p>"))
+			http.NewResponseController(w).Flush()
+			<-flushed
+			w.Write(html)
+		})))
+		defer server.Close()
+
+		req, _ := http.NewRequest(http.MethodGet, server.URL, nil)
+		req.Header.Set("Accept-Encoding", Zstd)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+		// The headers arrived before the handler returned
+		close(flushed)
+		if got := res.Header.Get("Content-Encoding"); got != "" {
+			t.Errorf("Expected the short start to be sent as is, got %q", got)
+		}
+		if body, _ := io.ReadAll(res.Body); !bytes.Equal(body, append([]byte("<p>First</p>"), html...)) {
+			t.Errorf("Unexpected body of %d bytes", len(body))
+		}
+	})
+}
+
+// slices splits data into chunks of n bytes.
+func slices(data []byte, n int) func(yield func([]byte) bool) {
+	return func(yield func([]byte) bool) {
+		for len(data) > 0 {
+			chunk := data[:min(n, len(data))]
+			data = data[len(chunk):]
+			if !yield(chunk) {
+				return
+			}
+		}
+	}
+}
+
+func TestPrecompressed(t *testing.T) {
+	css := bytes.Repeat([]byte("body { margin: 0 auto; }\n"), 200)
+	fsys := fstest.MapFS{
+		"css/style.css": {Data: css},
+		"small.css":     {Data: []byte("p {}")},
+		"image.png":     {Data: css},
+	}
+	p, err := NewPrecompressed(fsys)
+	if err != nil {
+		t.Fatalf("NewPrecompressed failed: %v", err)
+	}
+	serve := func(name, acceptEncoding, ifNoneMatch string) *http.Response {
+		r := httptest.NewRequest(http.MethodGet, "/"+name, nil)
+		r.Header.Set("Accept-Encoding", acceptEncoding)
+		if ifNoneMatch != "" {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		w := httptest.NewRecorder()
+		w.Header().Set("ETag", `"abc"`)
+		p.ServeHTTP(w, r)
+		return w.Result()
+	}
+
+	for _, encoding := range []string{Zstd, Gzip} {
+		t.Run("Serves "+encoding, func(t *testing.T) {
+			res := serve("css/style.css", encoding, "")
+			if res.StatusCode != http.StatusOK || res.Header.Get("Content-Encoding") != encoding {
+				t.Fatalf("Expected a %s response, got %d %v", encoding, res.StatusCode, res.Header)
+			}
+			if !hasVary(res.Header) || res.Header.Get("ETag") != `"abc-`+encoding+`"` ||
+				!strings.HasPrefix(res.Header.Get("Content-Type"), "text/css") {
+				t.Errorf("Unexpected headers %v", res.Header)
+			}
+			if body := decode(t, res); !bytes.Equal(body, css) {
+				t.Errorf("Expected the file back, got %d bytes", len(body))
+			}
+
+			res = serve("css/style.css", encoding, `"abc-`+encoding+`"`)
+			if res.StatusCode != http.StatusNotModified {
+				t.Errorf("Expected the encoded ETag to match, got %d", res.StatusCode)
+			}
+		})
+	}
+
+	t.Run("Identity", func(t *testing.T) {
+		res := serve("css/style.css", "", "")
+		if res.Header.Get("Content-Encoding") != "" || !hasVary(res.Header) || res.Header.Get("ETag") != `"abc"` {
+			t.Errorf("Unexpected headers %v", res.Header)
+		}
+		if body, _ := io.ReadAll(res.Body); !bytes.Equal(body, css) {
+			t.Errorf("Expected the file as is, got %d bytes", len(body))
+		}
+	})
+
+	for _, name := range []string{"small.css", "image.png"} {
+		t.Run("Uncompressed "+name, func(t *testing.T) {
+			res := serve(name, Zstd, "")
+			if res.StatusCode != http.StatusOK || res.Header.Get("Content-Encoding") != "" {
+				t.Errorf("Expected the file as is, got %d %v", res.StatusCode, res.Header)
+			}
+		})
+	}
+
+	t.Run("Missing", func(t *testing.T) {
+		if res := serve("missing.css", Zstd, ""); res.StatusCode != http.StatusNotFound {
+			t.Errorf("Expected a 404, got %d", res.StatusCode)
+		}
+	})
+}
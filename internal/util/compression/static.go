@@ -0,0 +1,111 @@
+package compression
+
+import (
+	"bytes"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strings"
+	"time"
+)
+
+// staticFile is a file of a Precompressed handler and its encodings.
+type staticFile struct {
+	name        string
+	contentType string
+	modTime     time.Time
+	encoded     map[string][]byte
+	encodings   []string // of encoded, preferred first
+}
+
+// Precompressed serves the files of a file system like http.FileServer,
+// except that the Compressible ones are compressed once, when it is created,
+// and served compressed to the clients that accept it.
+type Precompressed struct {
+	files map[string]*staticFile // keyed by path, without a leading slash
+	next  http.Handler
+}
+
+// NewPrecompressed compresses the files of fsys with zstd and gzip, keeping
+// the encodings that are smaller than the file, and serves the other requests
+// with http.FileServer.
+func NewPrecompressed(fsys fs.FS) (*Precompressed, error) {
+	p := &Precompressed{
+		files: make(map[string]*staticFile),
+		next:  http.FileServerFS(fsys),
+	}
+
+	err := fs.WalkDir(fsys, ".", func(name string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return err
+		}
+		contentType := mime.TypeByExtension(path.Ext(name))
+		if !Compressible(contentType) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		data, err := fs.ReadFile(fsys, name)
+		if err != nil || len(data) < MinSize {
+			return err
+		}
+
+		file := &staticFile{
+			name:        name,
+			contentType: contentType,
+			modTime:     info.ModTime(),
+			encoded:     make(map[string][]byte),
+		}
+		for _, c := range []Compressor{ZstdCompressor{}, GzipCompressor{}} {
+			compressed, err := c.Compress(data)
+			if err != nil {
+				return err
+			}
+			if len(compressed) < len(data) {
+				file.encoded[c.Name()] = compressed
+				file.encodings = append(file.encodings, c.Name())
+			}
+		}
+		p.files[name] = file
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *Precompressed) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	file, ok := p.files[strings.TrimPrefix(r.URL.Path, "/")]
+	if !ok {
+		p.next.ServeHTTP(w, r)
+		return
+	}
+
+	h := w.Header()
+	addVary(h)
+	encoding := negotiate(r.Header.Get("Accept-Encoding"), file.encodings)
+	if encoding == "" {
+		p.next.ServeHTTP(w, r)
+		return
+	}
+
+	h.Set("Content-Type", file.contentType)
+	h.Set("Content-Encoding", encoding)
+	if etag := h.Get("ETag"); etag != "" {
+		h.Set("ETag", encodedETag(etag, encoding))
+	}
+	http.ServeContent(w, r, file.name, file.modTime, bytes.NewReader(file.encoded[encoding]))
+}
+
+// encodedETag returns the entity tag of the representation of the file
+// tagged etag in encoding, as each encoding is a representation of its own.
+func encodedETag(etag, encoding string) string {
+	if quoted, ok := strings.CutSuffix(etag, `"`); ok {
+		return quoted + "-" + encoding + `"`
+	}
+	return etag + "-" + encoding
+}
@@ -1,25 +1,116 @@
 package compression
 
-import "github.com/klauspost/compress/zstd"
+import (
+	"fmt"
+	"sync"
+
+	"github.com/klauspost/compress/dict"
+	"github.com/klauspost/compress/zstd"
+)
+
+// zstdCodec is an encoder and decoder pair. Both keep a pool of states that
+// EncodeAll and DecodeAll reuse and may be called concurrently, so one pair
+// is shared by every caller instead of being built for each call.
+type zstdCodec struct {
+	encoder *zstd.Encoder
+	decoder *zstd.Decoder
+}
+
+func newZstdCodec(dictionary []byte) (*zstdCodec, error) {
+	var eopts []zstd.EOption
+	var dopts []zstd.DOption
+	if dictionary != nil {
+		eopts = append(eopts, zstd.WithEncoderDict(dictionary))
+		dopts = append(dopts, zstd.WithDecoderDicts(dictionary))
+	}
+
+	encoder, err := zstd.NewWriter(nil, eopts...)
+	if err != nil {
+		return nil, err
+	}
+	decoder, err := zstd.NewReader(nil, dopts...)
+	if err != nil {
+		encoder.Close()
+		return nil, err
+	}
+	return &zstdCodec{encoder: encoder, decoder: decoder}, nil
+}
+
+func (c *zstdCodec) compress(data []byte) []byte {
+	return c.encoder.EncodeAll(data, make([]byte, 0, len(data)/2))
+}
+
+func (c *zstdCodec) decompress(data []byte) ([]byte, error) {
+	return c.decoder.DecodeAll(data, nil)
+}
+
+var defaultZstd = sync.OnceValues(func() (*zstdCodec, error) {
+	return newZstdCodec(nil)
+})
 
 type ZstdCompressor struct{}
 
+func (z ZstdCompressor) Name() string { return Zstd }
+
 func (z ZstdCompressor) Compress(data []byte) ([]byte, error) {
-	encoder, err := zstd.NewWriter(nil)
+	codec, err := defaultZstd()
 	if err != nil {
 		return nil, err
 	}
-	defer encoder.Close()
-
-	return encoder.EncodeAll(data, nil), nil
+	return codec.compress(data), nil
 }
 
 func (z ZstdCompressor) Decompress(data []byte) ([]byte, error) {
-	decoder, err := zstd.NewReader(nil)
+	codec, err := defaultZstd()
+	if err != nil {
+		return nil, err
+	}
+	return codec.decompress(data)
+}
+
+// ZstdDictCompressor compresses with a trained zstd dictionary, which helps
+// small inputs that have too little data of their own to find repetitions
+// in. Data can only be decompressed with the same dictionary.
+type ZstdDictCompressor struct {
+	name  string
+	codec *zstdCodec
+}
+
+// NewZstdDictCompressor returns a compressor using the zstd dictionary in
+// dictionary, as built by TrainZstdDictionary.
+func NewZstdDictCompressor(dictionary []byte) (*ZstdDictCompressor, error) {
+	info, err := zstd.InspectDictionary(dictionary)
+	if err != nil {
+		return nil, fmt.Errorf("invalid zstd dictionary: %w", err)
+	}
+	codec, err := newZstdCodec(dictionary)
 	if err != nil {
 		return nil, err
 	}
-	defer decoder.Close()
+	return &ZstdDictCompressor{name: fmt.Sprintf("%s-dict-%d", Zstd, info.ID()), codec: codec}, nil
+}
+
+// Name includes the ID of the dictionary, so that data compressed with
+// different dictionaries is not confused.
+func (z *ZstdDictCompressor) Name() string { return z.name }
+
+func (z *ZstdDictCompressor) Compress(data []byte) ([]byte, error) {
+	return z.codec.compress(data), nil
+}
 
-	return decoder.DecodeAll(data, nil)
+func (z *ZstdDictCompressor) Decompress(data []byte) ([]byte, error) {
+	return z.codec.decompress(data)
+}
+
+// TrainZstdDictionary builds a zstd dictionary of at most size bytes from
+// samples of the data it will be used on.
+func TrainZstdDictionary(samples [][]byte, size int) ([]byte, error) {
+	dictionary, err := dict.BuildZstdDict(samples, dict.Options{
+		MaxDictSize: size,
+		HashBytes:   6,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error training zstd dictionary: %w", err)
+	}
+	return dictionary, nil
 }
@@ -0,0 +1,69 @@
+package util
+
+import (
+	"bytes"
+
+	"github.com/BurntSushi/toml"
+	"github.com/gomarkdown/markdown"
+)
+
+// frontMatterDelimiter opens and closes Mmark's TOML front matter.
+var frontMatterDelimiter = []byte("%%%")
+
+// MarkdownHash returns the content hash of a post's Markdown. Posts hash the
+// same however they were stored or edited, as long as they render the same.
+func MarkdownHash(md []byte) string {
+	return ContentHash(CanonicalMarkdown(md))
+}
+
+// CanonicalMarkdown returns md as its content hash is taken: with Unix
+// newlines, front matter re-encoded with sorted keys, and without a byte
+// order mark, leading blank lines or trailing whitespace.
+func CanonicalMarkdown(md []byte) []byte {
+	md = markdown.NormalizeNewlines(bytes.TrimPrefix(md, []byte("\ufeff")))
+	md = bytes.TrimLeft(md, "\n")
+
+	if front, body, ok := splitFrontMatter(md); ok {
+		var values map[string]any
+		var b bytes.Buffer
+		if _, err := toml.Decode(string(front), &values); err == nil {
+			b.Write(frontMatterDelimiter)
+			b.WriteByte('\n')
+			if err := toml.NewEncoder(&b).Encode(values); err == nil {
+				b.Write(frontMatterDelimiter)
+				b.WriteString("\n\n")
+				b.Write(bytes.TrimLeft(body, "\n"))
+				md = b.Bytes()
+			}
+		}
+	}
+
+	md = bytes.TrimRight(md, " \t\n")
+	if len(md) == 0 {
+		return md
+	}
+	return append(md, '\n')
+}
+
+// splitFrontMatter splits md into the contents of its front matter block
+// and the Markdown after it.
+func splitFrontMatter(md []byte) (front, body []byte, ok bool) {
+	rest, ok := bytes.CutPrefix(md, frontMatterDelimiter)
+	if !ok {
+		return nil, nil, false
+	}
+	opening, rest, ok := bytes.Cut(rest, []byte("\n"))
+	if !ok || len(bytes.TrimSpace(opening)) > 0 {
+		return nil, nil, false
+	}
+
+	for offset := 0; offset < len(rest); {
+		line, _, _ := bytes.Cut(rest[offset:], []byte("\n"))
+		if bytes.Equal(bytes.TrimSpace(line), frontMatterDelimiter) {
+			end := min(offset+len(line)+1, len(rest))
+			return rest[:offset], rest[end:], true
+		}
+		offset += len(line) + 1
+	}
+	return nil, nil, false
+}
@@ -0,0 +1,142 @@
+// Package tlsutil serves HTTPS with certificates that are reloaded when their
+// files change.
+package tlsutil
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/debemdeboas/the-archive/internal/config"
+	"github.com/rs/zerolog"
+)
+
+var tlsLogger zerolog.Logger
+
+func SetLogger(logger zerolog.Logger) {
+	tlsLogger = logger
+}
+
+// CertReloader holds the certificate of a certificate and key file pair, and
+// reads them again when they change.
+type CertReloader struct {
+	certFile string
+	keyFile  string
+
+	mu   sync.RWMutex
+	cert *tls.Certificate
+	// stamp identifies the version of the files cert was read from
+	stamp string
+}
+
+// NewCertReloader reads the PEM certificate chain in certFile and its
+// private key in keyFile.
+func NewCertReloader(certFile, keyFile string) (*CertReloader, error) {
+	r := &CertReloader{certFile: certFile, keyFile: keyFile}
+	if _, err := r.Reload(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// GetCertificate returns the current certificate, for tls.Config.
+func (r *CertReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.cert, nil
+}
+
+// Reload reads the files again if they changed since they were last read,
+// and reports whether they did. If they cannot be read, the current
+// certificate is kept.
+func (r *CertReloader) Reload() (bool, error) {
+	stamp, err := r.fileStamp()
+	if err != nil {
+		return false, err
+	}
+	r.mu.RLock()
+	unchanged := stamp == r.stamp
+	r.mu.RUnlock()
+	if unchanged {
+		return false, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(r.certFile, r.keyFile)
+	if err != nil {
+		return false, fmt.Errorf("error loading TLS certificate: %w", err)
+	}
+
+	r.mu.Lock()
+	r.cert, r.stamp = &cert, stamp
+	r.mu.Unlock()
+	return true, nil
+}
+
+// fileStamp returns the modification times and sizes of the files.
+func (r *CertReloader) fileStamp() (string, error) {
+	var stamp string
+	for _, path := range []string{r.certFile, r.keyFile} {
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", fmt.Errorf("error loading TLS certificate: %w", err)
+		}
+		stamp += fmt.Sprintf("%d:%d;", info.ModTime().UnixNano(), info.Size())
+	}
+	return stamp, nil
+}
+
+// Watch checks the files every interval in the background, until ctx is
+// done, and reloads the certificate when they change. Errors are logged and
+// the previous certificate is kept, as renewals may replace the files one at
+// a time.
+func (r *CertReloader) Watch(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+
+			reloaded, err := r.Reload()
+			if err != nil {
+				tlsLogger.Error().Err(err).Str("cert_file", r.certFile).Msg("Invalid TLS certificate, keeping the previous one")
+			} else if reloaded {
+				tlsLogger.Info().Str("cert_file", r.certFile).Msg("Reloaded TLS certificate")
+			}
+		}
+	}()
+}
+
+// NewServerConfig returns the TLS configuration of a server serving the
+// certificates of certs. If cfg sets a client CA file, clients may present a
+// certificate signed by one of its CAs, which is then verified; requests
+// that need one must check the verified chains of their connection.
+func NewServerConfig(cfg config.TLSConfig, certs *CertReloader) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certs.GetCertificate,
+	}
+
+	if cfg.ClientCAFile != "" {
+		data, err := os.ReadFile(cfg.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("error reading client CA file: %w", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return nil, errors.New("no certificates found in client CA file")
+		}
+		tlsConfig.ClientCAs = pool
+		tlsConfig.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return tlsConfig, nil
+}
@@ -0,0 +1,133 @@
+package tlsutil
+
+import (
+	"context"
+	"crypto/tls"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/debemdeboas/the-archive/internal/config"
+	"github.com/debemdeboas/the-archive/internal/tlstest"
+	"github.com/rs/zerolog"
+)
+
+// replace writes cert over the files, with a later modification time so
+// that the change is seen even on coarse clocks.
+func replace(t *testing.T, cert *tlstest.Certificate, certFile, keyFile string, age time.Duration) {
+	t.Helper()
+	for path, data := range map[string][]byte{certFile: cert.CertPEM, keyFile: cert.KeyPEM} {
+		if err := os.WriteFile(path, data, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		modTime := time.Now().Add(age)
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func serial(t *testing.T, r *CertReloader) string {
+	t.Helper()
+	cert, err := r.GetCertificate(&tls.ClientHelloInfo{})
+	if err != nil || cert == nil || cert.Leaf == nil {
+		t.Fatalf("Expected a certificate, got %v (%v)", cert, err)
+	}
+	return cert.Leaf.SerialNumber.String()
+}
+
+func TestCertReloader(t *testing.T) {
+	SetLogger(zerolog.Nop())
+	ca := tlstest.NewCA(t, "Test CA")
+	first, second, third := ca.Issue(t, "first"), ca.Issue(t, "second"), ca.Issue(t, "third")
+	certFile, keyFile := first.WriteFiles(t)
+
+	r, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("NewCertReloader failed: %v", err)
+	}
+	if serial(t, r) != first.Cert.SerialNumber.String() {
+		t.Error("Expected the first certificate")
+	}
+
+	t.Run("Unchanged files are not read again", func(t *testing.T) {
+		if reloaded, err := r.Reload(); reloaded || err != nil {
+			t.Errorf("Expected nothing to reload, got %v (%v)", reloaded, err)
+		}
+	})
+
+	t.Run("Changed files are read again", func(t *testing.T) {
+		replace(t, second, certFile, keyFile, time.Second)
+		if reloaded, err := r.Reload(); !reloaded || err != nil {
+			t.Fatalf("Expected the certificate to be reloaded, got %v (%v)", reloaded, err)
+		}
+		if serial(t, r) != second.Cert.SerialNumber.String() {
+			t.Error("Expected the second certificate")
+		}
+	})
+
+	t.Run("Invalid files keep the certificate", func(t *testing.T) {
+		// A renewal that replaced the certificate but not its key yet
+		if err := os.WriteFile(certFile, third.CertPEM, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		modTime := time.Now().Add(2 * time.Second)
+		os.Chtimes(certFile, modTime, modTime)
+
+		if _, err := r.Reload(); err == nil || !strings.Contains(err.Error(), "error loading TLS certificate") {
+			t.Errorf("Expected the mismatched key to fail, got %v", err)
+		}
+		if serial(t, r) != second.Cert.SerialNumber.String() {
+			t.Error("Expected the second certificate to be kept")
+		}
+	})
+
+	t.Run("Watch", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		r.Watch(ctx, 5*time.Millisecond)
+
+		replace(t, third, certFile, keyFile, 3*time.Second)
+		deadline := time.Now().Add(2 * time.Second)
+		for serial(t, r) != third.Cert.SerialNumber.String() {
+			if time.Now().After(deadline) {
+				t.Fatal("Timed out waiting for the certificate to be reloaded")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	})
+
+	t.Run("Missing files", func(t *testing.T) {
+		if _, err := NewCertReloader(certFile+".missing", keyFile); err == nil {
+			t.Error("Expected an error")
+		}
+	})
+}
+
+func TestNewServerConfig(t *testing.T) {
+	ca := tlstest.NewCA(t, "Test CA")
+	certFile, keyFile := ca.Issue(t, "localhost").WriteFiles(t)
+	certs, err := NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tlsConfig, err := NewServerConfig(config.TLSConfig{}, certs)
+	if err != nil || tlsConfig.ClientAuth != tls.NoClientCert || tlsConfig.ClientCAs != nil {
+		t.Errorf("Expected no client certificates to be requested, got %v (%v)", tlsConfig, err)
+	}
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	os.WriteFile(caFile, ca.CertPEM, 0o644)
+	tlsConfig, err = NewServerConfig(config.TLSConfig{ClientCAFile: caFile}, certs)
+	if err != nil || tlsConfig.ClientAuth != tls.VerifyClientCertIfGiven || tlsConfig.ClientCAs == nil {
+		t.Errorf("Expected client certificates to be verified, got %v (%v)", tlsConfig, err)
+	}
+
+	os.WriteFile(caFile, []byte("not a certificate"), 0o644)
+	if _, err := NewServerConfig(config.TLSConfig{ClientCAFile: caFile}, certs); err == nil || !strings.Contains(err.Error(), "no certificates") {
+		t.Errorf("Expected an invalid CA file, got %v", err)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"crypto/sha256"
 	"encoding/hex"
 	"fmt"
+	"strings"
 
 	"github.com/BurntSushi/toml"
 	"github.com/gomarkdown/markdown"
@@ -17,6 +18,9 @@ type ExtendedTitleData struct {
 	*mast.TitleData
 	Consumed     int
 	ToolbarTitle string
+
+	// Slug overrides the URL slug derived from the title.
+	Slug string `toml:"slug"`
 }
 
 func ContentHash(content []byte) string {
@@ -28,6 +32,45 @@ func ContentHashString(content string) string {
 	return ContentHash([]byte(content))
 }
 
+// slugReplacer transliterates common accented Latin characters before slugifying.
+var slugReplacer = strings.NewReplacer(
+	"á", "a", "à", "a", "â", "a", "ã", "a", "ä", "a", "å", "a",
+	"é", "e", "è", "e", "ê", "e", "ë", "e",
+	"í", "i", "ì", "i", "î", "i", "ï", "i",
+	"ó", "o", "ò", "o", "ô", "o", "õ", "o", "ö", "o", "ø", "o",
+	"ú", "u", "ù", "u", "û", "u", "ü", "u",
+	"ç", "c", "ñ", "n", "ß", "ss", "æ", "ae", "œ", "oe",
+)
+
+// MaxSlugLength is the maximum length of a slug returned by Slugify.
+const MaxSlugLength = 80
+
+// Slugify turns s into a lowercase, URL- and filename-safe string made of
+// ASCII letters, digits and single dashes. It returns "" if nothing is left.
+func Slugify(s string) string {
+	s = slugReplacer.Replace(strings.ToLower(s))
+
+	var b strings.Builder
+	dash := false
+	for _, c := range s {
+		if ('a' <= c && c <= 'z') || ('0' <= c && c <= '9') {
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(c)
+			dash = false
+		} else {
+			dash = true
+		}
+	}
+
+	slug := b.String()
+	if len(slug) > MaxSlugLength {
+		slug = strings.TrimRight(slug[:MaxSlugLength], "-")
+	}
+	return slug
+}
+
 func GetFrontMatter(md []byte) (*ExtendedTitleData, error) {
 	md = markdown.NormalizeNewlines(md)
 	md = bytes.TrimLeft(md, "\n \t\r")
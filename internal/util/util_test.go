@@ -1,6 +1,7 @@
 package util
 
 import (
+	"strings"
 	"testing"
 	"time"
 )
@@ -128,3 +129,74 @@ title = "No Date"
 		})
 	}
 }
+
+func TestSlugify(t *testing.T) {
+	testCases := map[string]string{
+		"Hello World":               "hello-world",
+		"  Leading and trailing!  ": "leading-and-trailing",
+		"Introdução à programação":  "introducao-a-programacao",
+		"Go 1.23 -- what's new?":    "go-1-23-what-s-new",
+		"日本語":                       "",
+		"already-a-slug":            "already-a-slug",
+		strings.Repeat("abcd ", 30): strings.TrimRight(strings.Repeat("abcd-", 16), "-"),
+	}
+
+	for input, expected := range testCases {
+		if got := Slugify(input); got != expected {
+			t.Errorf("Slugify(%q) = %q, expected %q", input, got, expected)
+		}
+	}
+}
+
+func TestMarkdownHash(t *testing.T) {
+	post := "%%%\ntitle = \"Hello World\"\ndate = 2025-01-01T00:00:00Z\n%%%\n\n# Content\n\nSome text.  \nA hard break.\n"
+
+	same := map[string]string{
+		"CRLF newlines":          strings.ReplaceAll(post, "\n", "\r\n"),
+		"CR newlines":            strings.ReplaceAll(post, "\n", "\r"),
+		"Byte order mark":        "\ufeff" + post,
+		"Surrounding blanks":     "\n\n" + post + "\n\n  \n",
+		"No trailing newline":    strings.TrimSuffix(post, "\n"),
+		"Front matter key order": "%%%\ndate = 2025-01-01T00:00:00Z\ntitle = \"Hello World\"\n%%%\n\n# Content\n\nSome text.  \nA hard break.\n",
+		"Front matter spacing":   "%%%\n\ntitle   =   'Hello World'\n\ndate = 2025-01-01T00:00:00Z\n%%%\n# Content\n\nSome text.  \nA hard break.\n",
+	}
+	want := MarkdownHash([]byte(post))
+	for name, md := range same {
+		if got := MarkdownHash([]byte(md)); got != want {
+			t.Errorf("%s: expected the hash of the original post\n%q\ngot the hash of\n%q", name, CanonicalMarkdown([]byte(post)), CanonicalMarkdown([]byte(md)))
+		}
+	}
+
+	different := map[string]string{
+		"Title":           strings.Replace(post, "Hello World", "Hello There", 1),
+		"Content":         strings.Replace(post, "Some text", "Other text", 1),
+		"Hard line break": strings.Replace(post, "text.  \n", "text.\n", 1),
+		"Indentation":     strings.Replace(post, "Some text", "    Some text", 1),
+	}
+	for name, md := range different {
+		if MarkdownHash([]byte(md)) == want {
+			t.Errorf("%s: expected a different hash", name)
+		}
+	}
+
+	t.Run("Invalid front matter is kept as is", func(t *testing.T) {
+		md := []byte("%%%\ntitle = \n%%%\n# Content\n")
+		if got := CanonicalMarkdown(md); string(got) != string(md) {
+			t.Errorf("Expected %q, got %q", md, got)
+		}
+	})
+
+	t.Run("The input is not modified", func(t *testing.T) {
+		md := []byte("# Content\r\n\r\n")
+		CanonicalMarkdown(md)
+		if string(md) != "# Content\r\n\r\n" {
+			t.Errorf("Input changed to %q", md)
+		}
+	})
+
+	t.Run("Empty", func(t *testing.T) {
+		if got := CanonicalMarkdown([]byte(" \n\n")); len(got) != 0 {
+			t.Errorf("Expected nothing, got %q", got)
+		}
+	})
+}
@@ -1,29 +1,42 @@
 package main
 
 import (
+	"context"
 	"crypto/rand"
 	"embed"
 	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
 	"fmt"
 	"html"
 	"html/template"
 	"io"
 	"io/fs"
+	"net"
 	"net/http"
 	"net/url"
 	"os"
-	"path/filepath"
+	"os/signal"
+	"reflect"
+	"slices"
+	"strconv"
 	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/joho/godotenv"
 	"github.com/rs/zerolog"
 
 	"github.com/debemdeboas/the-archive/internal/auth"
+	"github.com/debemdeboas/the-archive/internal/backup"
 	"github.com/debemdeboas/the-archive/internal/cache"
 	"github.com/debemdeboas/the-archive/internal/config"
 	"github.com/debemdeboas/the-archive/internal/db"
 	"github.com/debemdeboas/the-archive/internal/logger"
+	"github.com/debemdeboas/the-archive/internal/media"
+	"github.com/debemdeboas/the-archive/internal/media/library"
 	"github.com/debemdeboas/the-archive/internal/model"
 	"github.com/debemdeboas/the-archive/internal/render"
 	"github.com/debemdeboas/the-archive/internal/repository"
@@ -32,6 +45,8 @@ import (
 	"github.com/debemdeboas/the-archive/internal/sse"
 	"github.com/debemdeboas/the-archive/internal/theme"
 	"github.com/debemdeboas/the-archive/internal/util"
+	"github.com/debemdeboas/the-archive/internal/util/compression"
+	"github.com/debemdeboas/the-archive/internal/util/tlsutil"
 )
 
 // authStatusMiddleware adds authentication status to request context
@@ -55,40 +70,87 @@ type Application struct {
 	editorHandler *editor.Handler
 	authProvider  auth.AuthProvider
 	clients       *sse.SSEClients
+	mediaStore    media.Store
+	mediaRepo     repository.MediaRepository
+
+	// requireClientCert is set when the /api/ routes need a verified client
+	// certificate
+	requireClientCert bool
 }
 
 func main() {
+	configFile := flag.String("config", "config.yaml", "Path to the configuration file")
+	validate := flag.Bool("validate", false, "Check the configuration and exit")
+	overrides := config.Overrides{}
+	flag.Var(overrides, "set", "Override a configuration field, as section.field=value (can be repeated)")
+	watch := flag.Duration("watch", 2*time.Second, "How often the configuration file and TLS certificates are checked for changes, or 0 not to reload them")
+	flag.Parse()
+
 	err := godotenv.Load()
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error loading .env file: %v\n", err)
 	}
 
-	if err := config.LoadConfig("config.yaml"); err != nil {
-		fmt.Fprintf(os.Stderr, "Error loading config.yaml, using defaults: %v\n", err)
+	if *validate {
+		if err := config.ValidateFile(*configFile, overrides); err != nil {
+			fmt.Fprintf(os.Stderr, "%s is invalid: %v\n", *configFile, err)
+			os.Exit(1)
+		}
+		fmt.Printf("%s is valid\n", *configFile)
+		return
 	}
 
-	log := logger.New(config.AppConfig.Logging.Level)
+	if err := config.LoadConfigWithOverrides(*configFile, overrides); err != nil {
+		fmt.Fprintf(os.Stderr, "Error loading %s: %v\n", *configFile, err)
+		os.Exit(1)
+	}
+
+	cfg := config.Get()
+	log := logger.New(cfg.Logging.Level)
 
 	config.SetLogger(log)
 	db.SetLogger(log)
 	repository.SetLogger(log)
 	auth.SetLogger(log)
 	render.SetLogger(log)
+	tlsutil.SetLogger(log)
 
-	database := db.NewSQLite()
+	database, err := db.New(cfg.Database)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error creating database")
+	}
 	if err := database.InitDB(); err != nil {
 		log.Fatal().Err(err).Msg("Error initializing database")
 	}
 
-	postRepo := repository.NewDBPostRepository(database)
-	postRepo.SetReloadTimeout(time.Duration(config.AppConfig.Posts.ReloadTimeout) * time.Second)
+	postRepo, err := repository.NewPostRepositoryFromConfig(context.Background(), cfg.Posts, cfg.Repositories, database)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error initializing post repository")
+	}
+	sluggedRepo := repository.NewSluggedPostRepository(postRepo, repository.NewDBSlugRepository(database))
+
+	mediaRepo := repository.NewDBMediaRepository(database)
+	render.SetVariants(func(name string) ([]string, bool) {
+		m, err := mediaRepo.GetMedia(context.Background(), model.MediaID(name))
+		if err != nil {
+			if !errors.Is(err, repository.ErrMediaNotFound) {
+				log.Error().Err(err).Str("media_id", name).Msg("Error looking up image variants")
+			}
+			return nil, false
+		}
+		return m.Variants, true
+	})
+	mediaStore, err := media.NewStoreFromConfig(context.Background(), cfg.Media.Storage)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error initializing media storage")
+	}
 
 	editorRepo := editor.NewMemoryRepository()
 	clients := sse.NewSSEClients()
 	editorHandler := editor.NewHandler(editorRepo, clients, &content)
 
 	authProvider, err := auth.NewEd25519AuthProvider(
-		os.Getenv("ED25519_PUBKEY"),
+		cfg.Features.Authentication.PublicKey,
 		"Authorization",
 		model.UserID("admin"),
 	)
@@ -99,11 +161,13 @@ func main() {
 	app := &Application{
 		log:           log,
 		db:            database,
-		postRepo:      postRepo,
+		postRepo:      sluggedRepo,
 		editorRepo:    editorRepo,
 		editorHandler: editorHandler,
 		authProvider:  authProvider,
 		clients:       clients,
+		mediaStore:    mediaStore,
+		mediaRepo:     mediaRepo,
 	}
 
 	static, _ := fs.Sub(content, config.StaticLocalDir)
@@ -114,6 +178,209 @@ func main() {
 		return nil
 	})
 
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	app.postRepo.SetReloadNotifier(app.handleReloadPost)
+	if err := app.postRepo.Start(ctx); err != nil {
+		log.Fatal().Err(err).Msg("Error loading posts")
+	}
+
+	server := newServer(cfg.Server, loggingMiddleware(log)(cacheIt(compressed(app.routes()))))
+	server.RegisterOnShutdown(app.clients.Shutdown)
+
+	scheme := "http"
+	if tlsCfg := cfg.Server.TLS; tlsCfg.Enabled {
+		scheme = "https"
+		certs, err := tlsutil.NewCertReloader(tlsCfg.CertFile, tlsCfg.KeyFile)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Error loading TLS certificate")
+		}
+		if server.TLSConfig, err = tlsutil.NewServerConfig(tlsCfg, certs); err != nil {
+			log.Fatal().Err(err).Msg("Error configuring TLS")
+		}
+		app.requireClientCert = server.TLSConfig.ClientCAs != nil
+		if *watch > 0 {
+			certs.Watch(ctx, *watch)
+		}
+	}
+
+	listener, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Error listening")
+	}
+
+	// Plain HTTP requests are sent to the HTTPS server
+	var redirect *http.Server
+	var redirectListener net.Listener
+	if tlsCfg := cfg.Server.TLS; tlsCfg.Enabled && tlsCfg.RedirectAddr != "" {
+		redirect = newServer(cfg.Server, loggingMiddleware(log)(redirectToHTTPS(cfg.Server.Port)))
+		redirect.Addr = tlsCfg.RedirectAddr
+		if redirectListener, err = net.Listen("tcp", redirect.Addr); err != nil {
+			log.Fatal().Err(err).Msg("Error listening for HTTP redirects")
+		}
+		log.Info().Msg("Redirecting HTTP requests on " + redirectListener.Addr().String())
+	}
+
+	log.Info().Msg("Server started on " + scheme + "://" + listener.Addr().String())
+	log.Info().Msg("Using static files from " + config.StaticLocalDir)
+	log.Info().Msg("Using templates from " + config.TemplatesLocalDir)
+
+	if *watch > 0 {
+		config.Subscribe(func(old, new *config.Config) {
+			if sections := restartRequired(old, new); len(sections) > 0 {
+				log.Warn().Strs("sections", sections).Msg("Configuration changes that need a restart were not applied")
+			}
+		})
+		config.Watch(ctx, *configFile, overrides, *watch)
+	}
+
+	shutdownTimeout := time.Duration(cfg.Server.ShutdownTimeout) * time.Second
+	var redirecting sync.WaitGroup
+	if redirect != nil {
+		redirecting.Add(1)
+		go func() {
+			defer redirecting.Done()
+			if err := serve(ctx, log, redirect, redirectListener, shutdownTimeout); err != nil {
+				log.Error().Err(err).Msg("Redirect server closed")
+			}
+		}()
+	}
+	if err := serve(ctx, log, server, listener, shutdownTimeout); err != nil {
+		log.Error().Err(err).Msg("Server closed")
+	}
+	// The redirect server shuts down with the server, even if it failed
+	stop()
+	redirecting.Wait()
+
+	app.postRepo.Stop()
+	if err := database.Close(); err != nil {
+		log.Error().Err(err).Msg("Error closing database")
+	}
+}
+
+// newServer returns a server for handler with the address and timeouts of
+// cfg.
+func newServer(cfg config.ServerConfig, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              net.JoinHostPort(cfg.Host, cfg.Port),
+		Handler:           handler,
+		ReadHeaderTimeout: time.Duration(cfg.ReadTimeout) * time.Second,
+		ReadTimeout:       time.Duration(cfg.ReadTimeout) * time.Second,
+		WriteTimeout:      time.Duration(cfg.WriteTimeout) * time.Second,
+		IdleTimeout:       time.Duration(cfg.IdleTimeout) * time.Second,
+	}
+}
+
+// serve serves requests on listener, over TLS if the server has a TLS
+// configuration, until ctx is done. It then stops
+// accepting connections and waits up to timeout for the requests in progress,
+// such as event streams, before closing the connections left.
+func serve(ctx context.Context, log zerolog.Logger, server *http.Server, listener net.Listener, timeout time.Duration) error {
+	served := make(chan error, 1)
+	go func() {
+		if server.TLSConfig != nil {
+			served <- server.ServeTLS(listener, "", "")
+		} else {
+			served <- server.Serve(listener)
+		}
+	}()
+
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+	log.Info().Msg("Shutting down")
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil {
+		log.Warn().Err(err).Msg("Timed out waiting for connections to close")
+		server.Close()
+	}
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Feature flags of the configuration in use, checked on every request so
+// that features can be turned on and off without a restart.
+func themeSwitching(cfg *config.Config) bool { return cfg.Theme.AllowSwitching }
+func editorEnabled(cfg *config.Config) bool  { return cfg.Features.Editor.Enabled }
+func livePreview(cfg *config.Config) bool {
+	return cfg.Features.Editor.Enabled && cfg.Features.Editor.LivePreview
+}
+func draftsEnabled(cfg *config.Config) bool {
+	return cfg.Features.Editor.Enabled && cfg.Features.Editor.EnableDrafts
+}
+func draftPreview(cfg *config.Config) bool { return draftsEnabled(cfg) && livePreview(cfg) }
+func authEnabled(cfg *config.Config) bool  { return cfg.Features.Authentication.Enabled }
+
+// feature serves h while enabled returns true for the configuration in use,
+// and responds as if the route did not exist otherwise.
+func feature(enabled func(cfg *config.Config) bool, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !enabled(config.Get()) {
+			http.NotFound(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// compressed compresses the responses of h while compression is enabled.
+func compressed(h http.Handler) http.Handler {
+	compress := compression.Middleware(h)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.Get().Server.Compression {
+			compress.ServeHTTP(w, r)
+		} else {
+			h.ServeHTTP(w, r)
+		}
+	})
+}
+
+// staticFiles serves the embedded static files, compressed once at startup
+// and served compressed while compression is enabled.
+func (app *Application) staticFiles(static fs.FS) http.Handler {
+	files := http.FileServer(http.FS(static))
+	precompressed, err := compression.NewPrecompressed(static)
+	if err != nil {
+		app.log.Error().Err(err).Msg("Failed to compress static files, serving them uncompressed")
+		return files
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if config.Get().Server.Compression {
+			precompressed.ServeHTTP(w, r)
+		} else {
+			files.ServeHTTP(w, r)
+		}
+	})
+}
+
+// withAuthorization checks the signature of requests while authentication
+// is enabled.
+func (app *Application) withAuthorization(next http.Handler) http.Handler {
+	if app.authProvider == nil {
+		return next
+	}
+	authorized := app.authProvider.WithHeaderAuthorization()(next)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if authEnabled(config.Get()) {
+			authorized.ServeHTTP(w, r)
+		} else {
+			next.ServeHTTP(w, r)
+		}
+	})
+}
+
+// routes returns the handler of every route. Routes behind feature flags are
+// always registered and gated on each request.
+func (app *Application) routes() http.Handler {
+	static, _ := fs.Sub(content, config.StaticLocalDir)
+
 	mux := http.NewServeMux()
 
 	mux.HandleFunc(routes.RobotsPath, func(w http.ResponseWriter, r *http.Request) {
@@ -123,62 +390,43 @@ func main() {
 	})
 
 	mux.HandleFunc(routes.RootPath, app.serveIndex)
-	mux.Handle(config.StaticURLPath, http.StripPrefix(config.StaticURLPath, http.FileServer(http.FS(static))))
+	mux.Handle(config.StaticURLPath, http.StripPrefix(config.StaticURLPath, app.staticFiles(static)))
 
-	// Serve uploaded images from filesystem
-	mux.Handle("/static/uploads/", http.StripPrefix("/static/uploads/", http.FileServer(http.Dir("static/uploads/"))))
+	// Serve uploaded images from the configured media store
+	mux.Handle(config.UploadsURLPath, http.StripPrefix(config.UploadsURLPath, media.NewHandler(app.mediaStore)))
 
 	mux.HandleFunc(routes.AboutPath, app.serveProfile)
 	mux.HandleFunc(config.PostsURLPath, app.servePost)
 	mux.HandleFunc(routes.PartialsPost, app.servePartialsPost)
 
-	if config.AppConfig.Theme.AllowSwitching {
-		mux.HandleFunc(routes.ThemeToggle, app.serveThemePostToggle)
-		mux.HandleFunc(routes.ThemeOppositeIcon, app.serveThemeOppositeIcon)
-	}
+	mux.Handle(routes.ThemeToggle, feature(themeSwitching, http.HandlerFunc(app.serveThemePostToggle)))
+	mux.Handle(routes.ThemeOppositeIcon, feature(themeSwitching, http.HandlerFunc(app.serveThemeOppositeIcon)))
 	mux.HandleFunc(routes.SyntaxThemeSet, app.serveSyntaxThemePostSet)
 	mux.HandleFunc(routes.SyntaxThemeGet, app.serveSyntaxThemeGetTheme)
 
 	mux.HandleFunc(routes.SSEPath, app.eventsHandler)
 
-	if config.AppConfig.Features.Editor.Enabled {
-		// Editor routes (for editing existing posts) - protected by authentication
-		if config.AppConfig.Features.Authentication.Enabled {
-			mux.Handle(routes.EditPost, app.authProvider.WithHeaderAuthorization()(http.HandlerFunc(app.ServeEditPost)))
-		} else {
-			mux.Handle(routes.EditPost, http.HandlerFunc(app.ServeEditPost))
-		}
-		mux.HandleFunc(routes.APIPosts, app.handleAPIPosts)
-		mux.HandleFunc(routes.APIImages, app.handleAPIImages)
-
-		if config.AppConfig.Features.Editor.LivePreview {
-			mux.Handle(
-				routes.PartialsPostPreview,
-				http.HandlerFunc(app.midWithPostSaving(app.serveNewPostPreview)),
-			)
-		}
-
-		// Draft routes (for creating new posts)
-		if config.AppConfig.Features.Editor.EnableDrafts {
-			mux.HandleFunc(routes.NewPost, app.serveNewPost)
-			mux.Handle(routes.NewPostEdit, http.HandlerFunc(app.editorHandler.ServeNewDraftEditor))
-
-			if config.AppConfig.Features.Editor.LivePreview {
-				mux.Handle(
-					routes.PartialsDraftPreview,
-					http.HandlerFunc(app.midWithDraftSaving(app.serveNewPostPreview)),
-				)
-			}
+	// Editor routes (for editing existing posts) - protected by authentication
+	mux.Handle(routes.EditPost, feature(editorEnabled, app.withAuthorization(http.HandlerFunc(app.ServeEditPost))))
+	mux.Handle(routes.APIPosts, feature(editorEnabled, http.HandlerFunc(app.handleAPIPosts)))
+	mux.Handle(routes.APIImages, feature(editorEnabled, http.HandlerFunc(app.handleAPIImages)))
+	mux.Handle(routes.PartialsMedia, feature(editorEnabled, http.HandlerFunc(app.serveMediaBrowser)))
+	mux.Handle(routes.PartialsPostPreview, feature(livePreview, app.midWithPostSaving(app.serveNewPostPreview)))
+
+	// Draft routes (for creating new posts)
+	mux.Handle(routes.NewPost, feature(draftsEnabled, http.HandlerFunc(app.serveNewPost)))
+	mux.Handle(routes.NewPostEdit, feature(draftsEnabled, http.HandlerFunc(app.editorHandler.ServeNewDraftEditor)))
+	mux.Handle(routes.PartialsDraftPreview, feature(draftPreview, app.midWithDraftSaving(app.serveNewPostPreview)))
+
+	if provider, ok := app.authProvider.(*auth.Ed25519AuthProvider); ok {
+		authMux := http.NewServeMux()
+		auth.RegisterEd25519AuthRoutes(authMux, provider, &content)
+		for _, path := range []string{routes.AuthChallenge, routes.AuthVerify, routes.AuthLogin} {
+			mux.Handle(path, feature(authEnabled, authMux))
 		}
+		mux.Handle(routes.APIBackups, feature(authEnabled, http.HandlerFunc(app.handleAPIBackups)))
 	}
 
-	if config.AppConfig.Features.Authentication.Enabled {
-		auth.RegisterEd25519AuthRoutes(mux, app.authProvider.(*auth.Ed25519AuthProvider), &content)
-	}
-
-	go app.postRepo.Init()
-	app.postRepo.SetReloadNotifier(app.handleReloadPost)
-
 	securedMux := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		if r.URL.Path == routes.RobotsPath {
 			mux.ServeHTTP(w, r)
@@ -187,18 +435,61 @@ func main() {
 		}
 	})
 
-	var finalHandler http.Handler
-	if config.AppConfig.Features.Authentication.Enabled {
-		finalHandler = app.authProvider.WithHeaderAuthorization()(app.authStatusMiddleware(securedMux))
-	} else {
-		finalHandler = app.authStatusMiddleware(securedMux)
-	}
+	return app.withClientCertificate(app.withAuthorization(app.authStatusMiddleware(securedMux)))
+}
 
-	log.Info().Msg("Server started on " + config.AppConfig.Server.Host + ":" + config.AppConfig.Server.Port)
-	log.Info().Msg("Using static files from " + config.StaticLocalDir)
-	log.Info().Msg("Using templates from " + config.TemplatesLocalDir)
+// withClientCertificate rejects requests to the /api/ routes made without a
+// verified client certificate, when they need one.
+func (app *Application) withClientCertificate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.requireClientCert && strings.HasPrefix(r.URL.Path, routes.APIPrefix) && (r.TLS == nil || len(r.TLS.VerifiedChains) == 0) {
+			http.Error(w, "Client certificate required", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
 
-	log.Fatal().Err(http.ListenAndServe(config.AppConfig.Server.Host+":"+config.AppConfig.Server.Port, loggingMiddleware(log)(cacheIt(finalHandler)))).Msg("Server closed")
+// redirectToHTTPS redirects requests to the same URL over HTTPS on port.
+func redirectToHTTPS(port string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		if port != "443" {
+			host = net.JoinHostPort(host, port)
+		} else if strings.Contains(host, ":") {
+			host = "[" + host + "]"
+		}
+		http.Redirect(w, r, "https://"+host+r.URL.RequestURI(), http.StatusPermanentRedirect)
+	})
+}
+
+// restartRequired returns the sections of the configuration that changed
+// from old to new but are only read on startup.
+func restartRequired(old, new *config.Config) []string {
+	if old == nil || new == nil {
+		return nil
+	}
+	sections := map[string][2]any{
+		"server":        {old.Server, new.Server},
+		"logging":       {old.Logging, new.Logging},
+		"database":      {old.Database, new.Database},
+		"repositories":  {old.Repositories, new.Repositories},
+		"posts":         {old.Posts, new.Posts},
+		"media.storage": {old.Media.Storage, new.Media.Storage},
+
+		"features.authentication.public_key": {old.Features.Authentication.PublicKey, new.Features.Authentication.PublicKey},
+	}
+	var changed []string
+	for name, values := range sections {
+		if !reflect.DeepEqual(values[0], values[1]) {
+			changed = append(changed, name)
+		}
+	}
+	slices.Sort(changed)
+	return changed
 }
 
 func (app *Application) serveThemeOppositeIcon(w http.ResponseWriter, r *http.Request) {
@@ -361,6 +652,9 @@ func secureHeaders(h http.HandlerFunc) http.HandlerFunc {
 		w.Header().Set("X-Frame-Options", "deny")
 		w.Header().Set("X-Content-Type-Options", "nosniff")
 		w.Header().Set("X-XSS-Protection", "1; mode=block")
+		if maxAge := config.Get().Server.TLS.HSTSMaxAge; r.TLS != nil && maxAge > 0 {
+			w.Header().Set("Strict-Transport-Security", "max-age="+strconv.Itoa(maxAge))
+		}
 		h(w, r)
 	}
 }
@@ -396,6 +690,13 @@ func (app *Application) servePost(w http.ResponseWriter, r *http.Request) {
 	}
 	post, err := app.postRepo.ReadPost(postID)
 	if err != nil {
+		// Posts whose title changed are still reachable through their old slugs
+		if slugs, ok := app.postRepo.(*repository.SluggedPostRepository); ok {
+			if slug, ok := slugs.Redirect(r.Context(), postID); ok {
+				http.Redirect(w, r, config.PostsURLPath+slug, http.StatusMovedPermanently)
+				return
+			}
+		}
 		http.NotFound(w, r)
 		return
 	}
@@ -449,7 +750,7 @@ func (app *Application) servePost(w http.ResponseWriter, r *http.Request) {
 
 func (app *Application) serveThemePostToggle(w http.ResponseWriter, r *http.Request) {
 	currentTheme := theme.GetThemeFromRequest(r)
-	newTheme := config.AppConfig.Theme.Default
+	newTheme := config.Get().Theme.Default
 	if currentTheme == config.DarkTheme {
 		newTheme = config.LightTheme
 	}
@@ -511,6 +812,10 @@ func (app *Application) eventsHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Streaming unsupported", http.StatusInternalServerError)
 		return
 	}
+	// Event streams stay open for longer than the write timeout of the server
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Time{}); err != nil {
+		l.Warn().Err(err).Msg("Cannot clear the write deadline of the event stream")
+	}
 	fmt.Fprintf(w, "event: connected\ndata: SSE connection established\n\n")
 	flusher.Flush()
 	client := &sse.Client{
@@ -531,11 +836,21 @@ func (app *Application) eventsHandler(w http.ResponseWriter, r *http.Request) {
 			flusher.Flush()
 		case <-notify:
 			return
+		case <-app.clients.Done():
+			// Let the client know it should reconnect later, before the
+			// server waits for this request to finish
+			fmt.Fprintf(w, "event: shutdown\ndata: Server is shutting down\n\n")
+			flusher.Flush()
+			return
 		}
 	}
 }
 
-func (app *Application) handleReloadPost(postID model.PostID) {
+func (app *Application) handleReloadPost(postID model.PostID, event model.PostEvent) {
+	// Clients only watch posts that already exist, so new posts need no message
+	if event == model.PostCreated {
+		return
+	}
 	go app.clients.Broadcast(postID, "reload")
 }
 
@@ -570,11 +885,12 @@ func (app *Application) handleAPIPosts(w http.ResponseWriter, r *http.Request) {
 			post.Title = "Untitled - " + post.CreatedDate.Format("2006-01-02")
 		}
 
-		if err := app.postRepo.SavePost(post); err != nil {
+		if err := app.postRepo.SavePost(r.Context(), post); err != nil {
 			l.Error().Err(err).Str("post_id", string(post.ID)).Str("user_id", string(usrID)).Msg("Failed to save post")
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		app.syncMediaReferences(r.Context(), post)
 	case http.MethodPut:
 		postID := r.PathValue("id")
 		content := r.FormValue("content")
@@ -594,16 +910,25 @@ func (app *Application) handleAPIPosts(w http.ResponseWriter, r *http.Request) {
 			post.Title = frontMatter.Title
 		}
 
-		if err := app.postRepo.SetPostContent(post); err != nil {
+		if err := app.postRepo.SetPostContent(r.Context(), post); err != nil {
 			l.Error().Err(err).Str("post_id", string(post.ID)).Str("user_id", string(usrID)).Msg("Failed to set post content")
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
+		app.syncMediaReferences(r.Context(), post)
 	default:
 		http.Error(w, config.HTTPErrMethodNotAllowed, http.StatusMethodNotAllowed)
 	}
 }
 
+// syncMediaReferences records which uploads a post uses. Failures only affect
+// the media library, so they are logged instead of failing the save.
+func (app *Application) syncMediaReferences(ctx context.Context, post *model.Post) {
+	if _, err := library.SyncPostReferences(ctx, app.mediaRepo, post); err != nil {
+		zerolog.Ctx(ctx).Error().Err(err).Str("post_id", string(post.ID)).Msg("Failed to update media references")
+	}
+}
+
 func (app *Application) handleAPIImages(w http.ResponseWriter, r *http.Request) {
 	l := zerolog.Ctx(r.Context())
 
@@ -620,14 +945,21 @@ func (app *Application) handleAPIImages(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Parse multipart form with 10MB limit
-	if err := r.ParseMultipartForm(10 << 20); err != nil {
+	maxUploadSize := int64(config.Get().Media.MaxUploadSize) << 20
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadSize)
+	if err := r.ParseMultipartForm(maxUploadSize); err != nil {
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			l.Warn().Int64("limit", maxBytesErr.Limit).Msg("Image upload exceeds size limit")
+			http.Error(w, "Image too large", http.StatusRequestEntityTooLarge)
+			return
+		}
 		l.Error().Err(err).Msg("Failed to parse multipart form")
 		http.Error(w, "Failed to parse form", http.StatusBadRequest)
 		return
 	}
 
-	file, header, err := r.FormFile("image")
+	file, _, err := r.FormFile("image")
 	if err != nil {
 		l.Error().Err(err).Msg("Failed to get image from form")
 		http.Error(w, "No image file found", http.StatusBadRequest)
@@ -635,12 +967,21 @@ func (app *Application) handleAPIImages(w http.ResponseWriter, r *http.Request)
 	}
 	defer file.Close()
 
-	// Validate file type
-	contentType := header.Header.Get("Content-Type")
-	if !strings.HasPrefix(contentType, "image/") {
-		l.Warn().Str("content_type", contentType).Msg("Invalid file type for image upload")
-		http.Error(w, "File must be an image", http.StatusBadRequest)
+	data, err := io.ReadAll(file)
+	if err != nil {
+		l.Error().Err(err).Msg("Failed to read uploaded image")
+		http.Error(w, "Failed to read image", http.StatusBadRequest)
+		return
+	}
+
+	// Uploading the same file twice reuses the existing upload
+	sourceHash := util.ContentHash(data)
+	if existing, err := app.mediaRepo.GetMediaBySourceHash(r.Context(), sourceHash); err == nil {
+		l.Info().Str("user_id", string(usrID)).Str("image_url", existing.URL()).Msg("Duplicate image upload, reusing existing file")
+		writeImageResponse(w, existing.URL(), existing.Width, existing.Height)
 		return
+	} else if !errors.Is(err, repository.ErrMediaNotFound) {
+		l.Error().Err(err).Msg("Failed to look up existing media")
 	}
 
 	// Generate unique filename
@@ -651,71 +992,166 @@ func (app *Application) handleAPIImages(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	filename := hex.EncodeToString(randomBytes)
+	// Validate, strip metadata and generate responsive variants
+	img, err := media.ProcessImage(hex.EncodeToString(randomBytes), data, media.ImageOptionsFromConfig(config.Get().Media))
+	if err != nil {
+		l.Warn().Err(err).Msg("Invalid image upload")
+		switch {
+		case errors.Is(err, media.ErrImageTooLarge):
+			http.Error(w, "Image dimensions too large", http.StatusRequestEntityTooLarge)
+		case errors.Is(err, media.ErrUnsupportedFormat):
+			http.Error(w, "File must be a JPEG, PNG, GIF or WebP image", http.StatusBadRequest)
+		default:
+			http.Error(w, "Invalid image", http.StatusBadRequest)
+		}
+		return
+	}
 
-	// Determine file extension from content type
-	var ext string
-	switch contentType {
-	case "image/jpeg":
-		ext = ".jpg"
-	case "image/png":
-		ext = ".png"
-	case "image/gif":
-		ext = ".gif"
-	case "image/webp":
-		ext = ".webp"
-	default:
-		ext = ".jpg" // default fallback
+	// Store the variants first so the original never references missing files
+	for _, v := range append(img.Variants, img.Original) {
+		if err := app.mediaStore.Put(r.Context(), v.Name, v.Data, img.ContentType); err != nil {
+			l.Error().Err(err).Str("name", v.Name).Msg("Failed to save file")
+			http.Error(w, "Failed to save file", http.StatusInternalServerError)
+			return
+		}
 	}
 
-	filename += ext
+	mediaItem := &model.Media{
+		ID:          model.MediaID(img.Original.Name),
+		Owner:       usrID,
+		ContentType: img.ContentType,
+		Size:        int64(len(img.Original.Data)),
+		Width:       img.Original.Width,
+		Height:      img.Original.Height,
+		SourceHash:  sourceHash,
+		CreatedDate: time.Now().UTC(),
+	}
+	for _, v := range img.Variants {
+		mediaItem.Variants = append(mediaItem.Variants, v.Name)
+	}
+	if err := app.mediaRepo.SaveMedia(r.Context(), mediaItem); err != nil {
+		// The file is stored and usable, it is only missing from the library
+		l.Error().Err(err).Str("name", img.Original.Name).Msg("Failed to record uploaded media")
+	}
+
+	// Return the URL path to the uploaded image
+	imageURL := mediaItem.URL()
+	l.Info().
+		Str("user_id", string(usrID)).
+		Str("image_url", imageURL).
+		Str("content_type", img.ContentType).
+		Int("variants", len(img.Variants)).
+		Msg("Image uploaded successfully")
+
+	writeImageResponse(w, imageURL, img.Original.Width, img.Original.Height)
+}
+
+func writeImageResponse(w http.ResponseWriter, imageURL string, width, height int) {
+	w.Header().Set(config.HCType, config.CTypeJSON)
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(struct {
+		URL    string `json:"url"`
+		Width  int    `json:"width"`
+		Height int    `json:"height"`
+	}{imageURL, width, height})
+}
 
-	// Create uploads directory if it doesn't exist
-	uploadsDir := filepath.Join("static", "uploads")
-	if err := os.MkdirAll(uploadsDir, 0755); err != nil {
-		l.Error().Err(err).Str("dir", uploadsDir).Msg("Failed to create uploads directory")
-		http.Error(w, "Failed to create uploads directory", http.StatusInternalServerError)
+func (app *Application) serveMediaBrowser(w http.ResponseWriter, r *http.Request) {
+	l := zerolog.Ctx(r.Context())
+	if _, err := app.authProvider.EnforceUserAndGetID(w, r); err != nil {
+		l.Error().Err(err).Str("method", r.Method).Str("path", r.URL.Path).Msg("Unauthorized media library access attempt")
+		return
+	}
+	if r.Method != http.MethodGet {
+		http.Error(w, config.HTTPErrMethodNotAllowed, http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Create the file
-	filePath := filepath.Join(uploadsDir, filename)
-	dst, err := os.Create(filePath)
+	mediaList, err := app.mediaRepo.ListMedia(r.Context())
 	if err != nil {
-		l.Error().Err(err).Str("file_path", filePath).Msg("Failed to create file")
-		http.Error(w, "Failed to create file", http.StatusInternalServerError)
+		l.Error().Err(err).Msg("Failed to list media")
+		http.Error(w, "Failed to list media", http.StatusInternalServerError)
 		return
 	}
-	defer dst.Close()
 
-	// Copy the uploaded file to the destination
-	if _, err := io.Copy(dst, file); err != nil {
-		l.Error().Err(err).Str("file_path", filePath).Msg("Failed to save file")
-		http.Error(w, "Failed to save file", http.StatusInternalServerError)
+	tmpl, err := template.ParseFS(content, config.TemplatesLocalDir+"/"+config.TemplateMedia)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
-	// Return the URL path to the uploaded image
-	imageURL := "/static/uploads/" + filename
-	l.Info().Str("user_id", string(usrID)).Str("image_url", imageURL).Str("content_type", contentType).Msg("Image uploaded successfully")
+	w.Header().Set(config.HCType, config.CTypeHTML)
+	err = tmpl.ExecuteTemplate(w, "media-browser", struct{ Media []model.Media }{mediaList})
+	if err != nil {
+		l.Error().Err(err).Msg("Failed to execute media template")
+	}
+}
 
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintf(w, `{"url": "%s"}`, imageURL)
+// handleAPIBackups lists the database snapshots, or takes a new one and
+// deletes the oldest ones beyond the configured retention.
+func (app *Application) handleAPIBackups(w http.ResponseWriter, r *http.Request) {
+	l := zerolog.Ctx(r.Context())
+	usrID, err := app.authProvider.EnforceUserAndGetID(w, r)
+	if err != nil {
+		l.Error().Err(err).Str("method", r.Method).Str("path", r.URL.Path).Msg("Unauthorized backup attempt")
+		return
+	}
+
+	cfg := config.Get().Database.Backup
+	switch r.Method {
+	case http.MethodGet:
+		snapshots, err := backup.List(cfg.Dir)
+		if err != nil {
+			l.Error().Err(err).Msg("Failed to list backups")
+			http.Error(w, "Failed to list backups", http.StatusInternalServerError)
+			return
+		}
+		if snapshots == nil {
+			snapshots = []backup.Info{}
+		}
+		w.Header().Set(config.HCType, config.CTypeJSON)
+		json.NewEncoder(w).Encode(snapshots)
+	case http.MethodPost:
+		info, err := backup.Create(r.Context(), app.db, cfg)
+		if errors.Is(err, backup.ErrUnsupported) {
+			http.Error(w, err.Error(), http.StatusNotImplemented)
+			return
+		} else if err != nil {
+			l.Error().Err(err).Msg("Failed to back up database")
+			http.Error(w, "Failed to back up database", http.StatusInternalServerError)
+			return
+		}
+		l.Info().Str("user_id", string(usrID)).Str("backup", info.Name).Int64("size", info.Size).Msg("Database backed up")
+
+		deleted, err := backup.Rotate(cfg.Dir, cfg.Keep)
+		if err != nil {
+			l.Error().Err(err).Msg("Failed to delete old backups")
+		}
+		for _, s := range deleted {
+			l.Info().Str("backup", s.Name).Msg("Old backup deleted")
+		}
+
+		w.Header().Set(config.HCType, config.CTypeJSON)
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(info)
+	default:
+		http.Error(w, config.HTTPErrMethodNotAllowed, http.StatusMethodNotAllowed)
+	}
 }
 
 func (app *Application) serveProfile(w http.ResponseWriter, r *http.Request) {
 	l := zerolog.Ctx(r.Context())
 
-	if !config.AppConfig.Profile.Enabled {
+	profile := config.Get().Profile
+	if !profile.Enabled {
 		http.NotFound(w, r)
 		return
 	}
 
 	// Read local profile markdown file from config
-	readmeContent, err := os.ReadFile(config.AppConfig.Profile.ContentFile)
+	readmeContent, err := os.ReadFile(profile.ContentFile)
 	if err != nil {
-		l.Error().Err(err).Str("file", config.AppConfig.Profile.ContentFile).Msg("Failed to read profile content file")
+		l.Error().Err(err).Str("file", profile.ContentFile).Msg("Failed to read profile content file")
 		http.Error(w, "Profile data not found", http.StatusNotFound)
 		return
 	}
@@ -734,10 +1170,10 @@ func (app *Application) serveProfile(w http.ResponseWriter, r *http.Request) {
 	}{
 		PageData:       model.NewPageData(r),
 		ProfileContent: template.HTML(unescapedHTML),
-		ProfileName:    config.AppConfig.Profile.Name,
-		ProfileImage:   config.AppConfig.Profile.ImageURL,
-		ProfileCVURL:   config.AppConfig.Profile.CVURL,
-		ProfileEmail:   config.AppConfig.Profile.Email,
+		ProfileName:    profile.Name,
+		ProfileImage:   profile.ImageURL,
+		ProfileCVURL:   profile.CVURL,
+		ProfileEmail:   profile.Email,
 	}
 
 	if extTitleData, ok := frontMatter.(*util.ExtendedTitleData); ok {
@@ -1,27 +1,42 @@
 package main
 
 import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strconv"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/debemdeboas/the-archive/internal/auth"
 	"github.com/debemdeboas/the-archive/internal/auth/testdata"
+	"github.com/debemdeboas/the-archive/internal/backup"
 	"github.com/debemdeboas/the-archive/internal/config"
 	"github.com/debemdeboas/the-archive/internal/db"
+	"github.com/debemdeboas/the-archive/internal/media"
 	"github.com/debemdeboas/the-archive/internal/model"
 	"github.com/debemdeboas/the-archive/internal/repository"
 	"github.com/debemdeboas/the-archive/internal/repository/editor"
+	"github.com/debemdeboas/the-archive/internal/routes"
 	"github.com/debemdeboas/the-archive/internal/sse"
+	"github.com/debemdeboas/the-archive/internal/tlstest"
+	"github.com/debemdeboas/the-archive/internal/util/tlsutil"
 	"github.com/rs/zerolog"
 )
 
 // newTestApplication creates a test application using existing patterns
 func newTestApplication(t *testing.T) *Application {
 	// Setup minimal config for testing
-	config.AppConfig = &config.Config{
+	config.Set(&config.Config{
 		Site: config.SiteConfig{
 			Name:        "Test Blog",
 			Description: "Test blog for unit testing",
@@ -38,29 +53,16 @@ func newTestApplication(t *testing.T) *Application {
 				Enabled: true,
 			},
 		},
-	}
+	})
 
 	// Create database - use a temporary file for testing
-	dbFile, err := os.CreateTemp("", "test-db-*.sqlite")
-	if err != nil {
-		t.Fatalf("Failed to create temp db file: %v", err)
-	}
-	dbFile.Close()
-
-	// Override the database path for testing
-	originalDB := os.Getenv("DATABASE_PATH")
-	os.Setenv("DATABASE_PATH", dbFile.Name())
-	defer func() {
-		os.Setenv("DATABASE_PATH", originalDB)
-	}()
-
-	database := db.NewSQLite()
+	database := db.NewSQLite(config.DatabaseConfig{Path: filepath.Join(t.TempDir(), "test.db")})
 	if err := database.InitDB(); err != nil {
 		t.Fatalf("Failed to initialize test database: %v", err)
 	}
 
 	// Create components
-	postRepo := repository.NewDBPostRepository(database)
+	postRepo := repository.NewSluggedPostRepository(repository.NewDBPostRepository(database), repository.NewDBSlugRepository(database))
 	editorRepo := editor.NewMemoryRepository()
 	clients := sse.NewSSEClients()
 	editorHandler := editor.NewHandler(editorRepo, clients, &content)
@@ -86,12 +88,13 @@ func newTestApplication(t *testing.T) *Application {
 		editorHandler: editorHandler,
 		authProvider:  authProvider,
 		clients:       clients,
+		mediaStore:    media.NewFSStore(t.TempDir()),
+		mediaRepo:     repository.NewDBMediaRepository(database),
 	}
 
 	// Cleanup
 	t.Cleanup(func() {
 		database.Close()
-		os.Remove(dbFile.Name())
 	})
 
 	return app
@@ -150,6 +153,55 @@ func TestServePost(t *testing.T) {
 	}
 }
 
+func TestServePostBySlug(t *testing.T) {
+	ctx := context.Background()
+	app := newTestApplication(t)
+
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "slug-test.md"), []byte("%%%\ntitle = \"Slug Test Before\"\n%%%\n\nContent"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	postRepo := repository.NewSluggedPostRepository(repository.NewFSPostRepository(dir), repository.NewDBSlugRepository(app.db))
+	if err := postRepo.Start(ctx); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+	t.Cleanup(postRepo.Stop)
+	app.postRepo = postRepo
+
+	get := func(path string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("GET", path, nil)
+		recorder := httptest.NewRecorder()
+		app.servePost(recorder, req)
+		return recorder
+	}
+
+	if recorder := get("/posts/slug-test-before"); recorder.Code != http.StatusOK {
+		t.Fatalf("Expected post to be served by slug, got %d", recorder.Code)
+	}
+
+	post, err := postRepo.ReadPost("slug-test-before")
+	if err != nil {
+		t.Fatalf("ReadPost failed: %v", err)
+	}
+	if recorder := get("/posts/" + string(post.ID)); recorder.Code != http.StatusOK {
+		t.Errorf("Expected post to be served by ID, got %d", recorder.Code)
+	}
+
+	post.Title = "Slug Test After"
+	post.Markdown = []byte("%%%\ntitle = \"Slug Test After\"\n%%%\n\nContent")
+	if err := postRepo.SetPostContent(ctx, post); err != nil {
+		t.Fatalf("SetPostContent failed: %v", err)
+	}
+
+	recorder := get("/posts/slug-test-before")
+	if recorder.Code != http.StatusMovedPermanently {
+		t.Fatalf("Expected 301 for the old slug, got %d", recorder.Code)
+	}
+	if location := recorder.Header().Get("Location"); location != "/posts/slug-test-after" {
+		t.Errorf("Unexpected redirect location %q", location)
+	}
+}
+
 func TestHandleApiPosts(t *testing.T) {
 	app := newTestApplication(t)
 
@@ -200,6 +252,56 @@ func TestHandleApiPosts(t *testing.T) {
 	}
 }
 
+func TestServeMediaBrowser(t *testing.T) {
+	ctx := context.Background()
+	app := newTestApplication(t)
+
+	// The test database is shared between runs, so use a fresh upload ID
+	id := strconv.FormatInt(time.Now().UnixNano(), 16)
+	item := &model.Media{
+		ID:          model.MediaID(id + ".1600x900.jpg"),
+		Owner:       model.UserID(testdata.TestUserID),
+		Width:       1600,
+		Height:      900,
+		SourceHash:  id,
+		Variants:    []string{id + ".1600x900.480w.jpg"},
+		CreatedDate: time.Now().UTC(),
+	}
+	if err := app.mediaRepo.SaveMedia(ctx, item); err != nil {
+		t.Fatalf("Failed to save media: %v", err)
+	}
+
+	t.Run("Unauthenticated returns 401", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		app.serveMediaBrowser(recorder, httptest.NewRequest(http.MethodGet, routes.PartialsMedia, nil))
+		if recorder.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status 401, got %d", recorder.Code)
+		}
+	})
+
+	t.Run("Lists uploads", func(t *testing.T) {
+		req := httptest.NewRequest(http.MethodGet, routes.PartialsMedia, nil)
+		req = req.WithContext(auth.ContextWithUserID(req.Context(), model.UserID(testdata.TestUserID)))
+		recorder := httptest.NewRecorder()
+
+		app.serveMediaBrowser(recorder, req)
+
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", recorder.Code)
+		}
+		body := recorder.Body.String()
+		for _, expected := range []string{
+			`data-markdown="![Image](/static/uploads/` + id + `.1600x900.jpg)"`,
+			`src="/static/uploads/` + id + `.1600x900.480w.jpg"`,
+			"unused",
+		} {
+			if !strings.Contains(body, expected) {
+				t.Errorf("Expected body to contain %s, got:\n%s", expected, body)
+			}
+		}
+	})
+}
+
 func TestApplicationComponents(t *testing.T) {
 	app := newTestApplication(t)
 
@@ -249,3 +351,346 @@ func TestApplicationComponents(t *testing.T) {
 		}
 	})
 }
+
+func TestHandleAPIBackups(t *testing.T) {
+	app := newTestApplication(t)
+	config.Get().Database.Backup = config.DatabaseBackupConfig{Dir: t.TempDir(), Compress: true, Keep: 1}
+
+	authenticated := func(method string) *http.Request {
+		req := httptest.NewRequest(method, routes.APIBackups, nil)
+		return req.WithContext(auth.ContextWithUserID(req.Context(), model.UserID(testdata.TestUserID)))
+	}
+
+	t.Run("Unauthenticated returns 401", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		app.handleAPIBackups(recorder, httptest.NewRequest(http.MethodPost, routes.APIBackups, nil))
+		if recorder.Code != http.StatusUnauthorized {
+			t.Errorf("Expected status 401, got %d", recorder.Code)
+		}
+	})
+
+	t.Run("POST takes a snapshot", func(t *testing.T) {
+		for range 2 {
+			recorder := httptest.NewRecorder()
+			app.handleAPIBackups(recorder, authenticated(http.MethodPost))
+			if recorder.Code != http.StatusCreated {
+				t.Fatalf("Expected status 201, got %d: %s", recorder.Code, recorder.Body.String())
+			}
+
+			var info backup.Info
+			if err := json.NewDecoder(recorder.Body).Decode(&info); err != nil || !info.Compressed || info.Size == 0 {
+				t.Errorf("Unexpected snapshot %+v (%v)", info, err)
+			}
+			time.Sleep(2 * time.Millisecond)
+		}
+	})
+
+	t.Run("GET lists the snapshots that were kept", func(t *testing.T) {
+		recorder := httptest.NewRecorder()
+		app.handleAPIBackups(recorder, authenticated(http.MethodGet))
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("Expected status 200, got %d", recorder.Code)
+		}
+
+		var snapshots []backup.Info
+		if err := json.NewDecoder(recorder.Body).Decode(&snapshots); err != nil || len(snapshots) != 1 {
+			t.Errorf("Expected 1 snapshot, got %+v (%v)", snapshots, err)
+		}
+	})
+}
+
+func TestRoutesFeatureFlags(t *testing.T) {
+	app := newTestApplication(t)
+	handler := app.routes()
+
+	withConfig := func(change func(cfg *config.Config)) {
+		cfg := *config.Get()
+		change(&cfg)
+		config.Set(&cfg)
+	}
+	found := func(path string) bool {
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, httptest.NewRequest("GET", path, nil))
+		return recorder.Code != http.StatusNotFound
+	}
+
+	if found(routes.NewPost) {
+		t.Errorf("Expected %s to be disabled without drafts", routes.NewPost)
+	}
+	if !found(routes.AuthLogin) || !found(routes.PartialsMedia) {
+		t.Errorf("Expected %s and %s to be enabled", routes.AuthLogin, routes.PartialsMedia)
+	}
+
+	withConfig(func(cfg *config.Config) { cfg.Features.Editor.EnableDrafts = true })
+	if !found(routes.NewPost) {
+		t.Errorf("Expected %s to be enabled with drafts", routes.NewPost)
+	}
+
+	withConfig(func(cfg *config.Config) {
+		cfg.Features.Editor.Enabled = false
+		cfg.Features.Authentication.Enabled = false
+	})
+	for _, path := range []string{routes.NewPost, routes.PartialsMedia, routes.AuthLogin, routes.APIBackups} {
+		if found(path) {
+			t.Errorf("Expected %s to be disabled with the editor and authentication", path)
+		}
+	}
+}
+
+func TestServeCompressed(t *testing.T) {
+	app := newTestApplication(t)
+	handler := cacheIt(compressed(app.routes()))
+	compression := func(enabled bool) {
+		cfg := *config.Get()
+		cfg.Server.Compression = enabled
+		config.Set(&cfg)
+	}
+
+	serve := func(path string) *http.Response {
+		r := httptest.NewRequest("GET", path, nil)
+		r.Header.Set("Accept-Encoding", "gzip, zstd")
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, r)
+		return recorder.Result()
+	}
+	vary := func(res *http.Response) string { return strings.Join(res.Header.Values("Vary"), ", ") }
+
+	compression(true)
+	res := serve(config.StaticURLPath + "style.css")
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Encoding") != "zstd" || !strings.Contains(vary(res), "Accept-Encoding") {
+		t.Errorf("Expected a zstd style.css, got %d %v", res.StatusCode, res.Header)
+	}
+
+	res = serve(routes.RootPath)
+	if v := vary(res); !strings.Contains(v, "Cookie") || !strings.Contains(v, "Accept-Encoding") {
+		t.Errorf("Expected the index to vary by cookie and encoding, got %q", v)
+	}
+
+	compression(false)
+	res = serve(config.StaticURLPath + "style.css")
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Encoding") != "" {
+		t.Errorf("Expected an uncompressed style.css, got %d %v", res.StatusCode, res.Header)
+	}
+}
+
+func TestRestartRequired(t *testing.T) {
+	old := &config.Config{Server: config.ServerConfig{Port: "8080"}, Site: config.SiteConfig{Name: "Before"}}
+	new := &config.Config{Server: config.ServerConfig{Port: "9090"}, Site: config.SiteConfig{Name: "After"}}
+	new.Posts.Repository = "fs"
+
+	if got := restartRequired(old, new); !slices.Equal(got, []string{"posts", "server"}) {
+		t.Errorf("Expected posts and server to need a restart, got %v", got)
+	}
+	if got := restartRequired(nil, new); got != nil {
+		t.Errorf("Expected nothing to restart on the first load, got %v", got)
+	}
+}
+
+// expectGoroutines fails t unless the number of goroutines goes back to at
+// most n shortly.
+func expectGoroutines(t *testing.T, n int) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for runtime.NumGoroutine() > n {
+		if time.Now().After(deadline) {
+			buf := make([]byte, 1<<16)
+			t.Fatalf("Expected at most %d goroutines, got %d:\n%s", n, runtime.NumGoroutine(), buf[:runtime.Stack(buf, true)])
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestServeShutdown(t *testing.T) {
+	app := newTestApplication(t)
+	before := runtime.NumGoroutine()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := app.postRepo.Start(ctx); err != nil {
+		t.Fatalf("Start failed: %v", err)
+	}
+
+	server := newServer(config.ServerConfig{Host: "127.0.0.1", ReadTimeout: 1, WriteTimeout: 1, IdleTimeout: 1}, app.routes())
+	// Event streams must outlive the write timeout
+	server.WriteTimeout = 50 * time.Millisecond
+	server.RegisterOnShutdown(app.clients.Shutdown)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(ctx, app.log, server, listener, time.Second)
+	}()
+
+	client := &http.Client{Transport: &http.Transport{}}
+	resp, err := client.Get("http://" + listener.Addr().String() + routes.SSEPath + "?post=shutdown")
+	if err != nil {
+		t.Fatalf("Failed to connect to the event stream: %v", err)
+	}
+	defer resp.Body.Close()
+	events := bufio.NewReader(resp.Body)
+	if line, _ := events.ReadString('\n'); line != "event: connected\n" {
+		t.Fatalf("Expected the connected event, got %q", line)
+	}
+	for app.clients.Len() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	time.Sleep(2 * server.WriteTimeout)
+	start := time.Now()
+	cancel()
+
+	var stream strings.Builder
+	for {
+		line, err := events.ReadString('\n')
+		stream.WriteString(line)
+		if err != nil {
+			break
+		}
+	}
+	if !strings.Contains(stream.String(), "event: shutdown\n") {
+		t.Errorf("Expected a shutdown event, got %q", stream.String())
+	}
+
+	select {
+	case err := <-served:
+		if err != nil {
+			t.Errorf("Expected a clean shutdown, got %v", err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Timed out waiting for the server to shut down")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected event streams not to hold up the shutdown, took %s", elapsed)
+	}
+	if app.clients.Len() != 0 {
+		t.Errorf("Expected every client to be removed, got %d", app.clients.Len())
+	}
+
+	app.postRepo.Stop()
+	client.CloseIdleConnections()
+	expectGoroutines(t, before)
+}
+
+func TestServeTLS(t *testing.T) {
+	app := newTestApplication(t)
+	cfg := *config.Get()
+	cfg.Server.TLS.HSTSMaxAge = 3600
+	config.Set(&cfg)
+
+	ca := tlstest.NewCA(t, "Test CA")
+	certFile, keyFile := ca.Issue(t, "localhost").WriteFiles(t)
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	if err := os.WriteFile(caFile, ca.CertPEM, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	certs, err := tlsutil.NewCertReloader(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("NewCertReloader failed: %v", err)
+	}
+	server := newServer(config.ServerConfig{ReadTimeout: 5, WriteTimeout: 5, IdleTimeout: 5}, app.routes())
+	if server.TLSConfig, err = tlsutil.NewServerConfig(config.TLSConfig{ClientCAFile: caFile}, certs); err != nil {
+		t.Fatalf("NewServerConfig failed: %v", err)
+	}
+	app.requireClientCert = true
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- serve(ctx, app.log, server, listener, time.Second)
+	}()
+	defer func() {
+		cancel()
+		if err := <-served; err != nil {
+			t.Errorf("Expected a clean shutdown, got %v", err)
+		}
+	}()
+
+	newClient := func(certs ...tls.Certificate) *http.Client {
+		return &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      ca.Pool(),
+			Certificates: certs,
+		}}}
+	}
+	baseURL := "https://" + listener.Addr().String()
+
+	t.Run("HSTS", func(t *testing.T) {
+		resp, err := newClient().Get(baseURL + routes.RootPath)
+		if err != nil {
+			t.Fatalf("Request failed: %v", err)
+		}
+		resp.Body.Close()
+		if got := resp.Header.Get("Strict-Transport-Security"); got != "max-age=3600" {
+			t.Errorf("Expected HSTS, got %q", got)
+		}
+
+		// Never over plain HTTP
+		recorder := httptest.NewRecorder()
+		app.routes().ServeHTTP(recorder, httptest.NewRequest("GET", routes.RootPath, nil))
+		if got := recorder.Header().Get("Strict-Transport-Security"); got != "" {
+			t.Errorf("Expected no HSTS over HTTP, got %q", got)
+		}
+	})
+
+	t.Run("Client certificates", func(t *testing.T) {
+		get := func(client *http.Client, path string) int {
+			t.Helper()
+			resp, err := client.Get(baseURL + path)
+			if err != nil {
+				t.Fatalf("Request failed: %v", err)
+			}
+			resp.Body.Close()
+			return resp.StatusCode
+		}
+
+		anonymous := newClient()
+		if code := get(anonymous, routes.APIImages); code != http.StatusForbidden {
+			t.Errorf("Expected the API to need a client certificate, got %d", code)
+		}
+		if code := get(anonymous, routes.AboutPath); code == http.StatusForbidden {
+			t.Error("Expected other routes not to need a client certificate")
+		}
+
+		trusted := newClient(ca.Issue(t, "client").TLSCertificate())
+		if code := get(trusted, routes.APIImages); code == http.StatusForbidden {
+			t.Error("Expected a trusted client certificate to be accepted")
+		}
+
+		// Clients only present certificates of the CAs the server asks for,
+		// and the server rejects the others
+		untrusted := newClient(tlstest.NewCA(t, "Other CA").Issue(t, "client").TLSCertificate())
+		if resp, err := untrusted.Get(baseURL + routes.APIImages); err == nil {
+			resp.Body.Close()
+			if resp.StatusCode != http.StatusForbidden {
+				t.Errorf("Expected an untrusted client certificate to be rejected, got %d", resp.StatusCode)
+			}
+		}
+	})
+}
+
+func TestRedirectToHTTPS(t *testing.T) {
+	testCases := []struct {
+		port, host, target, expected string
+	}{
+		{"8443", "example.com", "/posts/a?b=c", "https://example.com:8443/posts/a?b=c"},
+		{"8443", "example.com:8080", "/", "https://example.com:8443/"},
+		{"443", "example.com:80", "/about", "https://example.com/about"},
+		{"443", "[::1]:80", "/", "https://[::1]/"},
+	}
+	for _, tc := range testCases {
+		req := httptest.NewRequest("POST", tc.target, nil)
+		req.Host = tc.host
+		recorder := httptest.NewRecorder()
+		redirectToHTTPS(tc.port).ServeHTTP(recorder, req)
+
+		if recorder.Code != http.StatusPermanentRedirect || recorder.Header().Get("Location") != tc.expected {
+			t.Errorf("Expected %s%s to redirect to %s, got %d %s", tc.host, tc.target, tc.expected, recorder.Code, recorder.Header().Get("Location"))
+		}
+	}
+}